@@ -6,18 +6,31 @@ import (
 	"encoding/json"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
 	"runtime"
+	"syscall"
+	"time"
 
 	"github.com/UNO-SOFT/szamlazo/controller"
 	"github.com/UNO-SOFT/szamlazo/controller/status"
+	"github.com/UNO-SOFT/szamlazo/lib/dunning"
 	"github.com/UNO-SOFT/szamlazo/lib/flight"
+	"github.com/UNO-SOFT/szamlazo/lib/jobqueue"
+	"github.com/UNO-SOFT/szamlazo/lib/mnb"
+	"github.com/UNO-SOFT/szamlazo/lib/nav"
+	"github.com/UNO-SOFT/szamlazo/lib/scheduler"
+	"github.com/UNO-SOFT/szamlazo/middleware/bearer"
 	"github.com/UNO-SOFT/szamlazo/middleware/logrequest"
+	"github.com/UNO-SOFT/szamlazo/middleware/requestid"
 	"github.com/UNO-SOFT/szamlazo/middleware/rest"
 	"github.com/UNO-SOFT/szamlazo/model"
 	"github.com/UNO-SOFT/szamlazo/viewfunc/link"
 	"github.com/UNO-SOFT/szamlazo/viewfunc/noescape"
 	"github.com/UNO-SOFT/szamlazo/viewfunc/prettytime"
 	"github.com/UNO-SOFT/szamlazo/viewmodify/authlevel"
+	"github.com/UNO-SOFT/szamlazo/viewmodify/company"
+	"github.com/UNO-SOFT/szamlazo/viewmodify/permission"
 	"github.com/UNO-SOFT/szamlazo/viewmodify/uri"
 
 	"github.com/blue-jay/core/asset"
@@ -44,11 +57,16 @@ import (
 // Info contains the application settings.
 type Info struct {
 	Asset      asset.Info    `json:"Asset"`
+	Dunning    dunning.Info  `json:"Dunning"`
 	Email      email.Info    `json:"Email"`
 	Form       form.Info     `json:"Form"`
 	Generation generate.Info `json:"Generation"`
+	Jobs       jobqueue.Info `json:"Jobs"`
+	MNB        mnb.Info      `json:"MNB"`
+	NAV        nav.Info      `json:"NAV"`
 	//MySQL      mysql.Info    `json:"MySQL"`
 	PostgreSQL postgresql.Info `json:"PostgreSQL"`
+	Scheduler  scheduler.Info  `json:"Scheduler"`
 	Server     server.Info     `json:"Server"`
 	Session    session.Info    `json:"Session"`
 	Template   view.Template   `json:"Template"`
@@ -65,6 +83,10 @@ func (c *Info) ParseJSON(b []byte) error {
 // Application Logic
 // *****************************************************************************
 
+// shutdownTimeout is how long the running background jobs may take to finish
+// on exit.
+const shutdownTimeout = 30 * time.Second
+
 // init sets runtime settings.
 func init() {
 	// Verbose logging with file name and line number
@@ -121,6 +143,18 @@ func RegisterServices(config *Info) {
 	// Store the view information to flight (context)
 	flight.SetView(&config.View)
 
+	// Store the Online Invoice settings to flight (context)
+	flight.SetNAV(&config.NAV)
+
+	// Store the exchange rate settings to flight (context)
+	flight.SetMNB(&config.MNB)
+
+	// Store the mail server settings to flight (context)
+	flight.SetEmail(&config.Email)
+
+	// Store the payment reminder settings to flight (context)
+	flight.SetDunning(&config.Dunning)
+
 	// Set up the views
 	config.View.SetTemplates(config.Template.Root, config.Template.Children)
 
@@ -136,10 +170,31 @@ func RegisterServices(config *Info) {
 	// Set up the variables and modifiers for the views
 	config.View.SetModifiers(
 		authlevel.Modify,
+		company.Modify,
+		permission.Modify,
 		uri.Modify,
 		xsrf.Token,
 		flash.Modify,
 	)
+
+	// Start the background jobs, the handlers are registered with the routes
+	jobqueue.Start(model.Job, config.Jobs)
+
+	// Start the periodic tasks, they are registered with the routes too
+	scheduler.Start(model.Schedule, config.Scheduler)
+	go stopOnSignal()
+}
+
+// stopOnSignal lets the running background jobs and periodic tasks finish
+// before exiting on an interrupt.
+func stopOnSignal() {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	sig := <-c
+	log.Printf("%v: stopping the background jobs", sig)
+	scheduler.Stop(shutdownTimeout)
+	jobqueue.Stop(shutdownTimeout)
+	os.Exit(0)
 }
 
 // *****************************************************************************
@@ -152,12 +207,16 @@ func SetUpMiddleware(h http.Handler) http.Handler {
 		h,                    // Handler to wrap
 		setUpCSRF,            // Prevent CSRF
 		rest.Handler,         // Support changing HTTP method sent via query string
+		requestid.Handler,    // Give every request an ID
+		bearer.Handler,       // Authenticate API clients by their token
 		logrequest.Handler,   // Log every request
 		context.ClearHandler, // Prevent memory leak with gorilla.sessions
 	)
 }
 
-// setUpCSRF sets up the CSRF protection
+// setUpCSRF sets up the CSRF protection. Requests with an API token are let
+// through: browsers do not send the token on their own, so there is no
+// request to forge.
 func setUpCSRF(h http.Handler) http.Handler {
 	x := flight.Xsrf()
 
@@ -173,5 +232,11 @@ func setUpCSRF(h http.Handler) http.Handler {
 		csrf.FieldName("_token"),
 		csrf.Secure(x.Secure),
 	)(h)
-	return cs
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if bearer.Token(r) != "" {
+			h.ServeHTTP(w, r)
+			return
+		}
+		cs.ServeHTTP(w, r)
+	})
 }
@@ -0,0 +1,212 @@
+// Package api provides version 1 of the JSON API at /api/v1, so programs
+// like an ERP can manage partners, products, invoices and payments. Unlike
+// the HTML controllers, it answers with HTTP status codes and JSON errors
+// instead of flashes and redirects. It is described by an OpenAPI document
+// served at /api/v1/openapi.yaml.
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/UNO-SOFT/szamlazo/lib/flight"
+	"github.com/UNO-SOFT/szamlazo/model"
+	"github.com/UNO-SOFT/szamlazo/model/payment"
+
+	"github.com/blue-jay/core/router"
+)
+
+var (
+	uri = "/api/v1"
+
+	// maxBody is the largest request body accepted, in bytes.
+	maxBody int64 = 1 << 20
+)
+
+// Load the routes.
+func Load() {
+	router.Get(uri+"/openapi.yaml", OpenAPI)
+
+	partners := router.Chain(require("partner.view"))
+	partnerEdit := router.Chain(require("partner.edit"))
+	router.Get(uri+"/partners", Partners, partners...)
+	router.Post(uri+"/partners", CreatePartner, partnerEdit...)
+	router.Get(uri+"/partners/:id", Partner, partners...)
+	router.Put(uri+"/partners/:id", UpdatePartner, partnerEdit...)
+	router.Delete(uri+"/partners/:id", DeletePartner, partnerEdit...)
+
+	products := router.Chain(require("product.view"))
+	productEdit := router.Chain(require("product.edit"))
+	router.Get(uri+"/products", Products, products...)
+	router.Post(uri+"/products", CreateProduct, productEdit...)
+	router.Get(uri+"/products/:id", Product, products...)
+	router.Put(uri+"/products/:id", UpdateProduct, productEdit...)
+	router.Delete(uri+"/products/:id", DeleteProduct, productEdit...)
+
+	view := router.Chain(require("invoice.view"))
+	edit := router.Chain(require("invoice.edit"))
+	issue := router.Chain(require("invoice.issue"))
+	router.Get(uri+"/invoices", Invoices, view...)
+	router.Post(uri+"/invoices", CreateInvoice, edit...)
+	router.Get(uri+"/invoices/:id", Invoice, view...)
+	router.Put(uri+"/invoices/:id", UpdateInvoice, edit...)
+	router.Delete(uri+"/invoices/:id", DeleteInvoice, edit...)
+	router.Post(uri+"/invoices/:id/issue", IssueInvoice, issue...)
+	router.Get(uri+"/invoices/:id/pdf", InvoicePDF, view...)
+
+	verify := router.Chain(require("invoice.verify"))
+	router.Get(uri+"/series/:id/chain", SeriesChain, verify...)
+
+	payments := router.Chain(require("payment.view"))
+	pay := router.Chain(require("payment.edit"))
+	router.Get(uri+"/payments", Payments, payments...)
+	router.Post(uri+"/payments", CreatePayment, pay...)
+	router.Get(uri+"/payments/:id", Payment, payments...)
+	router.Post(uri+"/payments/:id/allocations", AllocatePayment, pay...)
+}
+
+// OpenAPI sends the description of the API.
+func OpenAPI(w http.ResponseWriter, r *http.Request) {
+	c := flight.Context(w, r)
+
+	w.Header().Set("Content-Type", "application/yaml; charset=utf-8")
+	http.ServeFile(w, r, path.Join(c.Asset.Folder, "static", "api", "v1", "openapi.yaml"))
+}
+
+// require does not allow access to anonymous users, nor to users whose role
+// in the active company lacks the permission. Users of an API token work for
+// the company the token was created for. An empty permission needs no
+// company. IDs in the path which are not numbers are not found.
+func require(permission string) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			c := flight.Context(w, r)
+
+			if !c.Bearer && c.Sess.Values["id"] == nil {
+				writeError(w, http.StatusUnauthorized, "authentication required")
+				return
+			}
+			if ID := c.Param("id"); ID != "" {
+				if _, err := strconv.ParseUint(ID, 10, 32); err != nil {
+					writeError(w, http.StatusNotFound, fmt.Sprintf("%q is not an ID", ID))
+					return
+				}
+			}
+			if permission != "" {
+				if c.Company() == 0 {
+					writeError(w, http.StatusForbidden, "no active company")
+					return
+				}
+				role, noRows, err := model.Role.Member(c.CompanyID, c.UserID)
+				if noRows {
+					writeError(w, http.StatusForbidden, "not a member of the company")
+					return
+				} else if err != nil {
+					fail(w, err)
+					return
+				}
+				if !role.Can(permission) {
+					writeError(w, http.StatusForbidden, fmt.Sprintf("the %s role lacks the %s permission", role.Name, permission))
+					return
+				}
+			}
+
+			h.ServeHTTP(w, r)
+		})
+	}
+}
+
+// errorBody is the JSON of an error.
+type errorBody struct {
+	Error string `json:"error"`
+}
+
+// writeJSON sends v as JSON with the status.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Println(err)
+	}
+}
+
+// writeError sends the message as a JSON error with the status.
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, errorBody{Error: message})
+}
+
+// invalidError is an error about the request, like a failed validation.
+type invalidError struct {
+	error
+}
+
+// invalid marks err as an error about the request. A nil err stays nil.
+func invalid(err error) error {
+	if err == nil {
+		return nil
+	}
+	return invalidError{err}
+}
+
+// fail sends an error. The ones marked by invalid and the refused
+// allocations are about the request and sent as they are; the others are
+// failures of the server, logged and not shown.
+func fail(w http.ResponseWriter, err error) {
+	switch err.(type) {
+	case invalidError, payment.RefusedError:
+		writeError(w, http.StatusUnprocessableEntity, err.Error())
+	default:
+		log.Println(err)
+		writeError(w, http.StatusInternalServerError, "internal server error")
+	}
+}
+
+// readJSON reads the JSON body of the request into v, rejecting unknown
+// fields so typos do not go unnoticed.
+func readJSON(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	d := json.NewDecoder(io.LimitReader(r.Body, maxBody))
+	d.DisallowUnknownFields()
+	if err := d.Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON: "+err.Error())
+		return false
+	}
+	return true
+}
+
+// created sends the new item with its address.
+func created(w http.ResponseWriter, location string, v interface{}) {
+	w.Header().Set("Location", location)
+	writeJSON(w, http.StatusCreated, v)
+}
+
+// notFound sends that the item asked for does not exist.
+func notFound(w http.ResponseWriter, what string, ID string) {
+	writeError(w, http.StatusNotFound, fmt.Sprintf("%s %s not found", what, ID))
+}
+
+// dateLayout is the format of the dates.
+const dateLayout = "2006-01-02"
+
+// date is a day without time, in JSON as "2006-01-02".
+type date time.Time
+
+// MarshalText writes the day.
+func (d date) MarshalText() ([]byte, error) {
+	return []byte(time.Time(d).Format(dateLayout)), nil
+}
+
+// UnmarshalText reads the day.
+func (d *date) UnmarshalText(b []byte) error {
+	t, err := time.Parse(dateLayout, string(b))
+	if err != nil {
+		return fmt.Errorf("%q is not a date like %s", b, dateLayout)
+	}
+	*d = date(t)
+	return nil
+}
@@ -0,0 +1,394 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	invoicectl "github.com/UNO-SOFT/szamlazo/controller/invoice"
+	"github.com/UNO-SOFT/szamlazo/lib/flight"
+	"github.com/UNO-SOFT/szamlazo/lib/money"
+	"github.com/UNO-SOFT/szamlazo/model"
+	"github.com/UNO-SOFT/szamlazo/model/invoice"
+
+	"gopkg.in/guregu/null.v3"
+)
+
+// invoiceJSON is an invoice in JSON. The number, status, totals, the NAV
+// fields and the hash are read only.
+type invoiceJSON struct {
+	ID                uint32        `json:"id"`
+	SeriesID          uint32        `json:"series_id"`
+	Number            null.String   `json:"number"`
+	Status            string        `json:"status"`
+	Kind              string        `json:"kind"`
+	OriginalID        null.Int      `json:"original_id"`
+	ModificationIndex null.Int      `json:"modification_index"`
+	SellerName        string        `json:"seller_name"`
+	SellerAddress     string        `json:"seller_address"`
+	SellerTaxNumber   string        `json:"seller_tax_number"`
+	PartnerID         null.Int      `json:"partner_id"`
+	BuyerName         string        `json:"buyer_name"`
+	BuyerAddress      string        `json:"buyer_address"`
+	BuyerTaxNumber    string        `json:"buyer_tax_number"`
+	IssueDate         date          `json:"issue_date"`
+	FulfilmentDate    date          `json:"fulfilment_date"`
+	DueDate           date          `json:"due_date"`
+	Currency          string        `json:"currency"`
+	PaymentMethod     string        `json:"payment_method"`
+	Rounding          string        `json:"rounding"`
+	GrossTotal        money.Decimal `json:"gross_total"`
+	ExchangeRate      money.Decimal `json:"exchange_rate"`
+	NAVStatus         string        `json:"nav_status"`
+	Hash              null.String   `json:"hash"`
+	CreatedAt         null.Time     `json:"created_at"`
+	UpdatedAt         null.Time     `json:"updated_at"`
+
+	Lines []lineJSON `json:"lines,omitempty"`
+}
+
+// lineJSON is an invoice line in JSON. The empty fields of a line with a
+// product are taken from the catalogue, the unit price only when it is
+// missing.
+type lineJSON struct {
+	LineNumber  uint32         `json:"line_number"`
+	ProductID   null.Int       `json:"product_id"`
+	CodeType    string         `json:"code_type"`
+	Code        string         `json:"code"`
+	Description string         `json:"description"`
+	Quantity    money.Decimal  `json:"quantity"`
+	Unit        string         `json:"unit"`
+	UnitPrice   *money.Decimal `json:"unit_price"`
+	VATRate     string         `json:"vat_rate"`
+}
+
+// fromInvoice converts an invoice to JSON.
+func fromInvoice(item invoice.Item) invoiceJSON {
+	result := invoiceJSON{
+		ID:                item.ID,
+		SeriesID:          item.SeriesID,
+		Number:            item.Number,
+		Status:            item.Status,
+		Kind:              item.Kind,
+		OriginalID:        item.OriginalID,
+		ModificationIndex: item.ModificationIndex,
+		SellerName:        item.SellerName,
+		SellerAddress:     item.SellerAddress,
+		SellerTaxNumber:   item.SellerTaxNumber,
+		PartnerID:         item.PartnerID,
+		BuyerName:         item.BuyerName,
+		BuyerAddress:      item.BuyerAddress,
+		BuyerTaxNumber:    item.BuyerTaxNumber,
+		IssueDate:         date(item.IssueDate),
+		FulfilmentDate:    date(item.FulfilmentDate),
+		DueDate:           date(item.DueDate),
+		Currency:          item.Currency,
+		PaymentMethod:     item.PaymentMethod,
+		Rounding:          item.Rounding,
+		GrossTotal:        item.GrossTotal,
+		ExchangeRate:      item.ExchangeRate,
+		NAVStatus:         item.NAVStatus,
+		Hash:              item.Hash,
+		CreatedAt:         item.CreatedAt,
+		UpdatedAt:         item.UpdatedAt,
+	}
+	for _, line := range item.Lines {
+		price := line.UnitPrice
+		result.Lines = append(result.Lines, lineJSON{
+			LineNumber:  line.LineNumber,
+			ProductID:   line.ProductID,
+			CodeType:    line.CodeType,
+			Code:        line.Code,
+			Description: line.Description,
+			Quantity:    line.Quantity,
+			Unit:        line.Unit,
+			UnitPrice:   &price,
+			VATRate:     line.VATRate,
+		})
+	}
+	return result
+}
+
+// item converts the JSON to an invoice. The seller defaults to the active
+// company, the buyer is copied from the partner if one is given, and the
+// lines with a product get their empty fields from the catalogue.
+func (p invoiceJSON) item(c *flight.Info) (invoice.Item, error) {
+	item := invoice.Item{
+		SeriesID:        p.SeriesID,
+		SellerName:      p.SellerName,
+		SellerAddress:   p.SellerAddress,
+		SellerTaxNumber: p.SellerTaxNumber,
+		BuyerName:       p.BuyerName,
+		BuyerAddress:    p.BuyerAddress,
+		BuyerTaxNumber:  p.BuyerTaxNumber,
+		IssueDate:       time.Time(p.IssueDate),
+		FulfilmentDate:  time.Time(p.FulfilmentDate),
+		DueDate:         time.Time(p.DueDate),
+		Currency:        strings.ToUpper(p.Currency),
+		PaymentMethod:   p.PaymentMethod,
+		Rounding:        p.Rounding,
+		CompanyID:       c.Company(),
+	}
+	if item.IssueDate.IsZero() {
+		item.IssueDate = today()
+	}
+	if item.FulfilmentDate.IsZero() {
+		item.FulfilmentDate = item.IssueDate
+	}
+	if item.DueDate.IsZero() {
+		item.DueDate = item.IssueDate.AddDate(0, 0, 8)
+	}
+	if item.Currency == "" {
+		item.Currency = "HUF"
+	}
+	if item.PaymentMethod == "" {
+		item.PaymentMethod = invoice.PaymentTransfer
+	}
+	if item.Rounding == "" {
+		item.Rounding = invoice.RoundPerLine
+	}
+
+	if _, noRows, err := model.Series.ByID(fmt.Sprint(p.SeriesID), c.CompanyID); noRows {
+		return item, invalid(fmt.Errorf("unknown series %d", p.SeriesID))
+	} else if err != nil {
+		return item, err
+	}
+
+	if item.SellerName == "" {
+		seller, _, err := model.Company.ByID(c.CompanyID, c.UserID)
+		if err != nil {
+			return item, err
+		}
+		item.SellerName = seller.Name
+		item.SellerAddress = seller.Address
+		item.SellerTaxNumber = seller.TaxNumber
+	}
+
+	if p.PartnerID.Valid {
+		partner, noRows, err := model.Partner.ByID(fmt.Sprint(p.PartnerID.Int64), c.CompanyID)
+		if noRows {
+			return item, invalid(fmt.Errorf("unknown partner %d", p.PartnerID.Int64))
+		} else if err != nil {
+			return item, err
+		}
+		item.PartnerID = p.PartnerID
+		item.BuyerName = partner.Name
+		item.BuyerAddress = partner.Address()
+		item.BuyerTaxNumber = partner.TaxNumber
+		if item.BuyerTaxNumber == "" {
+			item.BuyerTaxNumber = partner.EUVATNumber
+		}
+	}
+
+	for i, l := range p.Lines {
+		line := invoice.Line{
+			ProductID:   l.ProductID,
+			CodeType:    l.CodeType,
+			Code:        l.Code,
+			Description: strings.TrimSpace(l.Description),
+			Quantity:    l.Quantity,
+			Unit:        l.Unit,
+			VATRate:     l.VATRate,
+		}
+		if l.UnitPrice != nil {
+			line.UnitPrice = *l.UnitPrice
+		}
+		if line.ProductID.Valid {
+			product, noRows, err := model.Product.ByID(fmt.Sprint(line.ProductID.Int64), c.CompanyID)
+			if noRows {
+				return item, invalid(fmt.Errorf("line %d: unknown product %d", i+1, line.ProductID.Int64))
+			} else if err != nil {
+				return item, err
+			}
+			product.Fill(&line, l.UnitPrice != nil)
+		}
+		item.Lines = append(item.Lines, line)
+	}
+	return item, invalid(item.Validate())
+}
+
+// Invoices sends the invoices of the active company, without their lines.
+func Invoices(w http.ResponseWriter, r *http.Request) {
+	c := flight.Context(w, r)
+
+	items, _, err := model.Invoice.ByCompanyID(c.CompanyID)
+	if err != nil {
+		fail(w, err)
+		return
+	}
+
+	result := make([]invoiceJSON, 0, len(items))
+	for _, item := range items {
+		result = append(result, fromInvoice(item))
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+// Invoice sends a single invoice with its lines.
+func Invoice(w http.ResponseWriter, r *http.Request) {
+	c := flight.Context(w, r)
+
+	item, noRows, err := model.Invoice.ByID(c.Param("id"), c.CompanyID)
+	if noRows {
+		notFound(w, "invoice", c.Param("id"))
+		return
+	} else if err != nil {
+		fail(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, fromInvoice(item))
+}
+
+// CreateInvoice adds an invoice draft.
+func CreateInvoice(w http.ResponseWriter, r *http.Request) {
+	c := flight.Context(w, r)
+
+	var p invoiceJSON
+	if !readJSON(w, r, &p) {
+		return
+	}
+	item, err := p.item(c)
+	if err != nil {
+		fail(w, err)
+		return
+	}
+
+	ID, err := model.Invoice.As(c.Actor()).Create(item, c.UserID)
+	if err != nil {
+		fail(w, err)
+		return
+	}
+
+	item, _, err = model.Invoice.ByID(fmt.Sprint(ID), c.CompanyID)
+	if err != nil {
+		fail(w, err)
+		return
+	}
+	created(w, fmt.Sprintf("%s/invoices/%d", uri, ID), fromInvoice(item))
+}
+
+// UpdateInvoice replaces an invoice draft.
+func UpdateInvoice(w http.ResponseWriter, r *http.Request) {
+	c := flight.Context(w, r)
+
+	var p invoiceJSON
+	if !readJSON(w, r, &p) {
+		return
+	}
+	item, err := p.item(c)
+	if err != nil {
+		fail(w, err)
+		return
+	}
+
+	result, err := model.Invoice.As(c.Actor()).Update(item, c.Param("id"), c.CompanyID)
+	if err == invoice.ErrFinalized {
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	} else if err != nil {
+		fail(w, err)
+		return
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		notFound(w, "invoice", c.Param("id"))
+		return
+	}
+
+	Invoice(w, r)
+}
+
+// DeleteInvoice removes an invoice draft.
+func DeleteInvoice(w http.ResponseWriter, r *http.Request) {
+	c := flight.Context(w, r)
+
+	result, err := model.Invoice.As(c.Actor()).DeleteSoft(c.Param("id"), c.CompanyID)
+	if err == invoice.ErrFinalized {
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	} else if err != nil {
+		fail(w, err)
+		return
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		notFound(w, "invoice", c.Param("id"))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// IssueInvoice issues a draft: it is numbered, dated today and reported.
+// Invoices which cannot be issued are a conflict.
+func IssueInvoice(w http.ResponseWriter, r *http.Request) {
+	c := flight.Context(w, r)
+
+	item, noRows, err := model.Invoice.ByID(c.Param("id"), c.CompanyID)
+	if noRows {
+		notFound(w, "invoice", c.Param("id"))
+		return
+	} else if err != nil {
+		fail(w, err)
+		return
+	}
+	if item.Status != invoice.StatusDraft {
+		writeError(w, http.StatusConflict, invoice.ErrIssued.Error())
+		return
+	}
+	item.IssueDate = today()
+	if err = item.Validate(); err != nil {
+		writeError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	if err = invoicectl.EnsureRate(item); err != nil {
+		writeError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+
+	err = invoicectl.IssueAndReport(c.Actor(), c.Param("id"), c.CompanyID, c.UserID, item.IssueDate)
+	if err == invoice.ErrIssued {
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	} else if err != nil {
+		fail(w, err)
+		return
+	}
+
+	Invoice(w, r)
+}
+
+// InvoicePDF sends an invoice as a PDF document. The first one is the
+// original, the later ones are marked as copies. Drafts are not counted.
+func InvoicePDF(w http.ResponseWriter, r *http.Request) {
+	c := flight.Context(w, r)
+
+	item, noRows, err := model.Invoice.ByID(c.Param("id"), c.CompanyID)
+	if noRows {
+		notFound(w, "invoice", c.Param("id"))
+		return
+	} else if err != nil {
+		fail(w, err)
+		return
+	}
+
+	buf, err := invoicectl.RenderPDF(item, c.Actor())
+	if err != nil {
+		fail(w, err)
+		return
+	}
+
+	name := strings.Replace(item.Number.String, "/", "-", -1) + ".pdf"
+	if !item.Finalized() {
+		name = fmt.Sprintf("draft-%d.pdf", item.ID)
+	}
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+	w.Write(buf.Bytes())
+}
+
+// today returns the date of today.
+func today() time.Time {
+	y, m, d := time.Now().Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
@@ -0,0 +1,191 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/UNO-SOFT/szamlazo/lib/flight"
+	"github.com/UNO-SOFT/szamlazo/model"
+	"github.com/UNO-SOFT/szamlazo/model/partner"
+
+	"gopkg.in/guregu/null.v3"
+)
+
+// partnerJSON is a partner in JSON.
+type partnerJSON struct {
+	ID            uint32    `json:"id"`
+	Name          string    `json:"name"`
+	CountryCode   string    `json:"country_code"`
+	PostalCode    string    `json:"postal_code"`
+	City          string    `json:"city"`
+	Street        string    `json:"street"`
+	TaxNumber     string    `json:"tax_number"`
+	EUVATNumber   string    `json:"eu_vat_number"`
+	GroupID       string    `json:"group_id"`
+	Email         string    `json:"email"`
+	EmailCC       string    `json:"email_cc"`
+	EmailBCC      string    `json:"email_bcc"`
+	Language      string    `json:"language"`
+	DunningPaused bool      `json:"dunning_paused"`
+	BankAccounts  []string  `json:"bank_accounts"`
+	CreatedAt     null.Time `json:"created_at"`
+	UpdatedAt     null.Time `json:"updated_at"`
+}
+
+// fromPartner converts a partner to JSON.
+func fromPartner(item partner.Item) partnerJSON {
+	accounts := item.BankAccounts
+	if accounts == nil {
+		accounts = []string{}
+	}
+	return partnerJSON{
+		ID:            item.ID,
+		Name:          item.Name,
+		CountryCode:   item.CountryCode,
+		PostalCode:    item.PostalCode,
+		City:          item.City,
+		Street:        item.Street,
+		TaxNumber:     item.TaxNumber,
+		EUVATNumber:   item.EUVATNumber,
+		GroupID:       item.GroupID,
+		Email:         item.Email,
+		EmailCC:       item.EmailCC,
+		EmailBCC:      item.EmailBCC,
+		Language:      item.Language,
+		DunningPaused: item.DunningPaused,
+		BankAccounts:  accounts,
+		CreatedAt:     item.CreatedAt,
+		UpdatedAt:     item.UpdatedAt,
+	}
+}
+
+// item converts the JSON to a partner.
+func (p partnerJSON) item() partner.Item {
+	return partner.Item{
+		Name:          p.Name,
+		CountryCode:   p.CountryCode,
+		PostalCode:    p.PostalCode,
+		City:          p.City,
+		Street:        p.Street,
+		TaxNumber:     p.TaxNumber,
+		EUVATNumber:   p.EUVATNumber,
+		GroupID:       p.GroupID,
+		Email:         p.Email,
+		EmailCC:       p.EmailCC,
+		EmailBCC:      p.EmailBCC,
+		Language:      p.Language,
+		DunningPaused: p.DunningPaused,
+		BankAccounts:  p.BankAccounts,
+	}
+}
+
+// Partners sends the partners of the user.
+func Partners(w http.ResponseWriter, r *http.Request) {
+	c := flight.Context(w, r)
+
+	items, _, err := model.Partner.ByCompanyID(c.CompanyID)
+	if err != nil {
+		fail(w, err)
+		return
+	}
+
+	result := make([]partnerJSON, 0, len(items))
+	for _, item := range items {
+		result = append(result, fromPartner(item))
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+// Partner sends a single partner.
+func Partner(w http.ResponseWriter, r *http.Request) {
+	c := flight.Context(w, r)
+
+	item, noRows, err := model.Partner.ByID(c.Param("id"), c.CompanyID)
+	if noRows {
+		notFound(w, "partner", c.Param("id"))
+		return
+	} else if err != nil {
+		fail(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, fromPartner(item))
+}
+
+// CreatePartner adds a partner.
+func CreatePartner(w http.ResponseWriter, r *http.Request) {
+	c := flight.Context(w, r)
+
+	var p partnerJSON
+	if !readJSON(w, r, &p) {
+		return
+	}
+	item := p.item()
+	if item.CountryCode == "" {
+		item.CountryCode = "HU"
+	}
+	if err := item.Normalize(); err != nil {
+		writeError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+
+	item.CompanyID = c.Company()
+	ID, err := model.Partner.As(c.Actor()).Create(item, c.UserID)
+	if err != nil {
+		fail(w, err)
+		return
+	}
+
+	item, _, err = model.Partner.ByID(fmt.Sprint(ID), c.CompanyID)
+	if err != nil {
+		fail(w, err)
+		return
+	}
+	created(w, fmt.Sprintf("%s/partners/%d", uri, ID), fromPartner(item))
+}
+
+// UpdatePartner replaces the details of a partner.
+func UpdatePartner(w http.ResponseWriter, r *http.Request) {
+	c := flight.Context(w, r)
+
+	var p partnerJSON
+	if !readJSON(w, r, &p) {
+		return
+	}
+	item := p.item()
+	if err := item.Normalize(); err != nil {
+		writeError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+
+	if _, noRows, err := model.Partner.ByID(c.Param("id"), c.CompanyID); noRows {
+		notFound(w, "partner", c.Param("id"))
+		return
+	} else if err != nil {
+		fail(w, err)
+		return
+	}
+	if _, err := model.Partner.As(c.Actor()).Update(item, c.Param("id"), c.CompanyID); err != nil {
+		fail(w, err)
+		return
+	}
+
+	Partner(w, r)
+}
+
+// DeletePartner removes a partner.
+func DeletePartner(w http.ResponseWriter, r *http.Request) {
+	c := flight.Context(w, r)
+
+	result, err := model.Partner.As(c.Actor()).DeleteSoft(c.Param("id"), c.CompanyID)
+	if err != nil {
+		fail(w, err)
+		return
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		notFound(w, "partner", c.Param("id"))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
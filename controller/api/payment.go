@@ -0,0 +1,194 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/UNO-SOFT/szamlazo/lib/flight"
+	"github.com/UNO-SOFT/szamlazo/lib/money"
+	"github.com/UNO-SOFT/szamlazo/model"
+	"github.com/UNO-SOFT/szamlazo/model/payment"
+
+	"gopkg.in/guregu/null.v3"
+)
+
+// paymentJSON is a payment in JSON. The allocated amount is read only.
+type paymentJSON struct {
+	ID            uint32        `json:"id"`
+	PartnerID     null.Int      `json:"partner_id"`
+	PayerName     string        `json:"payer_name"`
+	Date          date          `json:"payment_date"`
+	Amount        money.Decimal `json:"amount"`
+	Currency      string        `json:"currency"`
+	Method        string        `json:"method"`
+	BankReference string        `json:"bank_reference"`
+	Allocated     money.Decimal `json:"allocated"`
+	CreatedAt     null.Time     `json:"created_at"`
+
+	Allocations []allocationJSON `json:"allocations,omitempty"`
+}
+
+// allocationJSON is a part of a payment paying an invoice in JSON.
+type allocationJSON struct {
+	InvoiceID     uint32        `json:"invoice_id"`
+	InvoiceNumber null.String   `json:"invoice_number"`
+	Amount        money.Decimal `json:"amount"`
+}
+
+// allocationsJSON is the body of an allocation request.
+type allocationsJSON struct {
+	Allocations []allocationJSON `json:"allocations"`
+}
+
+// fromPayment converts a payment to JSON.
+func fromPayment(item payment.Item) paymentJSON {
+	result := paymentJSON{
+		ID:            item.ID,
+		PartnerID:     item.PartnerID,
+		PayerName:     item.PayerName,
+		Date:          date(item.Date),
+		Amount:        item.Amount,
+		Currency:      item.Currency,
+		Method:        item.Method,
+		BankReference: item.BankReference,
+		Allocated:     item.Allocated,
+		CreatedAt:     item.CreatedAt,
+	}
+	for _, a := range item.Allocations {
+		result.Allocations = append(result.Allocations, allocationJSON{
+			InvoiceID:     a.InvoiceID,
+			InvoiceNumber: a.InvoiceNumber,
+			Amount:        a.Amount,
+		})
+	}
+	return result
+}
+
+// allocations converts the JSON allocations.
+func allocations(list []allocationJSON) []payment.Allocation {
+	var result []payment.Allocation
+	for _, a := range list {
+		result = append(result, payment.Allocation{InvoiceID: a.InvoiceID, Amount: a.Amount})
+	}
+	return result
+}
+
+// item converts the JSON to a payment of the active company. When a partner
+// is given, the payer is named after it.
+func (p paymentJSON) item(c *flight.Info) (payment.Item, error) {
+	item := payment.Item{
+		PayerName:     strings.TrimSpace(p.PayerName),
+		Date:          time.Time(p.Date),
+		Amount:        p.Amount,
+		Currency:      strings.ToUpper(strings.TrimSpace(p.Currency)),
+		Method:        p.Method,
+		BankReference: strings.TrimSpace(p.BankReference),
+		CompanyID:     c.Company(),
+		Allocations:   allocations(p.Allocations),
+	}
+	if p.PartnerID.Valid {
+		partner, noRows, err := model.Partner.ByID(fmt.Sprint(p.PartnerID.Int64), c.CompanyID)
+		if noRows {
+			return item, invalid(fmt.Errorf("unknown partner %d", p.PartnerID.Int64))
+		} else if err != nil {
+			return item, err
+		}
+		item.PartnerID = p.PartnerID
+		if item.PayerName == "" {
+			item.PayerName = partner.Name
+		}
+	}
+	return item, invalid(item.Validate())
+}
+
+// Payments sends the payments of the active company, without their
+// allocations.
+func Payments(w http.ResponseWriter, r *http.Request) {
+	c := flight.Context(w, r)
+
+	items, _, err := model.Payment.ByCompanyID(c.CompanyID)
+	if err != nil {
+		fail(w, err)
+		return
+	}
+
+	result := make([]paymentJSON, 0, len(items))
+	for _, item := range items {
+		result = append(result, fromPayment(item))
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+// Payment sends a single payment with its allocations.
+func Payment(w http.ResponseWriter, r *http.Request) {
+	c := flight.Context(w, r)
+
+	item, noRows, err := model.Payment.ByID(c.Param("id"), c.CompanyID)
+	if noRows {
+		notFound(w, "payment", c.Param("id"))
+		return
+	} else if err != nil {
+		fail(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, fromPayment(item))
+}
+
+// CreatePayment records a payment with its allocations.
+func CreatePayment(w http.ResponseWriter, r *http.Request) {
+	c := flight.Context(w, r)
+
+	var p paymentJSON
+	if !readJSON(w, r, &p) {
+		return
+	}
+	item, err := p.item(c)
+	if err != nil {
+		fail(w, err)
+		return
+	}
+
+	ID, err := model.Payment.As(c.Actor()).Create(item, c.UserID)
+	if err != nil {
+		fail(w, err)
+		return
+	}
+
+	item, _, err = model.Payment.ByID(fmt.Sprint(ID), c.CompanyID)
+	if err != nil {
+		fail(w, err)
+		return
+	}
+	created(w, fmt.Sprintf("%s/payments/%d", uri, ID), fromPayment(item))
+}
+
+// AllocatePayment allocates the unallocated part of a payment to invoices.
+func AllocatePayment(w http.ResponseWriter, r *http.Request) {
+	c := flight.Context(w, r)
+
+	var p allocationsJSON
+	if !readJSON(w, r, &p) {
+		return
+	}
+	if len(p.Allocations) == 0 {
+		writeError(w, http.StatusUnprocessableEntity, "no allocations")
+		return
+	}
+
+	if _, noRows, err := model.Payment.ByID(c.Param("id"), c.CompanyID); noRows {
+		notFound(w, "payment", c.Param("id"))
+		return
+	} else if err != nil {
+		fail(w, err)
+		return
+	}
+	if err := model.Payment.As(c.Actor()).Allocate(c.Param("id"), allocations(p.Allocations), c.CompanyID, c.UserID); err != nil {
+		fail(w, err)
+		return
+	}
+
+	Payment(w, r)
+}
@@ -0,0 +1,162 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/UNO-SOFT/szamlazo/lib/flight"
+	"github.com/UNO-SOFT/szamlazo/lib/money"
+	"github.com/UNO-SOFT/szamlazo/model"
+	"github.com/UNO-SOFT/szamlazo/model/product"
+
+	"gopkg.in/guregu/null.v3"
+)
+
+// productJSON is a product in JSON.
+type productJSON struct {
+	ID        uint32        `json:"id"`
+	SKU       string        `json:"sku"`
+	Name      string        `json:"name"`
+	Unit      string        `json:"unit"`
+	UnitPrice money.Decimal `json:"unit_price"`
+	VATRate   string        `json:"vat_rate"`
+	CodeType  string        `json:"code_type"`
+	Code      string        `json:"code"`
+	CreatedAt null.Time     `json:"created_at"`
+	UpdatedAt null.Time     `json:"updated_at"`
+}
+
+// fromProduct converts a product to JSON.
+func fromProduct(item product.Item) productJSON {
+	return productJSON{
+		ID:        item.ID,
+		SKU:       item.SKU,
+		Name:      item.Name,
+		Unit:      item.Unit,
+		UnitPrice: item.UnitPrice,
+		VATRate:   item.VATRate,
+		CodeType:  item.CodeType,
+		Code:      item.Code,
+		CreatedAt: item.CreatedAt,
+		UpdatedAt: item.UpdatedAt,
+	}
+}
+
+// item converts the JSON to a product.
+func (p productJSON) item() product.Item {
+	return product.Item{
+		SKU:       p.SKU,
+		Name:      p.Name,
+		Unit:      p.Unit,
+		UnitPrice: p.UnitPrice,
+		VATRate:   p.VATRate,
+		CodeType:  p.CodeType,
+		Code:      p.Code,
+	}
+}
+
+// Products sends the products of the user.
+func Products(w http.ResponseWriter, r *http.Request) {
+	c := flight.Context(w, r)
+
+	items, _, err := model.Product.ByCompanyID(c.CompanyID)
+	if err != nil {
+		fail(w, err)
+		return
+	}
+
+	result := make([]productJSON, 0, len(items))
+	for _, item := range items {
+		result = append(result, fromProduct(item))
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+// Product sends a single product.
+func Product(w http.ResponseWriter, r *http.Request) {
+	c := flight.Context(w, r)
+
+	item, noRows, err := model.Product.ByID(c.Param("id"), c.CompanyID)
+	if noRows {
+		notFound(w, "product", c.Param("id"))
+		return
+	} else if err != nil {
+		fail(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, fromProduct(item))
+}
+
+// CreateProduct adds a product.
+func CreateProduct(w http.ResponseWriter, r *http.Request) {
+	c := flight.Context(w, r)
+
+	var p productJSON
+	if !readJSON(w, r, &p) {
+		return
+	}
+	item := p.item()
+	if err := item.Validate(); err != nil {
+		writeError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+
+	item.CompanyID = c.Company()
+	ID, err := model.Product.As(c.Actor()).Create(item, c.UserID)
+	if err != nil {
+		fail(w, err)
+		return
+	}
+
+	item, _, err = model.Product.ByID(fmt.Sprint(ID), c.CompanyID)
+	if err != nil {
+		fail(w, err)
+		return
+	}
+	created(w, fmt.Sprintf("%s/products/%d", uri, ID), fromProduct(item))
+}
+
+// UpdateProduct replaces the details of a product.
+func UpdateProduct(w http.ResponseWriter, r *http.Request) {
+	c := flight.Context(w, r)
+
+	var p productJSON
+	if !readJSON(w, r, &p) {
+		return
+	}
+	item := p.item()
+	if err := item.Validate(); err != nil {
+		writeError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+
+	result, err := model.Product.As(c.Actor()).Update(item, c.Param("id"), c.CompanyID)
+	if err != nil {
+		fail(w, err)
+		return
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		notFound(w, "product", c.Param("id"))
+		return
+	}
+
+	Product(w, r)
+}
+
+// DeleteProduct removes a product.
+func DeleteProduct(w http.ResponseWriter, r *http.Request) {
+	c := flight.Context(w, r)
+
+	result, err := model.Product.As(c.Actor()).DeleteSoft(c.Param("id"), c.CompanyID)
+	if err != nil {
+		fail(w, err)
+		return
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		notFound(w, "product", c.Param("id"))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
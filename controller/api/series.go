@@ -0,0 +1,57 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/UNO-SOFT/szamlazo/lib/flight"
+	"github.com/UNO-SOFT/szamlazo/model"
+)
+
+// chainJSON is the verification of the hash chain of a series in JSON.
+type chainJSON struct {
+	SeriesID uint32      `json:"series_id"`
+	Code     string      `json:"code"`
+	Checked  int         `json:"checked"`
+	Intact   bool        `json:"intact"`
+	Breaks   []breakJSON `json:"breaks"`
+}
+
+// breakJSON is an invoice where the chain is broken.
+type breakJSON struct {
+	InvoiceID uint32 `json:"invoice_id"`
+	Number    string `json:"number"`
+	Reason    string `json:"reason"`
+}
+
+// SeriesChain walks the hash chain of the issued invoices of a series and
+// sends the breaks found.
+func SeriesChain(w http.ResponseWriter, r *http.Request) {
+	c := flight.Context(w, r)
+
+	sr, noRows, err := model.Series.ByID(c.Param("id"), c.CompanyID)
+	if noRows {
+		notFound(w, "series", c.Param("id"))
+		return
+	} else if err != nil {
+		fail(w, err)
+		return
+	}
+
+	verification, err := model.Invoice.Verify(sr.ID, c.CompanyID)
+	if err != nil {
+		fail(w, err)
+		return
+	}
+
+	result := chainJSON{
+		SeriesID: sr.ID,
+		Code:     sr.Code,
+		Checked:  verification.Checked,
+		Intact:   verification.Intact(),
+		Breaks:   make([]breakJSON, 0, len(verification.Breaks)),
+	}
+	for _, b := range verification.Breaks {
+		result.Breaks = append(result.Breaks, breakJSON{b.ID, b.Number, b.Reason})
+	}
+	writeJSON(w, http.StatusOK, result)
+}
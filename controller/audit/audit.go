@@ -0,0 +1,82 @@
+// Package audit provides the audit log of the active company for its admins:
+// who changed what and when, filtered by entity, action, user and day.
+package audit
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/UNO-SOFT/szamlazo/lib/flight"
+	"github.com/UNO-SOFT/szamlazo/middleware/acl"
+	"github.com/UNO-SOFT/szamlazo/model"
+	"github.com/UNO-SOFT/szamlazo/model/audit"
+
+	"github.com/blue-jay/core/router"
+)
+
+var (
+	uri = "/audit"
+
+	// limit is the number of entries shown at most.
+	limit = 200
+
+	// actions are the actions offered in the filter.
+	actions = []string{audit.Create, audit.Update, audit.Delete,
+		"issue", "status", "print", "nav_status", "allocate", "review", "member"}
+)
+
+// dateLayout is the format of the date inputs.
+const dateLayout = "2006-01-02"
+
+// Load the routes.
+func Load() {
+	c := router.Chain(acl.DisallowAnon, acl.Require("audit.view"))
+	router.Get(uri, Index, c...)
+}
+
+// Index displays the newest entries matching the filter in the query.
+func Index(w http.ResponseWriter, r *http.Request) {
+	c := flight.Context(w, r)
+
+	q := r.URL.Query()
+	f := audit.Filter{
+		Entity:   q.Get("entity"),
+		EntityID: strings.TrimSpace(q.Get("entity_id")),
+		Action:   q.Get("action"),
+		Email:    strings.TrimSpace(q.Get("email")),
+	}
+	for _, d := range []struct {
+		field string
+		dest  *time.Time
+	}{{"from", &f.From}, {"to", &f.To}} {
+		if s := q.Get(d.field); s != "" {
+			day, err := time.ParseInLocation(dateLayout, s, time.Local)
+			if err != nil {
+				c.FlashWarning("Enter the days like " + dateLayout + ".")
+				continue
+			}
+			*d.dest = day
+		}
+	}
+
+	items, _, err := model.Audit.Find(c.CompanyID, f, limit)
+	if err != nil {
+		c.FlashError(err)
+		items = []audit.Entry{}
+	}
+	entities, err := model.Audit.Entities(c.CompanyID)
+	if err != nil {
+		c.FlashError(err)
+	}
+
+	v := c.View.New("audit/index")
+	v.Vars["items"] = items
+	v.Vars["entities"] = entities
+	v.Vars["actions"] = actions
+	v.Vars["limit"] = limit
+	for _, field := range []string{"entity", "entity_id", "action", "email", "from", "to"} {
+		v.Vars[field] = q.Get(field)
+	}
+	v.Render(w, r)
+}
@@ -0,0 +1,337 @@
+// Package company provides the companies of the users: their seller details,
+// members and logo, and the switching between them.
+package company
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/UNO-SOFT/szamlazo/lib/flight"
+	"github.com/UNO-SOFT/szamlazo/middleware/acl"
+	"github.com/UNO-SOFT/szamlazo/model"
+	"github.com/UNO-SOFT/szamlazo/model/company"
+	"github.com/UNO-SOFT/szamlazo/model/role"
+	"github.com/UNO-SOFT/szamlazo/model/series"
+
+	"github.com/blue-jay/core/router"
+)
+
+var (
+	uri = "/company"
+
+	// fields are the form fields of a company.
+	fields = []string{"name", "address", "tax_number", "eu_vat_number", "bank_accounts"}
+
+	// storageDir is the folder of the uploaded files.
+	storageDir = "filestorage"
+
+	// maxLogoSize is the largest logo accepted, in bytes.
+	maxLogoSize int64 = 1 << 20
+
+	// logoTypes are the accepted logo formats with their file extensions.
+	logoTypes = map[string]string{"image/png": ".png", "image/jpeg": ".jpg"}
+)
+
+// Load the routes.
+func Load() {
+	c := router.Chain(acl.DisallowAnon)
+	router.Get(uri, Index, c...)
+	router.Get(uri+"/create", Create, c...)
+	router.Post(uri+"/create", Store, c...)
+	router.Get(uri+"/view/:id", Show, c...)
+	router.Get(uri+"/edit/:id", Edit, c...)
+	router.Patch(uri+"/edit/:id", Update, c...)
+	router.Post(uri+"/switch/:id", Switch, c...)
+	router.Post(uri+"/member/:id", SetMember, c...)
+	router.Post(uri+"/remove/:id", RemoveMember, c...)
+	router.Get(uri+"/logo/:id", Logo, c...)
+	router.Post(uri+"/logo/:id", UploadLogo, c...)
+}
+
+// Index displays the companies of the user.
+func Index(w http.ResponseWriter, r *http.Request) {
+	c := flight.Context(w, r)
+
+	items, _, err := model.Company.ByUserID(c.UserID)
+	if err != nil {
+		c.FlashError(err)
+		items = []company.Membership{}
+	}
+
+	v := c.View.New("company/index")
+	v.Vars["items"] = items
+	v.Vars["active"] = c.CompanyID
+	v.Render(w, r)
+}
+
+// Create displays the create form.
+func Create(w http.ResponseWriter, r *http.Request) {
+	c := flight.Context(w, r)
+
+	v := c.View.New("company/create")
+	c.Repopulate(v.Vars, fields...)
+	v.Render(w, r)
+}
+
+// Store handles the create form submission. The user becomes the admin of
+// the new company and starts working for it.
+func Store(w http.ResponseWriter, r *http.Request) {
+	c := flight.Context(w, r)
+
+	if !c.FormValid("name") {
+		Create(w, r)
+		return
+	}
+
+	item := itemFromForm(r)
+	if err := item.Normalize(); err != nil {
+		c.FlashWarning(err.Error())
+		Create(w, r)
+		return
+	}
+
+	ID, err := model.Company.As(c.Actor()).Create(item, c.UserID)
+	if err != nil {
+		c.FlashError(err)
+		Create(w, r)
+		return
+	}
+
+	c.Sess.Values["company_id"] = fmt.Sprint(ID)
+	c.Sess.Values["company_name"] = item.Name
+	c.FlashSuccess("Company added.")
+	c.Redirect(fmt.Sprintf("%s/view/%d", uri, ID))
+}
+
+// Show displays a single item with its members and numbering series.
+func Show(w http.ResponseWriter, r *http.Request) {
+	c := flight.Context(w, r)
+
+	item, _, err := model.Company.ByID(c.Param("id"), c.UserID)
+	if err != nil {
+		c.FlashError(err)
+		c.Redirect(uri)
+		return
+	}
+	members, _, err := model.Company.Members(c.Param("id"), c.UserID)
+	if err != nil {
+		c.FlashError(err)
+		members = []company.Member{}
+	}
+	roles, _, err := model.Role.All()
+	if err != nil {
+		c.FlashError(err)
+		roles = []role.Item{}
+	}
+	member, _, err := model.Role.Member(c.Param("id"), c.UserID)
+	if err != nil {
+		c.FlashError(err)
+	}
+	seriesList, _, err := model.Series.ByCompanyID(c.Param("id"))
+	if err != nil {
+		c.FlashError(err)
+		seriesList = []series.Item{}
+	}
+
+	v := c.View.New("company/show")
+	v.Vars["item"] = item
+	v.Vars["members"] = members
+	v.Vars["series"] = seriesList
+	v.Vars["roles"] = roles
+	v.Vars["manage"] = member.Can("company.manage")
+	v.Vars["active"] = c.Param("id") == c.CompanyID
+	v.Render(w, r)
+}
+
+// Edit displays the edit form.
+func Edit(w http.ResponseWriter, r *http.Request) {
+	c := flight.Context(w, r)
+
+	item, _, err := model.Company.ByID(c.Param("id"), c.UserID)
+	if err != nil {
+		c.FlashError(err)
+		c.Redirect(uri)
+		return
+	}
+
+	v := c.View.New("company/edit")
+	v.Vars["bank_accounts"] = strings.Join(item.BankAccounts, "\n")
+	c.Repopulate(v.Vars, fields...)
+	v.Vars["item"] = item
+	v.Render(w, r)
+}
+
+// Update handles the edit form submission.
+func Update(w http.ResponseWriter, r *http.Request) {
+	c := flight.Context(w, r)
+
+	if !c.FormValid("name") {
+		Edit(w, r)
+		return
+	}
+
+	item := itemFromForm(r)
+	if err := item.Normalize(); err != nil {
+		c.FlashWarning(err.Error())
+		Edit(w, r)
+		return
+	}
+
+	if err := model.Company.As(c.Actor()).Update(item, c.Param("id"), c.UserID); err == company.ErrNotAdmin {
+		c.FlashWarning(err.Error())
+		c.Redirect(fmt.Sprintf("%s/view/%s", uri, c.Param("id")))
+		return
+	} else if err != nil {
+		c.FlashError(err)
+		Edit(w, r)
+		return
+	}
+
+	if c.Param("id") == c.CompanyID {
+		c.Sess.Values["company_name"] = item.Name
+	}
+	c.FlashSuccess("Company updated.")
+	c.Redirect(fmt.Sprintf("%s/view/%s", uri, c.Param("id")))
+}
+
+// Switch makes a company of the user the active one.
+func Switch(w http.ResponseWriter, r *http.Request) {
+	c := flight.Context(w, r)
+
+	item, _, err := model.Company.ByID(c.Param("id"), c.UserID)
+	if err != nil {
+		c.FlashError(err)
+		c.Redirect(uri)
+		return
+	}
+
+	c.Sess.Values["company_id"] = fmt.Sprint(item.ID)
+	c.Sess.Values["company_name"] = item.Name
+	c.FlashNotice(fmt.Sprintf("Working for %s.", item.Name))
+	c.Redirect("/invoice")
+}
+
+// SetMember handles the member form submission: adds a user to the company
+// or changes the role.
+func SetMember(w http.ResponseWriter, r *http.Request) {
+	c := flight.Context(w, r)
+	back := fmt.Sprintf("%s/view/%s", uri, c.Param("id"))
+
+	if !c.FormValid("email", "role") {
+		c.Redirect(back)
+		return
+	}
+
+	err := model.Company.As(c.Actor()).SetMember(c.Param("id"), strings.TrimSpace(r.FormValue("email")), r.FormValue("role"), c.UserID)
+	if err != nil {
+		c.FlashWarning(err.Error())
+	} else {
+		c.FlashSuccess("Member saved.")
+	}
+
+	c.Redirect(back)
+}
+
+// RemoveMember takes a user off the company.
+func RemoveMember(w http.ResponseWriter, r *http.Request) {
+	c := flight.Context(w, r)
+
+	err := model.Company.As(c.Actor()).RemoveMember(c.Param("id"), r.FormValue("user_id"), c.UserID)
+	if err != nil {
+		c.FlashWarning(err.Error())
+	} else {
+		c.FlashNotice("Member removed.")
+	}
+
+	c.Redirect(fmt.Sprintf("%s/view/%s", uri, c.Param("id")))
+}
+
+// Logo sends the logo of a company.
+func Logo(w http.ResponseWriter, r *http.Request) {
+	c := flight.Context(w, r)
+
+	item, _, err := model.Company.ByID(c.Param("id"), c.UserID)
+	if err != nil || item.LogoPath == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	http.ServeFile(w, r, item.LogoPath)
+}
+
+// UploadLogo handles the logo form submission: the image is stored under
+// storageDir, replacing the earlier one.
+func UploadLogo(w http.ResponseWriter, r *http.Request) {
+	c := flight.Context(w, r)
+	back := fmt.Sprintf("%s/view/%s", uri, c.Param("id"))
+
+	member, _, err := model.Role.Member(c.Param("id"), c.UserID)
+	if err != nil {
+		c.FlashError(err)
+		c.Redirect(uri)
+		return
+	} else if !member.Can("company.manage") {
+		c.FlashWarning(company.ErrNotAdmin.Error())
+		c.Redirect(back)
+		return
+	}
+
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		c.FlashWarning("Pick an image.")
+		c.Redirect(back)
+		return
+	}
+	defer file.Close()
+
+	data, err := ioutil.ReadAll(io.LimitReader(file, maxLogoSize+1))
+	if err != nil {
+		c.FlashError(err)
+		c.Redirect(back)
+		return
+	}
+	if int64(len(data)) > maxLogoSize {
+		c.FlashWarning(fmt.Sprintf("The image is larger than %d MiB.", maxLogoSize>>20))
+		c.Redirect(back)
+		return
+	}
+	ext, ok := logoTypes[http.DetectContentType(data)]
+	if !ok {
+		c.FlashWarning("The logo must be a PNG or JPEG image.")
+		c.Redirect(back)
+		return
+	}
+
+	dir := filepath.Join(storageDir, "company", c.Param("id"))
+	path := filepath.Join(dir, "logo"+ext)
+	if err = os.MkdirAll(dir, 0750); err == nil {
+		err = ioutil.WriteFile(path, data, 0640)
+	}
+	if err == nil {
+		err = model.Company.As(c.Actor()).SetLogo(c.Param("id"), path, c.UserID)
+	}
+	if err != nil {
+		c.FlashError(err)
+	} else {
+		c.FlashSuccess("Logo saved.")
+	}
+
+	c.Redirect(back)
+}
+
+// itemFromForm reads a company from the submitted form, one bank account
+// per line.
+func itemFromForm(r *http.Request) company.Item {
+	return company.Item{
+		Name:         strings.TrimSpace(r.FormValue("name")),
+		Address:      r.FormValue("address"),
+		TaxNumber:    r.FormValue("tax_number"),
+		EUVATNumber:  r.FormValue("eu_vat_number"),
+		BankAccounts: strings.Split(r.FormValue("bank_accounts"), "\n"),
+	}
+}
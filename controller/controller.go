@@ -3,11 +3,25 @@ package controller
 
 import (
 	"github.com/UNO-SOFT/szamlazo/controller/about"
+	"github.com/UNO-SOFT/szamlazo/controller/api"
+	"github.com/UNO-SOFT/szamlazo/controller/audit"
+	"github.com/UNO-SOFT/szamlazo/controller/company"
 	"github.com/UNO-SOFT/szamlazo/controller/debug"
 	"github.com/UNO-SOFT/szamlazo/controller/home"
+	"github.com/UNO-SOFT/szamlazo/controller/invoice"
 	"github.com/UNO-SOFT/szamlazo/controller/login"
+	"github.com/UNO-SOFT/szamlazo/controller/mailtemplate"
 	"github.com/UNO-SOFT/szamlazo/controller/notepad"
+	"github.com/UNO-SOFT/szamlazo/controller/partner"
+	"github.com/UNO-SOFT/szamlazo/controller/payment"
+	"github.com/UNO-SOFT/szamlazo/controller/product"
+	"github.com/UNO-SOFT/szamlazo/controller/profile"
+	"github.com/UNO-SOFT/szamlazo/controller/rate"
+	"github.com/UNO-SOFT/szamlazo/controller/recurring"
 	"github.com/UNO-SOFT/szamlazo/controller/register"
+	"github.com/UNO-SOFT/szamlazo/controller/scheduler"
+	"github.com/UNO-SOFT/szamlazo/controller/series"
+	"github.com/UNO-SOFT/szamlazo/controller/statement"
 	"github.com/UNO-SOFT/szamlazo/controller/static"
 	"github.com/UNO-SOFT/szamlazo/controller/status"
 )
@@ -19,7 +33,21 @@ func LoadRoutes() {
 	register.Load()
 	login.Load()
 	home.Load()
+	company.Load()
+	profile.Load()
+	audit.Load()
+	scheduler.Load()
 	static.Load()
 	status.Load()
 	notepad.Load()
+	partner.Load()
+	product.Load()
+	invoice.Load()
+	recurring.Load()
+	mailtemplate.Load()
+	series.Load()
+	payment.Load()
+	statement.Load()
+	rate.Load()
+	api.Load()
 }
@@ -0,0 +1,43 @@
+package invoice
+
+import (
+	"net/http"
+
+	"github.com/UNO-SOFT/szamlazo/lib/flight"
+	"github.com/UNO-SOFT/szamlazo/model"
+	"github.com/UNO-SOFT/szamlazo/model/invoice"
+	"github.com/UNO-SOFT/szamlazo/model/series"
+)
+
+// chainResult is the verification of the chain of a series.
+type chainResult struct {
+	Series series.Item
+	invoice.Verification
+}
+
+// Verify walks the hash chains of all the series of the company and displays
+// the breaks.
+func Verify(w http.ResponseWriter, r *http.Request) {
+	c := flight.Context(w, r)
+
+	seriesList, _, err := model.Series.ByCompanyID(c.CompanyID)
+	if err != nil {
+		c.FlashError(err)
+		c.Redirect(uri)
+		return
+	}
+	results := make([]chainResult, 0, len(seriesList))
+	for _, sr := range seriesList {
+		verification, err := model.Invoice.Verify(sr.ID, c.CompanyID)
+		if err != nil {
+			c.FlashError(err)
+			c.Redirect(uri)
+			return
+		}
+		results = append(results, chainResult{sr, verification})
+	}
+
+	v := c.View.New("invoice/verify")
+	v.Vars["results"] = results
+	v.Render(w, r)
+}
@@ -0,0 +1,137 @@
+package invoice
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/UNO-SOFT/szamlazo/lib/flight"
+	"github.com/UNO-SOFT/szamlazo/model"
+	"github.com/UNO-SOFT/szamlazo/model/invoice"
+)
+
+// Storno handles the cancellation of an issued invoice with a storno invoice
+// of today.
+func Storno(w http.ResponseWriter, r *http.Request) {
+	c := flight.Context(w, r)
+
+	original, _, err := model.Invoice.ByID(c.Param("id"), c.CompanyID)
+	if err != nil {
+		c.FlashError(err)
+		c.Redirect(uri)
+		return
+	}
+
+	storno := original.Storno(today())
+	if err = storno.Validate(); err != nil {
+		c.FlashWarning(err.Error())
+		c.Redirect(uri + "/view/" + c.Param("id"))
+		return
+	}
+	issueCorrection(c, storno, "Storno invoice added.")
+}
+
+// Modify displays the form of a modification invoice.
+func Modify(w http.ResponseWriter, r *http.Request) {
+	c := flight.Context(w, r)
+
+	original, _, err := model.Invoice.ByID(c.Param("id"), c.CompanyID)
+	if err != nil {
+		c.FlashError(err)
+		c.Redirect(uri)
+		return
+	}
+
+	m := original.Modification(today())
+	v := c.View.New("invoice/modify")
+	v.Vars["original"] = original
+	v.Vars["issue_date"] = m.IssueDate.Format(dateLayout)
+	v.Vars["fulfilment_date"] = m.FulfilmentDate.Format(dateLayout)
+	v.Vars["due_date"] = m.DueDate.Format(dateLayout)
+	c.Repopulate(v.Vars, "issue_date", "fulfilment_date", "due_date")
+	SetChoices(c, v.Vars, nil)
+	v.Render(w, r)
+}
+
+// StoreModification handles the modification form submission. The lines are
+// the differences to the original, negative to take away.
+func StoreModification(w http.ResponseWriter, r *http.Request) {
+	c := flight.Context(w, r)
+
+	if !c.FormValid("issue_date", "fulfilment_date", "due_date") {
+		Modify(w, r)
+		return
+	}
+
+	original, _, err := model.Invoice.ByID(c.Param("id"), c.CompanyID)
+	if err != nil {
+		c.FlashError(err)
+		c.Redirect(uri)
+		return
+	}
+
+	m, err := modificationFromForm(c, original)
+	if err != nil {
+		c.FlashWarning(err.Error())
+		Modify(w, r)
+		return
+	}
+	issueCorrection(c, m, "Modification invoice added.")
+}
+
+// modificationFromForm reads and validates the submitted modification of
+// original. The parties and the terms are kept from the original.
+func modificationFromForm(c *flight.Info, original invoice.Item) (invoice.Item, error) {
+	m := original.Modification(today())
+	var priced []bool
+	var err error
+	if m.Lines, priced, err = LinesFromForm(c.R); err != nil {
+		return m, err
+	}
+	if err = datesFromForm(c.R, &m); err != nil {
+		return m, err
+	}
+	if err = FillProducts(c, m.Lines, priced); err != nil {
+		return m, err
+	}
+	return m, m.Validate()
+}
+
+// issueCorrection stores and reports a storno or modification invoice, then
+// shows it.
+func issueCorrection(c *flight.Info, item invoice.Item, success string) {
+	if item.ExchangeRate.IsZero() {
+		if err := EnsureRate(item); err != nil {
+			c.FlashWarning(err.Error())
+			c.Redirect(uri + "/view/" + c.Param("id"))
+			return
+		}
+	}
+	var ID uint32
+	err := model.Transaction(func(tx model.Tx) error {
+		var err error
+		if ID, err = tx.Invoice.As(c.Actor()).CreateCorrection(item, c.UserID); err != nil {
+			return err
+		}
+		return EnqueueReport(tx.Job, fmt.Sprint(ID), c.CompanyID)
+	})
+	if err != nil {
+		c.FlashError(err)
+		c.Redirect(uri + "/view/" + c.Param("id"))
+		return
+	}
+
+	c.FlashSuccess(success)
+	c.Redirect(fmt.Sprintf("%s/view/%d", uri, ID))
+}
+
+// today returns the date of today.
+func today() time.Time {
+	return dateOf(time.Now())
+}
+
+// dateOf returns the date of t.
+func dateOf(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
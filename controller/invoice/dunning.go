@@ -0,0 +1,150 @@
+package invoice
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/UNO-SOFT/szamlazo/lib/dunning"
+	"github.com/UNO-SOFT/szamlazo/lib/flight"
+	"github.com/UNO-SOFT/szamlazo/lib/jobqueue"
+	"github.com/UNO-SOFT/szamlazo/lib/mail"
+	"github.com/UNO-SOFT/szamlazo/model"
+	"github.com/UNO-SOFT/szamlazo/model/invoice"
+	"github.com/UNO-SOFT/szamlazo/model/mailtemplate"
+	"github.com/UNO-SOFT/szamlazo/model/reminder"
+	"github.com/UNO-SOFT/szamlazo/model/sentmail"
+)
+
+// reminderLogLimit is the number of reminders listed for the company.
+const reminderLogLimit = 200
+
+// Reminders displays the latest payment reminders of the company.
+func Reminders(w http.ResponseWriter, r *http.Request) {
+	c := flight.Context(w, r)
+
+	items, _, err := model.Reminder.ByCompanyID(c.CompanyID, reminderLogLimit)
+	if err != nil {
+		c.FlashError(err)
+		items = []reminder.Item{}
+	}
+
+	v := c.View.New("invoice/reminder")
+	v.Vars["items"] = items
+	v.Vars["offsets"] = flight.Dunning().Schedule()
+	v.Vars["enabled"] = mail.Enabled(*flight.Email())
+	v.Render(w, r)
+}
+
+// dun is the periodic task sending the payment reminders.
+func dun(ctx context.Context, at time.Time) (string, error) {
+	n, err := Dun(ctx, dateOf(at))
+	return fmt.Sprintf("%d reminders queued", n), err
+}
+
+// Dun queues a reminder of each overdue invoice which reached the next
+// offset of the settings since its last one, and returns their number.
+// Nothing is sent while sending mail is not configured.
+func Dun(ctx context.Context, today time.Time) (int, error) {
+	if !mail.Enabled(*flight.Email()) {
+		return 0, nil
+	}
+	info := *flight.Dunning()
+	candidates, err := model.Reminder.Candidates(today)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, cand := range candidates {
+		if err = ctx.Err(); err != nil {
+			return n, err
+		}
+		days := dunning.DaysOverdue(cand.DueDate, today)
+		level, final := info.Level(days)
+		if level <= cand.Level {
+			continue
+		}
+		queued, err := remind(cand, level, final, days, today)
+		if err != nil {
+			log.Printf("dunning: invoice %d: %v", cand.InvoiceID, err)
+			continue
+		}
+		if queued {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// remind records the reminder of an invoice at level, marks the invoice
+// overdue and queues the mail with the invoice attached, all in one
+// transaction. It returns false when another server was quicker.
+func remind(cand reminder.Candidate, level int, final bool, days int, today time.Time) (bool, error) {
+	ID, companyID := fmt.Sprint(cand.InvoiceID), fmt.Sprint(cand.CompanyID)
+	item, _, err := model.Invoice.ByID(ID, companyID)
+	if err != nil {
+		return false, err
+	}
+
+	kind := mailtemplate.KindReminder
+	if final {
+		kind = mailtemplate.KindFinalReminder
+	}
+	t, err := model.MailTemplate.ForLanguage(cand.CompanyID, kind, cand.Language)
+	if err != nil {
+		return false, err
+	}
+	d := mailData(item)
+	d.Balance = cand.Balance().String()
+	d.DaysOverdue = days
+	subject, body, err := t.Render(d)
+	if err != nil {
+		return false, err
+	}
+
+	created := false
+	err = model.Transaction(func(tx model.Tx) error {
+		reminderID, ok, err := tx.Reminder.Create(reminder.Item{
+			CompanyID:   cand.CompanyID,
+			InvoiceID:   cand.InvoiceID,
+			Level:       level,
+			DaysOverdue: days,
+			Balance:     cand.Balance(),
+		})
+		if err != nil || !ok {
+			return err
+		}
+		created = true
+
+		m := sentmail.Item{
+			CompanyID:  cand.CompanyID,
+			InvoiceID:  cand.InvoiceID,
+			Recipients: cand.Email,
+			CC:         cand.EmailCC,
+			BCC:        cand.EmailBCC,
+			Subject:    subject,
+			Body:       body,
+		}
+		if m.CopyNo, err = tx.Invoice.Printed(ID, companyID); err != nil {
+			return err
+		}
+		mailID, err := tx.SentMail.Create(m, "")
+		if err != nil {
+			return err
+		}
+		if _, err = tx.Reminder.SetSentMail(reminderID, mailID); err != nil {
+			return err
+		}
+		if err = jobqueue.EnqueueIn(tx.Job, jobEmail, emailJob{ID: mailID, CompanyID: cand.CompanyID}, 0); err != nil {
+			return err
+		}
+		if !invoice.CanTransition(item.Status, invoice.StatusOverdue) {
+			return nil
+		}
+		note := fmt.Sprintf("Payment reminder %d, %d days overdue", level, days)
+		return tx.Invoice.SetStatus(ID, invoice.StatusOverdue, companyID, "", note, today)
+	})
+	return created && err == nil, err
+}
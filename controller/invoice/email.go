@@ -0,0 +1,300 @@
+package invoice
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/UNO-SOFT/szamlazo/lib/flight"
+	"github.com/UNO-SOFT/szamlazo/lib/invoicepdf"
+	"github.com/UNO-SOFT/szamlazo/lib/jobqueue"
+	"github.com/UNO-SOFT/szamlazo/lib/mail"
+	"github.com/UNO-SOFT/szamlazo/model"
+	"github.com/UNO-SOFT/szamlazo/model/audit"
+	"github.com/UNO-SOFT/szamlazo/model/invoice"
+	"github.com/UNO-SOFT/szamlazo/model/mailtemplate"
+	"github.com/UNO-SOFT/szamlazo/model/partner"
+	"github.com/UNO-SOFT/szamlazo/model/sentmail"
+)
+
+// jobEmail is the kind of the jobs sending the invoices by mail.
+const jobEmail = "invoice.email"
+
+// mailLogLimit is the number of mails listed in the log of the company.
+const mailLogLimit = 200
+
+// emailFields are the form fields of a mail.
+var emailFields = []string{"recipients", "cc", "bcc", "language", "subject", "body"}
+
+// emailJob is the payload of the mail sending jobs.
+type emailJob struct {
+	ID        uint32 `json:"id"`
+	CompanyID uint32 `json:"company_id"`
+}
+
+// Email displays the form sending an issued item by mail, filled from the
+// partner and the template of its language.
+func Email(w http.ResponseWriter, r *http.Request) {
+	c := flight.Context(w, r)
+
+	item, _, err := model.Invoice.ByID(c.Param("id"), c.CompanyID)
+	if err != nil {
+		c.FlashError(err)
+		c.Redirect(uri)
+		return
+	}
+	if !item.Finalized() {
+		c.FlashWarning("Only issued invoices can be sent.")
+		c.Redirect(uri + "/view/" + c.Param("id"))
+		return
+	}
+
+	p := partner.Item{Language: partner.DefaultLanguage}
+	if item.PartnerID.Valid {
+		if p, _, err = model.Partner.ByID(fmt.Sprint(item.PartnerID.Int64), fmt.Sprint(item.CompanyID)); err != nil {
+			p = partner.Item{Language: partner.DefaultLanguage}
+		}
+	}
+	language := p.Language
+	if l := r.FormValue("language"); l != "" {
+		language = strings.ToLower(l)
+	}
+
+	t, err := model.MailTemplate.ForLanguage(c.CompanyID, mailtemplate.KindInvoice, language)
+	if err != nil {
+		c.FlashError(err)
+		t = mailtemplate.Default(mailtemplate.KindInvoice, language)
+	}
+	subject, body, err := t.Render(mailData(item))
+	if err != nil {
+		c.FlashError(err)
+	}
+
+	v := c.View.New("invoice/email")
+	v.Vars["item"] = item
+	v.Vars["recipients"] = p.Email
+	v.Vars["cc"] = p.EmailCC
+	v.Vars["bcc"] = p.EmailBCC
+	v.Vars["language"] = language
+	v.Vars["subject"] = subject
+	v.Vars["body"] = body
+	if r.Method == http.MethodPost {
+		c.Repopulate(v.Vars, emailFields...)
+	}
+	v.Vars["enabled"] = mail.Enabled(*flight.Email())
+	v.Render(w, r)
+}
+
+// SendEmail handles the mail form submission: it records the mail and
+// schedules sending it.
+func SendEmail(w http.ResponseWriter, r *http.Request) {
+	c := flight.Context(w, r)
+
+	if !mail.Enabled(*flight.Email()) {
+		c.FlashWarning("Sending mail is not configured.")
+		Email(w, r)
+		return
+	}
+	if !c.FormValid("recipients", "subject", "body") {
+		Email(w, r)
+		return
+	}
+
+	item, _, err := model.Invoice.ByID(c.Param("id"), c.CompanyID)
+	if err != nil {
+		c.FlashError(err)
+		c.Redirect(uri)
+		return
+	}
+	if !item.Finalized() {
+		c.FlashWarning("Only issued invoices can be sent.")
+		c.Redirect(uri + "/view/" + c.Param("id"))
+		return
+	}
+
+	m := sentmail.Item{
+		CompanyID: item.CompanyID,
+		InvoiceID: item.ID,
+		Subject:   strings.TrimSpace(r.FormValue("subject")),
+		Body:      r.FormValue("body"),
+	}
+	for _, f := range []struct {
+		name string
+		dest *string
+	}{{"recipients", &m.Recipients}, {"cc", &m.CC}, {"bcc", &m.BCC}} {
+		list, err := mail.ParseList(r.FormValue(f.name))
+		if err != nil {
+			c.FlashWarning(err.Error())
+			Email(w, r)
+			return
+		}
+		*f.dest = strings.Join(list, ", ")
+	}
+	if m.Recipients == "" {
+		c.FlashWarning("A recipient is required.")
+		Email(w, r)
+		return
+	}
+
+	if err = queueMail(m, c.Actor(), c.UserID); err != nil {
+		c.FlashError(err)
+		Email(w, r)
+		return
+	}
+
+	c.FlashSuccess("Mail queued.")
+	c.Redirect(uri + "/view/" + c.Param("id"))
+}
+
+// QueueMail records the mail of an issued item to the partner, with the
+// invoice template of its language, and schedules sending it as the user.
+func QueueMail(item invoice.Item, p partner.Item, actor audit.Actor, userID string) error {
+	if !mail.Enabled(*flight.Email()) {
+		return fmt.Errorf("sending mail is not configured")
+	}
+	if p.Email == "" {
+		return fmt.Errorf("partner %s has no mail address", p.Name)
+	}
+	t, err := model.MailTemplate.ForLanguage(item.CompanyID, mailtemplate.KindInvoice, p.Language)
+	if err != nil {
+		return err
+	}
+	subject, body, err := t.Render(mailData(item))
+	if err != nil {
+		return err
+	}
+	return queueMail(sentmail.Item{
+		CompanyID:  item.CompanyID,
+		InvoiceID:  item.ID,
+		Recipients: p.Email,
+		CC:         p.EmailCC,
+		BCC:        p.EmailBCC,
+		Subject:    subject,
+		Body:       body,
+	}, actor, userID)
+}
+
+// queueMail records a mail and schedules sending it, all in one
+// transaction, so no mail is left without its job. The attachment is counted
+// as a printing now, so the retries send the same copy.
+func queueMail(m sentmail.Item, actor audit.Actor, userID string) error {
+	ID, companyID := fmt.Sprint(m.InvoiceID), fmt.Sprint(m.CompanyID)
+	return model.Transaction(func(tx model.Tx) error {
+		var err error
+		if m.CopyNo, err = tx.Invoice.As(actor).Printed(ID, companyID); err != nil {
+			return err
+		}
+		mailID, err := tx.SentMail.Create(m, userID)
+		if err != nil {
+			return err
+		}
+		return jobqueue.EnqueueIn(tx.Job, jobEmail, emailJob{ID: mailID, CompanyID: m.CompanyID}, 0)
+	})
+}
+
+// MailLog displays the latest mails of the company.
+func MailLog(w http.ResponseWriter, r *http.Request) {
+	c := flight.Context(w, r)
+
+	items, _, err := model.SentMail.ByCompanyID(c.CompanyID, mailLogLimit)
+	if err != nil {
+		c.FlashError(err)
+		items = []sentmail.Item{}
+	}
+
+	v := c.View.New("invoice/mail")
+	v.Vars["items"] = items
+	v.Render(w, r)
+}
+
+// sendEmail sends a recorded mail with the invoice attached, and moves the
+// invoice to sent by the system once the mail is delivered. Failures are
+// recorded with the mail, the job is retried unless the server refused it
+// for good.
+func sendEmail(ctx context.Context, payload []byte) error {
+	var j emailJob
+	if err := json.Unmarshal(payload, &j); err != nil {
+		return jobqueue.Permanent(err)
+	}
+	m, noRows, err := model.SentMail.ByID(j.ID, j.CompanyID)
+	if noRows {
+		return jobqueue.Permanent(err)
+	} else if err != nil {
+		return err
+	}
+	if m.Status == sentmail.StatusSent {
+		return nil
+	}
+
+	fail := func(err error, permanent bool) error {
+		final := permanent || jobqueue.LastAttempt(ctx)
+		if _, dbErr := model.SentMail.Failed(m.ID, err.Error(), final); dbErr != nil {
+			log.Println(dbErr)
+		}
+		if permanent {
+			return jobqueue.Permanent(err)
+		}
+		return err
+	}
+
+	item, _, err := model.Invoice.ByID(fmt.Sprint(m.InvoiceID), fmt.Sprint(m.CompanyID))
+	if err != nil {
+		return fail(err, false)
+	}
+	var buf bytes.Buffer
+	if err = invoicepdf.Render(&buf, item, m.CopyNo); err != nil {
+		return fail(err, true)
+	}
+
+	msg := mail.Message{
+		Subject: m.Subject,
+		Body:    m.Body,
+		Attachments: []mail.Attachment{{
+			Name:        strings.Replace(item.Number.String, "/", "-", -1) + ".pdf",
+			ContentType: "application/pdf",
+			Data:        buf.Bytes(),
+		}},
+	}
+	for _, f := range []struct {
+		list string
+		dest *[]string
+	}{{m.Recipients, &msg.To}, {m.CC, &msg.Cc}, {m.BCC, &msg.Bcc}} {
+		if *f.dest, err = mail.ParseList(f.list); err != nil {
+			return fail(err, true)
+		}
+	}
+
+	messageID, err := mail.Send(ctx, *flight.Email(), msg)
+	if err != nil {
+		return fail(err, mail.Permanent(err))
+	}
+	if _, err = model.SentMail.Sent(m.ID, messageID); err != nil {
+		// The mail is out, sending it again would duplicate it.
+		log.Println(err)
+		return nil
+	}
+	if invoice.CanTransition(item.Status, invoice.StatusSent) {
+		err = model.Invoice.SetStatus(fmt.Sprint(item.ID), invoice.StatusSent, fmt.Sprint(m.CompanyID), "", messageID, today())
+		if err != nil {
+			log.Println(err)
+		}
+	}
+	return nil
+}
+
+// mailData returns the fields of an item for the mail templates.
+func mailData(item invoice.Item) mailtemplate.Data {
+	return mailtemplate.Data{
+		Number:    item.Number.String,
+		Seller:    item.SellerName,
+		Buyer:     item.BuyerName,
+		IssueDate: item.IssueDate.Format(dateLayout),
+		DueDate:   item.DueDate.Format(dateLayout),
+		Total:     item.GrossTotal.String(),
+		Currency:  item.Currency,
+	}
+}
@@ -0,0 +1,503 @@
+// Package invoice provides the issuing and listing of invoices.
+package invoice
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/UNO-SOFT/szamlazo/lib/flight"
+	"github.com/UNO-SOFT/szamlazo/lib/invoicepdf"
+	"github.com/UNO-SOFT/szamlazo/lib/money"
+	"github.com/UNO-SOFT/szamlazo/middleware/acl"
+	"github.com/UNO-SOFT/szamlazo/model"
+	"github.com/UNO-SOFT/szamlazo/model/audit"
+	"github.com/UNO-SOFT/szamlazo/model/invoice"
+	"github.com/UNO-SOFT/szamlazo/model/partner"
+	"github.com/UNO-SOFT/szamlazo/model/payment"
+	"github.com/UNO-SOFT/szamlazo/model/product"
+	"github.com/UNO-SOFT/szamlazo/model/reminder"
+	"github.com/UNO-SOFT/szamlazo/model/sentmail"
+	"github.com/UNO-SOFT/szamlazo/model/series"
+
+	"github.com/blue-jay/core/router"
+)
+
+var (
+	uri = "/invoice"
+
+	// fields are the form fields of the invoice header.
+	fields = []string{"series_id", "partner_id",
+		"seller_name", "seller_address", "seller_tax_number",
+		"buyer_name", "buyer_address", "buyer_tax_number",
+		"issue_date", "fulfilment_date", "due_date", "currency", "payment_method",
+		"rounding"}
+
+	// required are the header fields which cannot be left empty.
+	required = []string{"series_id", "seller_name", "seller_address",
+		"issue_date", "fulfilment_date", "due_date", "currency", "payment_method"}
+
+	// blankLines is the number of empty lines offered in the forms.
+	blankLines = 3
+)
+
+// dateLayout is the format of the date inputs.
+const dateLayout = "2006-01-02"
+
+// Load the routes and the background jobs.
+func Load() {
+	c := router.Chain(acl.DisallowAnon, acl.Require("invoice.view"))
+	edit := router.Chain(acl.DisallowAnon, acl.Require("invoice.edit"))
+	issue := router.Chain(acl.DisallowAnon, acl.Require("invoice.issue"))
+	status := router.Chain(acl.DisallowAnon, acl.Require("invoice.status"))
+	verify := router.Chain(acl.DisallowAnon, acl.Require("invoice.verify"))
+	send := router.Chain(acl.DisallowAnon, acl.Require("invoice.send"))
+	router.Get(uri, Index, c...)
+	router.Get(uri+"/create", Create, edit...)
+	router.Post(uri+"/create", Store, edit...)
+	router.Get(uri+"/view/:id", Show, c...)
+	router.Get(uri+"/pdf/:id", PDF, c...)
+	router.Get(uri+"/edit/:id", Edit, edit...)
+	router.Patch(uri+"/edit/:id", Update, edit...)
+	router.Delete(uri+"/:id", Destroy, edit...)
+	router.Post(uri+"/issue/:id", Issue, issue...)
+	router.Post(uri+"/status/:id", SetStatus, status...)
+	router.Post(uri+"/storno/:id", Storno, issue...)
+	router.Get(uri+"/modify/:id", Modify, issue...)
+	router.Post(uri+"/modify/:id", StoreModification, issue...)
+	router.Get(uri+"/verify", Verify, verify...)
+	router.Get(uri+"/email/:id", Email, send...)
+	router.Post(uri+"/email/:id", SendEmail, send...)
+	router.Get(uri+"/mail", MailLog, c...)
+	router.Get(uri+"/reminders", Reminders, c...)
+
+	loadJobs()
+}
+
+// Index displays the items.
+func Index(w http.ResponseWriter, r *http.Request) {
+	c := flight.Context(w, r)
+
+	items, _, err := model.Invoice.ByCompanyID(c.CompanyID)
+	if err != nil {
+		c.FlashError(err)
+		items = []invoice.Item{}
+	}
+
+	v := c.View.New("invoice/index")
+	v.Vars["items"] = items
+	v.Render(w, r)
+}
+
+// Create displays the create form.
+func Create(w http.ResponseWriter, r *http.Request) {
+	c := flight.Context(w, r)
+
+	v := c.View.New("invoice/create")
+	today := time.Now().Format(dateLayout)
+	v.Vars["issue_date"] = today
+	v.Vars["fulfilment_date"] = today
+	v.Vars["due_date"] = time.Now().AddDate(0, 0, 8).Format(dateLayout)
+	v.Vars["currency"] = "HUF"
+	v.Vars["payment_method"] = invoice.PaymentTransfer
+	v.Vars["rounding"] = invoice.RoundPerLine
+	if seller, _, err := model.Company.ByID(c.CompanyID, c.UserID); err == nil {
+		v.Vars["seller_name"] = seller.Name
+		v.Vars["seller_address"] = seller.Address
+		v.Vars["seller_tax_number"] = seller.TaxNumber
+	}
+	c.Repopulate(v.Vars, fields...)
+	SetChoices(c, v.Vars, nil)
+	v.Render(w, r)
+}
+
+// Store handles the create form submission.
+func Store(w http.ResponseWriter, r *http.Request) {
+	c := flight.Context(w, r)
+
+	if !c.FormValid(required...) {
+		Create(w, r)
+		return
+	}
+
+	item, err := itemFromForm(c)
+	if err != nil {
+		c.FlashWarning(err.Error())
+		Create(w, r)
+		return
+	}
+
+	item.CompanyID = c.Company()
+	ID, err := model.Invoice.As(c.Actor()).Create(item, c.UserID)
+	if err != nil {
+		c.FlashError(err)
+		Create(w, r)
+		return
+	}
+
+	c.FlashSuccess("Invoice draft added.")
+	c.Redirect(fmt.Sprintf("%s/view/%d", uri, ID))
+}
+
+// Show displays a single item.
+func Show(w http.ResponseWriter, r *http.Request) {
+	c := flight.Context(w, r)
+
+	item, _, err := model.Invoice.ByID(c.Param("id"), c.CompanyID)
+	if err != nil {
+		c.FlashError(err)
+		c.Redirect(uri)
+		return
+	}
+
+	corrections, _, err := model.Invoice.Corrections(c.Param("id"), c.CompanyID)
+	if err != nil {
+		c.FlashError(err)
+		corrections = []invoice.Item{}
+	}
+
+	history, _, err := model.Invoice.History(c.Param("id"), c.CompanyID)
+	if err != nil {
+		c.FlashError(err)
+		history = []invoice.Transition{}
+	}
+
+	payments, _, err := model.Payment.ByInvoice(c.Param("id"), c.CompanyID)
+	if err != nil {
+		c.FlashError(err)
+		payments = []payment.Allocation{}
+	}
+	mails, _, err := model.SentMail.ByInvoiceID(c.Param("id"), c.CompanyID)
+	if err != nil {
+		c.FlashError(err)
+		mails = []sentmail.Item{}
+	}
+
+	reminders, _, err := model.Reminder.ByInvoiceID(c.Param("id"), c.CompanyID)
+	if err != nil {
+		c.FlashError(err)
+		reminders = []reminder.Item{}
+	}
+
+	balance := item.GrossTotal
+	for _, p := range payments {
+		balance = balance.Sub(p.Amount)
+	}
+
+	v := c.View.New("invoice/show")
+	v.Vars["item"] = item
+	v.Vars["totals"] = item.Totals()
+	v.Vars["corrections"] = corrections
+	v.Vars["history"] = history
+	v.Vars["actions"] = statusActions(item)
+	v.Vars["payments"] = payments
+	v.Vars["balance"] = balance
+	v.Vars["mails"] = mails
+	v.Vars["reminders"] = reminders
+	v.Vars["payable"] = balance.Sign() > 0 && invoice.CanTransition(item.Status, invoice.StatusPaid)
+	v.Render(w, r)
+}
+
+// PDF sends a single item as a PDF document. The first one is the original,
+// the later ones are marked as copies. Drafts are not counted.
+func PDF(w http.ResponseWriter, r *http.Request) {
+	c := flight.Context(w, r)
+
+	item, _, err := model.Invoice.ByID(c.Param("id"), c.CompanyID)
+	if err != nil {
+		c.FlashError(err)
+		c.Redirect(uri)
+		return
+	}
+
+	buf, err := RenderPDF(item, c.Actor())
+	if err != nil {
+		c.FlashError(err)
+		c.Redirect(uri)
+		return
+	}
+
+	name := strings.Replace(item.Number.String, "/", "-", -1) + ".pdf"
+	if !item.Finalized() {
+		name = fmt.Sprintf("draft-%d.pdf", item.ID)
+	}
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=%q", name))
+	w.Write(buf.Bytes())
+}
+
+// RenderPDF renders an item as a PDF document. Issued items are counted as
+// printed in the same transaction, so a failed rendering does not use up the
+// original.
+func RenderPDF(item invoice.Item, actor audit.Actor) (*bytes.Buffer, error) {
+	var buf bytes.Buffer
+	if !item.Finalized() {
+		return &buf, invoicepdf.Render(&buf, item, 0)
+	}
+	err := model.Transaction(func(tx model.Tx) error {
+		copyNo, err := tx.Invoice.As(actor).Printed(fmt.Sprint(item.ID), fmt.Sprint(item.CompanyID))
+		if err != nil {
+			return err
+		}
+		return invoicepdf.Render(&buf, item, copyNo)
+	})
+	return &buf, err
+}
+
+// Edit displays the edit form.
+func Edit(w http.ResponseWriter, r *http.Request) {
+	c := flight.Context(w, r)
+
+	item, _, err := model.Invoice.ByID(c.Param("id"), c.CompanyID)
+	if err != nil {
+		c.FlashError(err)
+		c.Redirect(uri)
+		return
+	}
+
+	if item.Finalized() {
+		c.FlashWarning(invoice.ErrFinalized.Error())
+		c.Redirect(uri + "/view/" + c.Param("id"))
+		return
+	}
+
+	v := c.View.New("invoice/edit")
+	v.Vars["issue_date"] = item.IssueDate.Format(dateLayout)
+	v.Vars["fulfilment_date"] = item.FulfilmentDate.Format(dateLayout)
+	v.Vars["due_date"] = item.DueDate.Format(dateLayout)
+	v.Vars["series_id"] = fmt.Sprint(item.SeriesID)
+	v.Vars["payment_method"] = item.PaymentMethod
+	v.Vars["rounding"] = item.Rounding
+	if item.PartnerID.Valid {
+		v.Vars["partner_id"] = fmt.Sprint(item.PartnerID.Int64)
+	}
+	c.Repopulate(v.Vars, fields...)
+	SetChoices(c, v.Vars, item.Lines)
+	v.Vars["item"] = item
+	v.Render(w, r)
+}
+
+// Update handles the edit form submission.
+func Update(w http.ResponseWriter, r *http.Request) {
+	c := flight.Context(w, r)
+
+	if !c.FormValid(required...) {
+		Edit(w, r)
+		return
+	}
+
+	item, err := itemFromForm(c)
+	if err != nil {
+		c.FlashWarning(err.Error())
+		Edit(w, r)
+		return
+	}
+
+	_, err = model.Invoice.As(c.Actor()).Update(item, c.Param("id"), c.CompanyID)
+	if err == invoice.ErrFinalized {
+		c.FlashWarning(err.Error())
+		c.Redirect(uri + "/view/" + c.Param("id"))
+		return
+	} else if err != nil {
+		c.FlashError(err)
+		Edit(w, r)
+		return
+	}
+
+	c.FlashSuccess("Invoice updated.")
+	c.Redirect(uri)
+}
+
+// Destroy handles the delete form submission.
+func Destroy(w http.ResponseWriter, r *http.Request) {
+	c := flight.Context(w, r)
+
+	_, err := model.Invoice.As(c.Actor()).DeleteSoft(c.Param("id"), c.CompanyID)
+	if err == invoice.ErrFinalized {
+		c.FlashWarning(err.Error())
+	} else if err != nil {
+		c.FlashError(err)
+	} else {
+		c.FlashNotice("Invoice deleted.")
+	}
+
+	c.Redirect(uri)
+}
+
+// SetChoices fills the variables of the series, partner and product
+// drop-downs, and the line rows: the submitted ones if any, else lines, plus
+// blank rows. The rows without a unit price of their own are offered with
+// an empty price and VAT rate, to be taken from the product.
+func SetChoices(c *flight.Info, vars map[string]interface{}, lines []invoice.Line) {
+	seriesList, _, err := model.Series.ByCompanyID(c.CompanyID)
+	if err != nil {
+		c.FlashError(err)
+		seriesList = []series.Item{}
+	}
+	vars["series"] = seriesList
+
+	partners, _, err := model.Partner.ByCompanyID(c.CompanyID)
+	if err != nil {
+		c.FlashError(err)
+		partners = []partner.Item{}
+	}
+	vars["partners"] = partners
+
+	products, _, err := model.Product.ByCompanyID(c.CompanyID)
+	if err != nil {
+		c.FlashError(err)
+		products = []product.Item{}
+	}
+	vars["products"] = products
+
+	priced := make([]bool, len(lines))
+	for i := range priced {
+		priced[i] = true
+	}
+	if _, ok := c.R.Form["line_description"]; ok {
+		lines, priced, _ = LinesFromForm(c.R)
+	}
+	for i := 0; i < blankLines; i++ {
+		lines = append(lines, invoice.Line{Quantity: money.New(1, 0)})
+		priced = append(priced, false)
+	}
+	vars["lines"] = lines
+	vars["priced"] = priced
+	vars["vat_rates"] = invoice.VATRates
+	vars["payment_methods"] = []string{invoice.PaymentTransfer, invoice.PaymentCash,
+		invoice.PaymentCard, invoice.PaymentVoucher, invoice.PaymentOther}
+	vars["roundings"] = []string{invoice.RoundPerLine, invoice.RoundPerRate}
+}
+
+// itemFromForm reads and validates the submitted invoice. When a partner is
+// picked, the buyer is copied from the registry, and the lines with a picked
+// product get their empty fields from the catalogue.
+func itemFromForm(c *flight.Info) (invoice.Item, error) {
+	r := c.R
+	item := invoice.Item{
+		SellerName:      r.FormValue("seller_name"),
+		SellerAddress:   r.FormValue("seller_address"),
+		SellerTaxNumber: r.FormValue("seller_tax_number"),
+		BuyerName:       r.FormValue("buyer_name"),
+		BuyerAddress:    r.FormValue("buyer_address"),
+		BuyerTaxNumber:  r.FormValue("buyer_tax_number"),
+		Currency:        strings.ToUpper(r.FormValue("currency")),
+		PaymentMethod:   r.FormValue("payment_method"),
+		Rounding:        r.FormValue("rounding"),
+	}
+	var priced []bool
+	var err error
+	if item.Lines, priced, err = LinesFromForm(r); err != nil {
+		return item, err
+	}
+	if _, err := fmt.Sscan(r.FormValue("series_id"), &item.SeriesID); err != nil {
+		return item, fmt.Errorf("unknown series %q", r.FormValue("series_id"))
+	}
+
+	if err = datesFromForm(r, &item); err != nil {
+		return item, err
+	}
+
+	if ID := r.FormValue("partner_id"); ID != "" {
+		p, _, err := model.Partner.ByID(ID, c.CompanyID)
+		if err != nil {
+			return item, fmt.Errorf("unknown partner %q", ID)
+		}
+		item.PartnerID.SetValid(int64(p.ID))
+		item.BuyerName = p.Name
+		item.BuyerAddress = p.Address()
+		item.BuyerTaxNumber = p.TaxNumber
+		if item.BuyerTaxNumber == "" {
+			item.BuyerTaxNumber = p.EUVATNumber
+		}
+	}
+
+	if err = FillProducts(c, item.Lines, priced); err != nil {
+		return item, err
+	}
+	return item, item.Validate()
+}
+
+// datesFromForm reads the dates of the submitted invoice.
+func datesFromForm(r *http.Request, item *invoice.Item) error {
+	for _, d := range []struct {
+		field string
+		dest  *time.Time
+	}{
+		{"issue_date", &item.IssueDate},
+		{"fulfilment_date", &item.FulfilmentDate},
+		{"due_date", &item.DueDate},
+	} {
+		var err error
+		if *d.dest, err = time.Parse(dateLayout, r.FormValue(d.field)); err != nil {
+			return fmt.Errorf("%s: %q is not a date", d.field, r.FormValue(d.field))
+		}
+	}
+	return nil
+}
+
+// FillProducts fills the empty fields of the lines with a picked product
+// from the catalogue. The lines which are not priced get the price of the
+// product too.
+func FillProducts(c *flight.Info, lines []invoice.Line, priced []bool) error {
+	for i, line := range lines {
+		if !line.ProductID.Valid {
+			continue
+		}
+		p, noRows, err := model.Product.ByID(fmt.Sprint(line.ProductID.Int64), c.CompanyID)
+		if noRows {
+			return fmt.Errorf("line %d: unknown product %d", i+1, line.ProductID.Int64)
+		} else if err != nil {
+			return err
+		}
+		p.Fill(&lines[i], priced[i])
+	}
+	return nil
+}
+
+// LinesFromForm reads the line rows of the form, dropping the ones with
+// neither a description nor a product, and tells which rows got a unit price.
+// The rows are returned even when a number cannot be read, to be offered
+// again.
+func LinesFromForm(r *http.Request) ([]invoice.Line, []bool, error) {
+	var firstErr error
+	var lines []invoice.Line
+	var priced []bool
+	descriptions := r.Form["line_description"]
+	value := func(name string, i int) string {
+		if values := r.Form[name]; i < len(values) {
+			return strings.TrimSpace(values[i])
+		}
+		return ""
+	}
+	for i := range descriptions {
+		line := invoice.Line{
+			Description: value("line_description", i),
+			Unit:        value("line_unit", i),
+			VATRate:     value("line_vat_rate", i),
+		}
+		if ID, err := strconv.ParseInt(value("line_product_id", i), 10, 64); err == nil {
+			line.ProductID.SetValid(ID)
+		}
+		if line.Description == "" && !line.ProductID.Valid {
+			continue
+		}
+		var err error
+		if line.Quantity, err = money.Parse(value("line_quantity", i)); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("line %d: quantity: %v", len(lines)+1, err)
+		}
+		// An empty price is taken from the product.
+		price := value("line_unit_price", i)
+		if price != "" || !line.ProductID.Valid {
+			if line.UnitPrice, err = money.Parse(price); err != nil && firstErr == nil {
+				firstErr = fmt.Errorf("line %d: unit price: %v", len(lines)+1, err)
+			}
+		}
+		lines = append(lines, line)
+		priced = append(priced, price != "")
+	}
+	return lines, priced, firstErr
+}
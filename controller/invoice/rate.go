@@ -0,0 +1,27 @@
+package invoice
+
+import (
+	"context"
+	"time"
+
+	"github.com/UNO-SOFT/szamlazo/lib/flight"
+	"github.com/UNO-SOFT/szamlazo/lib/mnb"
+	"github.com/UNO-SOFT/szamlazo/model"
+	"github.com/UNO-SOFT/szamlazo/model/invoice"
+)
+
+// rateTimeout limits the wait for the rates of the MNB.
+const rateTimeout = 20 * time.Second
+
+// EnsureRate fetches the MNB exchange rate of the fulfilment date of a
+// foreign currency invoice, unless it is stored already, so the invoice can
+// be issued with it.
+func EnsureRate(item invoice.Item) error {
+	if !item.Foreign() {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), rateTimeout)
+	defer cancel()
+	_, err := mnb.Ensure(ctx, flight.MNB().Source(), model.Rate, item.Currency, item.FulfilmentDate)
+	return err
+}
@@ -0,0 +1,195 @@
+package invoice
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/UNO-SOFT/szamlazo/lib/flight"
+	"github.com/UNO-SOFT/szamlazo/lib/jobqueue"
+	"github.com/UNO-SOFT/szamlazo/lib/nav"
+	"github.com/UNO-SOFT/szamlazo/lib/scheduler"
+	"github.com/UNO-SOFT/szamlazo/model"
+	"github.com/UNO-SOFT/szamlazo/model/audit"
+)
+
+// Kinds of the background jobs of the invoices.
+const (
+	jobReport       = "invoice.report"
+	jobReportStatus = "invoice.report_status"
+)
+
+// Names of the periodic tasks of the invoices.
+const (
+	taskDunning   = "invoice.dunning"
+	taskNAVStatus = "invoice.nav_status"
+)
+
+// statusDelay is the wait before first querying the result of a report,
+// later queries back off like the retries.
+var statusDelay = 5 * time.Second
+
+// reportJob is the payload of the reporting jobs.
+type reportJob struct {
+	ID            string `json:"id"`
+	CompanyID     string `json:"company_id"`
+	TransactionID string `json:"transaction_id,omitempty"`
+}
+
+// loadJobs registers the background jobs and the periodic tasks.
+func loadJobs() {
+	jobqueue.Handle(jobReport, report)
+	jobqueue.Handle(jobReportStatus, reportStatus)
+	jobqueue.Handle(jobEmail, sendEmail)
+
+	scheduler.Register(taskDunning, "@every 1h", dun)
+	scheduler.Register(taskNAVStatus, "*/15 * * * *", pollReports)
+}
+
+// IssueAndReport issues a draft and schedules its report to NAV in one
+// transaction, so that no invoice is issued without its report.
+func IssueAndReport(actor audit.Actor, ID string, companyID string, userID string, issued time.Time) error {
+	return model.Transaction(func(tx model.Tx) error {
+		if err := tx.Invoice.As(actor).Issue(ID, companyID, userID, issued); err != nil {
+			return err
+		}
+		return EnqueueReport(tx.Job, ID, companyID)
+	})
+}
+
+// EnqueueReport schedules sending an invoice to NAV with jobs, which is to be
+// bound to the transaction issuing the invoice.
+func EnqueueReport(jobs jobqueue.Enqueuer, ID string, companyID string) error {
+	if !flight.NAV().Enabled() {
+		return nil
+	}
+	return jobqueue.EnqueueIn(jobs, jobReport, reportJob{ID: ID, CompanyID: companyID}, 0)
+}
+
+// report sends an invoice to NAV and schedules querying the result. Failures
+// are recorded with the invoice, the job is retried unless the invoice
+// cannot be reported at all. An invoice NAV already accepted is not sent
+// again, only its result is queried.
+func report(ctx context.Context, payload []byte) error {
+	var j reportJob
+	if err := json.Unmarshal(payload, &j); err != nil {
+		return jobqueue.Permanent(err)
+	}
+	info := flight.NAV()
+	if !info.Enabled() {
+		return nil
+	}
+	fail := func(err error) error {
+		if _, dbErr := model.Invoice.SetNAVStatus(j.ID, "", nav.StatusFailed, err.Error()); dbErr != nil {
+			log.Println(dbErr)
+		}
+		return err
+	}
+
+	item, noRows, err := model.Invoice.ByID(j.ID, j.CompanyID)
+	if noRows {
+		return jobqueue.Permanent(fmt.Errorf("invoice %s not found", j.ID))
+	} else if err != nil {
+		return err
+	}
+	if item.NAVTxID.String != "" && item.NAVStatus != nav.StatusFailed {
+		if finished(item.NAVStatus) {
+			return nil
+		}
+		j.TransactionID = item.NAVTxID.String
+		return jobqueue.Enqueue(jobReportStatus, j, statusDelay)
+	}
+	d, err := nav.NewInvoiceData(item, item.ExchangeRate)
+	if err != nil {
+		return fail(jobqueue.Permanent(err))
+	}
+	data, err := d.Marshal()
+	if err != nil {
+		return fail(jobqueue.Permanent(err))
+	}
+
+	txID, err := nav.NewClient(*info).Report(ctx, nav.Operation{Operation: nav.InvoiceOperation(item), Data: data})
+	if err != nil {
+		return fail(err)
+	}
+	if _, err = model.Invoice.SetNAVStatus(j.ID, txID, nav.StatusReceived, ""); err != nil {
+		return fmt.Errorf("transaction %s is not recorded: %v", txID, err)
+	}
+	j.TransactionID = txID
+	return jobqueue.Enqueue(jobReportStatus, j, statusDelay)
+}
+
+// reportStatus records the result of a report. It fails, so that it is
+// retried later, while NAV is still processing the invoice.
+func reportStatus(ctx context.Context, payload []byte) error {
+	var j reportJob
+	if err := json.Unmarshal(payload, &j); err != nil {
+		return jobqueue.Permanent(err)
+	}
+	status, err := queryReport(ctx, j)
+	if err != nil {
+		return err
+	}
+	if !finished(status) {
+		return fmt.Errorf("transaction %s is %s", j.TransactionID, status)
+	}
+	return nil
+}
+
+// pollReports is the periodic task recording the results of the reports
+// NAV was still processing when their status jobs gave up. The run fails
+// when any of the queries failed.
+func pollReports(ctx context.Context, at time.Time) (string, error) {
+	if !flight.NAV().Enabled() {
+		return "reporting is off", nil
+	}
+	items, _, err := model.Invoice.ByNAVStatus(nav.StatusReceived, nav.StatusProcessing, nav.StatusSaved)
+	if err != nil {
+		return "", err
+	}
+	n, failed := 0, 0
+	var last error
+	for _, item := range items {
+		if err = ctx.Err(); err != nil {
+			return fmt.Sprintf("%d of %d reports finished", n, len(items)), err
+		}
+		status, err := queryReport(ctx, reportJob{
+			ID:            fmt.Sprint(item.ID),
+			CompanyID:     fmt.Sprint(item.CompanyID),
+			TransactionID: item.NAVTxID.String,
+		})
+		if err != nil {
+			log.Printf("nav status: invoice %d: %v", item.ID, err)
+			failed, last = failed+1, err
+		} else if finished(status) {
+			n++
+		}
+	}
+	summary := fmt.Sprintf("%d of %d reports finished", n, len(items))
+	if failed > 0 {
+		return summary, fmt.Errorf("%d of %d queries failed, the last: %v", failed, len(items), last)
+	}
+	return summary, nil
+}
+
+// queryReport records the result of a report, and returns the status of the
+// invoice at NAV.
+func queryReport(ctx context.Context, j reportJob) (string, error) {
+	results, err := nav.NewClient(*flight.NAV()).QueryTransactionStatus(ctx, j.TransactionID)
+	if err != nil {
+		return "", err
+	}
+	if len(results) == 0 {
+		return "", fmt.Errorf("no result for transaction %s yet", j.TransactionID)
+	}
+	r := results[0]
+	_, err = model.Invoice.SetNAVStatus(j.ID, j.TransactionID, r.InvoiceStatus, r.Messages())
+	return r.InvoiceStatus, err
+}
+
+// finished reports whether NAV is done with an invoice of the status.
+func finished(status string) bool {
+	return status == nav.StatusDone || status == nav.StatusAborted
+}
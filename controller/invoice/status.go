@@ -0,0 +1,78 @@
+package invoice
+
+import (
+	"net/http"
+
+	"github.com/UNO-SOFT/szamlazo/lib/flight"
+	"github.com/UNO-SOFT/szamlazo/model"
+	"github.com/UNO-SOFT/szamlazo/model/invoice"
+)
+
+// statusLabels are the buttons of the statuses set by hand.
+var statusLabels = map[string]string{
+	invoice.StatusSent:          "Mark as Sent",
+	invoice.StatusPartiallyPaid: "Mark as Partially Paid",
+	invoice.StatusPaid:          "Mark as Paid",
+	invoice.StatusOverdue:       "Mark as Overdue",
+}
+
+// statusAction is a button moving an invoice to another status.
+type statusAction struct {
+	Status string
+	Label  string
+}
+
+// statusActions returns the buttons of the statuses the item can be moved to
+// by hand. Issuing and cancelling have their own buttons.
+func statusActions(item invoice.Item) []statusAction {
+	var actions []statusAction
+	for _, status := range item.Transitions() {
+		label, ok := statusLabels[status]
+		if !ok || status == invoice.StatusOverdue && !item.DueDate.Before(today()) {
+			continue
+		}
+		actions = append(actions, statusAction{status, label})
+	}
+	return actions
+}
+
+// Issue handles the issuing of a draft: it is numbered, dated today and
+// reported.
+func Issue(w http.ResponseWriter, r *http.Request) {
+	c := flight.Context(w, r)
+
+	item, _, err := model.Invoice.ByID(c.Param("id"), c.CompanyID)
+	if err != nil {
+		c.FlashError(err)
+		c.Redirect(uri)
+		return
+	}
+	if err = EnsureRate(item); err != nil {
+		c.FlashWarning(err.Error())
+		c.Redirect(uri + "/view/" + c.Param("id"))
+		return
+	}
+
+	if err = IssueAndReport(c.Actor(), c.Param("id"), c.CompanyID, c.UserID, today()); err != nil {
+		c.FlashWarning(err.Error())
+		c.Redirect(uri + "/view/" + c.Param("id"))
+		return
+	}
+
+	c.FlashSuccess("Invoice issued.")
+	c.Redirect(uri + "/view/" + c.Param("id"))
+}
+
+// SetStatus handles the status buttons.
+func SetStatus(w http.ResponseWriter, r *http.Request) {
+	c := flight.Context(w, r)
+
+	status := r.FormValue("status")
+	if err := model.Invoice.As(c.Actor()).SetStatus(c.Param("id"), status, c.CompanyID, c.UserID, r.FormValue("note"), today()); err != nil {
+		c.FlashWarning(err.Error())
+	} else {
+		c.FlashSuccess("Invoice status changed.")
+	}
+
+	c.Redirect(uri + "/view/" + c.Param("id"))
+}
@@ -3,6 +3,7 @@ package login
 
 import (
 	"errors"
+	"fmt"
 	"net/http"
 
 	"github.com/UNO-SOFT/szamlazo/lib/flight"
@@ -67,6 +68,12 @@ func Store(w http.ResponseWriter, r *http.Request) {
 			c.Sess.Values["id"] = result.ID
 			c.Sess.Values["email"] = email
 			c.Sess.Values["first_name"] = result.FirstName
+			// Start working for the first company of the user
+			companies, _, err := model.Company.ByUserID(fmt.Sprint(result.ID))
+			if err == nil && len(companies) > 0 {
+				c.Sess.Values["company_id"] = fmt.Sprint(companies[0].CompanyID)
+				c.Sess.Values["company_name"] = companies[0].Name
+			}
 			c.Sess.Save(r, w)
 			http.Redirect(w, r, "/", http.StatusFound)
 			return
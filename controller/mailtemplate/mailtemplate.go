@@ -0,0 +1,186 @@
+// Package mailtemplate provides the texts of the invoice mails of the active
+// company.
+package mailtemplate
+
+import (
+	"net/http"
+
+	"github.com/UNO-SOFT/szamlazo/lib/flight"
+	"github.com/UNO-SOFT/szamlazo/middleware/acl"
+	"github.com/UNO-SOFT/szamlazo/model"
+	"github.com/UNO-SOFT/szamlazo/model/mailtemplate"
+
+	"github.com/blue-jay/core/router"
+)
+
+var (
+	uri = "/mailtemplate"
+
+	// fields are the form fields of a template.
+	fields = []string{"kind", "language", "subject", "body"}
+)
+
+// Load the routes.
+func Load() {
+	c := router.Chain(acl.DisallowAnon, acl.Require("company.manage"))
+	router.Get(uri, Index, c...)
+	router.Get(uri+"/create", Create, c...)
+	router.Post(uri+"/create", Store, c...)
+	router.Get(uri+"/edit/:id", Edit, c...)
+	router.Patch(uri+"/edit/:id", Update, c...)
+	router.Delete(uri+"/:id", Destroy, c...)
+}
+
+// Index displays the items, and the defaults of the kinds and languages
+// without one.
+func Index(w http.ResponseWriter, r *http.Request) {
+	c := flight.Context(w, r)
+
+	items, _, err := model.MailTemplate.ByCompanyID(c.CompanyID)
+	if err != nil {
+		c.FlashError(err)
+		items = []mailtemplate.Item{}
+	}
+
+	// The built-in texts of the kinds and languages without a template
+	own := make(map[string]bool, len(items))
+	for _, item := range items {
+		own[item.Kind+"/"+item.Language] = true
+	}
+	var defaults []mailtemplate.Item
+	for _, kind := range mailtemplate.Kinds {
+		for _, language := range []string{"hu", "en"} {
+			if !own[kind+"/"+language] {
+				defaults = append(defaults, mailtemplate.Defaults[kind][language])
+			}
+		}
+	}
+
+	v := c.View.New("mailtemplate/index")
+	v.Vars["items"] = items
+	v.Vars["defaults"] = defaults
+	v.Render(w, r)
+}
+
+// Create displays the create form, filled with the default of the kind and
+// the language.
+func Create(w http.ResponseWriter, r *http.Request) {
+	c := flight.Context(w, r)
+
+	kind := r.FormValue("kind")
+	if _, ok := mailtemplate.Defaults[kind]; !ok {
+		kind = mailtemplate.KindInvoice
+	}
+	language := r.FormValue("language")
+	if language == "" {
+		language = "hu"
+	}
+	d := mailtemplate.Default(kind, language)
+
+	v := c.View.New("mailtemplate/create")
+	v.Vars["kinds"] = mailtemplate.Kinds
+	v.Vars["kind"] = kind
+	v.Vars["language"] = language
+	v.Vars["subject"] = d.Subject
+	v.Vars["body"] = d.Body
+	c.Repopulate(v.Vars, fields...)
+	v.Render(w, r)
+}
+
+// Store handles the create form submission.
+func Store(w http.ResponseWriter, r *http.Request) {
+	c := flight.Context(w, r)
+
+	if !c.FormValid(fields...) {
+		Create(w, r)
+		return
+	}
+
+	item := itemFromForm(r)
+	item.CompanyID = c.Company()
+	if err := item.Normalize(); err != nil {
+		c.FlashWarning(err.Error())
+		Create(w, r)
+		return
+	}
+
+	if _, err := model.MailTemplate.As(c.Actor()).Create(item); err != nil {
+		c.FlashError(err)
+		Create(w, r)
+		return
+	}
+
+	c.FlashSuccess("Mail template added.")
+	c.Redirect(uri)
+}
+
+// Edit displays the edit form.
+func Edit(w http.ResponseWriter, r *http.Request) {
+	c := flight.Context(w, r)
+
+	item, _, err := model.MailTemplate.ByID(c.Param("id"), c.CompanyID)
+	if err != nil {
+		c.FlashError(err)
+		c.Redirect(uri)
+		return
+	}
+
+	v := c.View.New("mailtemplate/edit")
+	v.Vars["kinds"] = mailtemplate.Kinds
+	v.Vars["kind"] = item.Kind
+	v.Vars["language"] = item.Language
+	v.Vars["subject"] = item.Subject
+	v.Vars["body"] = item.Body
+	c.Repopulate(v.Vars, fields...)
+	v.Vars["item"] = item
+	v.Render(w, r)
+}
+
+// Update handles the edit form submission.
+func Update(w http.ResponseWriter, r *http.Request) {
+	c := flight.Context(w, r)
+
+	if !c.FormValid(fields...) {
+		Edit(w, r)
+		return
+	}
+
+	item := itemFromForm(r)
+	if err := item.Normalize(); err != nil {
+		c.FlashWarning(err.Error())
+		Edit(w, r)
+		return
+	}
+
+	if _, err := model.MailTemplate.As(c.Actor()).Update(item, c.Param("id"), c.CompanyID); err != nil {
+		c.FlashError(err)
+		Edit(w, r)
+		return
+	}
+
+	c.FlashSuccess("Mail template updated.")
+	c.Redirect(uri)
+}
+
+// Destroy handles the delete form submission.
+func Destroy(w http.ResponseWriter, r *http.Request) {
+	c := flight.Context(w, r)
+
+	if _, err := model.MailTemplate.As(c.Actor()).DeleteSoft(c.Param("id"), c.CompanyID); err != nil {
+		c.FlashError(err)
+	} else {
+		c.FlashNotice("Mail template deleted.")
+	}
+
+	c.Redirect(uri)
+}
+
+// itemFromForm reads a template from the submitted form.
+func itemFromForm(r *http.Request) mailtemplate.Item {
+	return mailtemplate.Item{
+		Kind:     r.FormValue("kind"),
+		Language: r.FormValue("language"),
+		Subject:  r.FormValue("subject"),
+		Body:     r.FormValue("body"),
+	}
+}
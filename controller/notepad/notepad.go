@@ -61,7 +61,7 @@ func Store(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	_, err := model.Note.Create(r.FormValue("name"), c.UserID)
+	_, err := model.Note.As(c.Actor()).Create(r.FormValue("name"), c.UserID)
 	if err != nil {
 		c.FlashError(err)
 		Create(w, r)
@@ -114,7 +114,7 @@ func Update(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	_, err := model.Note.Update(r.FormValue("name"), c.Param("id"), c.UserID)
+	_, err := model.Note.As(c.Actor()).Update(r.FormValue("name"), c.Param("id"), c.UserID)
 	if err != nil {
 		c.FlashError(err)
 		Edit(w, r)
@@ -129,7 +129,7 @@ func Update(w http.ResponseWriter, r *http.Request) {
 func Destroy(w http.ResponseWriter, r *http.Request) {
 	c := flight.Context(w, r)
 
-	_, err := model.Note.DeleteSoft(c.Param("id"), c.UserID)
+	_, err := model.Note.As(c.Actor()).DeleteSoft(c.Param("id"), c.UserID)
 	if err != nil {
 		c.FlashError(err)
 	} else {
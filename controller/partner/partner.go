@@ -0,0 +1,186 @@
+// Package partner provides the registry of customers and suppliers.
+package partner
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/UNO-SOFT/szamlazo/lib/flight"
+	"github.com/UNO-SOFT/szamlazo/middleware/acl"
+	"github.com/UNO-SOFT/szamlazo/model"
+	"github.com/UNO-SOFT/szamlazo/model/partner"
+
+	"github.com/blue-jay/core/router"
+)
+
+var (
+	uri = "/partner"
+
+	// fields are the form fields of a partner.
+	fields = []string{"name", "country_code", "postal_code", "city", "street",
+		"tax_number", "eu_vat_number", "group_id",
+		"email", "email_cc", "email_bcc", "language", "dunning_paused", "bank_accounts"}
+)
+
+// Load the routes.
+func Load() {
+	c := router.Chain(acl.DisallowAnon, acl.Require("partner.view"))
+	edit := router.Chain(acl.DisallowAnon, acl.Require("partner.edit"))
+	router.Get(uri, Index, c...)
+	router.Get(uri+"/create", Create, edit...)
+	router.Post(uri+"/create", Store, edit...)
+	router.Get(uri+"/view/:id", Show, c...)
+	router.Get(uri+"/edit/:id", Edit, edit...)
+	router.Patch(uri+"/edit/:id", Update, edit...)
+	router.Delete(uri+"/:id", Destroy, edit...)
+}
+
+// Index displays the items.
+func Index(w http.ResponseWriter, r *http.Request) {
+	c := flight.Context(w, r)
+
+	items, _, err := model.Partner.ByCompanyID(c.CompanyID)
+	if err != nil {
+		c.FlashError(err)
+		items = []partner.Item{}
+	}
+
+	v := c.View.New("partner/index")
+	v.Vars["items"] = items
+	v.Render(w, r)
+}
+
+// Create displays the create form.
+func Create(w http.ResponseWriter, r *http.Request) {
+	c := flight.Context(w, r)
+
+	v := c.View.New("partner/create")
+	v.Vars["country_code"] = "HU"
+	v.Vars["language"] = partner.DefaultLanguage
+	c.Repopulate(v.Vars, fields...)
+	v.Render(w, r)
+}
+
+// Store handles the create form submission.
+func Store(w http.ResponseWriter, r *http.Request) {
+	c := flight.Context(w, r)
+
+	if !c.FormValid("name", "country_code") {
+		Create(w, r)
+		return
+	}
+
+	item := itemFromForm(r)
+	if err := item.Normalize(); err != nil {
+		c.FlashWarning(err.Error())
+		Create(w, r)
+		return
+	}
+
+	item.CompanyID = c.Company()
+	_, err := model.Partner.As(c.Actor()).Create(item, c.UserID)
+	if err != nil {
+		c.FlashError(err)
+		Create(w, r)
+		return
+	}
+
+	c.FlashSuccess("Partner added.")
+	c.Redirect(uri)
+}
+
+// Show displays a single item.
+func Show(w http.ResponseWriter, r *http.Request) {
+	c := flight.Context(w, r)
+
+	item, _, err := model.Partner.ByID(c.Param("id"), c.CompanyID)
+	if err != nil {
+		c.FlashError(err)
+		c.Redirect(uri)
+		return
+	}
+
+	v := c.View.New("partner/show")
+	v.Vars["item"] = item
+	v.Render(w, r)
+}
+
+// Edit displays the edit form.
+func Edit(w http.ResponseWriter, r *http.Request) {
+	c := flight.Context(w, r)
+
+	item, _, err := model.Partner.ByID(c.Param("id"), c.CompanyID)
+	if err != nil {
+		c.FlashError(err)
+		c.Redirect(uri)
+		return
+	}
+
+	v := c.View.New("partner/edit")
+	v.Vars["bank_accounts"] = strings.Join(item.BankAccounts, "\n")
+	c.Repopulate(v.Vars, fields...)
+	v.Vars["item"] = item
+	v.Render(w, r)
+}
+
+// Update handles the edit form submission.
+func Update(w http.ResponseWriter, r *http.Request) {
+	c := flight.Context(w, r)
+
+	if !c.FormValid("name", "country_code") {
+		Edit(w, r)
+		return
+	}
+
+	item := itemFromForm(r)
+	if err := item.Normalize(); err != nil {
+		c.FlashWarning(err.Error())
+		Edit(w, r)
+		return
+	}
+
+	_, err := model.Partner.As(c.Actor()).Update(item, c.Param("id"), c.CompanyID)
+	if err != nil {
+		c.FlashError(err)
+		Edit(w, r)
+		return
+	}
+
+	c.FlashSuccess("Partner updated.")
+	c.Redirect(uri)
+}
+
+// Destroy handles the delete form submission.
+func Destroy(w http.ResponseWriter, r *http.Request) {
+	c := flight.Context(w, r)
+
+	_, err := model.Partner.As(c.Actor()).DeleteSoft(c.Param("id"), c.CompanyID)
+	if err != nil {
+		c.FlashError(err)
+	} else {
+		c.FlashNotice("Partner deleted.")
+	}
+
+	c.Redirect(uri)
+}
+
+// itemFromForm reads a partner from the submitted form, one bank account
+// per line.
+func itemFromForm(r *http.Request) partner.Item {
+	return partner.Item{
+		Name:          r.FormValue("name"),
+		CountryCode:   r.FormValue("country_code"),
+		PostalCode:    r.FormValue("postal_code"),
+		City:          r.FormValue("city"),
+		Street:        r.FormValue("street"),
+		TaxNumber:     r.FormValue("tax_number"),
+		EUVATNumber:   r.FormValue("eu_vat_number"),
+		GroupID:       r.FormValue("group_id"),
+		Email:         r.FormValue("email"),
+		EmailCC:       r.FormValue("email_cc"),
+		EmailBCC:      r.FormValue("email_bcc"),
+		Language:      r.FormValue("language"),
+		DunningPaused: r.FormValue("dunning_paused") != "",
+		BankAccounts:  strings.Split(r.FormValue("bank_accounts"), "\n"),
+	}
+}
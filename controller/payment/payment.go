@@ -0,0 +1,285 @@
+// Package payment provides the recording of incoming payments, their
+// allocation to invoices and the open items.
+package payment
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/UNO-SOFT/szamlazo/lib/flight"
+	"github.com/UNO-SOFT/szamlazo/lib/money"
+	"github.com/UNO-SOFT/szamlazo/middleware/acl"
+	"github.com/UNO-SOFT/szamlazo/model"
+	"github.com/UNO-SOFT/szamlazo/model/invoice"
+	"github.com/UNO-SOFT/szamlazo/model/partner"
+	"github.com/UNO-SOFT/szamlazo/model/payment"
+
+	"github.com/blue-jay/core/router"
+)
+
+var (
+	uri = "/payment"
+
+	// fields are the form fields of a payment.
+	fields = []string{"partner_id", "payer_name", "payment_date", "amount",
+		"currency", "method", "bank_reference"}
+
+	// blankAllocations is the number of empty allocations offered in the
+	// forms.
+	blankAllocations = 3
+)
+
+// dateLayout is the format of the date inputs.
+const dateLayout = "2006-01-02"
+
+// Load the routes.
+func Load() {
+	c := router.Chain(acl.DisallowAnon, acl.Require("payment.view"))
+	edit := router.Chain(acl.DisallowAnon, acl.Require("payment.edit"))
+	router.Get(uri, Index, c...)
+	router.Get(uri+"/create", Create, edit...)
+	router.Post(uri+"/create", Store, edit...)
+	router.Get(uri+"/view/:id", Show, c...)
+	router.Post(uri+"/allocate/:id", Allocate, edit...)
+	router.Get(uri+"/open", OpenItems, c...)
+}
+
+// Index displays the items.
+func Index(w http.ResponseWriter, r *http.Request) {
+	c := flight.Context(w, r)
+
+	items, _, err := model.Payment.ByCompanyID(c.CompanyID)
+	if err != nil {
+		c.FlashError(err)
+		items = []payment.Item{}
+	}
+
+	v := c.View.New("payment/index")
+	v.Vars["items"] = items
+	v.Render(w, r)
+}
+
+// Create displays the create form. Given an invoice_id, the form is filled
+// to pay the balance of that invoice.
+func Create(w http.ResponseWriter, r *http.Request) {
+	c := flight.Context(w, r)
+
+	v := c.View.New("payment/create")
+	v.Vars["payment_date"] = today().Format(dateLayout)
+	v.Vars["currency"] = "HUF"
+	v.Vars["method"] = invoice.PaymentTransfer
+	open := setChoices(c, v.Vars)
+	if ID := r.FormValue("invoice_id"); ID != "" {
+		for _, o := range open {
+			if fmt.Sprint(o.InvoiceID) != ID {
+				continue
+			}
+			if o.PartnerID.Valid {
+				v.Vars["partner_id"] = o.PartnerID.Int64
+			}
+			v.Vars["payer_name"] = o.BuyerName
+			v.Vars["amount"] = o.Balance()
+			v.Vars["currency"] = o.Currency
+			v.Vars["allocations"] = append([]payment.Allocation{{InvoiceID: o.InvoiceID, Amount: o.Balance()}},
+				v.Vars["allocations"].([]payment.Allocation)...)
+		}
+	}
+	c.Repopulate(v.Vars, fields...)
+	v.Render(w, r)
+}
+
+// Store handles the create form submission.
+func Store(w http.ResponseWriter, r *http.Request) {
+	c := flight.Context(w, r)
+
+	if !c.FormValid("payment_date", "amount", "currency", "method") {
+		Create(w, r)
+		return
+	}
+
+	item, err := itemFromForm(c)
+	if err == nil {
+		err = item.Validate()
+	}
+	if err != nil {
+		c.FlashWarning(err.Error())
+		Create(w, r)
+		return
+	}
+
+	item.CompanyID = c.Company()
+	ID, err := model.Payment.As(c.Actor()).Create(item, c.UserID)
+	if err != nil {
+		c.FlashError(err)
+		Create(w, r)
+		return
+	}
+
+	c.FlashSuccess("Payment added.")
+	c.Redirect(fmt.Sprintf("%s/view/%d", uri, ID))
+}
+
+// Show displays a single item with a form to allocate the rest of it.
+func Show(w http.ResponseWriter, r *http.Request) {
+	c := flight.Context(w, r)
+
+	item, _, err := model.Payment.ByID(c.Param("id"), c.CompanyID)
+	if err != nil {
+		c.FlashError(err)
+		c.Redirect(uri)
+		return
+	}
+
+	v := c.View.New("payment/show")
+	v.Vars["item"] = item
+	if item.Unallocated().Sign() > 0 {
+		setChoices(c, v.Vars)
+	}
+	v.Render(w, r)
+}
+
+// Allocate handles the allocation form submission.
+func Allocate(w http.ResponseWriter, r *http.Request) {
+	c := flight.Context(w, r)
+
+	allocations, err := allocationsFromForm(r)
+	if err != nil {
+		c.FlashWarning(err.Error())
+		c.Redirect(uri + "/view/" + c.Param("id"))
+		return
+	}
+	if len(allocations) == 0 {
+		c.FlashWarning("No invoice picked.")
+		c.Redirect(uri + "/view/" + c.Param("id"))
+		return
+	}
+
+	err = model.Payment.As(c.Actor()).Allocate(c.Param("id"), allocations, c.CompanyID, c.UserID)
+	if _, ok := err.(payment.RefusedError); ok {
+		c.FlashWarning(err.Error())
+	} else if err != nil {
+		c.FlashError(err)
+	} else {
+		c.FlashSuccess("Payment allocated.")
+	}
+
+	c.Redirect(uri + "/view/" + c.Param("id"))
+}
+
+// OpenItems displays what each partner still owes.
+func OpenItems(w http.ResponseWriter, r *http.Request) {
+	c := flight.Context(w, r)
+
+	debtors, err := model.Payment.OpenItems(c.CompanyID, today())
+	if err != nil {
+		c.FlashError(err)
+		debtors = []payment.Debtor{}
+	}
+
+	v := c.View.New("payment/open")
+	v.Vars["debtors"] = debtors
+	v.Vars["today"] = today()
+	v.Render(w, r)
+}
+
+// setChoices fills the lists offered by the forms, and returns the open
+// items.
+func setChoices(c *flight.Info, vars map[string]interface{}) []payment.OpenItem {
+	partners, _, err := model.Partner.ByCompanyID(c.CompanyID)
+	if err != nil {
+		c.FlashError(err)
+		partners = []partner.Item{}
+	}
+	vars["partners"] = partners
+
+	debtors, err := model.Payment.OpenItems(c.CompanyID, today())
+	if err != nil {
+		c.FlashError(err)
+	}
+	var open []payment.OpenItem
+	for _, d := range debtors {
+		for _, o := range d.Items {
+			if o.Balance().Sign() > 0 {
+				open = append(open, o)
+			}
+		}
+	}
+	vars["open_items"] = open
+
+	var allocations []payment.Allocation
+	if _, ok := c.R.Form["allocation_invoice_id"]; ok {
+		allocations, _ = allocationsFromForm(c.R)
+	}
+	for i := 0; i < blankAllocations; i++ {
+		allocations = append(allocations, payment.Allocation{})
+	}
+	vars["allocations"] = allocations
+	vars["methods"] = []string{invoice.PaymentTransfer, invoice.PaymentCash,
+		invoice.PaymentCard, invoice.PaymentVoucher, invoice.PaymentOther}
+	return open
+}
+
+// itemFromForm reads the submitted payment with its allocations. When a
+// partner is picked, the payer is named after it.
+func itemFromForm(c *flight.Info) (payment.Item, error) {
+	r := c.R
+	item := payment.Item{
+		PayerName:     strings.TrimSpace(r.FormValue("payer_name")),
+		Currency:      strings.ToUpper(strings.TrimSpace(r.FormValue("currency"))),
+		Method:        r.FormValue("method"),
+		BankReference: strings.TrimSpace(r.FormValue("bank_reference")),
+	}
+	var err error
+	if item.Date, err = time.Parse(dateLayout, r.FormValue("payment_date")); err != nil {
+		return item, fmt.Errorf("payment date: %v", err)
+	}
+	if item.Amount, err = money.Parse(strings.TrimSpace(r.FormValue("amount"))); err != nil {
+		return item, fmt.Errorf("amount: %v", err)
+	}
+	if ID := r.FormValue("partner_id"); ID != "" {
+		p, _, err := model.Partner.ByID(ID, c.CompanyID)
+		if err != nil {
+			return item, err
+		}
+		item.PartnerID.SetValid(int64(p.ID))
+		if item.PayerName == "" {
+			item.PayerName = p.Name
+		}
+	}
+	item.Allocations, err = allocationsFromForm(r)
+	return item, err
+}
+
+// allocationsFromForm reads the submitted allocations, skipping the ones
+// without an invoice.
+func allocationsFromForm(r *http.Request) ([]payment.Allocation, error) {
+	var firstErr error
+	var allocations []payment.Allocation
+	value := func(name string, i int) string {
+		if values := r.Form[name]; i < len(values) {
+			return strings.TrimSpace(values[i])
+		}
+		return ""
+	}
+	for i := range r.Form["allocation_invoice_id"] {
+		ID, err := strconv.ParseUint(value("allocation_invoice_id", i), 10, 32)
+		if err != nil {
+			continue
+		}
+		a := payment.Allocation{InvoiceID: uint32(ID)}
+		if a.Amount, err = money.Parse(value("allocation_amount", i)); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("allocation %d: amount: %v", len(allocations)+1, err)
+		}
+		allocations = append(allocations, a)
+	}
+	return allocations, firstErr
+}
+
+// today returns the date of today.
+func today() time.Time {
+	y, m, d := time.Now().Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
@@ -0,0 +1,195 @@
+// Package product provides the catalogue of goods and services.
+package product
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/UNO-SOFT/szamlazo/lib/flight"
+	"github.com/UNO-SOFT/szamlazo/lib/money"
+	"github.com/UNO-SOFT/szamlazo/middleware/acl"
+	"github.com/UNO-SOFT/szamlazo/model"
+	"github.com/UNO-SOFT/szamlazo/model/invoice"
+	"github.com/UNO-SOFT/szamlazo/model/product"
+
+	"github.com/blue-jay/core/router"
+)
+
+var (
+	uri = "/product"
+
+	// fields are the form fields of a product.
+	fields = []string{"sku", "name", "unit", "unit_price", "vat_rate", "code_type", "code"}
+
+	// codeTypes are the classification code types offered.
+	codeTypes = []string{product.CodeVTSZ, product.CodeSZJ, product.CodeOwn}
+)
+
+// Load the routes.
+func Load() {
+	c := router.Chain(acl.DisallowAnon, acl.Require("product.view"))
+	edit := router.Chain(acl.DisallowAnon, acl.Require("product.edit"))
+	router.Get(uri, Index, c...)
+	router.Get(uri+"/create", Create, edit...)
+	router.Post(uri+"/create", Store, edit...)
+	router.Get(uri+"/view/:id", Show, c...)
+	router.Get(uri+"/edit/:id", Edit, edit...)
+	router.Patch(uri+"/edit/:id", Update, edit...)
+	router.Delete(uri+"/:id", Destroy, edit...)
+}
+
+// Index displays the items.
+func Index(w http.ResponseWriter, r *http.Request) {
+	c := flight.Context(w, r)
+
+	items, _, err := model.Product.ByCompanyID(c.CompanyID)
+	if err != nil {
+		c.FlashError(err)
+		items = []product.Item{}
+	}
+
+	v := c.View.New("product/index")
+	v.Vars["items"] = items
+	v.Render(w, r)
+}
+
+// Create displays the create form.
+func Create(w http.ResponseWriter, r *http.Request) {
+	c := flight.Context(w, r)
+
+	v := c.View.New("product/create")
+	v.Vars["vat_rate"] = invoice.VAT27
+	c.Repopulate(v.Vars, fields...)
+	v.Vars["vat_rates"] = invoice.VATRates
+	v.Vars["code_types"] = codeTypes
+	v.Render(w, r)
+}
+
+// Store handles the create form submission.
+func Store(w http.ResponseWriter, r *http.Request) {
+	c := flight.Context(w, r)
+
+	if !c.FormValid("sku", "name", "unit", "unit_price", "vat_rate") {
+		Create(w, r)
+		return
+	}
+
+	item, err := itemFromForm(r)
+	if err == nil {
+		err = item.Validate()
+	}
+	if err != nil {
+		c.FlashWarning(err.Error())
+		Create(w, r)
+		return
+	}
+
+	item.CompanyID = c.Company()
+	_, err = model.Product.As(c.Actor()).Create(item, c.UserID)
+	if err != nil {
+		c.FlashError(err)
+		Create(w, r)
+		return
+	}
+
+	c.FlashSuccess("Product added.")
+	c.Redirect(uri)
+}
+
+// Show displays a single item.
+func Show(w http.ResponseWriter, r *http.Request) {
+	c := flight.Context(w, r)
+
+	item, _, err := model.Product.ByID(c.Param("id"), c.CompanyID)
+	if err != nil {
+		c.FlashError(err)
+		c.Redirect(uri)
+		return
+	}
+
+	v := c.View.New("product/show")
+	v.Vars["item"] = item
+	v.Render(w, r)
+}
+
+// Edit displays the edit form.
+func Edit(w http.ResponseWriter, r *http.Request) {
+	c := flight.Context(w, r)
+
+	item, _, err := model.Product.ByID(c.Param("id"), c.CompanyID)
+	if err != nil {
+		c.FlashError(err)
+		c.Redirect(uri)
+		return
+	}
+
+	v := c.View.New("product/edit")
+	v.Vars["vat_rate"] = item.VATRate
+	v.Vars["code_type"] = item.CodeType
+	c.Repopulate(v.Vars, fields...)
+	v.Vars["vat_rates"] = invoice.VATRates
+	v.Vars["code_types"] = codeTypes
+	v.Vars["item"] = item
+	v.Render(w, r)
+}
+
+// Update handles the edit form submission.
+func Update(w http.ResponseWriter, r *http.Request) {
+	c := flight.Context(w, r)
+
+	if !c.FormValid("sku", "name", "unit", "unit_price", "vat_rate") {
+		Edit(w, r)
+		return
+	}
+
+	item, err := itemFromForm(r)
+	if err == nil {
+		err = item.Validate()
+	}
+	if err != nil {
+		c.FlashWarning(err.Error())
+		Edit(w, r)
+		return
+	}
+
+	_, err = model.Product.As(c.Actor()).Update(item, c.Param("id"), c.CompanyID)
+	if err != nil {
+		c.FlashError(err)
+		Edit(w, r)
+		return
+	}
+
+	c.FlashSuccess("Product updated.")
+	c.Redirect(uri)
+}
+
+// Destroy handles the delete form submission.
+func Destroy(w http.ResponseWriter, r *http.Request) {
+	c := flight.Context(w, r)
+
+	_, err := model.Product.As(c.Actor()).DeleteSoft(c.Param("id"), c.CompanyID)
+	if err != nil {
+		c.FlashError(err)
+	} else {
+		c.FlashNotice("Product deleted.")
+	}
+
+	c.Redirect(uri)
+}
+
+// itemFromForm reads a product from the submitted form.
+func itemFromForm(r *http.Request) (product.Item, error) {
+	item := product.Item{
+		SKU:      r.FormValue("sku"),
+		Name:     r.FormValue("name"),
+		Unit:     r.FormValue("unit"),
+		VATRate:  r.FormValue("vat_rate"),
+		CodeType: r.FormValue("code_type"),
+		Code:     r.FormValue("code"),
+	}
+	var err error
+	if item.UnitPrice, err = money.Parse(r.FormValue("unit_price")); err != nil {
+		return item, fmt.Errorf("unit price: %v", err)
+	}
+	return item, nil
+}
@@ -0,0 +1,124 @@
+// Package profile provides the profile page of the user, with the API tokens
+// machine clients authenticate with.
+package profile
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/UNO-SOFT/szamlazo/lib/flight"
+	"github.com/UNO-SOFT/szamlazo/middleware/acl"
+	"github.com/UNO-SOFT/szamlazo/model"
+	"github.com/UNO-SOFT/szamlazo/model/token"
+
+	"github.com/blue-jay/core/router"
+
+	"gopkg.in/guregu/null.v3"
+)
+
+var (
+	uri = "/profile"
+)
+
+// dateLayout is the format of the date inputs.
+const dateLayout = "2006-01-02"
+
+// Load the routes.
+func Load() {
+	c := router.Chain(acl.DisallowAnon)
+	router.Get(uri, Index, c...)
+	router.Post(uri+"/token", StoreToken, c...)
+	router.Delete(uri+"/token/:id", RevokeToken, c...)
+}
+
+// Index displays the profile of the user with the tokens.
+func Index(w http.ResponseWriter, r *http.Request) {
+	show(w, r, "")
+}
+
+// show displays the profile, with the token just created if there is one.
+func show(w http.ResponseWriter, r *http.Request, created string) {
+	c := flight.Context(w, r)
+
+	user, _, err := model.User.ByID(c.UserID)
+	if err != nil {
+		c.FlashError(err)
+	}
+	items, _, err := model.Token.ByUserID(c.UserID)
+	if err != nil {
+		c.FlashError(err)
+		items = []token.Item{}
+	}
+
+	v := c.View.New("profile/index")
+	v.Vars["user"] = user
+	v.Vars["items"] = items
+	v.Vars["scopes"] = token.Scopes
+	v.Vars["created"] = created
+	v.Vars["min_date"] = time.Now().AddDate(0, 0, 1).Format(dateLayout)
+	c.Repopulate(v.Vars, "name", "expires_at")
+	v.Render(w, r)
+}
+
+// StoreToken handles the token form submission. The token is shown once, as
+// only its hash is kept. It works for the active company.
+func StoreToken(w http.ResponseWriter, r *http.Request) {
+	c := flight.Context(w, r)
+
+	if !c.FormValid("name") {
+		Index(w, r)
+		return
+	}
+
+	item := token.Item{Name: strings.TrimSpace(r.FormValue("name"))}
+	scopes, err := token.NormalizeScopes(r.Form["scopes"])
+	if err != nil {
+		c.FlashWarning(err.Error())
+		Index(w, r)
+		return
+	}
+	item.Scopes = scopes
+	if s := r.FormValue("expires_at"); s != "" {
+		day, err := time.ParseInLocation(dateLayout, s, time.Local)
+		if err != nil {
+			c.FlashWarning("The expiry is not a date.")
+			Index(w, r)
+			return
+		}
+		// The token is valid on the day of its expiry
+		expires := day.AddDate(0, 0, 1)
+		if !expires.After(time.Now()) {
+			c.FlashWarning("The expiry is in the past.")
+			Index(w, r)
+			return
+		}
+		item.ExpiresAt = null.TimeFrom(expires)
+	}
+	if ID := c.Company(); ID != 0 {
+		item.CompanyID = null.IntFrom(int64(ID))
+	}
+
+	t, err := model.Token.As(c.Actor()).Create(item, c.UserID)
+	if err != nil {
+		c.FlashError(err)
+		Index(w, r)
+		return
+	}
+
+	c.FlashSuccess("Token created. Copy it now, it is not shown again.")
+	show(w, r, t)
+}
+
+// RevokeToken handles the revoke form submission.
+func RevokeToken(w http.ResponseWriter, r *http.Request) {
+	c := flight.Context(w, r)
+
+	if _, err := model.Token.As(c.Actor()).Revoke(c.Param("id"), c.UserID); err != nil {
+		c.FlashError(err)
+	} else {
+		c.FlashNotice("Token revoked.")
+	}
+
+	c.Redirect(uri)
+}
@@ -0,0 +1,157 @@
+// Package rate provides the MNB exchange rates: the latest ones stored, the
+// import of the rate files and the fetching from the MNB, by hand and daily.
+package rate
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/UNO-SOFT/szamlazo/lib/flight"
+	"github.com/UNO-SOFT/szamlazo/lib/mnb"
+	"github.com/UNO-SOFT/szamlazo/lib/scheduler"
+	"github.com/UNO-SOFT/szamlazo/middleware/acl"
+	"github.com/UNO-SOFT/szamlazo/model"
+	"github.com/UNO-SOFT/szamlazo/model/rate"
+
+	"github.com/blue-jay/core/router"
+)
+
+var (
+	uri = "/rate"
+
+	// maxSize is the largest rate file accepted, in bytes.
+	maxSize int64 = 10 << 20
+)
+
+// taskImport is the name of the periodic task fetching the rates.
+const taskImport = "rate.import"
+
+// Load the routes and the periodic task. The MNB publishes the rates on the
+// workdays before noon.
+func Load() {
+	c := router.Chain(acl.DisallowAnon)
+	edit := router.Chain(acl.DisallowAnon, acl.Require("rate.import"))
+	router.Get(uri, Index, c...)
+	router.Post(uri+"/import", Import, edit...)
+	router.Post(uri+"/fetch", Fetch, edit...)
+
+	scheduler.Register(taskImport, "0 12 * * 1-5", importRates)
+}
+
+// Index displays the latest rate of each currency.
+func Index(w http.ResponseWriter, r *http.Request) {
+	c := flight.Context(w, r)
+
+	items, _, err := model.Rate.Latest()
+	if err != nil {
+		c.FlashError(err)
+		items = []rate.Item{}
+	}
+
+	v := c.View.New("rate/index")
+	v.Vars["items"] = items
+	v.Render(w, r)
+}
+
+// Import handles the upload of a rate file in the format of the MNB.
+func Import(w http.ResponseWriter, r *http.Request) {
+	c := flight.Context(w, r)
+
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		c.FlashWarning("Pick a rate file.")
+		c.Redirect(uri)
+		return
+	}
+	defer file.Close()
+
+	data, err := ioutil.ReadAll(io.LimitReader(file, maxSize))
+	if err != nil {
+		c.FlashError(err)
+		c.Redirect(uri)
+		return
+	}
+	rates, err := mnb.ParseRates(data)
+	if err != nil {
+		c.FlashWarning(err.Error())
+		c.Redirect(uri)
+		return
+	}
+	if err = model.Rate.Save(rates); err != nil {
+		c.FlashError(err)
+		c.Redirect(uri)
+		return
+	}
+
+	c.FlashSuccess(fmt.Sprintf("%d rates imported.", len(rates)))
+	c.Redirect(uri)
+}
+
+// Fetch handles the fetch form submission: the rates of the currency are
+// fetched from the source set, for the day given or today.
+func Fetch(w http.ResponseWriter, r *http.Request) {
+	c := flight.Context(w, r)
+
+	currency := strings.ToUpper(strings.TrimSpace(r.FormValue("currency")))
+	day := today()
+	if s := r.FormValue("date"); s != "" {
+		var err error
+		if day, err = time.Parse("2006-01-02", s); err != nil {
+			c.FlashWarning(fmt.Sprintf("date: %v", err))
+			c.Redirect(uri)
+			return
+		}
+	}
+	if len(currency) != 3 || currency == "HUF" {
+		c.FlashWarning("Give the code of a foreign currency.")
+		c.Redirect(uri)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+	rt, err := mnb.Ensure(ctx, flight.MNB().Source(), model.Rate, currency, day)
+	if err != nil {
+		c.FlashWarning(err.Error())
+		c.Redirect(uri)
+		return
+	}
+
+	c.FlashSuccess(fmt.Sprintf("1 %s = %s HUF on %s.", rt.Currency, rt.Rate, rt.Date.Format("2006-01-02")))
+	c.Redirect(uri)
+}
+
+// importRates is the periodic task fetching the rates of the day of the
+// currencies having a rate stored already.
+func importRates(ctx context.Context, at time.Time) (string, error) {
+	items, _, err := model.Rate.Latest()
+	if err != nil {
+		return "", err
+	}
+	y, m, d := at.Date()
+	day := time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+	var failed []string
+	for _, item := range items {
+		if _, err = mnb.Ensure(ctx, flight.MNB().Source(), model.Rate, item.Currency, day); err != nil {
+			log.Printf("rate import: %v", err)
+			failed = append(failed, item.Currency)
+		}
+	}
+	summary := fmt.Sprintf("%d of %d currencies fetched", len(items)-len(failed), len(items))
+	if len(failed) > 0 {
+		return summary, fmt.Errorf("no rate of %s", strings.Join(failed, ", "))
+	}
+	return summary, nil
+}
+
+// today returns the date of today.
+func today() time.Time {
+	y, m, d := time.Now().Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
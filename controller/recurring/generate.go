@@ -0,0 +1,143 @@
+package recurring
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	invoicectl "github.com/UNO-SOFT/szamlazo/controller/invoice"
+	"github.com/UNO-SOFT/szamlazo/model"
+	"github.com/UNO-SOFT/szamlazo/model/audit"
+	"github.com/UNO-SOFT/szamlazo/model/invoice"
+	"github.com/UNO-SOFT/szamlazo/model/partner"
+	"github.com/UNO-SOFT/szamlazo/model/recurring"
+)
+
+// taskGenerate is the name of the periodic task generating the invoices.
+const taskGenerate = "recurring.generate"
+
+// generateTask is the periodic task generating the due invoices.
+func generateTask(ctx context.Context, at time.Time) (string, error) {
+	y, m, d := at.Date()
+	n, err := Generate(ctx, time.Date(y, m, d, 0, 0, 0, 0, time.UTC))
+	return fmt.Sprintf("%d invoices generated", n), err
+}
+
+// Generate makes the invoices of all the templates due on or before today,
+// and returns their number. The dates missed while no server was running
+// get their invoices one by one. Failures are recorded with the templates.
+func Generate(ctx context.Context, today time.Time) (int, error) {
+	IDs, err := model.Recurring.Due(today)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, ID := range IDs {
+		for {
+			if err = ctx.Err(); err != nil {
+				return n, err
+			}
+			ok, err := generate(ID, today)
+			if err != nil {
+				fail(ID, err)
+				break
+			}
+			if !ok {
+				break
+			}
+			n++
+		}
+	}
+	return n, nil
+}
+
+// generate makes the invoice of the next date of a template, and issues and
+// sends it when the template says so. It returns false when the template is
+// not due any more, or another server is generating its invoice.
+//
+// The draft is created and the template scheduled for its following date in
+// one transaction. Issuing needs the exchange rate from the MNB, so it comes
+// after: when it fails, the draft is kept and the error recorded.
+func generate(ID uint32, today time.Time) (bool, error) {
+	var item recurring.Item
+	var inv invoice.Item
+	var p partner.Item
+	created := false
+	err := model.Transaction(func(tx model.Tx) error {
+		var noRows bool
+		var err error
+		if item, noRows, err = tx.Recurring.Lock(ID, today); noRows {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		userID := fmt.Sprint(item.UserID)
+
+		seller, _, err := tx.Company.ByID(fmt.Sprint(item.CompanyID), userID)
+		if err != nil {
+			return err
+		}
+		if p, _, err = tx.Partner.ByID(fmt.Sprint(item.PartnerID), fmt.Sprint(item.CompanyID)); err != nil {
+			return err
+		}
+		inv = item.Invoice(item.NextDate.Time)
+		inv.SellerName = seller.Name
+		inv.SellerAddress = seller.Address
+		inv.SellerTaxNumber = seller.TaxNumber
+		inv.BuyerName = p.Name
+		inv.BuyerAddress = p.Address()
+		inv.BuyerTaxNumber = p.TaxNumber
+		if inv.BuyerTaxNumber == "" {
+			inv.BuyerTaxNumber = p.EUVATNumber
+		}
+		if err = inv.Validate(); err != nil {
+			return err
+		}
+
+		if inv.ID, err = tx.Invoice.Create(inv, userID); err != nil {
+			return err
+		}
+		if err = tx.Recurring.Generated(item, inv.ID); err != nil {
+			return err
+		}
+		created = true
+		return nil
+	})
+	if err != nil || !created {
+		return false, err
+	}
+
+	if !item.AutoIssue {
+		return true, nil
+	}
+	userID := fmt.Sprint(item.UserID)
+	invoiceID, companyID := fmt.Sprint(inv.ID), fmt.Sprint(inv.CompanyID)
+	if err = invoicectl.EnsureRate(inv); err == nil {
+		err = invoicectl.IssueAndReport(audit.Actor{}, invoiceID, companyID, userID, today)
+	}
+	if err != nil {
+		fail(ID, fmt.Errorf("invoice %d is left as a draft: %v", inv.ID, err))
+		return true, nil
+	}
+
+	if !item.SendEmail {
+		return true, nil
+	}
+	issued, _, err := model.Invoice.ByID(invoiceID, companyID)
+	if err == nil {
+		err = invoicectl.QueueMail(issued, p, audit.Actor{UserID: userID}, userID)
+	}
+	if err != nil {
+		fail(ID, fmt.Errorf("invoice %s is not sent: %v", issued.Number.String, err))
+	}
+	return true, nil
+}
+
+// fail logs and records why generating an invoice of a template failed.
+func fail(ID uint32, err error) {
+	log.Printf("recurring: template %d: %v", ID, err)
+	if _, dbErr := model.Recurring.SetError(ID, err.Error()); dbErr != nil {
+		log.Println(dbErr)
+	}
+}
@@ -0,0 +1,268 @@
+// Package recurring provides the templates of the recurring invoices of the
+// active company, and the generating of their invoices.
+package recurring
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	invoicectl "github.com/UNO-SOFT/szamlazo/controller/invoice"
+	"github.com/UNO-SOFT/szamlazo/lib/flight"
+	"github.com/UNO-SOFT/szamlazo/lib/scheduler"
+	"github.com/UNO-SOFT/szamlazo/middleware/acl"
+	"github.com/UNO-SOFT/szamlazo/model"
+	"github.com/UNO-SOFT/szamlazo/model/invoice"
+	"github.com/UNO-SOFT/szamlazo/model/recurring"
+
+	"github.com/blue-jay/core/router"
+
+	"gopkg.in/guregu/null.v3"
+)
+
+var (
+	uri = "/recurring"
+
+	// fields are the form fields of the template header.
+	fields = []string{"name", "series_id", "partner_id", "currency",
+		"payment_method", "rounding", "payment_days",
+		"frequency", "day_of_month", "start_date", "end_date",
+		"auto_issue", "send_email"}
+
+	// required are the header fields which cannot be left empty.
+	required = []string{"name", "series_id", "partner_id", "currency",
+		"payment_method", "payment_days", "frequency", "day_of_month", "start_date"}
+)
+
+// dateLayout is the format of the date inputs.
+const dateLayout = "2006-01-02"
+
+// Load the routes and the periodic task.
+func Load() {
+	c := router.Chain(acl.DisallowAnon, acl.Require("invoice.recurring"))
+	router.Get(uri, Index, c...)
+	router.Get(uri+"/create", Create, c...)
+	router.Post(uri+"/create", Store, c...)
+	router.Get(uri+"/view/:id", Show, c...)
+	router.Get(uri+"/edit/:id", Edit, c...)
+	router.Patch(uri+"/edit/:id", Update, c...)
+	router.Delete(uri+"/:id", Destroy, c...)
+
+	scheduler.Register(taskGenerate, "@every 1h", generateTask)
+}
+
+// Index displays the items.
+func Index(w http.ResponseWriter, r *http.Request) {
+	c := flight.Context(w, r)
+
+	items, _, err := model.Recurring.ByCompanyID(c.CompanyID)
+	if err != nil {
+		c.FlashError(err)
+		items = []recurring.Item{}
+	}
+
+	v := c.View.New("recurring/index")
+	v.Vars["items"] = items
+	v.Render(w, r)
+}
+
+// Create displays the create form.
+func Create(w http.ResponseWriter, r *http.Request) {
+	c := flight.Context(w, r)
+
+	v := c.View.New("recurring/create")
+	now := time.Now()
+	v.Vars["currency"] = "HUF"
+	v.Vars["payment_method"] = invoice.PaymentTransfer
+	v.Vars["rounding"] = invoice.RoundPerLine
+	v.Vars["payment_days"] = "8"
+	v.Vars["frequency"] = recurring.FrequencyMonthly
+	v.Vars["day_of_month"] = "1"
+	v.Vars["start_date"] = now.Format(dateLayout)
+	c.Repopulate(v.Vars, fields...)
+	invoicectl.SetChoices(c, v.Vars, nil)
+	v.Vars["frequencies"] = recurring.Frequencies
+	v.Render(w, r)
+}
+
+// Store handles the create form submission.
+func Store(w http.ResponseWriter, r *http.Request) {
+	c := flight.Context(w, r)
+
+	if !c.FormValid(required...) {
+		Create(w, r)
+		return
+	}
+
+	item, err := itemFromForm(c)
+	if err != nil {
+		c.FlashWarning(err.Error())
+		Create(w, r)
+		return
+	}
+
+	item.CompanyID = c.Company()
+	ID, err := model.Recurring.As(c.Actor()).Create(item, c.UserID)
+	if err != nil {
+		c.FlashError(err)
+		Create(w, r)
+		return
+	}
+
+	c.FlashSuccess("Recurring invoice added.")
+	c.Redirect(uri + "/view/" + fmt.Sprint(ID))
+}
+
+// Show displays a single item with the invoices generated of it.
+func Show(w http.ResponseWriter, r *http.Request) {
+	c := flight.Context(w, r)
+
+	item, _, err := model.Recurring.ByID(c.Param("id"), c.CompanyID)
+	if err != nil {
+		c.FlashError(err)
+		c.Redirect(uri)
+		return
+	}
+	runs, _, err := model.Recurring.Runs(c.Param("id"), c.CompanyID)
+	if err != nil {
+		c.FlashError(err)
+		runs = []recurring.Run{}
+	}
+
+	v := c.View.New("recurring/show")
+	v.Vars["item"] = item
+	v.Vars["runs"] = runs
+	v.Render(w, r)
+}
+
+// Edit displays the edit form.
+func Edit(w http.ResponseWriter, r *http.Request) {
+	c := flight.Context(w, r)
+
+	item, _, err := model.Recurring.ByID(c.Param("id"), c.CompanyID)
+	if err != nil {
+		c.FlashError(err)
+		c.Redirect(uri)
+		return
+	}
+
+	v := c.View.New("recurring/edit")
+	v.Vars["name"] = item.Name
+	v.Vars["series_id"] = item.SeriesID
+	v.Vars["partner_id"] = item.PartnerID
+	v.Vars["currency"] = item.Currency
+	v.Vars["payment_method"] = item.PaymentMethod
+	v.Vars["rounding"] = item.Rounding
+	v.Vars["payment_days"] = item.PaymentDays
+	v.Vars["frequency"] = item.Frequency
+	v.Vars["day_of_month"] = item.DayOfMonth
+	v.Vars["start_date"] = item.StartDate.Format(dateLayout)
+	if item.EndDate.Valid {
+		v.Vars["end_date"] = item.EndDate.Time.Format(dateLayout)
+	}
+	c.Repopulate(v.Vars, fields...)
+	invoicectl.SetChoices(c, v.Vars, item.Lines)
+	v.Vars["frequencies"] = recurring.Frequencies
+	v.Vars["item"] = item
+	v.Render(w, r)
+}
+
+// Update handles the edit form submission.
+func Update(w http.ResponseWriter, r *http.Request) {
+	c := flight.Context(w, r)
+
+	if !c.FormValid(required...) {
+		Edit(w, r)
+		return
+	}
+
+	item, err := itemFromForm(c)
+	if err != nil {
+		c.FlashWarning(err.Error())
+		Edit(w, r)
+		return
+	}
+
+	if _, err = model.Recurring.As(c.Actor()).Update(item, c.Param("id"), c.CompanyID); err != nil {
+		c.FlashError(err)
+		Edit(w, r)
+		return
+	}
+
+	c.FlashSuccess("Recurring invoice updated.")
+	c.Redirect(uri + "/view/" + c.Param("id"))
+}
+
+// Destroy handles the delete form submission.
+func Destroy(w http.ResponseWriter, r *http.Request) {
+	c := flight.Context(w, r)
+
+	if _, err := model.Recurring.As(c.Actor()).DeleteSoft(c.Param("id"), c.CompanyID); err != nil {
+		c.FlashError(err)
+	} else {
+		c.FlashNotice("Recurring invoice deleted.")
+	}
+
+	c.Redirect(uri)
+}
+
+// itemFromForm reads and validates the submitted template. The series must
+// be of the active company, the partner of the user, and the lines with a
+// picked product get their empty fields from the catalogue.
+func itemFromForm(c *flight.Info) (recurring.Item, error) {
+	r := c.R
+	item := recurring.Item{
+		Name:          r.FormValue("name"),
+		Currency:      r.FormValue("currency"),
+		PaymentMethod: r.FormValue("payment_method"),
+		Rounding:      r.FormValue("rounding"),
+		Frequency:     r.FormValue("frequency"),
+		AutoIssue:     r.FormValue("auto_issue") != "",
+		SendEmail:     r.FormValue("send_email") != "",
+	}
+	var priced []bool
+	var err error
+	if item.Lines, priced, err = invoicectl.LinesFromForm(r); err != nil {
+		return item, err
+	}
+
+	sr, _, err := model.Series.ByID(r.FormValue("series_id"), c.CompanyID)
+	if err != nil {
+		return item, fmt.Errorf("unknown series %q", r.FormValue("series_id"))
+	}
+	item.SeriesID = sr.ID
+	p, _, err := model.Partner.ByID(r.FormValue("partner_id"), c.CompanyID)
+	if err != nil {
+		return item, fmt.Errorf("unknown partner %q", r.FormValue("partner_id"))
+	}
+	item.PartnerID = p.ID
+
+	for _, n := range []struct {
+		field string
+		dest  *int
+	}{
+		{"payment_days", &item.PaymentDays},
+		{"day_of_month", &item.DayOfMonth},
+	} {
+		if *n.dest, err = strconv.Atoi(strings.TrimSpace(r.FormValue(n.field))); err != nil {
+			return item, fmt.Errorf("%s: %q is not a number", n.field, r.FormValue(n.field))
+		}
+	}
+	if item.StartDate, err = time.Parse(dateLayout, r.FormValue("start_date")); err != nil {
+		return item, fmt.Errorf("start_date: %q is not a date", r.FormValue("start_date"))
+	}
+	if s := r.FormValue("end_date"); s != "" {
+		end, err := time.Parse(dateLayout, s)
+		if err != nil {
+			return item, fmt.Errorf("end_date: %q is not a date", s)
+		}
+		item.EndDate = null.TimeFrom(end)
+	}
+
+	if err = invoicectl.FillProducts(c, item.Lines, priced); err != nil {
+		return item, err
+	}
+	return item, item.Normalize()
+}
@@ -65,7 +65,7 @@ func Store(w http.ResponseWriter, r *http.Request) {
 	_, noRows, err := model.User.ByEmail(email)
 
 	if noRows { // If success (no user exists with that email)
-		_, err = model.User.Create(firstName, lastName, email, password)
+		_, err = model.User.As(c.Actor()).Create(firstName, lastName, email, password)
 		// Will only error if there is a problem with the query
 		if err != nil {
 			c.FlashError(err)
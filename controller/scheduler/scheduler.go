@@ -0,0 +1,65 @@
+// Package scheduler provides the periodic tasks for the admins: their
+// schedules on this server and the history of their runs on all of them.
+package scheduler
+
+import (
+	"net/http"
+
+	"github.com/UNO-SOFT/szamlazo/lib/flight"
+	"github.com/UNO-SOFT/szamlazo/lib/scheduler"
+	"github.com/UNO-SOFT/szamlazo/middleware/acl"
+	"github.com/UNO-SOFT/szamlazo/model"
+	"github.com/UNO-SOFT/szamlazo/model/schedule"
+
+	"github.com/blue-jay/core/router"
+)
+
+var (
+	uri = "/scheduler"
+
+	// limit is the number of runs shown at most.
+	limit = 200
+)
+
+// task is a task with its last run, if any.
+type task struct {
+	scheduler.Status
+	Last *schedule.Item
+}
+
+// Load the routes.
+func Load() {
+	c := router.Chain(acl.DisallowAnon, acl.Require("scheduler.view"))
+	router.Get(uri, Index, c...)
+}
+
+// Index displays the tasks and their latest runs.
+func Index(w http.ResponseWriter, r *http.Request) {
+	c := flight.Context(w, r)
+
+	last, _, err := model.Schedule.LastByTask()
+	if err != nil {
+		c.FlashError(err)
+	}
+	byTask := make(map[string]*schedule.Item, len(last))
+	for i := range last {
+		byTask[last[i].Task] = &last[i]
+	}
+	statuses := scheduler.Tasks()
+	tasks := make([]task, len(statuses))
+	for i, st := range statuses {
+		tasks[i] = task{Status: st, Last: byTask[st.Name]}
+	}
+
+	items, _, err := model.Schedule.Latest(limit)
+	if err != nil {
+		c.FlashError(err)
+		items = []schedule.Item{}
+	}
+
+	v := c.View.New("scheduler/index")
+	v.Vars["tasks"] = tasks
+	v.Vars["items"] = items
+	v.Vars["limit"] = limit
+	v.Render(w, r)
+}
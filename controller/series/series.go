@@ -0,0 +1,204 @@
+// Package series provides the invoice number series of the active company.
+package series
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/UNO-SOFT/szamlazo/lib/flight"
+	"github.com/UNO-SOFT/szamlazo/middleware/acl"
+	"github.com/UNO-SOFT/szamlazo/model"
+	"github.com/UNO-SOFT/szamlazo/model/series"
+
+	"github.com/blue-jay/core/router"
+
+	"gopkg.in/guregu/null.v3"
+)
+
+var (
+	uri = "/series"
+
+	// fields are the form fields of a series. The year_reset checkbox is
+	// left out, as an unchecked one is not submitted.
+	fields = []string{"code", "prefix", "padding", "correction_series_id"}
+)
+
+// Load the routes.
+func Load() {
+	c := router.Chain(acl.DisallowAnon, acl.Require("company.manage"))
+	router.Get(uri, Index, c...)
+	router.Get(uri+"/create", Create, c...)
+	router.Post(uri+"/create", Store, c...)
+	router.Get(uri+"/edit/:id", Edit, c...)
+	router.Patch(uri+"/edit/:id", Update, c...)
+}
+
+// Index displays the items.
+func Index(w http.ResponseWriter, r *http.Request) {
+	c := flight.Context(w, r)
+
+	items, _, err := model.Series.ByCompanyID(c.CompanyID)
+	if err != nil {
+		c.FlashError(err)
+		items = []series.Item{}
+	}
+
+	v := c.View.New("series/index")
+	v.Vars["items"] = items
+	v.Vars["codes"] = codes(items)
+	v.Render(w, r)
+}
+
+// Create displays the create form.
+func Create(w http.ResponseWriter, r *http.Request) {
+	c := flight.Context(w, r)
+
+	v := c.View.New("series/create")
+	v.Vars["padding"] = "5"
+	v.Vars["year_reset"] = r.Method == http.MethodGet || r.FormValue("year_reset") != ""
+	c.Repopulate(v.Vars, fields...)
+	setChoices(c, v.Vars, "")
+	v.Render(w, r)
+}
+
+// Store handles the create form submission.
+func Store(w http.ResponseWriter, r *http.Request) {
+	c := flight.Context(w, r)
+
+	if !c.FormValid("code") {
+		Create(w, r)
+		return
+	}
+
+	item, err := itemFromForm(c)
+	if err != nil {
+		c.FlashWarning(err.Error())
+		Create(w, r)
+		return
+	}
+
+	if _, err = model.Series.As(c.Actor()).Create(item); err != nil {
+		c.FlashError(err)
+		Create(w, r)
+		return
+	}
+
+	c.FlashSuccess("Series added.")
+	c.Redirect(uri)
+}
+
+// Edit displays the edit form.
+func Edit(w http.ResponseWriter, r *http.Request) {
+	c := flight.Context(w, r)
+
+	item, _, err := model.Series.ByID(c.Param("id"), c.CompanyID)
+	if err != nil {
+		c.FlashError(err)
+		c.Redirect(uri)
+		return
+	}
+
+	v := c.View.New("series/edit")
+	v.Vars["code"] = item.Code
+	v.Vars["prefix"] = item.Prefix
+	v.Vars["padding"] = fmt.Sprint(item.Padding)
+	v.Vars["correction_series_id"] = ""
+	if item.CorrectionSeriesID.Valid {
+		v.Vars["correction_series_id"] = fmt.Sprint(item.CorrectionSeriesID.Int64)
+	}
+	v.Vars["year_reset"] = item.YearReset
+	if r.Method != http.MethodGet {
+		v.Vars["year_reset"] = r.FormValue("year_reset") != ""
+	}
+	c.Repopulate(v.Vars, fields...)
+	setChoices(c, v.Vars, c.Param("id"))
+	v.Vars["item"] = item
+	v.Render(w, r)
+}
+
+// Update handles the edit form submission.
+func Update(w http.ResponseWriter, r *http.Request) {
+	c := flight.Context(w, r)
+
+	if !c.FormValid("code") {
+		Edit(w, r)
+		return
+	}
+
+	item, err := itemFromForm(c)
+	if err != nil {
+		c.FlashWarning(err.Error())
+		Edit(w, r)
+		return
+	}
+	if item.CorrectionSeriesID.Valid && fmt.Sprint(item.CorrectionSeriesID.Int64) == c.Param("id") {
+		c.FlashWarning("A series cannot number its own corrections.")
+		Edit(w, r)
+		return
+	}
+
+	if _, err = model.Series.As(c.Actor()).Update(item, c.Param("id"), c.CompanyID); err != nil {
+		c.FlashError(err)
+		Edit(w, r)
+		return
+	}
+
+	c.FlashSuccess("Series updated.")
+	c.Redirect(uri)
+}
+
+// setChoices adds the series which may number the corrections, all but the
+// one edited.
+func setChoices(c *flight.Info, vars map[string]interface{}, ID string) {
+	items, _, err := model.Series.ByCompanyID(c.CompanyID)
+	if err != nil {
+		c.FlashError(err)
+	}
+	var choices []series.Item
+	for _, item := range items {
+		if fmt.Sprint(item.ID) != ID {
+			choices = append(choices, item)
+		}
+	}
+	vars["choices"] = choices
+}
+
+// codes maps the IDs of the series to their codes, to name the series of
+// the corrections.
+func codes(items []series.Item) map[string]string {
+	result := make(map[string]string, len(items))
+	for _, item := range items {
+		result[fmt.Sprint(item.ID)] = item.Code
+	}
+	return result
+}
+
+// itemFromForm reads a series of the active company from the submitted form.
+// The series of the corrections must be one of the company too.
+func itemFromForm(c *flight.Info) (series.Item, error) {
+	r := c.R
+	item := series.Item{
+		CompanyID: c.Company(),
+		Code:      r.FormValue("code"),
+		Prefix:    r.FormValue("prefix"),
+		YearReset: r.FormValue("year_reset") != "",
+	}
+	padding, err := strconv.ParseUint(strings.TrimSpace(r.FormValue("padding")), 10, 8)
+	if err != nil {
+		return item, fmt.Errorf("padding: %q is not a number", r.FormValue("padding"))
+	}
+	item.Padding = uint8(padding)
+
+	if ID := r.FormValue("correction_series_id"); ID != "" {
+		correction, noRows, err := model.Series.ByID(ID, c.CompanyID)
+		if noRows {
+			return item, fmt.Errorf("unknown series %q", ID)
+		} else if err != nil {
+			return item, err
+		}
+		item.CorrectionSeriesID = null.IntFrom(int64(correction.ID))
+	}
+	return item, item.Normalize()
+}
@@ -0,0 +1,230 @@
+// Package statement provides the import of bank statements and the review of
+// the transactions not matched to invoices.
+package statement
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/UNO-SOFT/szamlazo/lib/bankstatement"
+	"github.com/UNO-SOFT/szamlazo/lib/flight"
+	"github.com/UNO-SOFT/szamlazo/lib/money"
+	"github.com/UNO-SOFT/szamlazo/middleware/acl"
+	"github.com/UNO-SOFT/szamlazo/model"
+	"github.com/UNO-SOFT/szamlazo/model/payment"
+	"github.com/UNO-SOFT/szamlazo/model/statement"
+
+	"github.com/blue-jay/core/router"
+)
+
+var (
+	uri = "/statement"
+
+	// storageDir is the folder of the uploaded files.
+	storageDir = "filestorage"
+
+	// maxSize is the largest statement accepted, in bytes.
+	maxSize int64 = 10 << 20
+)
+
+// Load the routes.
+func Load() {
+	c := router.Chain(acl.DisallowAnon, acl.Require("statement.view"))
+	edit := router.Chain(acl.DisallowAnon, acl.Require("statement.edit"))
+	router.Get(uri, Index, c...)
+	router.Post(uri+"/upload", Upload, edit...)
+	router.Get(uri+"/view/:id", Show, c...)
+	router.Get(uri+"/review", Review, edit...)
+	router.Post(uri+"/assign/:id", Assign, edit...)
+	router.Post(uri+"/ignore/:id", Ignore, edit...)
+}
+
+// Index displays the imported statements with the upload form.
+func Index(w http.ResponseWriter, r *http.Request) {
+	c := flight.Context(w, r)
+
+	items, _, err := model.Statement.ByCompanyID(c.CompanyID)
+	if err != nil {
+		c.FlashError(err)
+		items = []statement.Item{}
+	}
+
+	v := c.View.New("statement/index")
+	v.Vars["items"] = items
+	v.Render(w, r)
+}
+
+// Upload handles the upload form submission: the file is stored, and its
+// transactions are imported and matched.
+func Upload(w http.ResponseWriter, r *http.Request) {
+	c := flight.Context(w, r)
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		c.FlashWarning("Pick a statement file.")
+		c.Redirect(uri)
+		return
+	}
+	defer file.Close()
+
+	data, err := ioutil.ReadAll(io.LimitReader(file, maxSize+1))
+	if err != nil {
+		c.FlashError(err)
+		c.Redirect(uri)
+		return
+	}
+	if int64(len(data)) > maxSize {
+		c.FlashWarning(fmt.Sprintf("The file is larger than %d MiB.", maxSize>>20))
+		c.Redirect(uri)
+		return
+	}
+
+	statements, err := bankstatement.Parse(data)
+	if err != nil {
+		c.FlashWarning(err.Error())
+		c.Redirect(uri)
+		return
+	}
+
+	item := statement.Item{
+		CompanyID: c.Company(),
+		FileName:  filepath.Base(header.Filename),
+		Format:    bankstatement.Detect(data),
+	}
+	sum := sha256.Sum256(data)
+	item.SHA256 = hex.EncodeToString(sum[:])
+	if item.Path, err = store(item, data); err != nil {
+		c.FlashError(err)
+		c.Redirect(uri)
+		return
+	}
+
+	ID, err := model.Statement.As(c.Actor()).Import(item, statements, c.UserID, today())
+	if err != nil {
+		c.FlashError(err)
+		c.Redirect(uri)
+		return
+	}
+
+	c.FlashSuccess("Statement imported.")
+	c.Redirect(fmt.Sprintf("%s/view/%d", uri, ID))
+}
+
+// store writes the file of a statement under storageDir, named by its
+// content, so uploading it again does not take more space.
+func store(item statement.Item, data []byte) (string, error) {
+	ext := ".sta"
+	if item.Format == bankstatement.FormatCAMT053 {
+		ext = ".xml"
+	}
+	dir := filepath.Join(storageDir, "statement", fmt.Sprint(item.CompanyID))
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, item.SHA256+ext)
+	if _, err := os.Stat(path); err == nil {
+		return path, nil
+	}
+	return path, ioutil.WriteFile(path, data, 0640)
+}
+
+// Show displays a single item with its transactions.
+func Show(w http.ResponseWriter, r *http.Request) {
+	c := flight.Context(w, r)
+
+	item, _, err := model.Statement.ByID(c.Param("id"), c.CompanyID)
+	if err != nil {
+		c.FlashError(err)
+		c.Redirect(uri)
+		return
+	}
+
+	v := c.View.New("statement/show")
+	v.Vars["item"] = item
+	v.Render(w, r)
+}
+
+// Review displays the transactions waiting for review, with the open items
+// they may pay.
+func Review(w http.ResponseWriter, r *http.Request) {
+	c := flight.Context(w, r)
+
+	items, _, err := model.Statement.Review(c.CompanyID)
+	if err != nil {
+		c.FlashError(err)
+		items = []statement.Transaction{}
+	}
+
+	debtors, err := model.Payment.OpenItems(c.CompanyID, today())
+	if err != nil {
+		c.FlashError(err)
+	}
+	var open []payment.OpenItem
+	for _, d := range debtors {
+		for _, o := range d.Items {
+			if o.Balance().Sign() > 0 {
+				open = append(open, o)
+			}
+		}
+	}
+
+	v := c.View.New("statement/review")
+	v.Vars["items"] = items
+	v.Vars["open_items"] = open
+	v.Render(w, r)
+}
+
+// Assign handles the review form submission: the transaction is recorded
+// as a payment of the invoice picked.
+func Assign(w http.ResponseWriter, r *http.Request) {
+	c := flight.Context(w, r)
+
+	var allocations []payment.Allocation
+	if ID, err := strconv.ParseUint(r.FormValue("invoice_id"), 10, 32); err == nil {
+		amount, err := money.Parse(strings.TrimSpace(r.FormValue("amount")))
+		if err != nil {
+			c.FlashWarning(fmt.Sprintf("amount: %v", err))
+			c.Redirect(uri + "/review")
+			return
+		}
+		allocations = append(allocations, payment.Allocation{InvoiceID: uint32(ID), Amount: amount})
+	}
+
+	paymentID, err := model.Statement.As(c.Actor()).Assign(c.Param("id"), allocations, c.CompanyID, c.UserID)
+	if err != nil {
+		c.FlashError(err)
+		c.Redirect(uri + "/review")
+		return
+	}
+
+	c.FlashSuccess(fmt.Sprintf("Payment %d recorded.", paymentID))
+	c.Redirect(uri + "/review")
+}
+
+// Ignore handles the ignore button of the review.
+func Ignore(w http.ResponseWriter, r *http.Request) {
+	c := flight.Context(w, r)
+
+	if err := model.Statement.As(c.Actor()).Ignore(c.Param("id"), c.CompanyID); err != nil {
+		c.FlashError(err)
+	} else {
+		c.FlashNotice("Transaction ignored.")
+	}
+
+	c.Redirect(uri + "/review")
+}
+
+// today returns the date of today.
+func today() time.Time {
+	y, m, d := time.Now().Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
@@ -0,0 +1,99 @@
+// Package bankstatement reads the transactions of bank statements in the
+// SWIFT MT940 and the ISO 20022 CAMT.053 formats.
+package bankstatement
+
+import (
+	"bytes"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/UNO-SOFT/szamlazo/lib/money"
+
+	"github.com/pkg/errors"
+)
+
+// Formats of the statements.
+const (
+	FormatMT940   = "mt940"
+	FormatCAMT053 = "camt.053"
+)
+
+// Statement is the statement of one account.
+type Statement struct {
+	Account      string
+	Currency     string
+	Transactions []Transaction
+}
+
+// Transaction is an entry of a statement. Amount is positive for credits and
+// negative for debits; Name and Account are of the other party, the payer of
+// a credit.
+type Transaction struct {
+	Date       time.Time
+	Amount     money.Decimal
+	Currency   string
+	Reference  string
+	Remittance string
+	Name       string
+	Account    string
+}
+
+// Detect tells the format of a statement by its first characters: CAMT.053
+// is XML, anything else is taken for MT940.
+func Detect(data []byte) string {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("<")) {
+		return FormatCAMT053
+	}
+	return FormatMT940
+}
+
+// Parse reads the statements of a file in either format.
+func Parse(data []byte) ([]Statement, error) {
+	var statements []Statement
+	var err error
+	switch Detect(data) {
+	case FormatCAMT053:
+		statements, err = ParseCAMT053(data)
+	default:
+		statements, err = ParseMT940(data)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(statements) == 0 {
+		return nil, errors.New("no statement found")
+	}
+	return statements, nil
+}
+
+// AccountKey returns the account number in a form to compare by: only its
+// letters and digits, in upper case. Hungarian IBANs and the 16 digit form
+// of the domestic numbers are turned into the 24 digit domestic number, so
+// all the forms of an account have the same key.
+func AccountKey(account string) string {
+	key := strings.Map(func(r rune) rune {
+		if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			return unicode.ToUpper(r)
+		}
+		return -1
+	}, account)
+	switch {
+	case len(key) == 28 && strings.HasPrefix(key, "HU") && digits(key[2:]):
+		return key[4:]
+	case len(key) == 16 && digits(key):
+		return key + "00000000"
+	}
+	return key
+}
+
+// digits reports whether s consists of decimal digits only.
+func digits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
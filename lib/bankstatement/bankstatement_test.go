@@ -0,0 +1,94 @@
+package bankstatement_test
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/UNO-SOFT/szamlazo/lib/bankstatement"
+)
+
+// want is a transaction expected, with its amount as text.
+type want struct {
+	Amount, Reference, Remittance, Name, Account string
+}
+
+// check compares the transactions of the only statement parsed from file.
+func check(t *testing.T, file, format, account string, wants []want) {
+	data, err := ioutil.ReadFile(filepath.Join("testdata", file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := bankstatement.Detect(data); got != format {
+		t.Errorf("%s: detected %s, wanted %s", file, got, format)
+	}
+	statements, err := bankstatement.Parse(data)
+	if err != nil {
+		t.Fatalf("%s: %v", file, err)
+	}
+	if len(statements) != 1 {
+		t.Fatalf("%s: got %d statements", file, len(statements))
+	}
+	st := statements[0]
+	if st.Account != account || st.Currency != "HUF" {
+		t.Errorf("%s: got account %q in %q", file, st.Account, st.Currency)
+	}
+	if len(st.Transactions) != len(wants) {
+		t.Fatalf("%s: got %d transactions, wanted %d: %+v", file, len(st.Transactions), len(wants), st.Transactions)
+	}
+	day := time.Date(2026, 10, 16, 0, 0, 0, 0, time.UTC)
+	for i, w := range wants {
+		tr := st.Transactions[i]
+		got := want{tr.Amount.Normalize().String(), tr.Reference, tr.Remittance, tr.Name, tr.Account}
+		if got != w {
+			t.Errorf("%s: %d. got %+v, wanted %+v", file, i, got, w)
+		}
+		if !tr.Date.Equal(day) || tr.Currency != "HUF" {
+			t.Errorf("%s: %d. got %s in %q", file, i, tr.Date, tr.Currency)
+		}
+	}
+}
+
+func TestParseMT940(t *testing.T) {
+	check(t, "statement.sta", bankstatement.FormatMT940, "11773016-11111018-00000000", []want{
+		{"12700", "B26101600001", "SZ-2026-000012 szamlakiegyenlitese", "ALFA KFT.", "HU42117730161234567800000000"},
+		{"-5000", "B26101600002", "Bankkoltseg", "", ""},
+		{"1234.5", "REF123", "Payment for invoice 42", "", ""},
+	})
+}
+
+func TestParseCAMT053(t *testing.T) {
+	check(t, "statement.xml", bankstatement.FormatCAMT053, "HU42117730161111101800000000", []want{
+		{"12700", "B26101600001", "SZ-2026-000012 szamla kiegyenlitese", "ALFA KFT.", "11773016-12345678"},
+		{"1000", "B26101600002-1", "", "BETA BT.", ""},
+		{"2000", "B26101600002-2", "SZ-2026-000013", "GAMMA ZRT.", ""},
+		{"-5000", "B26101600003", "Bankkoltseg", "", ""},
+	})
+}
+
+func TestParseBad(t *testing.T) {
+	for name, data := range map[string]string{
+		"empty":      "",
+		"no :20:":    ":25:123\r\n",
+		"bad line":   ":20:X\r\n:61:yesterday\r\n",
+		"broken XML": "<Document><BkToCstmrStmt>",
+	} {
+		if _, err := bankstatement.Parse([]byte(data)); err == nil {
+			t.Errorf("%s: accepted", name)
+		}
+	}
+}
+
+func TestAccountKey(t *testing.T) {
+	for in, want := range map[string]string{
+		"11773016-12345678":                  "117730161234567800000000",
+		"11773016-12345678-00000000":         "117730161234567800000000",
+		"HU42 1177 3016 1234 5678 0000 0000": "117730161234567800000000",
+		"de89 3704 0044 0532 0130 00":        "DE89370400440532013000",
+	} {
+		if got := bankstatement.AccountKey(in); got != want {
+			t.Errorf("%q: got %q, wanted %q", in, got, want)
+		}
+	}
+}
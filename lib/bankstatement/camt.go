@@ -0,0 +1,175 @@
+package bankstatement
+
+import (
+	"encoding/xml"
+	"strings"
+	"time"
+
+	"github.com/UNO-SOFT/szamlazo/lib/money"
+
+	"github.com/pkg/errors"
+)
+
+// camtDocument is the part of a CAMT.053 document read. The elements are
+// matched by their local names, so any version of the schema does.
+type camtDocument struct {
+	Statements []struct {
+		Account camtAccount `xml:"Acct"`
+		Entries []struct {
+			Amount    camtAmount `xml:"Amt"`
+			Indicator string     `xml:"CdtDbtInd"`
+			Reversal  bool       `xml:"RvslInd"`
+			Booking   camtDate   `xml:"BookgDt"`
+			Value     camtDate   `xml:"ValDt"`
+			Reference string     `xml:"AcctSvcrRef"`
+			Details   []struct {
+				Amount     *camtAmount `xml:"Amt"`
+				TxAmount   *camtAmount `xml:"AmtDtls>TxAmt>Amt"`
+				Reference  string      `xml:"Refs>AcctSvcrRef"`
+				EndToEnd   string      `xml:"Refs>EndToEndId"`
+				Debtor     camtParty   `xml:"RltdPties>Dbtr"`
+				DebtorAcct camtAccount `xml:"RltdPties>DbtrAcct"`
+				Creditor   camtParty   `xml:"RltdPties>Cdtr"`
+				CredAcct   camtAccount `xml:"RltdPties>CdtrAcct"`
+				Ustrd      []string    `xml:"RmtInf>Ustrd"`
+				Strd       []string    `xml:"RmtInf>Strd>CdtrRefInf>Ref"`
+				Info       string      `xml:"AddtlTxInf"`
+			} `xml:"NtryDtls>TxDtls"`
+			Info string `xml:"AddtlNtryInf"`
+		} `xml:"Ntry"`
+	} `xml:"BkToCstmrStmt>Stmt"`
+}
+
+// camtAccount is an account identified by its IBAN or otherwise.
+type camtAccount struct {
+	IBAN     string `xml:"Id>IBAN"`
+	Other    string `xml:"Id>Othr>Id"`
+	Currency string `xml:"Ccy"`
+}
+
+// Number returns the IBAN, or the other identification.
+func (a camtAccount) Number() string {
+	if a.IBAN != "" {
+		return strings.TrimSpace(a.IBAN)
+	}
+	return strings.TrimSpace(a.Other)
+}
+
+// camtParty is a debtor or creditor, named directly or as a party since
+// version 8.
+type camtParty struct {
+	Name      string `xml:"Nm"`
+	PartyName string `xml:"Pty>Nm"`
+}
+
+// camtAmount is an amount with its currency.
+type camtAmount struct {
+	Value    string `xml:",chardata"`
+	Currency string `xml:"Ccy,attr"`
+}
+
+// camtDate is a date given as a date or as a time.
+type camtDate struct {
+	Date     string `xml:"Dt"`
+	DateTime string `xml:"DtTm"`
+}
+
+// Time returns the date, or the zero time when it is missing.
+func (d camtDate) Time() time.Time {
+	if t, err := time.Parse("2006-01-02", strings.TrimSpace(d.Date)); err == nil {
+		return t
+	}
+	if len(d.DateTime) >= 10 {
+		if t, err := time.Parse("2006-01-02", d.DateTime[:10]); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// ParseCAMT053 reads the statements of a CAMT.053 document. An entry with
+// more transaction details, each with its own amount, is a batch, and is
+// split to its transactions.
+func ParseCAMT053(data []byte) ([]Statement, error) {
+	var doc camtDocument
+	if err := xml.Unmarshal(data, &doc); err != nil {
+		return nil, errors.Wrap(err, "CAMT.053")
+	}
+
+	var statements []Statement
+	for _, s := range doc.Statements {
+		st := Statement{Account: s.Account.Number(), Currency: s.Account.Currency}
+		for _, e := range s.Entries {
+			credit := e.Indicator == "CRDT"
+			if e.Reversal {
+				credit = !credit
+			}
+			date := e.Booking.Time()
+			if date.IsZero() {
+				date = e.Value.Time()
+			}
+			base := Transaction{Date: date, Currency: e.Amount.Currency, Reference: e.Reference, Remittance: e.Info}
+			if base.Currency == "" {
+				base.Currency = st.Currency
+			}
+
+			add := func(t Transaction, a camtAmount) error {
+				amount, err := money.Parse(strings.TrimSpace(a.Value))
+				if err != nil {
+					return errors.Wrapf(err, "entry %s", e.Reference)
+				}
+				if !credit {
+					amount = amount.Neg()
+				}
+				t.Amount = amount
+				if a.Currency != "" {
+					t.Currency = a.Currency
+				}
+				t.Remittance = strings.TrimSpace(t.Remittance)
+				st.Transactions = append(st.Transactions, t)
+				return nil
+			}
+			if len(e.Details) == 0 {
+				if err := add(base, e.Amount); err != nil {
+					return nil, err
+				}
+			}
+			for _, d := range e.Details {
+				t, amount := base, e.Amount
+				if len(e.Details) > 1 {
+					switch {
+					case d.Amount != nil:
+						amount = *d.Amount
+					case d.TxAmount != nil:
+						amount = *d.TxAmount
+					default:
+						return nil, errors.Errorf("entry %s: a transaction of the batch has no amount", e.Reference)
+					}
+				}
+				if d.Reference != "" {
+					t.Reference = d.Reference
+				} else if t.Reference == "" && d.EndToEnd != "NOTPROVIDED" {
+					t.Reference = d.EndToEnd
+				}
+				remittance := append(append([]string{}, d.Ustrd...), d.Strd...)
+				if len(remittance) == 0 && d.Info != "" {
+					remittance = []string{d.Info}
+				}
+				if len(remittance) != 0 {
+					t.Remittance = strings.Join(remittance, " ")
+				}
+				party, acct := d.Debtor, d.DebtorAcct
+				if !credit {
+					party, acct = d.Creditor, d.CredAcct
+				}
+				t.Name = strings.TrimSpace(party.Name + party.PartyName)
+				t.Account = acct.Number()
+				if err := add(t, amount); err != nil {
+					return nil, err
+				}
+			}
+		}
+		statements = append(statements, st)
+	}
+	return statements, nil
+}
@@ -0,0 +1,153 @@
+package bankstatement
+
+import (
+	"bufio"
+	"bytes"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/UNO-SOFT/szamlazo/lib/money"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	// mt940Tag matches the first line of a field, like ":61:".
+	mt940Tag = regexp.MustCompile(`^:([0-9]{2}[A-Z]?):(.*)$`)
+	// mt940Balance matches the balances, like "C261016HUF1000,00".
+	mt940Balance = regexp.MustCompile(`^[CD]([0-9]{6})([A-Z]{3})([0-9]+,[0-9]*)`)
+	// mt940Line matches a statement line: value date, entry date, debit or
+	// credit mark, funds code, amount, transaction type and references.
+	mt940Line = regexp.MustCompile(`^([0-9]{6})([0-9]{4})?(R?[CD])[A-Z]?([0-9]+,[0-9]*)([NFS][A-Z0-9]{3})([^/\n]*)(?://([^\n]*))?`)
+	// mt940Subfield matches the structured subfields of information to the
+	// account owner, like "?20".
+	mt940Subfield = regexp.MustCompile(`\?([0-9]{2})`)
+)
+
+// mt940Field is a tag with its value; continuation lines are joined with a
+// newline.
+type mt940Field struct {
+	Tag, Value string
+}
+
+// ParseMT940 reads the statements of an MT940 file. The SWIFT envelope is
+// skipped, and the information to the account owner (:86:) is read in the
+// structured form with ?20-?29 remittance, ?31 account and ?32-?33 name
+// subfields, or as remittance text.
+func ParseMT940(data []byte) ([]Statement, error) {
+	fields, err := mt940Fields(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var statements []Statement
+	var st *Statement
+	var last *Transaction
+	for _, f := range fields {
+		if f.Tag == "20" {
+			statements = append(statements, Statement{})
+			st, last = &statements[len(statements)-1], nil
+			continue
+		}
+		if st == nil {
+			return nil, errors.Errorf(":%s: before the first :20:", f.Tag)
+		}
+		switch f.Tag {
+		case "25":
+			st.Account = strings.TrimSpace(f.Value)
+		case "60F", "60M":
+			if m := mt940Balance.FindStringSubmatch(f.Value); m != nil && st.Currency == "" {
+				st.Currency = m[2]
+			}
+		case "61":
+			t, err := mt940Transaction(f.Value, st.Currency)
+			if err != nil {
+				return nil, err
+			}
+			st.Transactions = append(st.Transactions, t)
+			last = &st.Transactions[len(st.Transactions)-1]
+		case "86":
+			if last != nil {
+				mt940Information(last, f.Value)
+			}
+		}
+	}
+	return statements, nil
+}
+
+// mt940Fields splits the text to fields.
+func mt940Fields(data []byte) ([]mt940Field, error) {
+	var fields []mt940Field
+	sc := bufio.NewScanner(bytes.NewReader(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))))
+	for sc.Scan() {
+		line := strings.TrimRight(sc.Text(), "\r ")
+		if m := mt940Tag.FindStringSubmatch(line); m != nil {
+			fields = append(fields, mt940Field{Tag: m[1], Value: m[2]})
+			continue
+		}
+		// The envelope and the end of the message
+		if line == "" || line == "-" || line == "-}" || strings.HasPrefix(line, "{") {
+			continue
+		}
+		if len(fields) == 0 {
+			return nil, errors.Errorf("%q is not an MT940 field", line)
+		}
+		fields[len(fields)-1].Value += "\n" + line
+	}
+	return fields, errors.Wrap(sc.Err(), "read")
+}
+
+// mt940Transaction reads a statement line.
+func mt940Transaction(value string, currency string) (Transaction, error) {
+	m := mt940Line.FindStringSubmatch(value)
+	if m == nil {
+		return Transaction{}, errors.Errorf("bad statement line %q", value)
+	}
+	date, err := time.Parse("060102", m[1])
+	if err != nil {
+		return Transaction{}, errors.Wrap(err, value)
+	}
+	amount, err := money.Parse(strings.Replace(m[4], ",", ".", 1))
+	if err != nil {
+		return Transaction{}, errors.Wrap(err, value)
+	}
+	// A reversal of a debit is a credit and the other way round
+	if m[3] == "D" || m[3] == "RC" {
+		amount = amount.Neg()
+	}
+	t := Transaction{Date: date, Amount: amount, Currency: currency, Reference: strings.TrimSpace(m[7])}
+	if ref := strings.TrimSpace(m[6]); t.Reference == "" && ref != "NONREF" {
+		t.Reference = ref
+	}
+	return t, nil
+}
+
+// mt940Information reads the information to the account owner of a
+// transaction.
+func mt940Information(t *Transaction, value string) {
+	value = strings.Replace(value, "\n", "", -1)
+	loc := mt940Subfield.FindAllStringSubmatchIndex(value, -1)
+	if len(loc) == 0 {
+		t.Remittance = strings.TrimSpace(value)
+		return
+	}
+	var remittance, name []string
+	for i, l := range loc {
+		end := len(value)
+		if i+1 < len(loc) {
+			end = loc[i+1][0]
+		}
+		code, text := value[l[2]:l[3]], value[l[1]:end]
+		switch {
+		case code >= "20" && code <= "29", code >= "60" && code <= "63":
+			remittance = append(remittance, text)
+		case code == "31":
+			t.Account = strings.TrimSpace(text)
+		case code == "32" || code == "33":
+			name = append(name, text)
+		}
+	}
+	t.Remittance = strings.TrimSpace(strings.Join(remittance, ""))
+	t.Name = strings.TrimSpace(strings.Join(name, ""))
+}
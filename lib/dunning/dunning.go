@@ -0,0 +1,59 @@
+// Package dunning provides the schedule of the payment reminders of the
+// overdue invoices: a reminder is due each time an invoice has been overdue
+// for one more of the day offsets, the last one being the final notice.
+package dunning
+
+import (
+	"sort"
+	"time"
+)
+
+// DefaultOffsets are the offsets when the settings have none.
+var DefaultOffsets = []int{3, 15, 30}
+
+// Info holds the settings of the reminders. Zero values select the defaults.
+// When they are sent is set by the schedule of the invoice.dunning task.
+type Info struct {
+	Offsets []int `json:"Offsets"` // Days after the due date the reminders are sent at, like [3, 15, 30]
+}
+
+// Schedule returns the offsets in increasing order, without the ones not
+// after the due date and the duplicates.
+func (i Info) Schedule() []int {
+	offsets := i.Offsets
+	if len(offsets) == 0 {
+		offsets = DefaultOffsets
+	}
+	result := make([]int, 0, len(offsets))
+	for _, d := range offsets {
+		if d > 0 {
+			result = append(result, d)
+		}
+	}
+	sort.Ints(result)
+	n := 0
+	for j, d := range result {
+		if j == 0 || d != result[n-1] {
+			result[n] = d
+			n++
+		}
+	}
+	return result[:n]
+}
+
+// Level returns the number of the offsets passed for an invoice overdue for
+// days, and whether the last of them is the final notice. Only the reminder
+// of the last one is sent when several passed since the previous reminder,
+// so the levels between have none.
+func (i Info) Level(days int) (int, bool) {
+	offsets := i.Schedule()
+	level := sort.SearchInts(offsets, days+1)
+	return level, level == len(offsets) && level > 1
+}
+
+// DaysOverdue returns the number of the days passed since the due date.
+func DaysOverdue(due, today time.Time) int {
+	d := time.Date(due.Year(), due.Month(), due.Day(), 0, 0, 0, 0, time.UTC)
+	t := time.Date(today.Year(), today.Month(), today.Day(), 0, 0, 0, 0, time.UTC)
+	return int(t.Sub(d).Hours() / 24)
+}
@@ -0,0 +1,53 @@
+package dunning_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/UNO-SOFT/szamlazo/lib/dunning"
+)
+
+func TestSchedule(t *testing.T) {
+	if got := (dunning.Info{}).Schedule(); !reflect.DeepEqual(got, dunning.DefaultOffsets) {
+		t.Errorf("default schedule is %v", got)
+	}
+	if got, want := (dunning.Info{Offsets: []int{30, 0, 8, 30, -1, 1}}).Schedule(), []int{1, 8, 30}; !reflect.DeepEqual(got, want) {
+		t.Errorf("schedule is %v, wanted %v", got, want)
+	}
+}
+
+func TestLevel(t *testing.T) {
+	info := dunning.Info{Offsets: []int{3, 15, 30}}
+	for _, tc := range []struct {
+		days  int
+		level int
+		final bool
+	}{
+		{-2, 0, false},
+		{0, 0, false},
+		{2, 0, false},
+		{3, 1, false},
+		{14, 1, false},
+		{15, 2, false},
+		{29, 2, false},
+		{30, 3, true},
+		{400, 3, true},
+	} {
+		level, final := info.Level(tc.days)
+		if level != tc.level || final != tc.final {
+			t.Errorf("%d days: got level %d (final %t), wanted %d (%t)", tc.days, level, final, tc.level, tc.final)
+		}
+	}
+	if _, final := (dunning.Info{Offsets: []int{7}}).Level(10); final {
+		t.Error("a single reminder is not a final notice")
+	}
+}
+
+func TestDaysOverdue(t *testing.T) {
+	due := time.Date(2026, 9, 30, 0, 0, 0, 0, time.UTC)
+	today := time.Date(2026, 10, 17, 23, 59, 0, 0, time.Local)
+	if got := dunning.DaysOverdue(due, today); got != 17 {
+		t.Errorf("got %d days", got)
+	}
+}
@@ -2,12 +2,21 @@
 package flight
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"strconv"
 	"sync"
 
+	"github.com/UNO-SOFT/szamlazo/lib/dunning"
+	"github.com/UNO-SOFT/szamlazo/lib/mnb"
+	"github.com/UNO-SOFT/szamlazo/lib/nav"
+	"github.com/UNO-SOFT/szamlazo/model/audit"
+
 	"github.com/blue-jay/core/asset"
+	"github.com/blue-jay/core/email"
 	"github.com/blue-jay/core/flash"
 	"github.com/blue-jay/core/form"
 	"github.com/blue-jay/core/router"
@@ -22,9 +31,21 @@ var (
 	assetInfo      *asset.Info
 	assetInfoMutex sync.RWMutex
 
+	dunningInfo      *dunning.Info
+	dunningInfoMutex sync.RWMutex
+
+	emailInfo      *email.Info
+	emailInfoMutex sync.RWMutex
+
 	formInfo      *form.Info
 	formInfoMutex sync.RWMutex
 
+	mnbInfo      *mnb.Info
+	mnbInfoMutex sync.RWMutex
+
+	navInfo      *nav.Info
+	navInfoMutex sync.RWMutex
+
 	viewInfo      *view.Info
 	viewInfoMutex sync.RWMutex
 
@@ -39,6 +60,42 @@ func SetAsset(i *asset.Info) {
 	assetInfoMutex.Unlock()
 }
 
+// SetDunning sets the payment reminder configuration.
+func SetDunning(i *dunning.Info) {
+	dunningInfoMutex.Lock()
+	dunningInfo = i
+	dunningInfoMutex.Unlock()
+}
+
+// Dunning returns the payment reminder configuration.
+func Dunning() *dunning.Info {
+	dunningInfoMutex.RLock()
+	d := dunningInfo
+	dunningInfoMutex.RUnlock()
+	if d == nil {
+		return &dunning.Info{}
+	}
+	return d
+}
+
+// SetEmail sets the mail server configuration.
+func SetEmail(i *email.Info) {
+	emailInfoMutex.Lock()
+	emailInfo = i
+	emailInfoMutex.Unlock()
+}
+
+// Email returns the mail server configuration.
+func Email() *email.Info {
+	emailInfoMutex.RLock()
+	e := emailInfo
+	emailInfoMutex.RUnlock()
+	if e == nil {
+		return &email.Info{}
+	}
+	return e
+}
+
 // SetForm sets the form configuration.
 func SetForm(i *form.Info) {
 	formInfoMutex.Lock()
@@ -46,6 +103,42 @@ func SetForm(i *form.Info) {
 	formInfoMutex.Unlock()
 }
 
+// SetMNB sets the exchange rate configuration.
+func SetMNB(i *mnb.Info) {
+	mnbInfoMutex.Lock()
+	mnbInfo = i
+	mnbInfoMutex.Unlock()
+}
+
+// MNB returns the exchange rate configuration.
+func MNB() *mnb.Info {
+	mnbInfoMutex.RLock()
+	m := mnbInfo
+	mnbInfoMutex.RUnlock()
+	if m == nil {
+		return &mnb.Info{}
+	}
+	return m
+}
+
+// SetNAV sets the Online Invoice configuration.
+func SetNAV(i *nav.Info) {
+	navInfoMutex.Lock()
+	navInfo = i
+	navInfoMutex.Unlock()
+}
+
+// NAV returns the Online Invoice configuration.
+func NAV() *nav.Info {
+	navInfoMutex.RLock()
+	n := navInfo
+	navInfoMutex.RUnlock()
+	if n == nil {
+		return &nav.Info{}
+	}
+	return n
+}
+
 // SetView sets the view configuration.
 func SetView(i *view.Info) {
 	viewInfoMutex.Lock()
@@ -68,15 +161,47 @@ func Xsrf() *xsrf.Info {
 	return x
 }
 
+// Bearer is the user of a request authenticated by an API token instead of
+// the session, see bearer.Handler.
+type Bearer struct {
+	UserID    string
+	CompanyID string // The company the token was created for, "" if none
+}
+
+// bearerKey is the context key of the Bearer of a request.
+type bearerKey struct{}
+
+// WithBearer returns the request authenticated as the user of an API token.
+func WithBearer(r *http.Request, b Bearer) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), bearerKey{}, b))
+}
+
+// requestIDKey is the context key of the ID of a request.
+type requestIDKey struct{}
+
+// WithRequestID returns the request with its ID, see requestid.Handler.
+func WithRequestID(r *http.Request, ID string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), requestIDKey{}, ID))
+}
+
+// RequestID returns the ID of the request, "" if it has none.
+func RequestID(r *http.Request) string {
+	ID, _ := r.Context().Value(requestIDKey{}).(string)
+	return ID
+}
+
 // Info holds the commonly used information.
 type Info struct {
-	Asset  *asset.Info
-	Form   *form.Info
-	Sess   *sessions.Session
-	UserID string
-	W      http.ResponseWriter
-	R      *http.Request
-	View   *view.Info
+	Asset     *asset.Info
+	Form      *form.Info
+	Sess      *sessions.Session
+	UserID    string
+	CompanyID string // The active company of the user, see acl.RequireCompany
+	Bearer    bool   // Authenticated by an API token, not the session
+	RequestID string
+	W         http.ResponseWriter
+	R         *http.Request
+	View      *view.Info
 }
 
 // Context returns commonly used information.
@@ -103,15 +228,23 @@ func Context(w http.ResponseWriter, r *http.Request) *Info {
 	v := viewInfo
 	viewInfoMutex.RUnlock()
 
-	return &Info{
-		Asset:  i,
-		Form:   f,
-		Sess:   sess,
-		UserID: fmt.Sprintf("%v", sess.Values["id"]),
-		W:      w,
-		R:      r,
-		View:   v,
+	c := &Info{
+		Asset:     i,
+		Form:      f,
+		Sess:      sess,
+		UserID:    fmt.Sprintf("%v", sess.Values["id"]),
+		CompanyID: fmt.Sprintf("%v", sess.Values["company_id"]),
+		W:         w,
+		R:         r,
+		View:      v,
+		RequestID: RequestID(r),
+	}
+
+	// A token stands in for the session, which is left untouched
+	if b, ok := r.Context().Value(bearerKey{}).(Bearer); ok {
+		c.UserID, c.CompanyID, c.Bearer = b.UserID, b.CompanyID, true
 	}
+	return c
 }
 
 // Param gets the URL parameter.
@@ -119,6 +252,27 @@ func (c *Info) Param(name string) string {
 	return router.Param(c.R, name)
 }
 
+// Company returns the ID of the active company, 0 if there is none.
+func (c *Info) Company() uint32 {
+	ID, _ := strconv.ParseUint(c.CompanyID, 10, 32)
+	return uint32(ID)
+}
+
+// Actor returns who makes the changes of the request, for the audit log.
+func (c *Info) Actor() audit.Actor {
+	a := audit.Actor{IP: c.R.RemoteAddr, RequestID: c.RequestID}
+	if host, _, err := net.SplitHostPort(c.R.RemoteAddr); err == nil {
+		a.IP = host
+	}
+	if c.Bearer || c.Sess.Values["id"] != nil {
+		a.UserID = c.UserID
+	}
+	if c.Company() != 0 {
+		a.CompanyID = c.CompanyID
+	}
+	return a
+}
+
 // Redirect sends a temporary redirect.
 func (c *Info) Redirect(urlStr string) {
 	http.Redirect(c.W, c.R, urlStr, http.StatusFound)
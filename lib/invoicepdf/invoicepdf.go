@@ -0,0 +1,326 @@
+// Package invoicepdf renders an invoice as a PDF document.
+package invoicepdf
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/UNO-SOFT/szamlazo/lib/money"
+	"github.com/UNO-SOFT/szamlazo/lib/pdf"
+	"github.com/UNO-SOFT/szamlazo/model/invoice"
+)
+
+// Margins and the bottom of the printable area, in millimetres.
+const (
+	left   = 15.0
+	right  = pdf.PageWidth - 15
+	bottom = pdf.PageHeight - 20
+)
+
+var (
+	// titles are the headings and the document names of the kinds of
+	// invoices.
+	titles = map[string]struct{ heading, name string }{
+		invoice.KindNormal:       {"SZÁMLA / INVOICE", "Számla"},
+		invoice.KindStorno:       {"SZTORNÓ SZÁMLA / CANCELLATION INVOICE", "Sztornó számla"},
+		invoice.KindModification: {"MÓDOSÍTÓ SZÁMLA / MODIFICATION INVOICE", "Módosító számla"},
+	}
+
+	// paymentNames are the labels of the payment methods.
+	paymentNames = map[string]string{
+		invoice.PaymentTransfer: "Átutalás / Transfer",
+		invoice.PaymentCash:     "Készpénz / Cash",
+		invoice.PaymentCard:     "Bankkártya / Card",
+		invoice.PaymentVoucher:  "Utalvány / Voucher",
+		invoice.PaymentOther:    "Egyéb / Other",
+	}
+
+	// currencyNames are the currencies spelled out after the amount in
+	// words.
+	currencyNames = map[string]string{
+		"HUF": "forint",
+		"EUR": "euró",
+		"USD": "amerikai dollár",
+		"GBP": "angol font",
+		"CHF": "svájci frank",
+	}
+
+	// columns of the line table: heading in Hungarian and English, edge in
+	// millimetres and whether the values are aligned right to it.
+	columns = []struct {
+		hu, en string
+		x      float64
+		right  bool
+	}{
+		{"#", "", 21, true},
+		{"Megnevezés", "Description", 23, false},
+		{"Mennyiség", "Quantity", 90, true},
+		{"Egység", "Unit", 92, false},
+		{"Egységár", "Unit price", 126, true},
+		{"ÁFA", "VAT", 128, false},
+		{"Nettó", "Net", 157, true},
+		{"ÁFA", "VAT", 176, true},
+		{"Bruttó", "Gross", right, true},
+	}
+)
+
+// descWidth is the width of the description column.
+const descWidth = 49.0
+
+// Render writes item as a PDF document to w. copyNo is 0 for the original
+// and counts the copies from 1. Drafts are marked as such.
+func Render(w io.Writer, item invoice.Item, copyNo int) error {
+	title, ok := titles[item.Kind]
+	if !ok {
+		title = titles[invoice.KindNormal]
+	}
+	r := renderer{
+		doc:    pdf.New(title.name + " " + item.Number.String),
+		title:  title.heading,
+		item:   item,
+		totals: item.Totals(),
+		marker: "Eredeti / Original",
+	}
+	if !item.Finalized() {
+		r.marker = "Piszkozat / Draft"
+	} else if copyNo > 0 {
+		r.marker = fmt.Sprintf("%d. másolat / Copy %d", copyNo, copyNo)
+	}
+	r.newPage()
+	r.parties()
+	r.dates()
+	r.lines()
+	r.summary()
+	_, err := r.doc.WriteTo(w)
+	return err
+}
+
+// renderer keeps the state of the document being rendered.
+type renderer struct {
+	doc    *pdf.Document
+	page   *pdf.Page
+	y      float64
+	pageNo int
+	title  string
+	item   invoice.Item
+	totals invoice.Totals
+	marker string
+}
+
+// newPage starts a page with the header repeated on every page.
+func (r *renderer) newPage() {
+	r.page = r.doc.AddPage()
+	r.pageNo++
+	r.page.Text(left, 22, pdf.Bold, 18, r.title)
+	r.page.TextRight(right, 16, pdf.Bold, 10, r.marker)
+	r.page.TextRight(right, 22, pdf.Bold, 12, r.item.Number.String)
+	r.page.TextRight(right, bottom+10, pdf.Regular, 8, fmt.Sprintf("%d. oldal / page", r.pageNo))
+	r.page.Line(left, 26, right, 26)
+	r.y = 34
+}
+
+// need starts a new page unless h millimetres are left on this one, and
+// reports whether it did.
+func (r *renderer) need(h float64) bool {
+	if r.y+h <= bottom {
+		return false
+	}
+	r.newPage()
+	return true
+}
+
+// parties writes the seller and buyer blocks.
+func (r *renderer) parties() {
+	mid := (left + right) / 2
+	block := func(x float64, title, name, address, taxNumber string) float64 {
+		y := r.y
+		r.page.Text(x, y, pdf.Bold, 8, title)
+		y += 6
+		for _, s := range pdf.Wrap(pdf.Bold, 11, mid-left-5, name) {
+			r.page.Text(x, y, pdf.Bold, 11, s)
+			y += 5
+		}
+		for _, s := range pdf.Wrap(pdf.Regular, 9, mid-left-5, address) {
+			r.page.Text(x, y, pdf.Regular, 9, s)
+			y += 4.5
+		}
+		if taxNumber != "" {
+			r.page.Text(x, y, pdf.Regular, 9, "Adószám / Tax number: "+taxNumber)
+			y += 4.5
+		}
+		return y
+	}
+	y1 := block(left, "ELADÓ / SELLER", r.item.SellerName, r.item.SellerAddress, r.item.SellerTaxNumber)
+	y2 := block(mid, "VEVŐ / BUYER", r.item.BuyerName, r.item.BuyerAddress, r.item.BuyerTaxNumber)
+	if y2 > y1 {
+		y1 = y2
+	}
+	r.y = y1 + 4
+}
+
+// dates writes the dates and the terms of payment.
+func (r *renderer) dates() {
+	const layout = "2006.01.02."
+	payment := paymentNames[r.item.PaymentMethod]
+	if payment == "" {
+		payment = r.item.PaymentMethod
+	}
+	boxes := [][2]string{
+		{"Kelte / Issued", r.item.IssueDate.Format(layout)},
+		{"Teljesítés / Fulfilled", r.item.FulfilmentDate.Format(layout)},
+		{"Fizetési határidő / Due", r.item.DueDate.Format(layout)},
+		{"Fizetési mód / Payment", payment},
+		{"Pénznem / Currency", r.item.Currency},
+	}
+	if r.item.IsCorrection() {
+		boxes = append([][2]string{{"Eredeti számla / Original", r.item.OriginalNumber.String}}, boxes...)
+	}
+	w := (right - left) / float64(len(boxes))
+	for i, b := range boxes {
+		x := left + float64(i)*w
+		r.page.Rect(x, r.y, w, 12)
+		r.page.Text(x+2, r.y+4.5, pdf.Regular, 7, b[0])
+		r.page.Text(x+2, r.y+9.5, pdf.Bold, 9, b[1])
+	}
+	r.y += 20
+}
+
+// lineHeader writes the heading of the line table.
+func (r *renderer) lineHeader() {
+	for _, c := range columns {
+		if c.right {
+			r.page.TextRight(c.x, r.y, pdf.Bold, 7, c.hu)
+			r.page.TextRight(c.x, r.y+3.5, pdf.Regular, 7, c.en)
+		} else {
+			r.page.Text(c.x, r.y, pdf.Bold, 7, c.hu)
+			r.page.Text(c.x, r.y+3.5, pdf.Regular, 7, c.en)
+		}
+	}
+	r.page.Line(left, r.y+5, right, r.y+5)
+	r.y += 9.5
+}
+
+// lines writes the line table, breaking it across pages.
+func (r *renderer) lines() {
+	r.lineHeader()
+	for i, line := range r.item.Lines {
+		desc := pdf.Wrap(pdf.Regular, 8, descWidth, line.Description)
+		if line.Code != "" {
+			desc = append(desc, line.CodeType+": "+line.Code)
+		}
+		if r.need(float64(len(desc)) * 4) {
+			r.lineHeader()
+		}
+		a := r.totals.Lines[i]
+		values := []string{
+			fmt.Sprint(i + 1), "",
+			format(line.Quantity), line.Unit, format(line.UnitPrice), rateName(line.VATRate),
+			format(a.Net), format(a.VAT), format(a.Gross),
+		}
+		for j, c := range columns {
+			if c.right {
+				r.page.TextRight(c.x, r.y, pdf.Regular, 8, values[j])
+			} else {
+				r.page.Text(c.x, r.y, pdf.Regular, 8, values[j])
+			}
+		}
+		for _, s := range desc {
+			r.page.Text(columns[1].x, r.y, pdf.Regular, 8, s)
+			r.y += 4
+		}
+		r.y++
+	}
+	r.page.Line(left, r.y-2, right, r.y-2)
+	r.y += 4
+}
+
+// summary writes the VAT summary, the totals and the amount in words.
+func (r *renderer) summary() {
+	r.need(float64(len(r.totals.ByRate))*5 + 42)
+	x := []float64{right - 90, right - 52, right - 26, right}
+	r.page.Text(x[0], r.y, pdf.Bold, 8, "ÁFA összesítő / VAT summary")
+	r.page.TextRight(x[1], r.y, pdf.Bold, 7, "Nettó / Net")
+	r.page.TextRight(x[2], r.y, pdf.Bold, 7, "ÁFA / VAT")
+	r.page.TextRight(x[3], r.y, pdf.Bold, 7, "Bruttó / Gross")
+	r.page.Line(x[0], r.y+1.5, right, r.y+1.5)
+	r.y += 6
+	row := func(font pdf.Font, title string, a invoice.Amounts) {
+		r.page.Text(x[0], r.y, font, 8, title)
+		r.page.TextRight(x[1], r.y, font, 8, format(a.Net))
+		r.page.TextRight(x[2], r.y, font, 8, format(a.VAT))
+		r.page.TextRight(x[3], r.y, font, 8, format(a.Gross))
+		r.y += 5
+	}
+	for _, a := range r.totals.ByRate {
+		row(pdf.Regular, rateName(a.Rate), a.Amounts)
+	}
+	r.page.Line(x[0], r.y-3.5, right, r.y-3.5)
+	row(pdf.Bold, "Összesen / Total", r.totals.Amounts)
+
+	r.y += 4
+	r.page.Text(left, r.y, pdf.Bold, 11, "Fizetendő / Amount due:")
+	r.page.TextRight(right, r.y, pdf.Bold, 11, format(r.totals.Gross)+" "+r.item.Currency)
+	r.y += 6
+	r.page.Text(left, r.y, pdf.Regular, 9, "azaz / in words: "+inWords(r.totals.Gross, r.item.Currency))
+
+	if r.item.Foreign() && !r.item.ExchangeRate.IsZero() {
+		r.y += 6
+		r.page.Text(left, r.y, pdf.Regular, 9, fmt.Sprintf("MNB árfolyam / MNB rate (%s): 1 %s = %s HUF",
+			r.item.FulfilmentDate.Format("2006.01.02."), r.item.Currency, format(r.item.ExchangeRate)))
+		r.y += 5
+		r.page.Text(left, r.y, pdf.Bold, 9, "ÁFA forintban / VAT in HUF: "+format(r.item.HUF(r.totals.VAT))+" HUF")
+	}
+}
+
+// rateName returns the label of a VAT rate: the percentage or the code of
+// the exemption.
+func rateName(rate string) string {
+	if _, err := strconv.Atoi(rate); err == nil {
+		return rate + "%"
+	}
+	return rate
+}
+
+// format writes d in the Hungarian way, with spaces between the thousands
+// and a decimal comma: 1 234,50.
+func format(d money.Decimal) string {
+	s := d.String()
+	sign := ""
+	if strings.HasPrefix(s, "-") {
+		sign, s = "-", s[1:]
+	}
+	frac := ""
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		s, frac = s[:i], ","+s[i+1:]
+	}
+	for i := len(s) - 3; i > 0; i -= 3 {
+		s = s[:i] + " " + s[i:]
+	}
+	return sign + s + frac
+}
+
+// inWords spells out the amount with its currency, the fraction as hundredths.
+func inWords(d money.Decimal, currency string) string {
+	whole := d.Int64()
+	s := money.Words(whole)
+	first, n := utf8.DecodeRuneInString(s)
+	s = string(unicode.ToUpper(first)) + s[n:]
+	if name, ok := currencyNames[currency]; ok {
+		s += " " + name
+	} else {
+		s += " " + currency
+	}
+	places := money.Places(currency)
+	if frac := d.Sub(money.New(whole, 0)).Round(places); !frac.IsZero() {
+		cents := frac.Mul(money.New(100, 0)).Int64()
+		if cents < 0 {
+			cents = -cents
+		}
+		s += fmt.Sprintf(" %02d/100", cents)
+	}
+	return s
+}
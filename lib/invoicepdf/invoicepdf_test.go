@@ -0,0 +1,116 @@
+package invoicepdf
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/UNO-SOFT/szamlazo/lib/money"
+	"github.com/UNO-SOFT/szamlazo/model/invoice"
+
+	"gopkg.in/guregu/null.v3"
+)
+
+// TestFormat checks the grouping of the thousands.
+func TestFormat(t *testing.T) {
+	for in, want := range map[string]string{
+		"0":           "0",
+		"999":         "999",
+		"1000":        "1 000",
+		"-1234567.50": "-1 234 567,50",
+		"123456":      "123 456",
+	} {
+		if got := format(money.MustParse(in)); got != want {
+			t.Errorf("%s: got %q want %q", in, got, want)
+		}
+	}
+}
+
+// TestInWords checks the currency and the fraction of the amount in words.
+func TestInWords(t *testing.T) {
+	for _, tc := range []struct {
+		amount, currency, want string
+	}{
+		{"12700", "HUF", "Tizenkétezer-hétszáz forint"},
+		{"5.5", "EUR", "Öt euró 50/100"},
+		{"300.07", "PLN", "Háromszáz PLN 07/100"},
+	} {
+		if got := inWords(money.MustParse(tc.amount), tc.currency); got != tc.want {
+			t.Errorf("%s %s: got %q want %q", tc.amount, tc.currency, got, tc.want)
+		}
+	}
+}
+
+// TestRender checks that long invoices are broken into pages.
+func TestRender(t *testing.T) {
+	item := invoice.Item{
+		Number:          null.StringFrom("SZ2026/00042"),
+		SellerName:      "Példa Kft.",
+		SellerAddress:   "1051 Budapest, Fő utca 1.",
+		SellerTaxNumber: "12345676-1-13",
+		BuyerName:       "Vevő Bt.",
+		BuyerAddress:    "6720 Szeged, Kárász utca 2.",
+		IssueDate:       time.Date(2026, 10, 17, 0, 0, 0, 0, time.UTC),
+		FulfilmentDate:  time.Date(2026, 10, 17, 0, 0, 0, 0, time.UTC),
+		DueDate:         time.Date(2026, 10, 25, 0, 0, 0, 0, time.UTC),
+		Currency:        "HUF",
+		PaymentMethod:   invoice.PaymentTransfer,
+		Rounding:        invoice.RoundPerLine,
+	}
+	for i := 0; i < 60; i++ {
+		item.Lines = append(item.Lines, invoice.Line{
+			Description: strings.Repeat("Szoftverfejlesztés ", 1+i%4),
+			Quantity:    money.MustParse("1.5"),
+			Unit:        "óra",
+			UnitPrice:   money.MustParse("15000"),
+			VATRate:     invoice.VAT27,
+		})
+	}
+
+	var buf bytes.Buffer
+	if err := Render(&buf, item, 2); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	if !strings.HasPrefix(out, "%PDF-") {
+		t.Fatal("not a PDF")
+	}
+	if strings.Contains(out, "/Count 1 ") {
+		t.Error("60 lines fit on a page")
+	}
+	if !strings.Contains(out, "(2. m\xe1solat / Copy 2)") {
+		t.Error("copy marker missing")
+	}
+
+	storno := item.Storno(item.IssueDate)
+	storno.Number = null.StringFrom("SZH2026/00001")
+	buf.Reset()
+	if err := Render(&buf, storno, 0); err != nil {
+		t.Fatal(err)
+	}
+	out = buf.String()
+	if !strings.Contains(out, "(SZTORN\xd3 SZ\xc1MLA / CANCELLATION INVOICE)") {
+		t.Error("storno title missing")
+	}
+	if !strings.Contains(out, "(SZ2026/00042)") {
+		t.Error("original invoice number missing")
+	}
+
+	// 60 × 1.5 × 150.00 EUR is 13 500.00 EUR, with 3 645.00 EUR VAT
+	item.Currency, item.ExchangeRate = "EUR", money.MustParse("390.12")
+	for i := range item.Lines {
+		item.Lines[i].UnitPrice = money.MustParse("150")
+	}
+	buf.Reset()
+	if err := Render(&buf, item, 0); err != nil {
+		t.Fatal(err)
+	}
+	out = buf.String()
+	if !strings.Contains(out, "(MNB \xe1rfolyam / MNB rate \\(2026.10.17.\\): 1 EUR = 390,12 HUF)") {
+		t.Error("exchange rate missing")
+	}
+	if !strings.Contains(out, "(\xc1FA forintban / VAT in HUF: 1 421 987,40 HUF)") {
+		t.Error("VAT in HUF missing")
+	}
+}
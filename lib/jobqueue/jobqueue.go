@@ -0,0 +1,343 @@
+// Package jobqueue runs the slow tasks, such as reporting to NAV, in the
+// background. The jobs are kept in the database, so they survive a restart:
+// failed jobs are retried with an exponential backoff and the ones failing
+// too many times are kept as dead letters.
+package jobqueue
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/UNO-SOFT/szamlazo/model/job"
+)
+
+// Defaults of the settings.
+const (
+	defaultWorkers      = 2
+	defaultPollInterval = 5 * time.Second
+	defaultLockTimeout  = 10 * time.Minute
+	defaultMaxAttempts  = 8
+)
+
+// Backoff bounds.
+const (
+	minBackoff = 10 * time.Second
+	maxBackoff = time.Hour
+)
+
+// Info holds the settings of the queue. Zero values select the defaults.
+type Info struct {
+	Workers      int `json:"Workers"`      // Number of the jobs run at a time
+	PollInterval int `json:"PollInterval"` // Seconds between looking for due jobs
+	LockTimeout  int `json:"LockTimeout"`  // Seconds a job may run before it is taken for abandoned
+	MaxAttempts  int `json:"MaxAttempts"`  // Runs before a job is dead
+}
+
+// Handler runs a job with the payload it was enqueued with. A returned error
+// makes the job retried later, unless it is Permanent. The context is
+// cancelled when the job runs out of time or the queue is stopped.
+type Handler func(ctx context.Context, payload []byte) error
+
+// Enqueuer adds the jobs, like a job.Service bound to a transaction.
+type Enqueuer interface {
+	Enqueue(kind string, payload []byte, runAt time.Time, maxAttempts int) (uint32, error)
+}
+
+// Store keeps the jobs, see job.Service.
+type Store interface {
+	Enqueuer
+	Claim(lockTimeout time.Duration) (job.Item, bool, error)
+	Done(ID uint32, attempts int) (sql.Result, error)
+	Retry(ID uint32, attempts int, runAt time.Time, lastError string) (sql.Result, error)
+	Bury(ID uint32, attempts int, lastError string) (sql.Result, error)
+}
+
+var (
+	handlers      = make(map[string]Handler)
+	handlersMutex sync.RWMutex
+
+	std      *Queue
+	stdMutex sync.RWMutex
+)
+
+// Handle registers the handler of a kind of job. Register the handlers before
+// the queue starts, like the routes.
+func Handle(kind string, h Handler) {
+	handlersMutex.Lock()
+	handlers[kind] = h
+	handlersMutex.Unlock()
+}
+
+// handler returns the handler of a kind of job.
+func handler(kind string) (Handler, bool) {
+	handlersMutex.RLock()
+	h, ok := handlers[kind]
+	handlersMutex.RUnlock()
+	return h, ok
+}
+
+// permanent is an error which is not worth retrying.
+type permanent struct {
+	error
+}
+
+// Permanent marks err as final: the job is dead at once.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return permanent{err}
+}
+
+// Queue runs the jobs of a store.
+type Queue struct {
+	store        Store
+	workers      int
+	pollInterval time.Duration
+	lockTimeout  time.Duration
+	maxAttempts  int
+	backoff      func(attempt int) time.Duration
+
+	wake   chan struct{}
+	ctx    context.Context
+	cancel context.CancelFunc
+	quit   chan struct{}
+	wg     sync.WaitGroup
+}
+
+// New returns a queue of the jobs in store. Start it with Run.
+func New(store Store, info Info) *Queue {
+	q := &Queue{
+		store:        store,
+		workers:      info.Workers,
+		pollInterval: time.Duration(info.PollInterval) * time.Second,
+		lockTimeout:  time.Duration(info.LockTimeout) * time.Second,
+		maxAttempts:  info.MaxAttempts,
+		backoff:      Backoff,
+		wake:         make(chan struct{}, 1),
+		quit:         make(chan struct{}),
+	}
+	if q.workers <= 0 {
+		q.workers = defaultWorkers
+	}
+	if q.pollInterval <= 0 {
+		q.pollInterval = defaultPollInterval
+	}
+	if q.lockTimeout <= 0 {
+		q.lockTimeout = defaultLockTimeout
+	}
+	if q.maxAttempts <= 0 {
+		q.maxAttempts = defaultMaxAttempts
+	}
+	q.ctx, q.cancel = context.WithCancel(context.Background())
+	return q
+}
+
+// Backoff returns the wait before the next run of a job failed attempt times:
+// 10s, 20s, 40s and so on, up to an hour.
+func Backoff(attempt int) time.Duration {
+	d := minBackoff
+	for i := 1; i < attempt && d < maxBackoff; i++ {
+		d *= 2
+	}
+	if d > maxBackoff {
+		d = maxBackoff
+	}
+	return d
+}
+
+// Run starts the workers.
+func (q *Queue) Run() {
+	for i := 0; i < q.workers; i++ {
+		q.wg.Add(1)
+		go q.work()
+	}
+}
+
+// Stop stops taking new jobs and waits for the running ones to finish, at
+// most for timeout. The jobs still running then are cancelled; they are run
+// again after a restart, once their lock times out.
+func (q *Queue) Stop(timeout time.Duration) {
+	close(q.quit)
+	done := make(chan struct{})
+	go func() {
+		q.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		log.Println("jobqueue: cancelling the running jobs")
+		q.cancel()
+		<-done
+	}
+	q.cancel()
+}
+
+// Enqueue adds a job of kind to run after delay. The payload is encoded as
+// JSON for the handler.
+func (q *Queue) Enqueue(kind string, payload interface{}, delay time.Duration) error {
+	if err := q.EnqueueIn(q.store, kind, payload, delay); err != nil {
+		return err
+	}
+	if delay <= 0 {
+		select {
+		case q.wake <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// EnqueueIn adds a job like Enqueue, but with e, so that a job enqueued
+// within a transaction is only added when the transaction commits. The
+// workers find it at their next poll.
+func (q *Queue) EnqueueIn(e Enqueuer, kind string, payload interface{}, delay time.Duration) error {
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	_, err = e.Enqueue(kind, b, time.Now().Add(delay), q.maxAttempts)
+	return err
+}
+
+// work runs the due jobs until the queue is stopped.
+func (q *Queue) work() {
+	defer q.wg.Done()
+	for {
+		select {
+		case <-q.quit:
+			return
+		default:
+		}
+
+		item, noRows, err := q.store.Claim(q.lockTimeout)
+		if err != nil {
+			log.Println("jobqueue:", err)
+		} else if !noRows {
+			q.run(item)
+			continue
+		}
+
+		select {
+		case <-q.quit:
+			return
+		case <-q.wake:
+		case <-time.After(q.pollInterval):
+		}
+	}
+}
+
+// run runs a job and records its outcome.
+func (q *Queue) run(item job.Item) {
+	var result sql.Result
+	err := q.call(item)
+	if err == nil {
+		result, err = q.store.Done(item.ID, item.Attempts)
+	} else if _, final := err.(permanent); final || item.Attempts >= item.MaxAttempts {
+		log.Printf("jobqueue: %s job %d is dead after %d attempts: %v", item.Kind, item.ID, item.Attempts, err)
+		result, err = q.store.Bury(item.ID, item.Attempts, err.Error())
+	} else {
+		log.Printf("jobqueue: %s job %d failed: %v", item.Kind, item.ID, err)
+		result, err = q.store.Retry(item.ID, item.Attempts, time.Now().Add(q.backoff(item.Attempts)), err.Error())
+	}
+	recorded(item, result, err)
+}
+
+// recorded logs when the outcome of a job could not be recorded, or was
+// dropped because another worker claimed the job again meanwhile.
+func recorded(item job.Item, result sql.Result, err error) {
+	if err != nil {
+		log.Println("jobqueue:", err)
+	} else if n, err := result.RowsAffected(); err == nil && n == 0 {
+		log.Printf("jobqueue: %s job %d was claimed again, the outcome of attempt %d is dropped", item.Kind, item.ID, item.Attempts)
+	}
+}
+
+// call calls the handler of a job, turning a panic into an error.
+func (q *Queue) call(item job.Item) (err error) {
+	h, ok := handler(item.Kind)
+	if !ok {
+		return Permanent(fmt.Errorf("no handler for %q", item.Kind))
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("jobqueue: %s job %d panicked: %v\n%s", item.Kind, item.ID, r, debug.Stack())
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	ctx, cancel := context.WithTimeout(q.ctx, q.handlerTimeout())
+	defer cancel()
+	ctx = context.WithValue(ctx, attemptKey{}, attempt{item.Attempts, item.MaxAttempts})
+	return h(ctx, item.Payload)
+}
+
+// handlerTimeout returns how long a handler may run: nine tenths of the lock
+// timeout, which leaves time to record the outcome before the job may be
+// claimed again.
+func (q *Queue) handlerTimeout() time.Duration {
+	return q.lockTimeout - q.lockTimeout/10
+}
+
+// attemptKey is the context key of the attempt of a job.
+type attemptKey struct{}
+
+// attempt counts the runs of a job.
+type attempt struct {
+	n, max int
+}
+
+// LastAttempt reports whether the job run by the handler with ctx is dead if
+// it fails now, so the handler can record the final failure.
+func LastAttempt(ctx context.Context) bool {
+	a, ok := ctx.Value(attemptKey{}).(attempt)
+	return ok && a.n >= a.max
+}
+
+// Start starts the queue of the application.
+func Start(store Store, info Info) {
+	q := New(store, info)
+	stdMutex.Lock()
+	std = q
+	stdMutex.Unlock()
+	q.Run()
+}
+
+// Stop stops the queue of the application, see Queue.Stop.
+func Stop(timeout time.Duration) {
+	stdMutex.RLock()
+	q := std
+	stdMutex.RUnlock()
+	if q != nil {
+		q.Stop(timeout)
+	}
+}
+
+// Enqueue adds a job to the queue of the application, see Queue.Enqueue.
+func Enqueue(kind string, payload interface{}, delay time.Duration) error {
+	stdMutex.RLock()
+	q := std
+	stdMutex.RUnlock()
+	if q == nil {
+		return fmt.Errorf("jobqueue: not started")
+	}
+	return q.Enqueue(kind, payload, delay)
+}
+
+// EnqueueIn adds a job to the queue of the application with e, see
+// Queue.EnqueueIn.
+func EnqueueIn(e Enqueuer, kind string, payload interface{}, delay time.Duration) error {
+	stdMutex.RLock()
+	q := std
+	stdMutex.RUnlock()
+	if q == nil {
+		return fmt.Errorf("jobqueue: not started")
+	}
+	return q.EnqueueIn(e, kind, payload, delay)
+}
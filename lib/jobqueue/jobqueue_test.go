@@ -0,0 +1,282 @@
+package jobqueue
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/UNO-SOFT/szamlazo/model/job"
+)
+
+// memStore keeps the jobs in memory.
+type memStore struct {
+	mu   sync.Mutex
+	jobs []job.Item
+}
+
+func (s *memStore) Enqueue(kind string, payload []byte, runAt time.Time, maxAttempts int) (uint32, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ID := uint32(len(s.jobs) + 1)
+	s.jobs = append(s.jobs, job.Item{ID: ID, Kind: kind, Payload: payload,
+		Status: job.StatusPending, MaxAttempts: maxAttempts, RunAt: runAt})
+	return ID, nil
+}
+
+func (s *memStore) Claim(lockTimeout time.Duration) (job.Item, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, item := range s.jobs {
+		if item.Status == job.StatusPending && !item.RunAt.After(time.Now()) {
+			s.jobs[i].Status = job.StatusRunning
+			s.jobs[i].Attempts++
+			return s.jobs[i], false, nil
+		}
+	}
+	return job.Item{}, true, nil
+}
+
+func (s *memStore) set(ID uint32, attempts int, status string, runAt time.Time, lastError string) (sql.Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.jobs[ID-1].Status != job.StatusRunning || s.jobs[ID-1].Attempts != attempts {
+		return driver.RowsAffected(0), nil
+	}
+	s.jobs[ID-1].Status = status
+	s.jobs[ID-1].LastError = lastError
+	if !runAt.IsZero() {
+		s.jobs[ID-1].RunAt = runAt
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func (s *memStore) Done(ID uint32, attempts int) (sql.Result, error) {
+	return s.set(ID, attempts, job.StatusDone, time.Time{}, "")
+}
+
+func (s *memStore) Retry(ID uint32, attempts int, runAt time.Time, lastError string) (sql.Result, error) {
+	return s.set(ID, attempts, job.StatusPending, runAt, lastError)
+}
+
+func (s *memStore) Bury(ID uint32, attempts int, lastError string) (sql.Result, error) {
+	return s.set(ID, attempts, job.StatusDead, time.Time{}, lastError)
+}
+
+// reclaim claims a running job again, like another worker after the lock
+// timeout.
+func (s *memStore) reclaim(ID uint32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.jobs[ID-1].Attempts++
+}
+
+// get returns a job.
+func (s *memStore) get(ID uint32) job.Item {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.jobs[ID-1]
+}
+
+// wait waits for a job to reach status.
+func wait(t *testing.T, s *memStore, ID uint32, status string) job.Item {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if item := s.get(ID); item.Status == status {
+			return item
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("job %d: got %+v, wanted %s", ID, s.get(ID), status)
+	return job.Item{}
+}
+
+// newTestQueue returns a running queue retrying at once.
+func newTestQueue(s *memStore, maxAttempts int) *Queue {
+	q := New(s, Info{Workers: 2, MaxAttempts: maxAttempts})
+	q.pollInterval = 10 * time.Millisecond
+	q.backoff = func(int) time.Duration { return 0 }
+	q.Run()
+	return q
+}
+
+// TestRetry checks that failed jobs are retried, then buried.
+func TestRetry(t *testing.T) {
+	var mu sync.Mutex
+	runs := make(map[string]int)
+	Handle("test.flaky", func(ctx context.Context, payload []byte) error {
+		mu.Lock()
+		defer mu.Unlock()
+		runs[string(payload)]++
+		if runs[string(payload)] < 3 {
+			return errors.New("not yet")
+		}
+		return nil
+	})
+	Handle("test.failing", func(ctx context.Context, payload []byte) error {
+		return errors.New("never")
+	})
+	Handle("test.permanent", func(ctx context.Context, payload []byte) error {
+		return Permanent(errors.New("bad payload"))
+	})
+	Handle("test.panic", func(ctx context.Context, payload []byte) error {
+		panic("boom")
+	})
+
+	s := &memStore{}
+	q := newTestQueue(s, 4)
+	defer q.Stop(time.Second)
+	for _, kind := range []string{"test.flaky", "test.failing", "test.permanent", "test.panic", "test.unknown"} {
+		if err := q.Enqueue(kind, kind, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if item := wait(t, s, 1, job.StatusDone); item.Attempts != 3 || item.LastError != "" {
+		t.Errorf("flaky: got %+v", item)
+	}
+	if item := wait(t, s, 2, job.StatusDead); item.Attempts != 4 || item.LastError != "never" {
+		t.Errorf("failing: got %+v", item)
+	}
+	if item := wait(t, s, 3, job.StatusDead); item.Attempts != 1 {
+		t.Errorf("permanent: got %+v", item)
+	}
+	if item := wait(t, s, 4, job.StatusDead); item.LastError != "panic: boom" {
+		t.Errorf("panic: got %+v", item)
+	}
+	wait(t, s, 5, job.StatusDead)
+}
+
+// TestLastAttempt checks that the handlers can tell their last attempt.
+func TestLastAttempt(t *testing.T) {
+	var mu sync.Mutex
+	var last []bool
+	Handle("test.last", func(ctx context.Context, payload []byte) error {
+		mu.Lock()
+		defer mu.Unlock()
+		last = append(last, LastAttempt(ctx))
+		return errors.New("never")
+	})
+	s := &memStore{}
+	q := newTestQueue(s, 3)
+	defer q.Stop(time.Second)
+	if err := q.Enqueue("test.last", nil, 0); err != nil {
+		t.Fatal(err)
+	}
+	wait(t, s, 1, job.StatusDead)
+	mu.Lock()
+	defer mu.Unlock()
+	if want := []bool{false, false, true}; !reflect.DeepEqual(last, want) {
+		t.Errorf("got %v, wanted %v", last, want)
+	}
+	if LastAttempt(context.Background()) {
+		t.Error("last attempt outside a job")
+	}
+}
+
+// TestDelay checks that delayed jobs wait for their time.
+func TestDelay(t *testing.T) {
+	Handle("test.noop", func(ctx context.Context, payload []byte) error { return nil })
+	s := &memStore{}
+	q := newTestQueue(s, 1)
+	defer q.Stop(time.Second)
+	if err := q.Enqueue("test.noop", nil, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if item := s.get(1); item.Status != job.StatusPending || item.Attempts != 0 {
+		t.Errorf("got %+v", item)
+	}
+}
+
+// TestEnqueueIn checks that a job is added with the given enqueuer, not the
+// store of the queue.
+func TestEnqueueIn(t *testing.T) {
+	Handle("test.noop", func(ctx context.Context, payload []byte) error { return nil })
+	s, tx := &memStore{}, &memStore{}
+	q := New(s, Info{MaxAttempts: 3})
+	if err := q.EnqueueIn(tx, "test.noop", "x", 0); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.jobs) != 0 || len(tx.jobs) != 1 {
+		t.Fatalf("got %d jobs in the store and %d in the transaction", len(s.jobs), len(tx.jobs))
+	}
+	if item := tx.get(1); item.Kind != "test.noop" || string(item.Payload) != `"x"` || item.MaxAttempts != 3 {
+		t.Errorf("got %+v", item)
+	}
+}
+
+// TestStop checks that Stop waits for the running jobs, and cancels them
+// after the timeout.
+func TestStop(t *testing.T) {
+	started := make(chan struct{}, 2)
+	Handle("test.slow", func(ctx context.Context, payload []byte) error {
+		started <- struct{}{}
+		select {
+		case <-time.After(100 * time.Millisecond):
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+	Handle("test.stuck", func(ctx context.Context, payload []byte) error {
+		started <- struct{}{}
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	s := &memStore{}
+	q := newTestQueue(s, 5)
+	q.Enqueue("test.slow", nil, 0)
+	<-started
+	q.Stop(time.Second)
+	if item := s.get(1); item.Status != job.StatusDone {
+		t.Errorf("slow: got %+v", item)
+	}
+
+	s = &memStore{}
+	q = newTestQueue(s, 5)
+	q.Enqueue("test.stuck", nil, 0)
+	<-started
+	q.Stop(10 * time.Millisecond)
+	if item := s.get(1); item.Status != job.StatusPending || item.LastError != context.Canceled.Error() {
+		t.Errorf("stuck: got %+v", item)
+	}
+}
+
+// TestBackoff checks the waits between the attempts.
+func TestBackoff(t *testing.T) {
+	for attempt, want := range map[int]time.Duration{
+		1: 10 * time.Second, 2: 20 * time.Second, 3: 40 * time.Second,
+		9: 2560 * time.Second, 10: time.Hour, 100: time.Hour,
+	} {
+		if got := Backoff(attempt); got != want {
+			t.Errorf("%d: got %s, wanted %s", attempt, got, want)
+		}
+	}
+}
+
+// TestReclaimed checks that the late outcome of a job claimed again by
+// another worker does not overwrite the state of that claim.
+func TestReclaimed(t *testing.T) {
+	s := &memStore{}
+	Handle("test.slow", func(ctx context.Context, payload []byte) error {
+		s.reclaim(1)
+		return errors.New("too late")
+	})
+	q := newTestQueue(s, 3)
+	if err := q.Enqueue("test.slow", nil, 0); err != nil {
+		t.Fatal(err)
+	}
+	wait(t, s, 1, job.StatusRunning)
+	time.Sleep(50 * time.Millisecond)
+	q.Stop(time.Second)
+	if item := s.get(1); item.Status != job.StatusRunning || item.Attempts != 2 || item.LastError != "" {
+		t.Errorf("got %+v", item)
+	}
+}
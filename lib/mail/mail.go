@@ -0,0 +1,288 @@
+// Package mail sends messages with attachments through the SMTP server of the
+// email settings.
+package mail
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/blue-jay/core/email"
+)
+
+// timeout limits a delivery when the context has no deadline.
+const timeout = time.Minute
+
+// Attachment is a file attached to a message.
+type Attachment struct {
+	Name        string
+	ContentType string
+	Data        []byte
+}
+
+// Message is an email. The addresses may have names, like
+// "Vevő Kft. <szamla@vevo.hu>". Bcc gets the message without being listed
+// in it.
+type Message struct {
+	From        string
+	To          []string
+	Cc          []string
+	Bcc         []string
+	Subject     string
+	Body        string // Plain text
+	Attachments []Attachment
+}
+
+// Enabled reports whether sending mail is configured.
+func Enabled(info email.Info) bool {
+	return info.Hostname != ""
+}
+
+// ParseList parses a list of addresses separated by commas, semicolons or
+// new lines, and returns them in their canonical form, which can be shown and
+// parsed again. An empty list is valid.
+func ParseList(s string) ([]string, error) {
+	var result []string
+	for _, part := range split(s) {
+		if part = strings.TrimSpace(part); part == "" {
+			continue
+		}
+		a, err := mail.ParseAddress(part)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not an email address", part)
+		}
+		result = append(result, readable(a))
+	}
+	return result, nil
+}
+
+// split splits a list of addresses at the separators outside the quoted
+// names.
+func split(s string) []string {
+	var parts []string
+	quoted, escaped, start := false, false, 0
+	for i, r := range s {
+		switch {
+		case escaped:
+			escaped = false
+		case r == '\\' && quoted:
+			escaped = true
+		case r == '"':
+			quoted = !quoted
+		case !quoted && (r == ',' || r == ';' || r == '\n'):
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// readable returns an address with its name unencoded, quoted when needed.
+func readable(a *mail.Address) string {
+	if a.Name == "" {
+		return a.Address
+	}
+	name := a.Name
+	if strings.ContainsAny(name, `,;:<>()[]@"\`) {
+		name = `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(name) + `"`
+	}
+	return name + " <" + a.Address + ">"
+}
+
+// encodeList returns the addresses encoded for a header.
+func encodeList(list []string) (string, error) {
+	encoded := make([]string, len(list))
+	for i, s := range list {
+		a, err := mail.ParseAddress(s)
+		if err != nil {
+			return "", fmt.Errorf("%q is not an email address", s)
+		}
+		encoded[i] = a.String()
+	}
+	return strings.Join(encoded, ", "), nil
+}
+
+// Bytes returns the message in MIME format with the given ID and date.
+func (m Message) Bytes(messageID string, date time.Time) ([]byte, error) {
+	var buf bytes.Buffer
+	header := func(name, value string) {
+		fmt.Fprintf(&buf, "%s: %s\r\n", name, value)
+	}
+	from, err := mail.ParseAddress(m.From)
+	if err != nil {
+		return nil, fmt.Errorf("sender %q is not an email address", m.From)
+	}
+	header("From", from.String())
+	for _, h := range []struct {
+		name string
+		list []string
+	}{{"To", m.To}, {"Cc", m.Cc}} {
+		if len(h.list) == 0 {
+			continue
+		}
+		value, err := encodeList(h.list)
+		if err != nil {
+			return nil, err
+		}
+		header(h.name, value)
+	}
+	header("Subject", mime.QEncoding.Encode("utf-8", m.Subject))
+	header("Date", date.Format(time.RFC1123Z))
+	header("Message-ID", messageID)
+	header("MIME-Version", "1.0")
+
+	boundary := randomHex(16)
+	if len(m.Attachments) > 0 {
+		header("Content-Type", mime.FormatMediaType("multipart/mixed", map[string]string{"boundary": boundary}))
+		fmt.Fprintf(&buf, "\r\n--%s\r\n", boundary)
+	}
+	header("Content-Type", "text/plain; charset=utf-8")
+	header("Content-Transfer-Encoding", "quoted-printable")
+	buf.WriteString("\r\n")
+	qp := quotedprintable.NewWriter(&buf)
+	qp.Write([]byte(strings.Replace(m.Body, "\n", "\r\n", -1)))
+	qp.Close()
+	buf.WriteString("\r\n")
+
+	for _, a := range m.Attachments {
+		fmt.Fprintf(&buf, "--%s\r\n", boundary)
+		contentType := a.ContentType
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		header("Content-Type", mime.FormatMediaType(contentType, map[string]string{"name": a.Name}))
+		header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": a.Name}))
+		header("Content-Transfer-Encoding", "base64")
+		buf.WriteString("\r\n")
+		encoded := base64.StdEncoding.EncodeToString(a.Data)
+		for len(encoded) > 76 {
+			buf.WriteString(encoded[:76] + "\r\n")
+			encoded = encoded[76:]
+		}
+		buf.WriteString(encoded + "\r\n")
+	}
+	if len(m.Attachments) > 0 {
+		fmt.Fprintf(&buf, "--%s--\r\n", boundary)
+	}
+	return buf.Bytes(), nil
+}
+
+// Send delivers the message to the SMTP server of info, and returns its
+// Message-ID. The server is asked for STARTTLS when it offers it, port 465
+// is TLS from the start. The user of info is authenticated when given.
+func Send(ctx context.Context, info email.Info, m Message) (string, error) {
+	if !Enabled(info) {
+		return "", fmt.Errorf("sending mail is not configured")
+	}
+	if m.From == "" {
+		m.From = info.From
+	}
+	from, err := mail.ParseAddress(m.From)
+	if err != nil {
+		return "", fmt.Errorf("sender %q is not an email address", m.From)
+	}
+	var recipients []string
+	for _, list := range [][]string{m.To, m.Cc, m.Bcc} {
+		for _, s := range list {
+			a, err := mail.ParseAddress(s)
+			if err != nil {
+				return "", fmt.Errorf("%q is not an email address", s)
+			}
+			recipients = append(recipients, a.Address)
+		}
+	}
+	if len(recipients) == 0 {
+		return "", fmt.Errorf("the message has no recipients")
+	}
+
+	domain := from.Address[strings.LastIndex(from.Address, "@")+1:]
+	messageID := "<" + randomHex(16) + "@" + domain + ">"
+	data, err := m.Bytes(messageID, time.Now())
+	if err != nil {
+		return "", err
+	}
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(timeout)
+	}
+	addr := net.JoinHostPort(info.Hostname, strconv.Itoa(info.Port))
+	dialer := &net.Dialer{Deadline: deadline}
+	var conn net.Conn
+	if info.Port == 465 {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, &tls.Config{ServerName: info.Hostname})
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return "", err
+	}
+	conn.SetDeadline(deadline)
+	c, err := smtp.NewClient(conn, info.Hostname)
+	if err != nil {
+		conn.Close()
+		return "", err
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok && info.Port != 465 {
+		if err = c.StartTLS(&tls.Config{ServerName: info.Hostname}); err != nil {
+			return "", err
+		}
+	}
+	if info.Username != "" {
+		if err = c.Auth(smtp.PlainAuth("", info.Username, info.Password, info.Hostname)); err != nil {
+			return "", err
+		}
+	}
+	if err = c.Mail(from.Address); err != nil {
+		return "", err
+	}
+	for _, rcpt := range recipients {
+		if err = c.Rcpt(rcpt); err != nil {
+			return "", err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return "", err
+	}
+	if _, err = w.Write(data); err != nil {
+		return "", err
+	}
+	if err = w.Close(); err != nil {
+		return "", err
+	}
+	// The message is accepted, a failing goodbye must not send it again
+	c.Quit()
+	return messageID, nil
+}
+
+// Permanent reports whether the server refused the message for good, with a
+// 5xx reply, so sending it again would not help.
+func Permanent(err error) bool {
+	e, ok := err.(*textproto.Error)
+	return ok && e.Code >= 500
+}
+
+// randomHex returns n random bytes in hex.
+func randomHex(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
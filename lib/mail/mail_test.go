@@ -0,0 +1,138 @@
+package mail_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	netmail "net/mail"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/UNO-SOFT/szamlazo/lib/mail"
+	"github.com/UNO-SOFT/szamlazo/lib/mail/mailtest"
+)
+
+// TestSend sends a message with an attachment to the fake server and reads
+// it back.
+func TestSend(t *testing.T) {
+	srv := mailtest.NewServer()
+	defer srv.Close()
+
+	pdf := bytes.Repeat([]byte("%PDF-1.4 számla\n"), 20)
+	msg := mail.Message{
+		To:      []string{"Vevő Kft. <szamla@vevo.hu>"},
+		Cc:      []string{"konyveles@vevo.hu"},
+		Bcc:     []string{"archiv@example.com"},
+		Subject: "Számla INV-0001",
+		Body:    "Tisztelt Partnerünk!\n\nCsatolva küldjük a számlát.",
+		Attachments: []mail.Attachment{
+			{Name: "INV-0001.pdf", ContentType: "application/pdf", Data: pdf},
+		},
+	}
+	messageID, err := mail.Send(context.Background(), srv.Info, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	received := srv.Messages()
+	if len(received) != 1 {
+		t.Fatalf("got %d messages, wanted 1", len(received))
+	}
+	got := received[0]
+	if got.From != "szamla@example.com" {
+		t.Errorf("sender is %q", got.From)
+	}
+	if want := []string{"szamla@vevo.hu", "konyveles@vevo.hu", "archiv@example.com"}; !reflect.DeepEqual(got.To, want) {
+		t.Errorf("recipients are %q, wanted %q", got.To, want)
+	}
+
+	m, err := netmail.ReadMessage(bytes.NewReader(got.Data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Header.Get("Message-ID") != messageID {
+		t.Errorf("Message-ID is %q, wanted %q", m.Header.Get("Message-ID"), messageID)
+	}
+	if m.Header.Get("Bcc") != "" || strings.Contains(string(got.Data), "archiv@") {
+		t.Error("the blind copy is listed in the message")
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(m.Header.Get("Subject"))
+	if err != nil || subject != msg.Subject {
+		t.Errorf("subject is %q (%v), wanted %q", subject, err, msg.Subject)
+	}
+	to, err := m.Header.AddressList("To")
+	if err != nil || len(to) != 1 || to[0].Name != "Vevő Kft." {
+		t.Errorf("To is %v (%v)", to, err)
+	}
+
+	_, params, err := mime.ParseMediaType(m.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := multipart.NewReader(m.Body, params["boundary"])
+	body, err := parts.NextPart()
+	if err != nil {
+		t.Fatal(err)
+	}
+	text, _ := ioutil.ReadAll(body)
+	if want := strings.Replace(msg.Body, "\n", "\r\n", -1); string(text) != want {
+		t.Errorf("body is %q, wanted %q", text, want)
+	}
+	attachment, err := parts.NextPart()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attachment.FileName() != "INV-0001.pdf" {
+		t.Errorf("attachment is named %q", attachment.FileName())
+	}
+	data, err := ioutil.ReadAll(base64.NewDecoder(base64.StdEncoding, attachment))
+	if err != nil || !bytes.Equal(data, pdf) {
+		t.Error("attachment changed in transit")
+	}
+}
+
+// TestSendFailure checks that the refusals are told apart.
+func TestSendFailure(t *testing.T) {
+	srv := mailtest.NewServer()
+	defer srv.Close()
+	msg := mail.Message{To: []string{"szamla@vevo.hu"}, Subject: "Számla", Body: "-"}
+
+	srv.Fail(1, 451)
+	if _, err := mail.Send(context.Background(), srv.Info, msg); err == nil || mail.Permanent(err) {
+		t.Errorf("temporary failure gave %v", err)
+	}
+	srv.Fail(1, 550)
+	if _, err := mail.Send(context.Background(), srv.Info, msg); err == nil || !mail.Permanent(err) {
+		t.Errorf("permanent failure gave %v", err)
+	}
+	if _, err := mail.Send(context.Background(), srv.Info, msg); err != nil {
+		t.Errorf("retry failed: %v", err)
+	}
+
+	info := srv.Info
+	info.Password = "wrong"
+	if _, err := mail.Send(context.Background(), info, msg); err == nil {
+		t.Error("wrong password accepted")
+	}
+	if n := len(srv.Messages()); n != 1 {
+		t.Errorf("got %d messages, wanted 1", n)
+	}
+}
+
+// TestParseList checks the parsing of the address fields of the forms.
+func TestParseList(t *testing.T) {
+	got, err := mail.ParseList("a@example.com; Béla <b@example.com>\n\n \"Vevő, Kft.\" <c@example.com>,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 3 || got[2] != `"Vevő, Kft." <c@example.com>` || got[0] != "a@example.com" || got[1] != "Béla <b@example.com>" {
+		t.Errorf("got %q", got)
+	}
+	if _, err = mail.ParseList("a@example.com, nobody"); err == nil {
+		t.Error("invalid address accepted")
+	}
+}
@@ -0,0 +1,201 @@
+// Package mailtest provides an in-process SMTP server for testing the
+// sending of mail offline.
+package mailtest
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/blue-jay/core/email"
+)
+
+// Message is a message received by the server.
+type Message struct {
+	From string
+	To   []string // All the recipients, with the blind ones
+	Data []byte
+}
+
+// Server speaks enough SMTP for net/smtp, with PLAIN authentication. It
+// listens on the loopback interface, where net/smtp allows authenticating
+// without TLS.
+type Server struct {
+	Info email.Info // Settings to reach the server, with the credentials accepted
+
+	ln       net.Listener
+	wg       sync.WaitGroup
+	mu       sync.Mutex
+	messages []Message
+	failures []int
+}
+
+// NewServer starts a server. Close it when done.
+func NewServer() *Server {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(fmt.Sprintf("mailtest: %v", err))
+	}
+	s := &Server{
+		Info: email.Info{
+			Username: "testuser",
+			Password: "testpassword",
+			Hostname: "127.0.0.1",
+			Port:     ln.Addr().(*net.TCPAddr).Port,
+			From:     "Számlázó <szamla@example.com>",
+		},
+		ln: ln,
+	}
+	s.wg.Add(1)
+	go s.accept()
+	return s
+}
+
+// Close stops the server.
+func (s *Server) Close() {
+	s.ln.Close()
+	s.wg.Wait()
+}
+
+// Messages returns the messages received, in their order.
+func (s *Server) Messages() []Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Message(nil), s.messages...)
+}
+
+// Fail makes the server refuse the next n messages with the reply code, like
+// 451 for a temporary and 550 for a permanent failure.
+func (s *Server) Fail(n int, code int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := 0; i < n; i++ {
+		s.failures = append(s.failures, code)
+	}
+}
+
+// failure returns the reply code refusing the next message, 0 to take it.
+func (s *Server) failure() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.failures) == 0 {
+		return 0
+	}
+	code := s.failures[0]
+	s.failures = s.failures[1:]
+	return code
+}
+
+// accept serves the connections until the server is closed.
+func (s *Server) accept() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer conn.Close()
+			s.serve(conn)
+		}()
+	}
+}
+
+// serve talks to a client.
+func (s *Server) serve(conn net.Conn) {
+	r := bufio.NewReader(conn)
+	reply := func(format string, args ...interface{}) {
+		fmt.Fprintf(conn, format+"\r\n", args...)
+	}
+	reply("220 mailtest ESMTP")
+
+	var msg Message
+	authenticated := false
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		verb, arg := line, ""
+		if i := strings.IndexByte(line, ' '); i >= 0 {
+			verb, arg = line[:i], line[i+1:]
+		}
+
+		switch strings.ToUpper(verb) {
+		case "EHLO":
+			reply("250-mailtest")
+			reply("250-8BITMIME")
+			reply("250 AUTH PLAIN")
+		case "HELO":
+			reply("250 mailtest")
+		case "AUTH":
+			want := base64.StdEncoding.EncodeToString([]byte("\x00" + s.Info.Username + "\x00" + s.Info.Password))
+			if arg == "PLAIN "+want {
+				authenticated = true
+				reply("235 2.7.0 Authentication successful")
+			} else {
+				reply("535 5.7.8 Authentication credentials invalid")
+			}
+		case "MAIL":
+			if !authenticated {
+				reply("530 5.7.0 Authentication required")
+				continue
+			}
+			if code := s.failure(); code != 0 {
+				reply("%d Refused for testing", code)
+				continue
+			}
+			msg = Message{From: address(arg)}
+			reply("250 OK")
+		case "RCPT":
+			msg.To = append(msg.To, address(arg))
+			reply("250 OK")
+		case "DATA":
+			reply("354 End data with <CR><LF>.<CR><LF>")
+			var data bytes.Buffer
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+				data.WriteString(strings.TrimPrefix(line, "."))
+			}
+			msg.Data = data.Bytes()
+			s.mu.Lock()
+			s.messages = append(s.messages, msg)
+			s.mu.Unlock()
+			reply("250 OK queued")
+		case "RSET":
+			msg = Message{}
+			reply("250 OK")
+		case "NOOP":
+			reply("250 OK")
+		case "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("502 5.5.2 Command not recognized")
+		}
+	}
+}
+
+// address returns the address of a MAIL FROM or RCPT TO argument.
+func address(arg string) string {
+	if i := strings.IndexByte(arg, '<'); i >= 0 {
+		arg = arg[i+1:]
+	}
+	if i := strings.IndexByte(arg, '>'); i >= 0 {
+		arg = arg[:i]
+	}
+	return arg
+}
@@ -0,0 +1,105 @@
+package mnb
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// DefaultURL is the address of the web service of the rates.
+const DefaultURL = "http://www.mnb.hu/arfolyamok.asmx"
+
+// namespace is the namespace of the web service.
+const namespace = "http://www.mnb.hu/webservices/"
+
+// Client calls the SOAP web service of the rates.
+type Client struct {
+	URL  string
+	HTTP *http.Client
+}
+
+// NewClient returns a client of the web service at url, DefaultURL if empty.
+func NewClient(url string) *Client {
+	if url == "" {
+		url = DefaultURL
+	}
+	return &Client{URL: url, HTTP: &http.Client{Timeout: 30 * time.Second}}
+}
+
+// getExchangeRates is the body of the request.
+type getExchangeRates struct {
+	XMLName       xml.Name `xml:"GetExchangeRates"`
+	Xmlns         string   `xml:"xmlns,attr"`
+	StartDate     string   `xml:"startDate"`
+	EndDate       string   `xml:"endDate"`
+	CurrencyNames string   `xml:"currencyNames"`
+}
+
+// envelope is the SOAP envelope of the request.
+type envelope struct {
+	XMLName xml.Name `xml:"soap:Envelope"`
+	Xmlns   string   `xml:"xmlns:soap,attr"`
+	Body    interface{}
+}
+
+// response is the SOAP envelope of the response: the result is the rates
+// document, escaped.
+type response struct {
+	Result string `xml:"Body>GetExchangeRatesResponse>GetExchangeRatesResult"`
+	Fault  string `xml:"Body>Fault>faultstring"`
+}
+
+// Rates returns the rates of the currency published between the days.
+func (c *Client) Rates(ctx context.Context, currency string, from, to time.Time) ([]Rate, error) {
+	body, err := xml.Marshal(envelope{
+		Xmlns: "http://schemas.xmlsoap.org/soap/envelope/",
+		Body: struct {
+			XMLName xml.Name `xml:"soap:Body"`
+			Request getExchangeRates
+		}{Request: getExchangeRates{
+			Xmlns:         namespace,
+			StartDate:     from.Format("2006-01-02"),
+			EndDate:       to.Format("2006-01-02"),
+			CurrencyNames: currency,
+		}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("POST", c.URL, bytes.NewReader(append([]byte(xml.Header), body...)))
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "text/xml; charset=utf-8")
+	req.Header.Set("SOAPAction", namespace+"MNBArfolyamServiceSoap/GetExchangeRates")
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	var r response
+	if err = xml.Unmarshal(b, &r); err != nil {
+		return nil, fmt.Errorf("GetExchangeRates: %s: %v", resp.Status, err)
+	}
+	if r.Fault != "" {
+		return nil, fmt.Errorf("GetExchangeRates: %s", r.Fault)
+	}
+	if r.Result == "" {
+		return nil, nil
+	}
+	all, err := ParseRates([]byte(r.Result))
+	if err != nil {
+		return nil, err
+	}
+	return filter(all, currency, from, to), nil
+}
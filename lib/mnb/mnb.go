@@ -0,0 +1,181 @@
+// Package mnb provides the official exchange rates of the Magyar Nemzeti Bank,
+// the ones the VAT of invoices in foreign currencies is converted to forints
+// by.
+package mnb
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/UNO-SOFT/szamlazo/lib/money"
+)
+
+// Rate is the price of a unit of a currency in forints on a day.
+type Rate struct {
+	Date     time.Time
+	Currency string
+	Rate     money.Decimal
+}
+
+// Source provides the rates of a currency published between two days, both
+// included. Days without a publication, like weekends, have no rates.
+type Source interface {
+	Rates(ctx context.Context, currency string, from, to time.Time) ([]Rate, error)
+}
+
+// Info holds the settings of the source of the rates.
+type Info struct {
+	URL  string `json:"URL"`  // Of the web service, DefaultURL if empty
+	File string `json:"File"` // Rates in the format of the web service, used instead of it if set
+}
+
+// Source returns the source set.
+func (i Info) Source() Source {
+	if i.File != "" {
+		return File(i.File)
+	}
+	return NewClient(i.URL)
+}
+
+// rates is the document of the rates, as published.
+type rates struct {
+	Days []struct {
+		Date  string `xml:"date,attr"`
+		Rates []struct {
+			Unit     string `xml:"unit,attr"`
+			Currency string `xml:"curr,attr"`
+			Value    string `xml:",chardata"`
+		} `xml:"Rate"`
+	} `xml:"Day"`
+}
+
+// ParseRates returns the rates of an MNBExchangeRates document, like
+//
+//	<MNBExchangeRates>
+//	  <Day date="2026-10-16"><Rate unit="1" curr="EUR">390,12</Rate></Day>
+//	</MNBExchangeRates>
+//
+// The rates quoted for 100 units, like that of the JPY, are converted to a
+// unit.
+func ParseRates(data []byte) ([]Rate, error) {
+	var doc rates
+	if err := xml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("MNB rates: %v", err)
+	}
+	var result []Rate
+	for _, day := range doc.Days {
+		date, err := time.Parse("2006-01-02", day.Date)
+		if err != nil {
+			return nil, fmt.Errorf("MNB rates: %v", err)
+		}
+		for _, r := range day.Rates {
+			value, err := money.Parse(strings.TrimSpace(r.Value))
+			if err != nil {
+				return nil, fmt.Errorf("MNB rates: %s %s: %v", day.Date, r.Currency, err)
+			}
+			places, err := unitPlaces(r.Unit)
+			if err != nil {
+				return nil, fmt.Errorf("MNB rates: %s %s: %v", day.Date, r.Currency, err)
+			}
+			result = append(result, Rate{Date: date, Currency: r.Currency, Rate: value.Shift(places)})
+		}
+	}
+	return result, nil
+}
+
+// unitPlaces returns the number of zeros of a unit like 1 or 100.
+func unitPlaces(unit string) (int, error) {
+	if unit == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(unit)
+	places := 0
+	for ; err == nil && n > 1 && n%10 == 0; n /= 10 {
+		places++
+	}
+	if err != nil || n != 1 {
+		return 0, fmt.Errorf("unit %q is not a power of ten", unit)
+	}
+	return places, nil
+}
+
+// File is a source reading the rates from a file in the format of the web
+// service, for tests and for working offline.
+type File string
+
+// Rates returns the rates of the currency in the file between the days.
+func (f File) Rates(ctx context.Context, currency string, from, to time.Time) ([]Rate, error) {
+	data, err := ioutil.ReadFile(string(f))
+	if err != nil {
+		return nil, err
+	}
+	all, err := ParseRates(data)
+	if err != nil {
+		return nil, err
+	}
+	return filter(all, currency, from, to), nil
+}
+
+// filter returns the rates of the currency between the days.
+func filter(all []Rate, currency string, from, to time.Time) []Rate {
+	var result []Rate
+	for _, r := range all {
+		if r.Currency == currency && !r.Date.Before(from) && !r.Date.After(to) {
+			result = append(result, r)
+		}
+	}
+	return result
+}
+
+// Store keeps the rates fetched.
+type Store interface {
+	// On returns the latest rate of the currency published on or before
+	// the day, with noRows set if there is none. The error may be set
+	// together with noRows, as the model services do.
+	On(currency string, day time.Time) (Rate, bool, error)
+	// Save stores the rates, replacing the ones of the same days.
+	Save(rates []Rate) error
+}
+
+// lookback is how far before a day the rates are fetched, to find the last
+// one published before holidays.
+const lookback = 10 * 24 * time.Hour
+
+// Ensure fetches the rates of the currency from the source into the store,
+// unless the rate of the day is stored already, and returns the rate in force
+// on the day: the last one published on or before it.
+func Ensure(ctx context.Context, source Source, store Store, currency string, day time.Time) (Rate, error) {
+	stored, noRows, err := store.On(currency, day)
+	if !noRows {
+		if err != nil {
+			return Rate{}, err
+		}
+		if stored.Date.Equal(day) {
+			return stored, nil
+		}
+	}
+
+	fetched, err := source.Rates(ctx, currency, day.Add(-lookback), day)
+	if err != nil {
+		return Rate{}, fmt.Errorf("MNB rates of %s: %v", currency, err)
+	}
+	if len(fetched) > 0 {
+		if err = store.Save(fetched); err != nil {
+			return Rate{}, err
+		}
+	}
+
+	stored, noRows, err = store.On(currency, day)
+	if noRows {
+		return Rate{}, fmt.Errorf("no MNB rate of %s on %s", currency, day.Format("2006-01-02"))
+	}
+	if err != nil {
+		return Rate{}, err
+	}
+	return stored, nil
+}
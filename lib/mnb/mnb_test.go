@@ -0,0 +1,149 @@
+package mnb_test
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/UNO-SOFT/szamlazo/lib/mnb"
+)
+
+var (
+	oct15 = time.Date(2026, 10, 15, 0, 0, 0, 0, time.UTC)
+	oct16 = time.Date(2026, 10, 16, 0, 0, 0, 0, time.UTC)
+	oct18 = time.Date(2026, 10, 18, 0, 0, 0, 0, time.UTC)
+)
+
+func TestParseRates(t *testing.T) {
+	data, err := ioutil.ReadFile(filepath.Join("testdata", "rates.xml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rates, err := mnb.ParseRates(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rates) != 6 {
+		t.Fatalf("got %d rates", len(rates))
+	}
+	for i, want := range []string{"390.12", "2.4531", "358.4"} {
+		if got := rates[i].Rate.Normalize().String(); got != want || !rates[i].Date.Equal(oct16) {
+			t.Errorf("%d. %s is %s on %s, wanted %s", i, rates[i].Currency, got, rates[i].Date, want)
+		}
+	}
+
+	for name, data := range map[string]string{
+		"broken": "<MNBExchangeRates><Day>",
+		"date":   `<MNBExchangeRates><Day date="16/10/2026"/></MNBExchangeRates>`,
+		"unit":   `<MNBExchangeRates><Day date="2026-10-16"><Rate unit="3" curr="EUR">1</Rate></Day></MNBExchangeRates>`,
+		"rate":   `<MNBExchangeRates><Day date="2026-10-16"><Rate unit="1" curr="EUR">n/a</Rate></Day></MNBExchangeRates>`,
+	} {
+		if _, err := mnb.ParseRates([]byte(data)); err == nil {
+			t.Errorf("%s: accepted", name)
+		}
+	}
+}
+
+// TestClient checks the request sent to the web service and the reading of
+// the escaped result.
+func TestClient(t *testing.T) {
+	data, err := ioutil.ReadFile(filepath.Join("testdata", "rates.xml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		if !strings.HasSuffix(r.Header.Get("SOAPAction"), "/GetExchangeRates") ||
+			!strings.Contains(string(b), "<startDate>2026-10-08</startDate><endDate>2026-10-18</endDate><currencyNames>EUR</currencyNames>") {
+			t.Errorf("got %s %s", r.Header.Get("SOAPAction"), b)
+		}
+		w.Header().Set("Content-Type", "text/xml; charset=utf-8")
+		head := `<?xml version="1.0" encoding="utf-8"?><s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body>` +
+			`<GetExchangeRatesResponse xmlns="http://www.mnb.hu/webservices/"><GetExchangeRatesResult>`
+		w.Write([]byte(head))
+		xml.EscapeText(w, data)
+		w.Write([]byte(`</GetExchangeRatesResult></GetExchangeRatesResponse></s:Body></s:Envelope>`))
+	}))
+	defer srv.Close()
+
+	rates, err := mnb.NewClient(srv.URL).Rates(context.Background(), "EUR", oct18.AddDate(0, 0, -10), oct18)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rates) != 2 || rates[0].Currency != "EUR" || rates[0].Rate.String() != "390.12" {
+		t.Errorf("got %+v", rates)
+	}
+}
+
+// memStore keeps the rates in memory. Like the model services, it returns
+// an error together with noRows.
+type memStore []mnb.Rate
+
+func (s *memStore) On(currency string, day time.Time) (mnb.Rate, bool, error) {
+	var result mnb.Rate
+	for _, r := range *s {
+		if r.Currency == currency && !r.Date.After(day) && r.Date.After(result.Date) {
+			result = r
+		}
+	}
+	if result.Currency == "" {
+		return result, true, errors.New("sql: no rows in result set")
+	}
+	return result, false, nil
+}
+
+func (s *memStore) Save(rates []mnb.Rate) error {
+	*s = append(*s, rates...)
+	return nil
+}
+
+// countingSource counts the calls of a source.
+type countingSource struct {
+	mnb.Source
+	calls int
+}
+
+func (s *countingSource) Rates(ctx context.Context, currency string, from, to time.Time) ([]mnb.Rate, error) {
+	s.calls++
+	return s.Source.Rates(ctx, currency, from, to)
+}
+
+// TestEnsure checks that the last rate before a weekend is used, and that
+// the source is not called for a day stored.
+func TestEnsure(t *testing.T) {
+	ctx := context.Background()
+	source := &countingSource{Source: mnb.File(filepath.Join("testdata", "rates.xml"))}
+	store := &memStore{}
+
+	for i, tc := range []struct {
+		currency string
+		day      time.Time
+		want     string
+		calls    int
+	}{
+		{"EUR", oct15, "389.75", 1},
+		{"EUR", oct16, "390.12", 2},
+		{"EUR", oct15, "389.75", 2},
+		{"EUR", oct18, "390.12", 3},
+		{"JPY", oct16, "2.4531", 4},
+	} {
+		r, err := mnb.Ensure(ctx, source, store, tc.currency, tc.day)
+		if err != nil {
+			t.Fatalf("%d. %v", i, err)
+		}
+		if got := r.Rate.Normalize().String(); got != tc.want || source.calls != tc.calls {
+			t.Errorf("%d. got %s after %d calls, wanted %s after %d", i, got, source.calls, tc.want, tc.calls)
+		}
+	}
+
+	if _, err := mnb.Ensure(ctx, source, store, "GBP", oct16); err == nil {
+		t.Error("GBP: no error")
+	}
+}
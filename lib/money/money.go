@@ -0,0 +1,252 @@
+// Package money provides exact decimal arithmetic for prices, quantities and
+// amounts, with the rounding used on invoices.
+package money
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// Decimal is an exact decimal number. The zero value is 0.
+//
+// Decimals are immutable: the operations return new values.
+type Decimal struct {
+	unscaled *big.Int // value × 10^scale, nil means 0
+	scale    int      // number of decimal places
+}
+
+// New returns unscaled × 10^-scale, so New(1250, 2) is 12.50.
+func New(unscaled int64, scale int) Decimal {
+	return Decimal{unscaled: big.NewInt(unscaled), scale: scale}
+}
+
+// Parse reads a decimal number. Both '.' and ',' are accepted as the decimal
+// separator, spaces between the digit groups are ignored.
+func Parse(s string) (Decimal, error) {
+	orig := s
+	s = strings.Replace(strings.TrimSpace(s), " ", "", -1)
+	s = strings.Replace(s, ",", ".", 1)
+	neg := false
+	if s != "" && (s[0] == '-' || s[0] == '+') {
+		neg = s[0] == '-'
+		s = s[1:]
+	}
+	intPart, fracPart := s, ""
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		intPart, fracPart = s[:i], s[i+1:]
+	}
+	if intPart == "" && fracPart == "" || !digits(intPart) || !digits(fracPart) {
+		return Decimal{}, fmt.Errorf("%q is not a decimal number", orig)
+	}
+	u, _ := new(big.Int).SetString("0"+intPart+fracPart, 10)
+	if neg {
+		u.Neg(u)
+	}
+	return Decimal{unscaled: u, scale: len(fracPart)}, nil
+}
+
+// MustParse is like Parse but panics on error. It is meant for constants.
+func MustParse(s string) Decimal {
+	d, err := Parse(s)
+	if err != nil {
+		panic(err)
+	}
+	return d
+}
+
+// digits reports whether s consists of decimal digits only.
+func digits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// int returns the unscaled value, never nil.
+func (d Decimal) int() *big.Int {
+	if d.unscaled == nil {
+		return new(big.Int)
+	}
+	return d.unscaled
+}
+
+// rescaled returns the unscaled value of d at a scale not less than its own.
+func (d Decimal) rescaled(scale int) *big.Int {
+	u := new(big.Int).Set(d.int())
+	if scale > d.scale {
+		u.Mul(u, pow10(scale-d.scale))
+	}
+	return u
+}
+
+// pow10 returns 10^n.
+func pow10(n int) *big.Int {
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(n)), nil)
+}
+
+// commonScale returns the scale both d and e can be represented at.
+func commonScale(d, e Decimal) int {
+	if d.scale > e.scale {
+		return d.scale
+	}
+	return e.scale
+}
+
+// Scale returns the number of decimal places of d.
+func (d Decimal) Scale() int {
+	return d.scale
+}
+
+// Add returns d + e.
+func (d Decimal) Add(e Decimal) Decimal {
+	s := commonScale(d, e)
+	return Decimal{unscaled: new(big.Int).Add(d.rescaled(s), e.rescaled(s)), scale: s}
+}
+
+// Sub returns d - e.
+func (d Decimal) Sub(e Decimal) Decimal {
+	return d.Add(e.Neg())
+}
+
+// Neg returns -d.
+func (d Decimal) Neg() Decimal {
+	return Decimal{unscaled: new(big.Int).Neg(d.int()), scale: d.scale}
+}
+
+// Mul returns d × e, with all the decimal places of both.
+func (d Decimal) Mul(e Decimal) Decimal {
+	return Decimal{unscaled: new(big.Int).Mul(d.int(), e.int()), scale: d.scale + e.scale}
+}
+
+// Cmp compares d and e, returning -1, 0 or +1.
+func (d Decimal) Cmp(e Decimal) int {
+	s := commonScale(d, e)
+	return d.rescaled(s).Cmp(e.rescaled(s))
+}
+
+// Sign returns -1, 0 or +1 as d is negative, zero or positive.
+func (d Decimal) Sign() int {
+	return d.int().Sign()
+}
+
+// IsZero reports whether d is 0.
+func (d Decimal) IsZero() bool {
+	return d.Sign() == 0
+}
+
+// Shift returns d × 10^-places, moving the decimal point to the left, so
+// New(39012, 0).Shift(2) is 390.12.
+func (d Decimal) Shift(places int) Decimal {
+	if places < 0 {
+		return Decimal{unscaled: new(big.Int).Mul(d.int(), pow10(-places)), scale: d.scale}
+	}
+	return Decimal{unscaled: d.int(), scale: d.scale + places}
+}
+
+// Round returns d with exactly places decimal places, rounding half away from
+// zero as usual in commerce: 2.5 becomes 3 and -2.5 becomes -3.
+func (d Decimal) Round(places int) Decimal {
+	if d.scale <= places {
+		return Decimal{unscaled: d.rescaled(places), scale: places}
+	}
+	div := pow10(d.scale - places)
+	q, r := new(big.Int).QuoRem(d.int(), div, new(big.Int))
+	if r.Abs(r).Lsh(r, 1).Cmp(div) >= 0 {
+		q.Add(q, big.NewInt(int64(d.Sign())))
+	}
+	return Decimal{unscaled: q, scale: places}
+}
+
+// Normalize returns d without the trailing zeros of its fraction.
+func (d Decimal) Normalize() Decimal {
+	u, s := new(big.Int).Set(d.int()), d.scale
+	ten, r := big.NewInt(10), new(big.Int)
+	for s > 0 {
+		q, _ := new(big.Int).QuoRem(u, ten, r)
+		if r.Sign() != 0 {
+			break
+		}
+		u, s = q, s-1
+	}
+	return Decimal{unscaled: u, scale: s}
+}
+
+// Int64 returns the integer part of d. It is only meaningful for values
+// which fit.
+func (d Decimal) Int64() int64 {
+	return new(big.Int).Quo(d.int(), pow10(d.scale)).Int64()
+}
+
+// String returns d with all its decimal places, like "-12.50".
+func (d Decimal) String() string {
+	digits := new(big.Int).Abs(d.int()).String()
+	if d.scale > 0 {
+		if len(digits) <= d.scale {
+			digits = strings.Repeat("0", d.scale-len(digits)+1) + digits
+		}
+		digits = digits[:len(digits)-d.scale] + "." + digits[len(digits)-d.scale:]
+	}
+	if d.Sign() < 0 {
+		return "-" + digits
+	}
+	return digits
+}
+
+// MarshalText implements encoding.TextMarshaler, for JSON and XML.
+func (d Decimal) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (d *Decimal) UnmarshalText(b []byte) error {
+	v, err := Parse(string(b))
+	if err == nil {
+		*d = v
+	}
+	return err
+}
+
+// Scan implements sql.Scanner. NUMERIC columns pad the value to the scale of
+// the column, so the trailing zeros are dropped.
+func (d *Decimal) Scan(src interface{}) error {
+	var s string
+	switch v := src.(type) {
+	case nil:
+		*d = Decimal{}
+		return nil
+	case []byte:
+		s = string(v)
+	case string:
+		s = v
+	case int64:
+		*d = New(v, 0)
+		return nil
+	default:
+		return fmt.Errorf("cannot scan %T into a decimal", src)
+	}
+	v, err := Parse(s)
+	if err != nil {
+		return err
+	}
+	*d = v.Normalize()
+	return nil
+}
+
+// Value implements driver.Valuer.
+func (d Decimal) Value() (driver.Value, error) {
+	return d.String(), nil
+}
+
+// Places returns the number of decimal places amounts are rounded to in the
+// currency: none for the forint and the yen, two otherwise.
+func Places(currency string) int {
+	switch strings.ToUpper(currency) {
+	case "HUF", "JPY":
+		return 0
+	}
+	return 2
+}
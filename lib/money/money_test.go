@@ -0,0 +1,122 @@
+package money_test
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+
+	"github.com/UNO-SOFT/szamlazo/lib/money"
+)
+
+// TestParse checks the accepted formats.
+func TestParse(t *testing.T) {
+	for in, want := range map[string]string{
+		"12.50":     "12.50",
+		"-0,5":      "-0.5",
+		"1 234 567": "1234567",
+		"+.25":      "0.25",
+		"7.":        "7",
+		"0.000001":  "0.000001",
+	} {
+		d, err := money.Parse(in)
+		if err != nil {
+			t.Errorf("%q: %v", in, err)
+		} else if d.String() != want {
+			t.Errorf("%q: got %q want %q", in, d, want)
+		}
+	}
+	for _, in := range []string{"", "-", ".", "1.2.3", "1e5", "12a"} {
+		if _, err := money.Parse(in); err == nil {
+			t.Errorf("%q accepted", in)
+		}
+	}
+}
+
+// TestArithmetic checks that no precision is lost.
+func TestArithmetic(t *testing.T) {
+	a, b := money.MustParse("0.1"), money.MustParse("0.2")
+	if got := a.Add(b); got.Cmp(money.MustParse("0.3")) != 0 {
+		t.Errorf("0.1 + 0.2 = %s", got)
+	}
+	if got := a.Sub(b).String(); got != "-0.1" {
+		t.Errorf("0.1 - 0.2 = %s", got)
+	}
+	if got := money.MustParse("3.333333").Mul(money.MustParse("123456789.123456")).String(); got != "411522589.259256958848" {
+		t.Errorf("product = %s", got)
+	}
+	if got := money.MustParse("39012").Shift(2).String(); got != "390.12" {
+		t.Errorf("shifted left = %s", got)
+	}
+	if got := money.MustParse("3.9").Shift(-2).String(); got != "390.0" {
+		t.Errorf("shifted right = %s", got)
+	}
+	var zero money.Decimal
+	if !zero.IsZero() || zero.String() != "0" {
+		t.Errorf("zero value is %s", zero)
+	}
+}
+
+// TestRound checks rounding half away from zero.
+func TestRound(t *testing.T) {
+	for _, tc := range []struct {
+		in     string
+		places int
+		want   string
+	}{
+		{"2.5", 0, "3"},
+		{"-2.5", 0, "-3"},
+		{"2.49", 0, "2"},
+		{"1234.565", 2, "1234.57"},
+		{"0.004", 2, "0.00"},
+		{"12", 2, "12.00"},
+		{"-0.4", 0, "0"},
+	} {
+		if got := money.MustParse(tc.in).Round(tc.places).String(); got != tc.want {
+			t.Errorf("%s to %d places: got %s want %s", tc.in, tc.places, got, tc.want)
+		}
+	}
+	if got := money.MustParse("12.3400").Normalize().String(); got != "12.34" {
+		t.Errorf("normalized 12.3400 = %s", got)
+	}
+}
+
+// TestScan checks the round trip through the database types and JSON.
+func TestScan(t *testing.T) {
+	var d money.Decimal
+	if err := d.Scan([]byte("15000.500000")); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := d.Value(); v != "15000.5" {
+		t.Errorf("value = %v", v)
+	}
+	b, err := json.Marshal(struct{ Price money.Decimal }{d})
+	if err != nil || string(b) != `{"Price":"15000.5"}` {
+		t.Errorf("json = %s, %v", b, err)
+	}
+}
+
+// TestWords checks the spelling and the hyphenation above 2000.
+func TestWords(t *testing.T) {
+	for n, want := range map[int64]string{
+		0:          "nulla",
+		2:          "kettő",
+		10:         "tíz",
+		22:         "huszonkettő",
+		100:        "egyszáz",
+		1999:       "egyezerkilencszázkilencvenkilenc",
+		2000:       "kétezer",
+		2001:       "kétezer-egy",
+		12700:      "tizenkétezer-hétszáz",
+		22000:      "huszonkétezer",
+		1000000:    "egymillió",
+		3040512:    "hárommillió-negyvenezer-ötszáztizenkettő",
+		-30:        "mínusz harminc",
+		1000000001: "egymilliárd-egy",
+		math.MinInt64: "mínusz kilenctrillió-kétszázhuszonhárombilliárd-háromszázhetvenkétbillió-harminchatmilliárd-" +
+			"nyolcszázötvennégymillió-hétszázhetvenötezer-nyolcszáznyolc",
+	} {
+		if got := money.Words(n); got != want {
+			t.Errorf("%d: got %q want %q", n, got, want)
+		}
+	}
+}
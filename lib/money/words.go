@@ -0,0 +1,66 @@
+package money
+
+import (
+	"strings"
+)
+
+var (
+	// units are the digits as written before a suffix, like két in kétszáz.
+	units = []string{"", "egy", "két", "három", "négy", "öt", "hat", "hét", "nyolc", "kilenc"}
+	// tens are the tens followed by units, like huszon in huszonegy.
+	tens = []string{"", "tizen", "huszon", "harminc", "negyven", "ötven", "hatvan", "hetven", "nyolcvan", "kilencven"}
+	// scales are the names of the groups of three digits.
+	scales = []string{"", "ezer", "millió", "milliárd", "billió", "billiárd", "trillió"}
+)
+
+// Words spells n out in Hungarian, as required on cheques and invoices:
+// 2300 is "kétezer-háromszáz", the groups are only hyphenated above 2000.
+// The hundreds and thousands are written with "egy", like "egyezeregyszáz".
+func Words(n int64) string {
+	if n < 0 {
+		// -n overflows for the minimum, but its bits are still the magnitude
+		return "mínusz " + words(uint64(-n))
+	}
+	return words(uint64(n))
+}
+
+// words spells out n without a sign.
+func words(n uint64) string {
+	if n == 0 {
+		return "nulla"
+	}
+	sep := ""
+	if n > 2000 {
+		sep = "-"
+	}
+	var groups []string
+	for scale := 0; n > 0; scale++ {
+		if g := int(n % 1000); g > 0 {
+			groups = append([]string{group(g, scale == 0) + scales[scale]}, groups...)
+		}
+		n /= 1000
+	}
+	return strings.Join(groups, sep)
+}
+
+// group spells out 1 <= g <= 999. The last group ends in kettő instead of
+// két.
+func group(g int, last bool) string {
+	var s string
+	if h := g / 100; h > 0 {
+		s = units[h] + "száz"
+	}
+	t, u := g/10%10, g%10
+	switch {
+	case t == 1 && u == 0:
+		s += "tíz"
+	case t == 2 && u == 0:
+		s += "húsz"
+	default:
+		s += tens[t]
+	}
+	if u == 2 && last {
+		return s + "kettő"
+	}
+	return s + units[u]
+}
@@ -0,0 +1,360 @@
+package nav
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/sha3"
+)
+
+// NamespaceAPI is the namespace of the requests and responses.
+const NamespaceAPI = "http://schemas.nav.gov.hu/OSA/3.0/api"
+
+// Invoice operations.
+const (
+	OperationCreate = "CREATE"
+	OperationModify = "MODIFY"
+	OperationStorno = "STORNO"
+)
+
+// Invoice statuses of the processing results.
+const (
+	StatusReceived   = "RECEIVED"
+	StatusProcessing = "PROCESSING"
+	StatusSaved      = "SAVED"
+	StatusDone       = "DONE"
+	StatusAborted    = "ABORTED"
+
+	// StatusFailed is not used by NAV: it marks the invoices which could not
+	// be sent.
+	StatusFailed = "FAILED"
+)
+
+// Info holds the technical user and the registration of the software. No
+// invoices are reported without a URL.
+type Info struct {
+	URL          string   `json:"URL"` // Like https://api-test.onlineszamla.nav.gov.hu/invoiceService/v3
+	Login        string   `json:"Login"`
+	Password     string   `json:"Password"`
+	TaxNumber    string   `json:"TaxNumber"` // First eight digits of the tax number of the seller
+	SignatureKey string   `json:"SignatureKey"`
+	ExchangeKey  string   `json:"ExchangeKey"`
+	Software     Software `json:"Software"`
+}
+
+// Enabled reports whether reporting is configured.
+func (i Info) Enabled() bool {
+	return i.URL != ""
+}
+
+// Software identifies the invoicing software in the requests.
+type Software struct {
+	ID             string `xml:"softwareId" json:"ID"`
+	Name           string `xml:"softwareName" json:"Name"`
+	Operation      string `xml:"softwareOperation" json:"Operation"` // ONLINE_SERVICE or LOCAL_SOFTWARE
+	MainVersion    string `xml:"softwareMainVersion" json:"MainVersion"`
+	DevName        string `xml:"softwareDevName" json:"DevName"`
+	DevContact     string `xml:"softwareDevContact" json:"DevContact"`
+	DevCountryCode string `xml:"softwareDevCountryCode,omitempty" json:"DevCountryCode"`
+	DevTaxNumber   string `xml:"softwareDevTaxNumber,omitempty" json:"DevTaxNumber"`
+}
+
+// Operation is an invoice to be reported.
+type Operation struct {
+	Operation string // OperationCreate, OperationModify or OperationStorno
+	Data      []byte // Marshalled InvoiceData
+}
+
+// ProcessingResult is the outcome of an operation.
+type ProcessingResult struct {
+	Index             int                 `xml:"index"`
+	InvoiceStatus     string              `xml:"invoiceStatus"`
+	TechnicalMessages []ValidationMessage `xml:"technicalValidationMessages"`
+	BusinessMessages  []ValidationMessage `xml:"businessValidationMessages"`
+}
+
+// ValidationMessage is a problem found in an invoice.
+type ValidationMessage struct {
+	ResultCode string `xml:"validationResultCode"` // ERROR, WARN or INFO
+	ErrorCode  string `xml:"validationErrorCode"`
+	Message    string `xml:"message"`
+}
+
+// Messages returns the messages of the result on a line each.
+func (r ProcessingResult) Messages() string {
+	var lines []string
+	for _, m := range append(append([]ValidationMessage(nil), r.TechnicalMessages...), r.BusinessMessages...) {
+		lines = append(lines, fmt.Sprintf("%s %s: %s", m.ResultCode, m.ErrorCode, m.Message))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Error is an error returned by the API.
+type Error struct {
+	Code    string
+	Message string
+}
+
+func (e *Error) Error() string {
+	return "NAV: " + e.Code + ": " + e.Message
+}
+
+// Client calls the Online Invoice API.
+type Client struct {
+	Info Info
+	HTTP *http.Client
+}
+
+// NewClient returns a client with the settings.
+func NewClient(i Info) *Client {
+	return &Client{Info: i, HTTP: &http.Client{Timeout: 30 * time.Second}}
+}
+
+// header is the common header of the requests.
+type header struct {
+	RequestID      string `xml:"common:requestId"`
+	Timestamp      string `xml:"common:timestamp"`
+	RequestVersion string `xml:"common:requestVersion"`
+	HeaderVersion  string `xml:"common:headerVersion"`
+}
+
+// user authenticates the requests.
+type user struct {
+	Login            string      `xml:"common:login"`
+	PasswordHash     cryptoValue `xml:"common:passwordHash"`
+	TaxNumber        string      `xml:"common:taxNumber"`
+	RequestSignature cryptoValue `xml:"common:requestSignature"`
+}
+
+// cryptoValue is a hash with its algorithm.
+type cryptoValue struct {
+	CryptoType string `xml:"cryptoType,attr"`
+	Value      string `xml:",chardata"`
+}
+
+// request holds the elements of all the requests used.
+type request struct {
+	XMLName       xml.Name
+	Xmlns         string             `xml:"xmlns,attr"`
+	XmlnsCommon   string             `xml:"xmlns:common,attr"`
+	Header        header             `xml:"common:header"`
+	User          user               `xml:"common:user"`
+	Software      Software           `xml:"software"`
+	ExchangeToken string             `xml:"exchangeToken,omitempty"`
+	TransactionID string             `xml:"transactionId,omitempty"`
+	Operations    *invoiceOperations `xml:"invoiceOperations,omitempty"`
+}
+
+// invoiceOperations are the invoices of a manageInvoice request.
+type invoiceOperations struct {
+	CompressedContent bool               `xml:"compressedContent"`
+	Operations        []invoiceOperation `xml:"invoiceOperation"`
+}
+
+// invoiceOperation is an invoice of a manageInvoice request.
+type invoiceOperation struct {
+	Index       int    `xml:"index"`
+	Operation   string `xml:"invoiceOperation"`
+	InvoiceData string `xml:"invoiceData"`
+}
+
+// response holds the elements of all the responses used.
+type response struct {
+	FuncCode             string             `xml:"result>funcCode"`
+	ErrorCode            string             `xml:"result>errorCode"`
+	Message              string             `xml:"result>message"`
+	EncodedExchangeToken string             `xml:"encodedExchangeToken"`
+	TransactionID        string             `xml:"transactionId"`
+	ProcessingResults    []ProcessingResult `xml:"processingResults>processingResult"`
+}
+
+// TokenExchange returns a new exchange token, decrypted.
+func (c *Client) TokenExchange(ctx context.Context) (string, error) {
+	var resp response
+	if err := c.call(ctx, "tokenExchange", c.newRequest("TokenExchangeRequest", nil), &resp); err != nil {
+		return "", err
+	}
+	return DecodeExchangeToken(resp.EncodedExchangeToken, c.Info.ExchangeKey)
+}
+
+// ManageInvoice sends the invoices with an exchange token and returns the ID
+// of the transaction.
+func (c *Client) ManageInvoice(ctx context.Context, token string, ops []Operation) (string, error) {
+	req := c.newRequest("ManageInvoiceRequest", ops)
+	req.ExchangeToken = token
+	req.Operations = &invoiceOperations{}
+	for i, op := range ops {
+		req.Operations.Operations = append(req.Operations.Operations, invoiceOperation{
+			Index:       i + 1,
+			Operation:   op.Operation,
+			InvoiceData: base64.StdEncoding.EncodeToString(op.Data),
+		})
+	}
+	var resp response
+	if err := c.call(ctx, "manageInvoice", req, &resp); err != nil {
+		return "", err
+	}
+	return resp.TransactionID, nil
+}
+
+// QueryTransactionStatus returns the results of the operations of a
+// transaction, in their order.
+func (c *Client) QueryTransactionStatus(ctx context.Context, transactionID string) ([]ProcessingResult, error) {
+	req := c.newRequest("QueryTransactionStatusRequest", nil)
+	req.TransactionID = transactionID
+	var resp response
+	if err := c.call(ctx, "queryTransactionStatus", req, &resp); err != nil {
+		return nil, err
+	}
+	return resp.ProcessingResults, nil
+}
+
+// Report sends an invoice with a new exchange token and returns the ID of the
+// transaction.
+func (c *Client) Report(ctx context.Context, op Operation) (string, error) {
+	token, err := c.TokenExchange(ctx)
+	if err != nil {
+		return "", err
+	}
+	return c.ManageInvoice(ctx, token, []Operation{op})
+}
+
+// newRequest returns a signed request.
+func (c *Client) newRequest(name string, ops []Operation) *request {
+	now := time.Now().UTC()
+	id := requestID(now)
+	return &request{
+		XMLName:     xml.Name{Local: name},
+		Xmlns:       NamespaceAPI,
+		XmlnsCommon: NamespaceCommon,
+		Header: header{
+			RequestID:      id,
+			Timestamp:      now.Format("2006-01-02T15:04:05.000Z"),
+			RequestVersion: "3.0",
+			HeaderVersion:  "1.0",
+		},
+		User: user{
+			Login:            c.Info.Login,
+			PasswordHash:     cryptoValue{"SHA-512", PasswordHash(c.Info.Password)},
+			TaxNumber:        c.Info.TaxNumber,
+			RequestSignature: cryptoValue{"SHA3-512", Signature(id, now, c.Info.SignatureKey, ops...)},
+		},
+		Software: c.Info.Software,
+	}
+}
+
+// call posts the request to the operation and reads the response. Error
+// responses are returned as *Error.
+func (c *Client) call(ctx context.Context, operation string, req *request, resp *response) error {
+	body, err := xml.Marshal(req)
+	if err != nil {
+		return err
+	}
+	httpReq, err := http.NewRequest("POST", strings.TrimSuffix(c.Info.URL, "/")+"/"+operation,
+		io.MultiReader(strings.NewReader(xml.Header), bytes.NewReader(body)))
+	if err != nil {
+		return err
+	}
+	httpReq = httpReq.WithContext(ctx)
+	httpReq.Header.Set("Content-Type", "application/xml")
+	httpReq.Header.Set("Accept", "application/xml")
+
+	httpResp, err := c.HTTP.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer httpResp.Body.Close()
+	b, err := ioutil.ReadAll(httpResp.Body)
+	if err != nil {
+		return err
+	}
+	if err = xml.Unmarshal(b, resp); err != nil {
+		return fmt.Errorf("%s: %s: %v", operation, httpResp.Status, err)
+	}
+	if resp.FuncCode != "OK" {
+		return &Error{Code: resp.ErrorCode, Message: resp.Message}
+	}
+	return nil
+}
+
+// requestID returns an ID unique to the request.
+func requestID(now time.Time) string {
+	b := make([]byte, 6)
+	rand.Read(b)
+	return "RID" + strconv.FormatInt(now.UnixNano(), 36) + hex.EncodeToString(b)
+}
+
+// PasswordHash returns the hash of the password sent in the requests.
+func PasswordHash(password string) string {
+	sum := sha512.Sum512([]byte(password))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+// Signature returns the signature of a request. The invoices of a
+// manageInvoice request are signed too.
+func Signature(requestID string, timestamp time.Time, signatureKey string, ops ...Operation) string {
+	s := requestID + timestamp.UTC().Format("20060102150405") + signatureKey
+	for _, op := range ops {
+		s += sha3Hex(op.Operation + base64.StdEncoding.EncodeToString(op.Data))
+	}
+	return sha3Hex(s)
+}
+
+// sha3Hex returns the SHA3-512 hash of s in upper case hexadecimal.
+func sha3Hex(s string) string {
+	h := sha3.New512()
+	h.Write([]byte(s))
+	return strings.ToUpper(hex.EncodeToString(h.Sum(nil)))
+}
+
+// DecodeExchangeToken decrypts an exchange token with the exchange key:
+// AES-128 in ECB mode with PKCS#7 padding.
+func DecodeExchangeToken(encoded, exchangeKey string) (string, error) {
+	b, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", err
+	}
+	block, err := aes.NewCipher([]byte(exchangeKey))
+	if err != nil {
+		return "", err
+	}
+	if len(b) == 0 || len(b)%aes.BlockSize != 0 {
+		return "", fmt.Errorf("exchange token of %d bytes is not in blocks", len(b))
+	}
+	for i := 0; i < len(b); i += aes.BlockSize {
+		block.Decrypt(b[i:i+aes.BlockSize], b[i:i+aes.BlockSize])
+	}
+	pad := int(b[len(b)-1])
+	if pad < 1 || pad > aes.BlockSize || pad > len(b) {
+		return "", fmt.Errorf("exchange token has a wrong padding")
+	}
+	return string(b[:len(b)-pad]), nil
+}
+
+// EncodeExchangeToken encrypts an exchange token as DecodeExchangeToken
+// expects it.
+func EncodeExchangeToken(token, exchangeKey string) (string, error) {
+	block, err := aes.NewCipher([]byte(exchangeKey))
+	if err != nil {
+		return "", err
+	}
+	pad := aes.BlockSize - len(token)%aes.BlockSize
+	b := append([]byte(token), bytes.Repeat([]byte{byte(pad)}, pad)...)
+	for i := 0; i < len(b); i += aes.BlockSize {
+		block.Encrypt(b[i:i+aes.BlockSize], b[i:i+aes.BlockSize])
+	}
+	return base64.StdEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,118 @@
+package nav_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/UNO-SOFT/szamlazo/lib/money"
+	"github.com/UNO-SOFT/szamlazo/lib/nav"
+	"github.com/UNO-SOFT/szamlazo/lib/nav/navtest"
+)
+
+// TestExchangeToken checks the encryption of the exchange token.
+func TestExchangeToken(t *testing.T) {
+	const key = "a8b23cd4e5f6a7b8"
+	for _, token := range []string{"x", "0123456789abcdef", "5f4c2d38-0d7e-4bbd-a0c2-7b6b0b41d8c7"} {
+		encoded, err := nav.EncodeExchangeToken(token, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := nav.DecodeExchangeToken(encoded, key)
+		if err != nil || got != token {
+			t.Errorf("%q: got %q, %v", token, got, err)
+		}
+	}
+	if _, err := nav.DecodeExchangeToken("bm90IGluIGJsb2Nrcw==", key); err == nil {
+		t.Error("partial block accepted")
+	}
+}
+
+// TestSignature checks that the invoices are part of the signature.
+func TestSignature(t *testing.T) {
+	ts := time.Date(2026, 10, 17, 8, 30, 0, 0, time.UTC)
+	plain := nav.Signature("RID1", ts, "key")
+	if len(plain) != 128 {
+		t.Fatalf("signature %q is not SHA3-512 hex", plain)
+	}
+	op := nav.Operation{Operation: nav.OperationCreate, Data: []byte("<InvoiceData/>")}
+	if nav.Signature("RID1", ts, "key", op) == plain {
+		t.Error("invoices not signed")
+	}
+	if nav.Signature("RID1", ts.Add(time.Second), "key") == plain {
+		t.Error("timestamp not signed")
+	}
+}
+
+// TestReport reports invoices to the mock server and queries their status.
+func TestReport(t *testing.T) {
+	srv := navtest.NewServer()
+	defer srv.Close()
+	client := nav.NewClient(srv.Info)
+	ctx := context.Background()
+
+	d, err := nav.NewInvoiceData(fixtures()["domestic"], money.Decimal{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := d.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	txID, err := client.Report(ctx, nav.Operation{Operation: nav.OperationCreate, Data: data})
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err := client.QueryTransactionStatus(ctx, txID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].InvoiceStatus != nav.StatusDone {
+		t.Errorf("got %+v", results)
+	}
+	if invoices := srv.Invoices(); len(invoices) != 1 || invoices[0].Number != "SZ2026/00042" {
+		t.Errorf("server got %+v", invoices)
+	}
+
+	// A broken document is aborted with a message.
+	txID, err = client.Report(ctx, nav.Operation{Operation: nav.OperationCreate, Data: []byte("not XML")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results, err = client.QueryTransactionStatus(ctx, txID); err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].InvoiceStatus != nav.StatusAborted || results[0].Messages() == "" {
+		t.Errorf("got %+v", results)
+	}
+
+	// A token is used once.
+	token, err := client.TokenExchange(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	op := nav.Operation{Operation: nav.OperationCreate, Data: data}
+	if _, err = client.ManageInvoice(ctx, token, []nav.Operation{op}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = client.ManageInvoice(ctx, token, []nav.Operation{op}); !isCode(err, "INVALID_EXCHANGE_TOKEN") {
+		t.Errorf("token reused: %v", err)
+	}
+
+	// Wrong credentials are refused.
+	client.Info.Password = "wrong"
+	if _, err = client.TokenExchange(ctx); !isCode(err, "INVALID_SECURITY_USER") {
+		t.Errorf("wrong password: %v", err)
+	}
+	client.Info.Password, client.Info.SignatureKey = srv.Info.Password, "wrong"
+	if _, err = client.TokenExchange(ctx); !isCode(err, "INVALID_REQUEST_SIGNATURE") {
+		t.Errorf("wrong signature key: %v", err)
+	}
+}
+
+// isCode reports whether err is an API error with the code.
+func isCode(err error, code string) bool {
+	e, ok := err.(*nav.Error)
+	return ok && e.Code == code
+}
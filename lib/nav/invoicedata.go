@@ -0,0 +1,436 @@
+// Package nav reports invoices to the Online Invoice system (Online Számla)
+// of the Hungarian tax authority, NAV.
+package nav
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strings"
+
+	"github.com/UNO-SOFT/szamlazo/lib/money"
+	"github.com/UNO-SOFT/szamlazo/lib/taxnumber"
+	"github.com/UNO-SOFT/szamlazo/model/invoice"
+)
+
+// Namespaces of the version 3.0 schemas.
+const (
+	NamespaceData   = "http://schemas.nav.gov.hu/OSA/3.0/data"
+	NamespaceBase   = "http://schemas.nav.gov.hu/OSA/3.0/base"
+	NamespaceCommon = "http://schemas.nav.gov.hu/NTCA/1.0/common"
+)
+
+// dateLayout is the format of xs:date.
+const dateLayout = "2006-01-02"
+
+// Customer VAT statuses.
+const (
+	CustomerDomestic = "DOMESTIC"       // Hungarian taxpayer
+	CustomerOther    = "OTHER"          // Foreign or not a taxpayer
+	CustomerPrivate  = "PRIVATE_PERSON" // Private person, not reported by name
+)
+
+// InvoiceData is the invoice as reported, in the InvoiceData element of the
+// data schema. The elements of the base schema are named with their prefix,
+// declared on the root element.
+type InvoiceData struct {
+	XMLName               xml.Name    `xml:"InvoiceData"`
+	Xmlns                 string      `xml:"xmlns,attr"`
+	XmlnsCommon           string      `xml:"xmlns:common,attr"`
+	XmlnsBase             string      `xml:"xmlns:base,attr"`
+	InvoiceNumber         string      `xml:"invoiceNumber"`
+	InvoiceIssueDate      string      `xml:"invoiceIssueDate"`
+	CompletenessIndicator bool        `xml:"completenessIndicator"`
+	Invoice               InvoiceType `xml:"invoiceMain>invoice"`
+}
+
+// InvoiceType is a single invoice. Storno and modification invoices refer to
+// the invoice they correct.
+type InvoiceType struct {
+	Reference *InvoiceReference `xml:"invoiceReference,omitempty"`
+	Head      InvoiceHead       `xml:"invoiceHead"`
+	Lines     InvoiceLines      `xml:"invoiceLines"`
+	Summary   InvoiceSummary    `xml:"invoiceSummary"`
+}
+
+// InvoiceReference is the original invoice of a correction, and the number
+// of the correction among the ones of the original.
+type InvoiceReference struct {
+	OriginalInvoiceNumber string `xml:"originalInvoiceNumber"`
+	ModifyWithoutMaster   bool   `xml:"modifyWithoutMaster"`
+	ModificationIndex     int64  `xml:"modificationIndex"`
+}
+
+// InvoiceHead holds the parties and the details of the invoice.
+type InvoiceHead struct {
+	Supplier SupplierInfo  `xml:"supplierInfo"`
+	Customer CustomerInfo  `xml:"customerInfo"`
+	Detail   InvoiceDetail `xml:"invoiceDetail"`
+}
+
+// TaxNumber is a Hungarian tax number split into its parts.
+type TaxNumber struct {
+	TaxpayerID string `xml:"base:taxpayerId"`
+	VATCode    string `xml:"base:vatCode,omitempty"`
+	CountyCode string `xml:"base:countyCode,omitempty"`
+}
+
+// SimpleAddress is an address with the street in a single field.
+type SimpleAddress struct {
+	CountryCode string `xml:"base:countryCode"`
+	PostalCode  string `xml:"base:postalCode"`
+	City        string `xml:"base:city"`
+	Detail      string `xml:"base:additionalAddressDetail"`
+}
+
+// SupplierInfo is the seller.
+type SupplierInfo struct {
+	TaxNumber TaxNumber     `xml:"supplierTaxNumber"`
+	Name      string        `xml:"supplierName"`
+	Address   SimpleAddress `xml:"supplierAddress>base:simpleAddress"`
+}
+
+// CustomerInfo is the buyer. Private persons are reported by their status
+// only.
+type CustomerInfo struct {
+	VATStatus string         `xml:"customerVatStatus"`
+	VATData   *CustomerVAT   `xml:"customerVatData,omitempty"`
+	Name      string         `xml:"customerName,omitempty"`
+	Address   *SimpleAddress `xml:"customerAddress>base:simpleAddress,omitempty"`
+}
+
+// CustomerVAT is one of the tax numbers of the buyer.
+type CustomerVAT struct {
+	TaxNumber          *TaxNumber `xml:"customerTaxNumber,omitempty"`
+	CommunityVATNumber string     `xml:"communityVatNumber,omitempty"`
+	ThirdStateTaxID    string     `xml:"thirdStateTaxId,omitempty"`
+}
+
+// InvoiceDetail holds the dates and terms of the invoice.
+type InvoiceDetail struct {
+	Category          string        `xml:"invoiceCategory"`
+	DeliveryDate      string        `xml:"invoiceDeliveryDate"`
+	CurrencyCode      string        `xml:"currencyCode"`
+	ExchangeRate      money.Decimal `xml:"exchangeRate"`
+	PaymentMethod     string        `xml:"paymentMethod,omitempty"`
+	PaymentDate       string        `xml:"paymentDate,omitempty"`
+	InvoiceAppearance string        `xml:"invoiceAppearance"`
+}
+
+// InvoiceLines are the lines of the invoice.
+type InvoiceLines struct {
+	MergedItemIndicator bool   `xml:"mergedItemIndicator"`
+	Lines               []Line `xml:"line"`
+}
+
+// ProductCodes are the classifications of the product of a line.
+type ProductCodes struct {
+	Codes []ProductCode `xml:"productCode"`
+}
+
+// ProductCode classifies the product of a line.
+type ProductCode struct {
+	Category string `xml:"productCodeCategory"`
+	Value    string `xml:"productCodeValue,omitempty"`
+	OwnValue string `xml:"productCodeOwnValue,omitempty"`
+}
+
+// Line is an invoice line with its amounts.
+type Line struct {
+	LineNumber            uint32                 `xml:"lineNumber"`
+	ModificationReference *ModificationReference `xml:"lineModificationReference,omitempty"`
+	ProductCodes          *ProductCodes          `xml:"productCodes,omitempty"`
+	ExpressionIndicator   bool                   `xml:"lineExpressionIndicator"`
+	NatureIndicator       string                 `xml:"lineNatureIndicator,omitempty"`
+	Description           string                 `xml:"lineDescription"`
+	Quantity              money.Decimal          `xml:"quantity"`
+	UnitOfMeasure         string                 `xml:"unitOfMeasure"`
+	UnitOfMeasureOwn      string                 `xml:"unitOfMeasureOwn,omitempty"`
+	UnitPrice             money.Decimal          `xml:"unitPrice"`
+	UnitPriceHUF          money.Decimal          `xml:"unitPriceHUF"`
+	Amounts               LineAmounts            `xml:"lineAmountsNormal"`
+}
+
+// ModificationReference places a line of a correction in the lines of the
+// original invoice and its corrections. The lines of the corrections are
+// all added after the earlier ones, never changing them.
+type ModificationReference struct {
+	LineNumberReference int64  `xml:"lineNumberReference"`
+	LineOperation       string `xml:"lineOperation"`
+}
+
+// LineAmounts are the amounts of a line in the currency and in forints.
+type LineAmounts struct {
+	Net      money.Decimal `xml:"lineNetAmountData>lineNetAmount"`
+	NetHUF   money.Decimal `xml:"lineNetAmountData>lineNetAmountHUF"`
+	VATRate  VATRate       `xml:"lineVatRate"`
+	VAT      money.Decimal `xml:"lineVatData>lineVatAmount"`
+	VATHUF   money.Decimal `xml:"lineVatData>lineVatAmountHUF"`
+	Gross    money.Decimal `xml:"lineGrossAmountData>lineGrossAmountNormal"`
+	GrossHUF money.Decimal `xml:"lineGrossAmountData>lineGrossAmountNormalHUF"`
+}
+
+// VATRate is a percentage, an exemption or a supply out of the scope of VAT.
+type VATRate struct {
+	Percentage *money.Decimal `xml:"vatPercentage,omitempty"`
+	Exemption  *VATCase       `xml:"vatExemption,omitempty"`
+	OutOfScope *VATCase       `xml:"vatOutOfScope,omitempty"`
+}
+
+// VATCase is the legal case of an exemption.
+type VATCase struct {
+	Case   string `xml:"case"`
+	Reason string `xml:"reason"`
+}
+
+// InvoiceSummary are the totals of the invoice.
+type InvoiceSummary struct {
+	ByRate   []RateSummary `xml:"summaryNormal>summaryByVatRate"`
+	Net      money.Decimal `xml:"summaryNormal>invoiceNetAmount"`
+	NetHUF   money.Decimal `xml:"summaryNormal>invoiceNetAmountHUF"`
+	VAT      money.Decimal `xml:"summaryNormal>invoiceVatAmount"`
+	VATHUF   money.Decimal `xml:"summaryNormal>invoiceVatAmountHUF"`
+	Gross    money.Decimal `xml:"summaryGrossData>invoiceGrossAmount"`
+	GrossHUF money.Decimal `xml:"summaryGrossData>invoiceGrossAmountHUF"`
+}
+
+// RateSummary are the totals of a VAT rate.
+type RateSummary struct {
+	VATRate  VATRate       `xml:"vatRate"`
+	Net      money.Decimal `xml:"vatRateNetData>vatRateNetAmount"`
+	NetHUF   money.Decimal `xml:"vatRateNetData>vatRateNetAmountHUF"`
+	VAT      money.Decimal `xml:"vatRateVatData>vatRateVatAmount"`
+	VATHUF   money.Decimal `xml:"vatRateVatData>vatRateVatAmountHUF"`
+	Gross    money.Decimal `xml:"vatRateGrossData>vatRateGrossAmount"`
+	GrossHUF money.Decimal `xml:"vatRateGrossData>vatRateGrossAmountHUF"`
+}
+
+var (
+	// units maps the usual units to the units of measure of the schema.
+	units = map[string]string{
+		"db": "PIECE", "darab": "PIECE", "pc": "PIECE", "piece": "PIECE",
+		"kg": "KILOGRAM", "t": "TON", "tonna": "TON", "kwh": "KWH",
+		"nap": "DAY", "day": "DAY", "óra": "HOUR", "hour": "HOUR", "h": "HOUR",
+		"perc": "MINUTE", "minute": "MINUTE", "hó": "MONTH", "hónap": "MONTH", "month": "MONTH",
+		"l": "LITER", "liter": "LITER", "km": "KILOMETER", "m3": "CUBIC_METER",
+		"m": "METER", "fm": "LINEAR_METER", "karton": "CARTON", "csomag": "PACK",
+	}
+
+	// exemptions are the reasons of the VAT exemptions.
+	exemptions = map[string]string{
+		invoice.VATAAM: "alanyi adómentes",
+		invoice.VATTAM: "tárgyi adómentes",
+		invoice.VATEU:  "Közösségen belüli adómentes termékértékesítés",
+	}
+)
+
+// InvoiceOperation returns the operation reporting an invoice by its kind.
+func InvoiceOperation(item invoice.Item) string {
+	switch item.Kind {
+	case invoice.KindStorno:
+		return OperationStorno
+	case invoice.KindModification:
+		return OperationModify
+	}
+	return OperationCreate
+}
+
+// NewInvoiceData converts an issued invoice for reporting. exchangeRate is
+// the price of a unit of the currency in forints, 1 for HUF.
+func NewInvoiceData(item invoice.Item, exchangeRate money.Decimal) (InvoiceData, error) {
+	if !item.Number.Valid {
+		return InvoiceData{}, fmt.Errorf("invoice %d has no number", item.ID)
+	}
+	if item.IsCorrection() && (!item.OriginalNumber.Valid || !item.ModificationIndex.Valid) {
+		return InvoiceData{}, fmt.Errorf("invoice %s: no reference to the original", item.Number.String)
+	}
+	if item.Currency == "HUF" {
+		exchangeRate = money.New(1, 0)
+	} else if exchangeRate.Sign() <= 0 {
+		return InvoiceData{}, fmt.Errorf("invoice %s: no exchange rate for %s", item.Number.String, item.Currency)
+	}
+
+	supplierTax, err := taxnumber.Parse(item.SellerTaxNumber)
+	if err != nil {
+		return InvoiceData{}, fmt.Errorf("invoice %s: seller: %v", item.Number.String, err)
+	}
+	supplierAddress, err := ParseAddress(item.SellerAddress)
+	if err != nil {
+		return InvoiceData{}, fmt.Errorf("invoice %s: seller: %v", item.Number.String, err)
+	}
+	customer, err := newCustomerInfo(item)
+	if err != nil {
+		return InvoiceData{}, fmt.Errorf("invoice %s: buyer: %v", item.Number.String, err)
+	}
+
+	huf := func(d money.Decimal) money.Decimal { return d.Mul(exchangeRate).Round(2).Normalize() }
+	d := InvoiceData{
+		Xmlns:            NamespaceData,
+		XmlnsCommon:      NamespaceCommon,
+		XmlnsBase:        NamespaceBase,
+		InvoiceNumber:    item.Number.String,
+		InvoiceIssueDate: item.IssueDate.Format(dateLayout),
+		Invoice: InvoiceType{
+			Head: InvoiceHead{
+				Supplier: SupplierInfo{
+					TaxNumber: TaxNumber{supplierTax.Base, supplierTax.VATCode, supplierTax.County},
+					Name:      item.SellerName,
+					Address:   supplierAddress,
+				},
+				Customer: customer,
+				Detail: InvoiceDetail{
+					Category:          "NORMAL",
+					DeliveryDate:      item.FulfilmentDate.Format(dateLayout),
+					CurrencyCode:      item.Currency,
+					ExchangeRate:      exchangeRate,
+					PaymentMethod:     item.PaymentMethod,
+					PaymentDate:       item.DueDate.Format(dateLayout),
+					InvoiceAppearance: "PAPER",
+				},
+			},
+		},
+	}
+
+	if item.IsCorrection() {
+		d.Invoice.Reference = &InvoiceReference{
+			OriginalInvoiceNumber: item.OriginalNumber.String,
+			ModificationIndex:     item.ModificationIndex.Int64,
+		}
+	}
+
+	totals := item.Totals()
+	for i, line := range item.Lines {
+		a := totals.Lines[i]
+		l := Line{
+			LineNumber:          uint32(i + 1),
+			ExpressionIndicator: true,
+			NatureIndicator:     natureIndicator(line.CodeType),
+			Description:         line.Description,
+			Quantity:            line.Quantity,
+			UnitPrice:           line.UnitPrice,
+			UnitPriceHUF:        huf(line.UnitPrice),
+			Amounts: LineAmounts{
+				Net: a.Net, NetHUF: huf(a.Net),
+				VATRate: newVATRate(line.VATRate),
+				VAT:     a.VAT, VATHUF: huf(a.VAT),
+				Gross: a.Gross, GrossHUF: huf(a.Gross),
+			},
+		}
+		l.UnitOfMeasure, l.UnitOfMeasureOwn = unitOfMeasure(line.Unit)
+		if item.IsCorrection() {
+			if !line.Reference.Valid {
+				return InvoiceData{}, fmt.Errorf("invoice %s: line %d has no reference", item.Number.String, i+1)
+			}
+			l.ModificationReference = &ModificationReference{line.Reference.Int64, "CREATE"}
+		}
+		switch line.CodeType {
+		case "":
+		case "VTSZ", "SZJ":
+			l.ProductCodes = &ProductCodes{[]ProductCode{{Category: line.CodeType, Value: line.Code}}}
+		default:
+			l.ProductCodes = &ProductCodes{[]ProductCode{{Category: "OWN", OwnValue: line.Code}}}
+		}
+		d.Invoice.Lines.Lines = append(d.Invoice.Lines.Lines, l)
+	}
+
+	s := &d.Invoice.Summary
+	for _, r := range totals.ByRate {
+		s.ByRate = append(s.ByRate, RateSummary{
+			VATRate: newVATRate(r.Rate),
+			Net:     r.Net, NetHUF: huf(r.Net),
+			VAT: r.VAT, VATHUF: huf(r.VAT),
+			Gross: r.Gross, GrossHUF: huf(r.Gross),
+		})
+		s.NetHUF = s.NetHUF.Add(huf(r.Net))
+		s.VATHUF = s.VATHUF.Add(huf(r.VAT))
+		s.GrossHUF = s.GrossHUF.Add(huf(r.Gross))
+	}
+	s.Net, s.VAT, s.Gross = totals.Net, totals.VAT, totals.Gross
+	return d, nil
+}
+
+// Marshal returns the document with an XML declaration.
+func (d InvoiceData) Marshal() ([]byte, error) {
+	b, err := xml.MarshalIndent(d, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), append(b, '\n')...), nil
+}
+
+// newCustomerInfo returns the buyer by the kind of its tax number: a
+// Hungarian one, an EU VAT number, or none for a private person.
+func newCustomerInfo(item invoice.Item) (CustomerInfo, error) {
+	if strings.TrimSpace(item.BuyerTaxNumber) == "" {
+		return CustomerInfo{VATStatus: CustomerPrivate}, nil
+	}
+	address, err := ParseAddress(item.BuyerAddress)
+	if err != nil {
+		return CustomerInfo{}, err
+	}
+	c := CustomerInfo{Name: item.BuyerName, Address: &address}
+	if n, err := taxnumber.Parse(item.BuyerTaxNumber); err == nil {
+		c.VATStatus = CustomerDomestic
+		c.VATData = &CustomerVAT{TaxNumber: &TaxNumber{n.Base, n.VATCode, n.County}}
+		return c, nil
+	}
+	n, err := taxnumber.ParseEU(item.BuyerTaxNumber)
+	if err != nil {
+		return c, fmt.Errorf("tax number %q is neither Hungarian nor an EU VAT number", item.BuyerTaxNumber)
+	}
+	c.VATStatus = CustomerOther
+	c.VATData = &CustomerVAT{CommunityVATNumber: n}
+	return c, nil
+}
+
+// newVATRate returns the rate of the schema for a VAT rate of the invoice.
+func newVATRate(rate string) VATRate {
+	if reason, ok := exemptions[rate]; ok {
+		return VATRate{Exemption: &VATCase{Case: rate, Reason: reason}}
+	}
+	if rate == invoice.VATATK {
+		return VATRate{OutOfScope: &VATCase{Case: rate, Reason: "áfa tv. tárgyi hatályán kívüli ügylet"}}
+	}
+	pct := invoice.VATPercent(rate)
+	return VATRate{Percentage: &pct}
+}
+
+// natureIndicator tells goods from services by their classification code.
+func natureIndicator(codeType string) string {
+	switch codeType {
+	case "VTSZ":
+		return "PRODUCT"
+	case "SZJ":
+		return "SERVICE"
+	}
+	return "OTHER"
+}
+
+// unitOfMeasure returns the unit of measure of the schema, or OWN and the
+// unit itself.
+func unitOfMeasure(unit string) (string, string) {
+	if u, ok := units[strings.ToLower(strings.TrimSuffix(strings.TrimSpace(unit), "."))]; ok {
+		return u, ""
+	}
+	return "OWN", unit
+}
+
+// ParseAddress splits an address written as "1051 Budapest, Fő utca 1." with
+// an optional ", DE" country code at the end, as partner addresses are.
+func ParseAddress(s string) (SimpleAddress, error) {
+	a := SimpleAddress{CountryCode: "HU"}
+	parts := strings.Split(s, ",")
+	for i := range parts {
+		parts[i] = strings.TrimSpace(parts[i])
+	}
+	if n := len(parts); n > 2 && len(parts[n-1]) == 2 && strings.ToUpper(parts[n-1]) == parts[n-1] {
+		a.CountryCode, parts = parts[n-1], parts[:n-1]
+	}
+	fields := strings.Fields(parts[0])
+	if len(fields) < 2 || len(parts) < 2 {
+		return a, fmt.Errorf("address %q is not in the \"1051 Budapest, Fő utca 1.\" form", s)
+	}
+	a.PostalCode = fields[0]
+	a.City = strings.Join(fields[1:], " ")
+	a.Detail = strings.Join(parts[1:], ", ")
+	return a, nil
+}
@@ -0,0 +1,199 @@
+package nav_test
+
+import (
+	"bytes"
+	"encoding/xml"
+	"flag"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/UNO-SOFT/szamlazo/lib/money"
+	"github.com/UNO-SOFT/szamlazo/lib/nav"
+	"github.com/UNO-SOFT/szamlazo/model/invoice"
+
+	"gopkg.in/guregu/null.v3"
+)
+
+var update = flag.Bool("update", false, "rewrite the expected files in testdata")
+
+// fixtures are the invoices of the tests, by the name of their expected file.
+func fixtures() map[string]invoice.Item {
+	day := func(d int) time.Time { return time.Date(2026, 10, d, 0, 0, 0, 0, time.UTC) }
+	base := invoice.Item{
+		ID:              1,
+		Number:          null.StringFrom("SZ2026/00042"),
+		SellerName:      "Példa Szoftver Kft.",
+		SellerAddress:   "1051 Budapest, Fő utca 1.",
+		SellerTaxNumber: "12345676-2-41",
+		IssueDate:       day(17),
+		FulfilmentDate:  day(16),
+		DueDate:         day(25),
+		Currency:        "HUF",
+		PaymentMethod:   invoice.PaymentTransfer,
+		Rounding:        invoice.RoundPerLine,
+	}
+
+	domestic := base
+	domestic.BuyerName = "Vevő Bt."
+	domestic.BuyerAddress = "6720 Szeged, Kárász utca 2."
+	domestic.BuyerTaxNumber = "15789934-2-51"
+	domestic.Lines = []invoice.Line{
+		{Description: "Szoftverfejlesztés", Quantity: money.MustParse("12.5"), Unit: "óra",
+			UnitPrice: money.MustParse("15000"), VATRate: invoice.VAT27, CodeType: "SZJ", Code: "72.22.1"},
+		{Description: "Kézikönyv", Quantity: money.MustParse("2"), Unit: "db",
+			UnitPrice: money.MustParse("3999"), VATRate: invoice.VAT5, CodeType: "VTSZ", Code: "4901"},
+		{Description: "Oktatás", Quantity: money.MustParse("1"), Unit: "alkalom",
+			UnitPrice: money.MustParse("50000"), VATRate: invoice.VATTAM},
+	}
+
+	eu := base
+	eu.Number = null.StringFrom("SZ2026/00043")
+	eu.BuyerName = "Beispiel GmbH"
+	eu.BuyerAddress = "10115 Berlin, Invalidenstraße 1, DE"
+	eu.BuyerTaxNumber = "DE123456789"
+	eu.Currency = "EUR"
+	eu.Lines = []invoice.Line{
+		{Description: "Software licence", Quantity: money.MustParse("3"), Unit: "pc",
+			UnitPrice: money.MustParse("199.90"), VATRate: invoice.VATEU, CodeType: "OWN", Code: "LIC-3"},
+	}
+
+	private := base
+	private.Number = null.StringFrom("SZ2026/00044")
+	private.BuyerName = "Kiss Anna"
+	private.BuyerAddress = "2000 Szentendre, Fő tér 3."
+	private.PaymentMethod = invoice.PaymentCash
+	private.DueDate = day(17)
+	private.Lines = []invoice.Line{
+		{Description: "Tanácsadás", Quantity: money.MustParse("1"), Unit: "óra",
+			UnitPrice: money.MustParse("10000"), VATRate: invoice.VAT27},
+	}
+
+	// A discount, then the cancellation of the domestic invoice
+	modification := domestic.Modification(day(20))
+	modification.Number = null.StringFrom("SZH2026/00001")
+	modification.ModificationIndex = null.IntFrom(1)
+	modification.Lines = []invoice.Line{
+		{Description: "Kedvezmény", Quantity: money.MustParse("1"), Unit: "db",
+			UnitPrice: money.MustParse("-20000"), VATRate: invoice.VAT27, Reference: null.IntFrom(4)},
+	}
+
+	storno := domestic.Storno(day(21))
+	storno.Number = null.StringFrom("SZH2026/00002")
+	storno.ModificationIndex = null.IntFrom(2)
+	for i := range storno.Lines {
+		storno.Lines[i].Reference = null.IntFrom(int64(5 + i))
+	}
+
+	return map[string]invoice.Item{"domestic": domestic, "eu": eu, "private": private,
+		"modification": modification, "storno": storno}
+}
+
+// TestInvoiceData compares the documents of the fixtures to the expected
+// ones, and checks that every element is in the namespace of its schema.
+func TestInvoiceData(t *testing.T) {
+	rate := money.MustParse("402.15")
+	for name, item := range fixtures() {
+		d, err := nav.NewInvoiceData(item, rate)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		got, err := d.Marshal()
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		file := filepath.Join("testdata", name+".xml")
+		if *update {
+			if err = ioutil.WriteFile(file, got, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		want, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("%s: got\n%s\nwant\n%s", name, got, want)
+		}
+		checkNamespaces(t, name, got)
+	}
+}
+
+// baseElements are the elements defined by the base schema.
+var baseElements = map[string]bool{
+	"taxpayerId": true, "vatCode": true, "countyCode": true, "simpleAddress": true,
+	"countryCode": true, "postalCode": true, "city": true, "additionalAddressDetail": true,
+}
+
+// checkNamespaces checks the namespace of every element of doc.
+func checkNamespaces(t *testing.T, name string, doc []byte) {
+	dec := xml.NewDecoder(bytes.NewReader(doc))
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		se, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		want := nav.NamespaceData
+		if baseElements[se.Name.Local] {
+			want = nav.NamespaceBase
+		}
+		if se.Name.Space != want {
+			t.Errorf("%s: %s is in %q want %q", name, se.Name.Local, se.Name.Space, want)
+		}
+	}
+}
+
+// TestInvoiceDataErrors checks the invoices which cannot be reported.
+func TestInvoiceDataErrors(t *testing.T) {
+	for name, change := range map[string]func(*invoice.Item){
+		"no number":      func(item *invoice.Item) { item.Number = null.String{} },
+		"no rate":        func(item *invoice.Item) { item.Currency = "EUR" },
+		"bad seller tax": func(item *invoice.Item) { item.SellerTaxNumber = "12345678-1-13" },
+		"bad address":    func(item *invoice.Item) { item.SellerAddress = "Budapest" },
+		"unknown tax":    func(item *invoice.Item) { item.BuyerTaxNumber = "123" },
+		"no original":    func(item *invoice.Item) { item.Kind = invoice.KindStorno },
+	} {
+		item := fixtures()["domestic"]
+		change(&item)
+		if _, err := nav.NewInvoiceData(item, money.Decimal{}); err == nil {
+			t.Errorf("%s: accepted", name)
+		}
+	}
+}
+
+// TestInvoiceOperation checks the operations of the kinds of invoices.
+func TestInvoiceOperation(t *testing.T) {
+	for name, want := range map[string]string{
+		"domestic": nav.OperationCreate, "modification": nav.OperationModify, "storno": nav.OperationStorno,
+	} {
+		if got := nav.InvoiceOperation(fixtures()[name]); got != want {
+			t.Errorf("%s: got %s want %s", name, got, want)
+		}
+	}
+}
+
+// TestParseAddress checks the splitting of addresses.
+func TestParseAddress(t *testing.T) {
+	for in, want := range map[string]nav.SimpleAddress{
+		"1051 Budapest, Fő utca 1.":           {CountryCode: "HU", PostalCode: "1051", City: "Budapest", Detail: "Fő utca 1."},
+		"2000 Szentendre, Fő tér 3., fszt. 1": {CountryCode: "HU", PostalCode: "2000", City: "Szentendre", Detail: "Fő tér 3., fszt. 1"},
+		"1010 Wien, Ring 1, AT":               {CountryCode: "AT", PostalCode: "1010", City: "Wien", Detail: "Ring 1"},
+	} {
+		got, err := nav.ParseAddress(in)
+		if err != nil {
+			t.Errorf("%q: %v", in, err)
+		} else if got != want {
+			t.Errorf("%q: got %+v want %+v", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,245 @@
+// Package navtest provides an in-process Online Invoice API for testing the
+// reporting offline.
+package navtest
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/UNO-SOFT/szamlazo/lib/nav"
+)
+
+// tokenValidity is how long an exchange token can be used.
+const tokenValidity = 5 * time.Minute
+
+// Invoice is an invoice received by the server.
+type Invoice struct {
+	TransactionID string
+	Operation     string
+	Number        string
+	Data          []byte
+}
+
+// Server checks the authentication and the signature of the requests like
+// the real API. Invoices which are not InvoiceData documents with a number
+// are aborted, the others are done.
+type Server struct {
+	*httptest.Server
+	Info nav.Info // Credentials accepted, with the URL of the server
+
+	mu           sync.Mutex
+	tokens       map[string]time.Time
+	transactions map[string][]Invoice
+	seq          int
+}
+
+// NewServer starts a server. Close it when done.
+func NewServer() *Server {
+	s := &Server{
+		Info: nav.Info{
+			Login:        "testlogin",
+			Password:     "testpassword",
+			TaxNumber:    "12345676",
+			SignatureKey: "ce-8f5e-215119fa7dd621DLMRHRLH2S",
+			ExchangeKey:  "a8b23cd4e5f6a7b8",
+			Software: nav.Software{
+				ID:        "HU12345676SZAMLA01",
+				Name:      "szamlazo",
+				Operation: "ONLINE_SERVICE",
+			},
+		},
+		tokens:       make(map[string]time.Time),
+		transactions: make(map[string][]Invoice),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	s.Info.URL = s.Server.URL
+	return s
+}
+
+// Invoices returns the invoices received, in their order.
+func (s *Server) Invoices() []Invoice {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var invoices []Invoice
+	for i := 1; i <= s.seq; i++ {
+		invoices = append(invoices, s.transactions[transactionID(i)]...)
+	}
+	return invoices
+}
+
+// request holds the elements of the requests, by their local names.
+type request struct {
+	XMLName   xml.Name
+	RequestID string `xml:"header>requestId"`
+	Timestamp string `xml:"header>timestamp"`
+	Login     string `xml:"user>login"`
+	Password  string `xml:"user>passwordHash"`
+	TaxNumber string `xml:"user>taxNumber"`
+	Signature string `xml:"user>requestSignature"`
+	Token     string `xml:"exchangeToken"`
+	TxID      string `xml:"transactionId"`
+	Ops       []struct {
+		Index     int    `xml:"index"`
+		Operation string `xml:"invoiceOperation"`
+		Data      string `xml:"invoiceData"`
+	} `xml:"invoiceOperations>invoiceOperation"`
+}
+
+// serve answers a request.
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	b, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		fail(w, "INVALID_REQUEST", err.Error())
+		return
+	}
+	var req request
+	if err = xml.Unmarshal(b, &req); err != nil {
+		fail(w, "INVALID_REQUEST", err.Error())
+		return
+	}
+	ts, err := time.Parse("2006-01-02T15:04:05.000Z", req.Timestamp)
+	if err != nil {
+		fail(w, "INVALID_REQUEST", "bad timestamp "+req.Timestamp)
+		return
+	}
+	if req.Login != s.Info.Login || req.Password != nav.PasswordHash(s.Info.Password) ||
+		req.TaxNumber != s.Info.TaxNumber {
+		fail(w, "INVALID_SECURITY_USER", "Helytelen authentikációs adatok!")
+		return
+	}
+	var ops []nav.Operation
+	for _, op := range req.Ops {
+		data, err := base64.StdEncoding.DecodeString(op.Data)
+		if err != nil {
+			fail(w, "INVALID_REQUEST", err.Error())
+			return
+		}
+		ops = append(ops, nav.Operation{Operation: op.Operation, Data: data})
+	}
+	if req.Signature != nav.Signature(req.RequestID, ts, s.Info.SignatureKey, ops...) {
+		fail(w, "INVALID_REQUEST_SIGNATURE", "Helytelen kérés aláírás érték!")
+		return
+	}
+
+	switch r.URL.Path {
+	case "/tokenExchange":
+		s.tokenExchange(w)
+	case "/manageInvoice":
+		s.manageInvoice(w, req.Token, ops)
+	case "/queryTransactionStatus":
+		s.queryTransactionStatus(w, req.TxID)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// tokenExchange issues a token.
+func (s *Server) tokenExchange(w http.ResponseWriter) {
+	s.mu.Lock()
+	s.seq++
+	token := fmt.Sprintf("token-%d", s.seq)
+	s.tokens[token] = time.Now().Add(tokenValidity)
+	s.mu.Unlock()
+
+	encoded, err := nav.EncodeExchangeToken(token, s.Info.ExchangeKey)
+	if err != nil {
+		fail(w, "OPERATION_FAILED", err.Error())
+		return
+	}
+	ok(w, "TokenExchangeResponse", "<encodedExchangeToken>"+encoded+"</encodedExchangeToken>")
+}
+
+// manageInvoice accepts the invoices with a valid token, once.
+func (s *Server) manageInvoice(w http.ResponseWriter, token string, ops []nav.Operation) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if valid, found := s.tokens[token]; !found || time.Now().After(valid) {
+		fail(w, "INVALID_EXCHANGE_TOKEN", "Érvénytelen vagy lejárt token!")
+		return
+	}
+	delete(s.tokens, token)
+
+	s.seq++
+	id := transactionID(s.seq)
+	for _, op := range ops {
+		var data struct {
+			Number string `xml:"invoiceNumber"`
+		}
+		if xml.Unmarshal(op.Data, &data) != nil {
+			data.Number = ""
+		}
+		s.transactions[id] = append(s.transactions[id], Invoice{
+			TransactionID: id, Operation: op.Operation, Number: data.Number, Data: op.Data,
+		})
+	}
+	ok(w, "ManageInvoiceResponse", "<transactionId>"+id+"</transactionId>")
+}
+
+// queryTransactionStatus returns the results of the invoices of a
+// transaction.
+func (s *Server) queryTransactionStatus(w http.ResponseWriter, id string) {
+	s.mu.Lock()
+	invoices, found := s.transactions[id]
+	s.mu.Unlock()
+	if !found {
+		fail(w, "INVALID_REQUEST", "Ismeretlen tranzakció: "+id)
+		return
+	}
+
+	var body bytes.Buffer
+	body.WriteString("<processingResults>")
+	for i, inv := range invoices {
+		fmt.Fprintf(&body, "<processingResult><index>%d</index>", i+1)
+		if inv.Number == "" {
+			body.WriteString("<invoiceStatus>ABORTED</invoiceStatus><technicalValidationMessages>" +
+				"<validationResultCode>ERROR</validationResultCode><validationErrorCode>SCHEMA_VIOLATION</validationErrorCode>" +
+				"<message>Az invoiceData nem felel meg a sémának.</message></technicalValidationMessages>")
+		} else {
+			body.WriteString("<invoiceStatus>DONE</invoiceStatus>")
+		}
+		body.WriteString("<compressedContentIndicator>false</compressedContentIndicator></processingResult>")
+	}
+	body.WriteString("</processingResults>")
+	ok(w, "QueryTransactionStatusResponse", body.String())
+}
+
+// transactionID returns the ID of the nth transaction.
+func transactionID(n int) string {
+	return "TX" + strconv.Itoa(100000+n)
+}
+
+// ok writes a successful response.
+func ok(w http.ResponseWriter, name, body string) {
+	write(w, http.StatusOK, name, "OK", "", "", body)
+}
+
+// fail writes an error response.
+func fail(w http.ResponseWriter, code, message string) {
+	write(w, http.StatusBadRequest, "GeneralErrorResponse", "ERROR", code, message, "")
+}
+
+// write writes a response document.
+func write(w http.ResponseWriter, status int, name, funcCode, errorCode, message, body string) {
+	w.Header().Set("Content-Type", "application/xml;charset=UTF-8")
+	w.WriteHeader(status)
+	var result bytes.Buffer
+	result.WriteString("<common:funcCode>" + funcCode + "</common:funcCode>")
+	if errorCode != "" {
+		result.WriteString("<common:errorCode>" + errorCode + "</common:errorCode><common:message>")
+		xml.EscapeText(&result, []byte(message))
+		result.WriteString("</common:message>")
+	}
+	fmt.Fprintf(w, `%s<%s xmlns="%s" xmlns:common="%s"><common:header><common:requestId>RID0</common:requestId>`+
+		`<common:timestamp>%s</common:timestamp><common:requestVersion>3.0</common:requestVersion>`+
+		`<common:headerVersion>1.0</common:headerVersion></common:header><common:result>%s</common:result>%s</%s>`,
+		xml.Header, name, nav.NamespaceAPI, nav.NamespaceCommon,
+		time.Now().UTC().Format("2006-01-02T15:04:05.000Z"), result.String(), body, name)
+}
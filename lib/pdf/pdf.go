@@ -0,0 +1,192 @@
+// Package pdf writes simple PDF documents: text in the standard Helvetica
+// fonts, lines and rectangles on A4 pages.
+//
+// The fonts are not embedded. The text is encoded in WinAnsiEncoding, with
+// the Hungarian ő, ű, Ő and Ű in place of õ, û, Õ and Û, so Hungarian text
+// displays correctly. Positions are in millimetres from the top left corner
+// of the page.
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Page size of A4 in millimetres.
+const (
+	PageWidth  = 210.0
+	PageHeight = 297.0
+)
+
+// ptPerMM is the number of PDF points in a millimetre.
+const ptPerMM = 72 / 25.4
+
+// Font is one of the standard fonts.
+type Font int
+
+// Fonts.
+const (
+	Regular Font = iota // Helvetica
+	Bold                // Helvetica-Bold
+)
+
+// Document is a PDF document being built.
+type Document struct {
+	Title string
+	pages []*Page
+}
+
+// New returns an empty document.
+func New(title string) *Document {
+	return &Document{Title: title}
+}
+
+// AddPage appends an A4 portrait page to the document.
+func (d *Document) AddPage() *Page {
+	p := &Page{}
+	d.pages = append(d.pages, p)
+	return p
+}
+
+// Page is a page of a document.
+type Page struct {
+	content bytes.Buffer
+}
+
+// Text writes s with its baseline starting at x, y.
+func (p *Page) Text(x, y float64, font Font, size float64, s string) {
+	fmt.Fprintf(&p.content, "BT /F%d %.2f Tf %.2f %.2f Td (%s) Tj ET\n",
+		font+1, size, x*ptPerMM, (PageHeight-y)*ptPerMM, escape(encode(s)))
+}
+
+// TextRight writes s with its baseline ending at x, y.
+func (p *Page) TextRight(x, y float64, font Font, size float64, s string) {
+	p.Text(x-Width(font, size, s), y, font, size, s)
+}
+
+// Line draws a thin line from x1, y1 to x2, y2.
+func (p *Page) Line(x1, y1, x2, y2 float64) {
+	fmt.Fprintf(&p.content, "0.5 w %.2f %.2f m %.2f %.2f l S\n",
+		x1*ptPerMM, (PageHeight-y1)*ptPerMM, x2*ptPerMM, (PageHeight-y2)*ptPerMM)
+}
+
+// Rect draws the outline of a rectangle with its top left corner at x, y.
+func (p *Page) Rect(x, y, w, h float64) {
+	fmt.Fprintf(&p.content, "0.5 w %.2f %.2f %.2f %.2f re S\n",
+		x*ptPerMM, (PageHeight-y-h)*ptPerMM, w*ptPerMM, h*ptPerMM)
+}
+
+// Width returns the width of s in millimetres.
+func Width(font Font, size float64, s string) float64 {
+	widths := &helvetica
+	if font == Bold {
+		widths = &helveticaBold
+	}
+	var w int
+	for _, c := range encode(s) {
+		if c >= 32 {
+			w += int(widths[c-32])
+		}
+	}
+	return float64(w) * size / 1000 / ptPerMM
+}
+
+// Wrap breaks s into lines not wider than width millimetres, at spaces where
+// possible.
+func Wrap(font Font, size, width float64, s string) []string {
+	var lines []string
+	for _, para := range strings.Split(s, "\n") {
+		line := ""
+		for _, word := range strings.Fields(para) {
+			next := word
+			if line != "" {
+				next = line + " " + word
+			}
+			if Width(font, size, next) <= width || line == "" && !tooWide(font, size, width, word) {
+				line = next
+				continue
+			}
+			if line != "" {
+				lines = append(lines, line)
+			}
+			// Break words which do not fit a line on their own.
+			for tooWide(font, size, width, word) {
+				r := []rune(word)
+				n := len(r) - 1
+				for n > 1 && Width(font, size, string(r[:n])) > width {
+					n--
+				}
+				lines = append(lines, string(r[:n]))
+				word = string(r[n:])
+			}
+			line = word
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// tooWide reports whether word is wider than width.
+func tooWide(font Font, size, width float64, word string) bool {
+	return len([]rune(word)) > 1 && Width(font, size, word) > width
+}
+
+// WriteTo writes the document in PDF format.
+func (d *Document) WriteTo(w io.Writer) (int64, error) {
+	var buf bytes.Buffer
+	var offsets []int
+	obj := func(format string, args ...interface{}) {
+		offsets = append(offsets, buf.Len())
+		fmt.Fprintf(&buf, "%d 0 obj\n", len(offsets))
+		fmt.Fprintf(&buf, format, args...)
+		buf.WriteString("\nendobj\n")
+	}
+
+	buf.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+
+	// Objects 1-5 are fixed, then each page takes two: itself and its content.
+	const firstPage = 6
+	kids := make([]string, len(d.pages))
+	for i := range d.pages {
+		kids[i] = fmt.Sprintf("%d 0 R", firstPage+2*i)
+	}
+	obj("<< /Type /Catalog /Pages 2 0 R >>")
+	obj("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(d.pages))
+	obj("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding 5 0 R >>")
+	obj("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding 5 0 R >>")
+	obj("<< /Type /Encoding /BaseEncoding /WinAnsiEncoding /Differences [%s] >>", differences)
+	for i, p := range d.pages {
+		obj("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.2f %.2f] "+
+			"/Resources << /Font << /F1 3 0 R /F2 4 0 R >> >> /Contents %d 0 R >>",
+			PageWidth*ptPerMM, PageHeight*ptPerMM, firstPage+2*i+1)
+		obj("<< /Length %d >>\nstream\n%sendstream", p.content.Len(), p.content.Bytes())
+	}
+	info := len(offsets) + 1
+	obj("<< /Title (%s) /Producer (szamlazo) >>", escape(encode(d.Title)))
+
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R /Info %d 0 R >>\nstartxref\n%d\n%%%%EOF\n",
+		len(offsets)+1, info, xref)
+
+	n, err := w.Write(buf.Bytes())
+	return int64(n), err
+}
+
+// escape escapes the special characters of a PDF string literal.
+func escape(s []byte) []byte {
+	var buf bytes.Buffer
+	for _, c := range s {
+		switch c {
+		case '\\', '(', ')':
+			buf.WriteByte('\\')
+		}
+		buf.WriteByte(c)
+	}
+	return buf.Bytes()
+}
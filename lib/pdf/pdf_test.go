@@ -0,0 +1,79 @@
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// TestEncode checks the Hungarian letters and the unknown characters.
+func TestEncode(t *testing.T) {
+	got := encode("Árvíztűrő tükörfúrógép €5 ☺")
+	want := []byte("\xc1rv\xedzt\xfbr\xf5 t\xfck\xf6rf\xfar\xf3g\xe9p \x805 ?")
+	if !bytes.Equal(got, want) {
+		t.Errorf("got %q want %q", got, want)
+	}
+}
+
+// TestWrap checks that the wrapped lines fit.
+func TestWrap(t *testing.T) {
+	s := "Szoftverfejlesztési szolgáltatás a 2026. októberi megrendelés alapján " +
+		strings.Repeat("x", 80)
+	lines := Wrap(Regular, 9, 40, s)
+	if len(lines) < 3 {
+		t.Fatalf("not wrapped: %q", lines)
+	}
+	for _, line := range lines {
+		if w := Width(Regular, 9, line); w > 40 {
+			t.Errorf("%q is %.1f mm wide", line, w)
+		}
+	}
+	if got := strings.Join(lines, ""); strings.Replace(s, " ", "", -1) != strings.Replace(got, " ", "", -1) {
+		t.Errorf("text lost: %q", got)
+	}
+}
+
+// TestWriteTo checks the structure of the written document.
+func TestWriteTo(t *testing.T) {
+	d := New("Számla (teszt)")
+	p := d.AddPage()
+	p.Text(20, 20, Bold, 14, "Számla")
+	p.TextRight(190, 20, Regular, 10, "1 270,00")
+	p.Line(20, 25, 190, 25)
+	d.AddPage().Rect(20, 20, 50, 10)
+
+	var buf bytes.Buffer
+	if _, err := d.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.Bytes()
+	if !bytes.HasPrefix(out, []byte("%PDF-1.4\n")) || !bytes.HasSuffix(out, []byte("%%EOF\n")) {
+		t.Fatal("missing header or trailer")
+	}
+	if !bytes.Contains(out, []byte(`/Title (Sz`+"\xe1"+`mla \(teszt\))`)) {
+		t.Error("title not escaped")
+	}
+	if !bytes.Contains(out, []byte("/Count 2")) {
+		t.Error("wrong page count")
+	}
+
+	// Every offset of the cross-reference table points at its object.
+	m := regexp.MustCompile(`startxref\n(\d+)\n`).FindSubmatch(out)
+	if m == nil {
+		t.Fatal("no startxref")
+	}
+	xref, _ := strconv.Atoi(string(m[1]))
+	entries := regexp.MustCompile(`(\d{10}) 00000 n `).FindAllSubmatch(out[xref:], -1)
+	if len(entries) != 10 {
+		t.Fatalf("got %d objects want 10", len(entries))
+	}
+	for i, e := range entries {
+		off, _ := strconv.Atoi(string(e[1]))
+		if want := fmt.Sprintf("%d 0 obj\n", i+1); !bytes.HasPrefix(out[off:], []byte(want)) {
+			t.Errorf("object %d is not at %d", i+1, off)
+		}
+	}
+}
@@ -0,0 +1,270 @@
+// Package scheduler runs the periodic tasks of the application, like sending
+// the payment reminders, on cron-style schedules. Every server runs the
+// scheduler, but each task runs once at each of its times: the server
+// holding the PostgreSQL advisory lock of the task runs it, unless a run of
+// that time has already been recorded. The runs are kept as the history of
+// the tasks.
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"runtime/debug"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Defaults of the settings.
+const (
+	defaultTimeout = 30 * time.Minute
+)
+
+// Off is the schedule of the tasks not run.
+const Off = "off"
+
+// Info holds the settings of the scheduler. Zero values select the defaults.
+type Info struct {
+	Disabled  bool              `json:"Disabled"`  // Run no tasks on this server
+	Schedules map[string]string `json:"Schedules"` // Schedules by task replacing the defaults, like {"invoice.dunning": "0 9 * * 1-5"}, "off" to stop one
+	Timeout   int               `json:"Timeout"`   // Seconds a run may take before it is cancelled
+}
+
+// Task does the work of a task due at the time, and returns a summary of it
+// for the history, like "3 reminders queued". The context is cancelled when
+// the run takes too long or the scheduler is stopped.
+type Task func(ctx context.Context, at time.Time) (string, error)
+
+// Store records the runs of the tasks, see schedule.Service.
+type Store interface {
+	// Run calls fn and records its outcome as the run of the task at the
+	// time, unless another server is running the task or has run it for
+	// the time. It reports whether fn was called.
+	Run(task string, at time.Time, host string, fn func() (string, error)) (bool, error)
+}
+
+// Status describes a task of the scheduler.
+type Status struct {
+	Name     string
+	Schedule string
+	Next     time.Time // Zero when the task is off
+}
+
+// registered is a task with its default schedule.
+type registered struct {
+	spec string
+	task Task
+}
+
+var (
+	tasks      = make(map[string]registered)
+	tasksMutex sync.RWMutex
+
+	std      *Scheduler
+	stdMutex sync.RWMutex
+)
+
+// Register adds a task with its default schedule, see Parse. Register the
+// tasks before the scheduler starts, like the routes. It panics on a bad
+// schedule.
+func Register(name string, spec string, t Task) {
+	if _, err := Parse(spec); err != nil {
+		panic(err)
+	}
+	tasksMutex.Lock()
+	tasks[name] = registered{spec: spec, task: t}
+	tasksMutex.Unlock()
+}
+
+// entry is a task of a scheduler.
+type entry struct {
+	name     string
+	spec     string
+	schedule Schedule
+	task     Task
+
+	mu   sync.Mutex
+	next time.Time
+}
+
+// Scheduler runs the registered tasks.
+type Scheduler struct {
+	store   Store
+	host    string
+	timeout time.Duration
+	entries []*entry
+	now     func() time.Time
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	quit   chan struct{}
+	wg     sync.WaitGroup
+}
+
+// New returns a scheduler of the tasks registered, recording their runs in
+// store. Start it with Run. A bad schedule of the settings is logged, and the
+// default kept.
+func New(store Store, info Info) *Scheduler {
+	s := &Scheduler{
+		store:   store,
+		host:    host(),
+		timeout: time.Duration(info.Timeout) * time.Second,
+		now:     time.Now,
+		quit:    make(chan struct{}),
+	}
+	if s.timeout <= 0 {
+		s.timeout = defaultTimeout
+	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+
+	tasksMutex.RLock()
+	defer tasksMutex.RUnlock()
+	for name, r := range tasks {
+		e := &entry{name: name, spec: r.spec, task: r.task}
+		if spec, ok := info.Schedules[name]; ok {
+			if spec == Off || spec == "" {
+				e.spec = Off
+			} else if _, err := Parse(spec); err != nil {
+				log.Printf("scheduler: %s: %v, keeping %q", name, err, r.spec)
+			} else {
+				e.spec = spec
+			}
+		}
+		if e.spec != Off {
+			e.schedule, _ = Parse(e.spec)
+		}
+		s.entries = append(s.entries, e)
+	}
+	sort.Slice(s.entries, func(i, j int) bool { return s.entries[i].name < s.entries[j].name })
+	return s
+}
+
+// host names the server in the history.
+func host() string {
+	name, err := os.Hostname()
+	if err != nil {
+		name = "unknown"
+	}
+	return fmt.Sprintf("%s/%d", name, os.Getpid())
+}
+
+// Run starts waiting for the times of the tasks.
+func (s *Scheduler) Run() {
+	for _, e := range s.entries {
+		if e.schedule == nil {
+			continue
+		}
+		s.wg.Add(1)
+		go s.loop(e)
+	}
+}
+
+// Stop stops starting tasks and waits for the running ones to finish, at most
+// for timeout. The ones still running then are cancelled.
+func (s *Scheduler) Stop(timeout time.Duration) {
+	close(s.quit)
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		log.Println("scheduler: cancelling the running tasks")
+		s.cancel()
+		<-done
+	}
+	s.cancel()
+}
+
+// Tasks returns the tasks with their schedules, by name.
+func (s *Scheduler) Tasks() []Status {
+	result := make([]Status, 0, len(s.entries))
+	for _, e := range s.entries {
+		e.mu.Lock()
+		result = append(result, Status{Name: e.name, Schedule: e.spec, Next: e.next})
+		e.mu.Unlock()
+	}
+	return result
+}
+
+// loop runs a task at its times until the scheduler is stopped.
+func (s *Scheduler) loop(e *entry) {
+	defer s.wg.Done()
+	for {
+		next := e.schedule.Next(s.now())
+		if next.IsZero() {
+			return
+		}
+		e.mu.Lock()
+		e.next = next
+		e.mu.Unlock()
+
+		select {
+		case <-s.quit:
+			return
+		case <-time.After(next.Sub(s.now())):
+		}
+		s.run(e, next)
+	}
+}
+
+// run runs a task due at the time, unless another server does, turning a
+// panic into an error.
+func (s *Scheduler) run(e *entry, at time.Time) bool {
+	ctx, cancel := context.WithTimeout(s.ctx, s.timeout)
+	defer cancel()
+	ran, err := s.store.Run(e.name, at, s.host, func() (summary string, err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				log.Printf("scheduler: %s panicked: %v\n%s", e.name, r, debug.Stack())
+				err = fmt.Errorf("panic: %v", r)
+			}
+		}()
+		if summary, err = e.task(ctx, at); err != nil {
+			log.Printf("scheduler: %s failed: %v", e.name, err)
+		}
+		return summary, err
+	})
+	if err != nil {
+		log.Printf("scheduler: %s: %v", e.name, err)
+	}
+	return ran
+}
+
+// Start starts the scheduler of the application, unless it is disabled.
+func Start(store Store, info Info) {
+	if info.Disabled {
+		return
+	}
+	s := New(store, info)
+	stdMutex.Lock()
+	std = s
+	stdMutex.Unlock()
+	s.Run()
+}
+
+// Stop stops the scheduler of the application, see Scheduler.Stop.
+func Stop(timeout time.Duration) {
+	stdMutex.RLock()
+	s := std
+	stdMutex.RUnlock()
+	if s != nil {
+		s.Stop(timeout)
+	}
+}
+
+// Tasks returns the tasks of the scheduler of the application, none if it
+// is not running on this server.
+func Tasks() []Status {
+	stdMutex.RLock()
+	s := std
+	stdMutex.RUnlock()
+	if s == nil {
+		return nil
+	}
+	return s.Tasks()
+}
@@ -0,0 +1,171 @@
+package scheduler
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestNext checks the times of the schedules.
+func TestNext(t *testing.T) {
+	loc := time.UTC
+	at := func(s string) time.Time {
+		v, err := time.ParseInLocation("2006-01-02 15:04", s, loc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	for _, tc := range []struct {
+		spec, from, want string
+	}{
+		{"*/15 * * * *", "2026-10-17 10:07", "2026-10-17 10:15"},
+		{"*/15 * * * *", "2026-10-17 10:15", "2026-10-17 10:30"},
+		{"0 12 * * 1-5", "2026-10-16 12:00", "2026-10-19 12:00"}, // Friday to Monday
+		{"30 11 1,15 * *", "2026-10-02 00:00", "2026-10-15 11:30"},
+		{"0 0 31 * *", "2026-11-01 00:00", "2026-12-31 00:00"},
+		{"0 9 1 * 0", "2026-10-17 10:00", "2026-10-18 09:00"}, // Sunday, or the 1st
+		{"0 9 * * 7", "2026-10-17 10:00", "2026-10-18 09:00"},
+		{"5/20 8-9 * * *", "2026-10-17 08:30", "2026-10-17 08:45"},
+		{"@daily", "2026-10-17 10:00", "2026-10-18 00:00"},
+		{"@monthly", "2026-12-17 10:00", "2027-01-01 00:00"},
+		{"@every 1h", "2026-10-17 10:59", "2026-10-17 11:00"},
+	} {
+		s, err := Parse(tc.spec)
+		if err != nil {
+			t.Errorf("%s: %v", tc.spec, err)
+			continue
+		}
+		if got := s.Next(at(tc.from)); !got.Equal(at(tc.want)) {
+			t.Errorf("%s from %s: got %s, wanted %s", tc.spec, tc.from, got.Format("2006-01-02 15:04"), tc.want)
+		}
+	}
+
+	for _, spec := range []string{"", "* * * *", "60 * * * *", "* * 0 * *", "*/0 * * * *",
+		"5-1 * * * *", "0 0 30 2 *", "@every 1ms", "@every soon", "@often"} {
+		if _, err := Parse(spec); err == nil {
+			t.Errorf("%q is valid", spec)
+		}
+	}
+}
+
+// memStore records the runs in memory, and locks the tasks like the
+// advisory locks.
+type memStore struct {
+	mu      sync.Mutex
+	locked  map[string]bool
+	runs    map[string]string
+	outcome map[string]error
+}
+
+func newMemStore() *memStore {
+	return &memStore{locked: map[string]bool{}, runs: map[string]string{}, outcome: map[string]error{}}
+}
+
+func (s *memStore) Run(task string, at time.Time, host string, fn func() (string, error)) (bool, error) {
+	key := task + at.String()
+	s.mu.Lock()
+	if s.locked[task] || s.runs[key] != "" {
+		s.mu.Unlock()
+		return false, nil
+	}
+	s.locked[task] = true
+	s.runs[key] = host
+	s.mu.Unlock()
+
+	_, err := fn()
+
+	s.mu.Lock()
+	s.locked[task] = false
+	s.outcome[key] = err
+	s.mu.Unlock()
+	return true, nil
+}
+
+// TestRunOnce checks that a task runs once at a time of several servers.
+func TestRunOnce(t *testing.T) {
+	var mu sync.Mutex
+	calls := 0
+	Register("test.once", "@every 1h", func(ctx context.Context, at time.Time) (string, error) {
+		mu.Lock()
+		calls++
+		mu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+		return "done", nil
+	})
+	Register("test.panic", "@hourly", func(ctx context.Context, at time.Time) (string, error) {
+		panic("boom")
+	})
+	defer func() {
+		tasksMutex.Lock()
+		delete(tasks, "test.once")
+		delete(tasks, "test.panic")
+		tasksMutex.Unlock()
+	}()
+
+	store := newMemStore()
+	servers := []*Scheduler{New(store, Info{}), New(store, Info{}), New(store, Info{})}
+	at := time.Date(2026, 10, 17, 10, 0, 0, 0, time.UTC)
+	find := func(s *Scheduler, name string) *entry {
+		for _, e := range s.entries {
+			if e.name == name {
+				return e
+			}
+		}
+		t.Fatalf("no task %s", name)
+		return nil
+	}
+
+	var wg sync.WaitGroup
+	for _, s := range servers {
+		wg.Add(1)
+		go func(s *Scheduler) {
+			defer wg.Done()
+			s.run(find(s, "test.once"), at)
+		}(s)
+	}
+	wg.Wait()
+	if calls != 1 {
+		t.Errorf("ran %d times", calls)
+	}
+	if !servers[0].run(find(servers[0], "test.once"), at.Add(time.Hour)) || calls != 2 {
+		t.Errorf("the next time did not run, %d calls", calls)
+	}
+
+	if !servers[1].run(find(servers[1], "test.panic"), at) {
+		t.Fatal("the panicking task did not run")
+	}
+	if err := store.outcome["test.panic"+at.String()]; err == nil {
+		t.Errorf("panic recorded as %v", err)
+	}
+}
+
+// TestSettings checks the schedules of the settings.
+func TestSettings(t *testing.T) {
+	noop := func(ctx context.Context, at time.Time) (string, error) { return "", nil }
+	Register("test.a", "@hourly", noop)
+	Register("test.b", "@hourly", noop)
+	Register("test.c", "@hourly", noop)
+	defer func() {
+		tasksMutex.Lock()
+		delete(tasks, "test.a")
+		delete(tasks, "test.b")
+		delete(tasks, "test.c")
+		tasksMutex.Unlock()
+	}()
+
+	s := New(newMemStore(), Info{Schedules: map[string]string{
+		"test.a": "*/5 * * * *",
+		"test.b": Off,
+		"test.c": "every now and then",
+	}})
+	want := map[string]string{"test.a": "*/5 * * * *", "test.b": Off, "test.c": "@hourly"}
+	for _, st := range s.Tasks() {
+		if w, ok := want[st.Name]; ok && st.Schedule != w {
+			t.Errorf("%s: got %q, wanted %q", st.Name, st.Schedule, w)
+		}
+	}
+	s.Run()
+	s.Stop(time.Second)
+}
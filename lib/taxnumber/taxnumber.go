@@ -0,0 +1,107 @@
+// Package taxnumber validates Hungarian tax numbers (adószám) and EU VAT
+// numbers.
+package taxnumber
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Number is a Hungarian tax number in the 8-1-2 format.
+type Number struct {
+	Base    string // Taxpayer ID (törzsszám), the last digit is a check digit
+	VATCode string // VAT code (áfakód)
+	County  string // County code (megyekód)
+}
+
+// String returns the number in the usual 12345678-1-23 form.
+func (n Number) String() string {
+	return n.Base + "-" + n.VATCode + "-" + n.County
+}
+
+// weights are the checksum weights of the first seven digits of the base.
+var weights = [7]int{9, 7, 3, 1, 9, 7, 3}
+
+// ValidBase reports whether base is eight digits with a correct check digit.
+func ValidBase(base string) bool {
+	if len(base) != 8 || !digits(base) {
+		return false
+	}
+	sum := 0
+	for i, w := range weights {
+		sum += int(base[i]-'0') * w
+	}
+	return (10-sum%10)%10 == int(base[7]-'0')
+}
+
+// Parse checks a Hungarian tax number, with or without the dashes.
+func Parse(s string) (Number, error) {
+	s = strings.TrimSpace(s)
+	compact := strings.Replace(s, "-", "", -1)
+	if len(compact) != 11 || !digits(compact) {
+		return Number{}, fmt.Errorf("tax number %q is not in the 12345678-1-23 format", s)
+	}
+	n := Number{Base: compact[:8], VATCode: compact[8:9], County: compact[9:]}
+	if !ValidBase(n.Base) {
+		return n, fmt.Errorf("tax number %q has a wrong check digit", s)
+	}
+	if n.VATCode < "1" || n.VATCode > "5" {
+		return n, fmt.Errorf("tax number %q has an unknown VAT code %s", s, n.VATCode)
+	}
+	if !validCounty(n.County) {
+		return n, fmt.Errorf("tax number %q has an unknown county code %s", s, n.County)
+	}
+	return n, nil
+}
+
+// ParseGroup checks the tax number of a VAT group (csoportazonosító).
+func ParseGroup(s string) (Number, error) {
+	n, err := Parse(s)
+	if err == nil && n.VATCode != "5" {
+		err = fmt.Errorf("group ID %q must have VAT code 5", s)
+	}
+	return n, err
+}
+
+// ParseEU checks the form of an EU VAT number and returns it without spaces
+// and in upper case. Hungarian ones are checked as tax number bases too.
+func ParseEU(s string) (string, error) {
+	compact := strings.ToUpper(strings.Replace(strings.TrimSpace(s), " ", "", -1))
+	if len(compact) < 4 || len(compact) > 14 {
+		return compact, fmt.Errorf("EU VAT number %q has a wrong length", s)
+	}
+	country := compact[:2]
+	if country[0] < 'A' || country[0] > 'Z' || country[1] < 'A' || country[1] > 'Z' {
+		return compact, fmt.Errorf("EU VAT number %q does not start with a country code", s)
+	}
+	for _, r := range compact[2:] {
+		if !(r >= '0' && r <= '9' || r >= 'A' && r <= 'Z') {
+			return compact, fmt.Errorf("EU VAT number %q has invalid characters", s)
+		}
+	}
+	if country == "HU" && !ValidBase(compact[2:]) {
+		return compact, fmt.Errorf("EU VAT number %q is not a valid Hungarian one", s)
+	}
+	return compact, nil
+}
+
+// validCounty reports whether c is a county or directorate code in use.
+func validCounty(c string) bool {
+	switch {
+	case c >= "02" && c <= "20":
+		return true
+	case c == "22", c >= "41" && c <= "44", c == "51":
+		return true
+	}
+	return false
+}
+
+// digits reports whether s consists of decimal digits only.
+func digits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
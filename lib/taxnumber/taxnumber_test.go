@@ -0,0 +1,55 @@
+package taxnumber_test
+
+import (
+	"testing"
+
+	"github.com/UNO-SOFT/szamlazo/lib/taxnumber"
+)
+
+// TestParse checks the format and the checksum of tax numbers.
+func TestParse(t *testing.T) {
+	for _, tc := range []struct {
+		in, want string
+		ok       bool
+	}{
+		{"15789934-2-51", "15789934-2-51", true},
+		{"10773381244", "10773381-2-44", true},
+		{" 12345676-1-13 ", "12345676-1-13", true},
+		{"12345678-1-13", "", false}, // check digit
+		{"15789934-6-51", "", false}, // VAT code
+		{"15789934-2-21", "", false}, // county
+		{"15789934-2-5", "", false},  // length
+		{"1578993A-2-51", "", false}, // letters
+	} {
+		n, err := taxnumber.Parse(tc.in)
+		if tc.ok != (err == nil) {
+			t.Errorf("%q: got error %v", tc.in, err)
+		} else if tc.ok && n.String() != tc.want {
+			t.Errorf("%q: got %q want %q", tc.in, n, tc.want)
+		}
+	}
+
+	if _, err := taxnumber.ParseGroup("24765129-5-44"); err != nil {
+		t.Error("group ID rejected:", err)
+	}
+	if _, err := taxnumber.ParseGroup("24765129-4-44"); err == nil {
+		t.Error("group member tax number accepted as group ID")
+	}
+}
+
+// TestParseEU checks the EU VAT numbers.
+func TestParseEU(t *testing.T) {
+	for in, ok := range map[string]bool{
+		"HU15789934":       true,
+		"hu 1578 9934":     true,
+		"HU15789935":       false,
+		"DE123456789":      true,
+		"ATU12345678":      true,
+		"123456789":        false,
+		"DE12345678901234": false,
+	} {
+		if _, err := taxnumber.ParseEU(in); ok != (err == nil) {
+			t.Errorf("%q: got error %v", in, err)
+		}
+	}
+}
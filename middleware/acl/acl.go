@@ -1,11 +1,16 @@
 // Package acl provides http.Handlers to prevent access to pages for
-// authenticated users and for non-authenticated users.
+// authenticated users, for non-authenticated users, for users without an
+// active company, and for users whose role lacks a permission.
 package acl
 
 import (
 	"net/http"
+	"net/url"
+	"strings"
 
 	"github.com/UNO-SOFT/szamlazo/lib/flight"
+	"github.com/UNO-SOFT/szamlazo/model"
+	"github.com/UNO-SOFT/szamlazo/model/role"
 )
 
 // DisallowAuth does not allow authenticated users to access the page.
@@ -37,3 +42,75 @@ func DisallowAnon(h http.Handler) http.Handler {
 		h.ServeHTTP(w, r)
 	})
 }
+
+// RequireCompany does not allow users without an active company to access
+// the page, and sends them to pick one. The membership of the user in the
+// company is checked on every request, so a user taken off a company loses
+// access at once.
+func RequireCompany(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := member(w, r); !ok {
+			return
+		}
+
+		h.ServeHTTP(w, r)
+	})
+}
+
+// Require does not allow users to access the page unless their role in the
+// active company has the permission, like "invoice.issue". It checks the
+// membership like RequireCompany, so it need not be chained with it.
+func Require(permission string) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			role, ok := member(w, r)
+			if !ok {
+				return
+			}
+			if !role.Can(permission) {
+				c := flight.Context(w, r)
+				c.FlashWarning("You are not allowed to do that.")
+				http.Redirect(w, r, back(r), http.StatusFound)
+				return
+			}
+
+			h.ServeHTTP(w, r)
+		})
+	}
+}
+
+// member gets the role of the user in the active company. Users without one
+// are redirected and ok is false.
+func member(w http.ResponseWriter, r *http.Request) (role.Item, bool) {
+	c := flight.Context(w, r)
+
+	if c.Sess.Values["company_id"] == nil {
+		c.FlashNotice("Pick a company to work for.")
+		http.Redirect(w, r, "/company", http.StatusFound)
+		return role.Item{}, false
+	}
+	result, noRows, err := model.Role.Member(c.CompanyID, c.UserID)
+	if noRows {
+		delete(c.Sess.Values, "company_id")
+		delete(c.Sess.Values, "company_name")
+		c.FlashWarning("You are not a member of the company any more.")
+		http.Redirect(w, r, "/company", http.StatusFound)
+		return result, false
+	} else if err != nil {
+		c.FlashError(err)
+		http.Redirect(w, r, "/", http.StatusFound)
+		return result, false
+	}
+	return result, true
+}
+
+// back returns the path of the page the request came from, the home page if
+// it is unknown. Only the path is kept so a forged referrer cannot send the
+// user to another site.
+func back(r *http.Request) string {
+	if u, err := url.Parse(r.Referer()); err == nil && strings.HasPrefix(u.Path, "/") &&
+		!strings.HasPrefix(u.Path, "//") && u.Path != r.URL.Path {
+		return u.Path
+	}
+	return "/"
+}
@@ -0,0 +1,75 @@
+// Package bearer provides an http.Handler that authenticates the requests of
+// machine clients by the API token in their "Authorization: Bearer" header,
+// as the session authenticates the requests of browsers.
+package bearer
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/UNO-SOFT/szamlazo/lib/flight"
+	"github.com/UNO-SOFT/szamlazo/model"
+)
+
+// scheme starts the Authorization header of a token.
+const scheme = "Bearer "
+
+// Token returns the API token sent with the request, "" if there is none.
+func Token(r *http.Request) string {
+	h := r.Header.Get("Authorization")
+	if len(h) <= len(scheme) || !strings.EqualFold(h[:len(scheme)], scheme) {
+		return ""
+	}
+	return strings.TrimSpace(h[len(scheme):])
+}
+
+// Handler authenticates the requests with a token as its user, for the
+// company it was created for. Requests without a token are left to the
+// session. Unknown, revoked and expired tokens are refused, and so are
+// requests the scopes of the token do not allow.
+func Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t := Token(r)
+		if t == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		item, noRows, err := model.Token.ByToken(t)
+		if noRows {
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			refuse(w, http.StatusUnauthorized, "invalid or expired token")
+			return
+		} else if err != nil {
+			log.Println(err)
+			refuse(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
+		if !item.Allows(r.Method) {
+			w.Header().Set("WWW-Authenticate", `Bearer error="insufficient_scope"`)
+			refuse(w, http.StatusForbidden, fmt.Sprintf("the token has the %q scopes only", item.Scopes))
+			return
+		}
+		if err := model.Token.Touch(item.ID); err != nil {
+			log.Println(err)
+		}
+
+		b := flight.Bearer{UserID: fmt.Sprint(item.UserID)}
+		if item.CompanyID.Valid {
+			b.CompanyID = fmt.Sprint(item.CompanyID.Int64)
+		}
+		next.ServeHTTP(w, flight.WithBearer(r, b))
+	})
+}
+
+// refuse sends the error as JSON, like the API does.
+func refuse(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(struct {
+		Error string `json:"error"`
+	}{message})
+}
@@ -0,0 +1,28 @@
+package bearer_test
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/UNO-SOFT/szamlazo/middleware/bearer"
+)
+
+// TestToken checks the parsing of the Authorization header.
+func TestToken(t *testing.T) {
+	for header, want := range map[string]string{
+		"":                   "",
+		"Bearer szt_abc":     "szt_abc",
+		"bearer  szt_abc ":   "szt_abc",
+		"Bearer ":            "",
+		"Basic dXNlcjpwYXNz": "",
+		"Bearerszt_abc":      "",
+	} {
+		r := httptest.NewRequest("GET", "/api/v1/invoices", nil)
+		if header != "" {
+			r.Header.Set("Authorization", header)
+		}
+		if got := bearer.Token(r); got != want {
+			t.Errorf("%q: got %q want %q", header, got, want)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+// Package requestid provides an http.Handler that gives every request an ID,
+// so the log lines and audit log entries of a request can be found together.
+package requestid
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+
+	"github.com/UNO-SOFT/szamlazo/lib/flight"
+)
+
+// header carries the ID, from the proxy and back to the client.
+const header = "X-Request-ID"
+
+// maxLength is the length of the longest ID accepted from a proxy.
+const maxLength = 64
+
+// Handler keeps the ID a proxy sent if it is sane, makes one up otherwise,
+// and sends it back in the response.
+func Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ID := r.Header.Get(header)
+		if !Valid(ID) {
+			ID = generate()
+		}
+		w.Header().Set(header, ID)
+		next.ServeHTTP(w, flight.WithRequestID(r, ID))
+	})
+}
+
+// Valid reports whether the ID is short and made of letters, digits, dots,
+// dashes and underscores only.
+func Valid(ID string) bool {
+	if ID == "" || len(ID) > maxLength {
+		return false
+	}
+	for _, c := range ID {
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9',
+			c == '.', c == '-', c == '_':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// generate returns a random ID.
+func generate() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
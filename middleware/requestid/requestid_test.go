@@ -0,0 +1,49 @@
+package requestid_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/UNO-SOFT/szamlazo/middleware/requestid"
+)
+
+// TestValid checks which IDs sent by a proxy are kept.
+func TestValid(t *testing.T) {
+	for ID, want := range map[string]bool{
+		"":                                     false,
+		"5f2b6c1e-9a3d-4e7b-8c11-0d2e3f4a5b6c": true,
+		"req_42.1":                             true,
+		"a b":                                  false,
+		"<script>":                             false,
+		"árvíztűrő":                            false,
+		strings.Repeat("a", 64):                true,
+		strings.Repeat("a", 65):                false,
+	} {
+		if got := requestid.Valid(ID); got != want {
+			t.Errorf("%q: got %t want %t", ID, got, want)
+		}
+	}
+}
+
+// TestHandler checks that the ID is sent back, made up when missing.
+func TestHandler(t *testing.T) {
+	h := requestid.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("X-Request-ID", "abc-1")
+	h.ServeHTTP(w, r)
+	if got := w.Header().Get("X-Request-ID"); got != "abc-1" {
+		t.Errorf("got %q want abc-1", got)
+	}
+
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("X-Request-ID", "bad id")
+	h.ServeHTTP(w, r)
+	if got := w.Header().Get("X-Request-ID"); len(got) != 32 {
+		t.Errorf("got %q, want a new ID", got)
+	}
+}
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS invoice_line CASCADE;
+DROP TABLE IF EXISTS invoice CASCADE;
@@ -0,0 +1,50 @@
+CREATE TABLE invoice (
+    id SERIAL,
+
+    number VARCHAR(50) NULL DEFAULT NULL,
+
+    seller_name VARCHAR(200) NOT NULL,
+    seller_address VARCHAR(300) NOT NULL,
+    seller_tax_number VARCHAR(20) NOT NULL,
+
+    buyer_name VARCHAR(200) NOT NULL,
+    buyer_address VARCHAR(300) NOT NULL,
+    buyer_tax_number VARCHAR(20) NOT NULL DEFAULT '',
+
+    issue_date DATE NOT NULL,
+    fulfilment_date DATE NOT NULL,
+    due_date DATE NOT NULL,
+    currency CHAR(3) NOT NULL DEFAULT 'HUF',
+    payment_method VARCHAR(20) NOT NULL,
+
+    user_id integer NOT NULL,
+
+    created_at TIMESTAMP NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NULL DEFAULT NULL,
+    deleted_at TIMESTAMP NULL DEFAULT NULL,
+
+    CONSTRAINT f_invoice_user FOREIGN KEY (user_id) REFERENCES "user" (id) ON DELETE CASCADE ON UPDATE CASCADE,
+
+    PRIMARY KEY (id)
+);
+
+CREATE TABLE invoice_line (
+    id SERIAL,
+
+    invoice_id integer NOT NULL,
+    line_number integer NOT NULL,
+
+    description TEXT NOT NULL,
+    quantity NUMERIC(18,6) NOT NULL,
+    unit VARCHAR(20) NOT NULL,
+    unit_price NUMERIC(18,6) NOT NULL,
+    vat_rate VARCHAR(10) NOT NULL,
+
+    created_at TIMESTAMP NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NULL DEFAULT NULL,
+
+    UNIQUE (invoice_id, line_number),
+    CONSTRAINT f_invoice_line_invoice FOREIGN KEY (invoice_id) REFERENCES invoice (id) ON DELETE CASCADE ON UPDATE CASCADE,
+
+    PRIMARY KEY (id)
+);
@@ -0,0 +1,4 @@
+ALTER TABLE invoice DROP CONSTRAINT IF EXISTS u_invoice_series_number;
+ALTER TABLE invoice DROP CONSTRAINT IF EXISTS f_invoice_series;
+ALTER TABLE invoice DROP COLUMN IF EXISTS series_id;
+DROP TABLE IF EXISTS invoice_series CASCADE;
@@ -0,0 +1,28 @@
+CREATE TABLE invoice_series (
+    id SERIAL,
+
+    code VARCHAR(20) NOT NULL,
+    prefix VARCHAR(20) NOT NULL DEFAULT '',
+    year_reset BOOLEAN NOT NULL DEFAULT TRUE,
+    padding SMALLINT NOT NULL DEFAULT 5,
+
+    year integer NOT NULL DEFAULT 0,
+    last_number integer NOT NULL DEFAULT 0,
+
+    created_at TIMESTAMP NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NULL DEFAULT NULL,
+    deleted_at TIMESTAMP NULL DEFAULT NULL,
+
+    UNIQUE (code),
+    CHECK (padding BETWEEN 0 AND 12),
+
+    PRIMARY KEY (id)
+);
+
+INSERT INTO invoice_series (code, prefix, year_reset, padding) VALUES
+('default', 'SZ', TRUE, 5);
+
+ALTER TABLE invoice ADD COLUMN series_id integer NOT NULL DEFAULT 1;
+ALTER TABLE invoice ALTER COLUMN series_id DROP DEFAULT;
+ALTER TABLE invoice ADD CONSTRAINT f_invoice_series FOREIGN KEY (series_id) REFERENCES invoice_series (id) ON UPDATE CASCADE;
+ALTER TABLE invoice ADD CONSTRAINT u_invoice_series_number UNIQUE (series_id, number);
@@ -0,0 +1,4 @@
+ALTER TABLE invoice DROP CONSTRAINT IF EXISTS f_invoice_partner;
+ALTER TABLE invoice DROP COLUMN IF EXISTS partner_id;
+DROP TABLE IF EXISTS partner_bank_account CASCADE;
+DROP TABLE IF EXISTS partner CASCADE;
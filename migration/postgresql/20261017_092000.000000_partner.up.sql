@@ -0,0 +1,40 @@
+CREATE TABLE partner (
+    id SERIAL,
+
+    name VARCHAR(200) NOT NULL,
+
+    country_code CHAR(2) NOT NULL DEFAULT 'HU',
+    postal_code VARCHAR(10) NOT NULL DEFAULT '',
+    city VARCHAR(100) NOT NULL DEFAULT '',
+    street VARCHAR(200) NOT NULL DEFAULT '',
+
+    tax_number VARCHAR(13) NOT NULL DEFAULT '',
+    eu_vat_number VARCHAR(14) NOT NULL DEFAULT '',
+    group_id VARCHAR(13) NOT NULL DEFAULT '',
+
+    user_id integer NOT NULL,
+
+    created_at TIMESTAMP NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NULL DEFAULT NULL,
+    deleted_at TIMESTAMP NULL DEFAULT NULL,
+
+    CONSTRAINT f_partner_user FOREIGN KEY (user_id) REFERENCES "user" (id) ON DELETE CASCADE ON UPDATE CASCADE,
+
+    PRIMARY KEY (id)
+);
+
+CREATE TABLE partner_bank_account (
+    id SERIAL,
+
+    partner_id integer NOT NULL,
+    account_number VARCHAR(34) NOT NULL,
+
+    created_at TIMESTAMP NULL DEFAULT CURRENT_TIMESTAMP,
+
+    CONSTRAINT f_partner_bank_account_partner FOREIGN KEY (partner_id) REFERENCES partner (id) ON DELETE CASCADE ON UPDATE CASCADE,
+
+    PRIMARY KEY (id)
+);
+
+ALTER TABLE invoice ADD COLUMN partner_id integer NULL DEFAULT NULL;
+ALTER TABLE invoice ADD CONSTRAINT f_invoice_partner FOREIGN KEY (partner_id) REFERENCES partner (id) ON DELETE SET NULL ON UPDATE CASCADE;
@@ -0,0 +1,5 @@
+ALTER TABLE invoice_line DROP CONSTRAINT IF EXISTS f_invoice_line_product;
+ALTER TABLE invoice_line DROP COLUMN IF EXISTS code;
+ALTER TABLE invoice_line DROP COLUMN IF EXISTS code_type;
+ALTER TABLE invoice_line DROP COLUMN IF EXISTS product_id;
+DROP TABLE IF EXISTS product CASCADE;
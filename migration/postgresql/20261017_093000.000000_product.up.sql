@@ -0,0 +1,29 @@
+CREATE TABLE product (
+    id SERIAL,
+
+    sku VARCHAR(50) NOT NULL,
+    name VARCHAR(200) NOT NULL,
+    unit VARCHAR(20) NOT NULL,
+    unit_price NUMERIC(18,6) NOT NULL DEFAULT 0,
+    vat_rate VARCHAR(10) NOT NULL DEFAULT '27',
+
+    code_type VARCHAR(10) NOT NULL DEFAULT '',
+    code VARCHAR(20) NOT NULL DEFAULT '',
+
+    user_id integer NOT NULL,
+
+    created_at TIMESTAMP NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NULL DEFAULT NULL,
+    deleted_at TIMESTAMP NULL DEFAULT NULL,
+
+    CONSTRAINT f_product_user FOREIGN KEY (user_id) REFERENCES "user" (id) ON DELETE CASCADE ON UPDATE CASCADE,
+
+    PRIMARY KEY (id)
+);
+
+CREATE UNIQUE INDEX u_product_sku ON product (user_id, sku) WHERE deleted_at IS NULL;
+
+ALTER TABLE invoice_line ADD COLUMN product_id integer NULL DEFAULT NULL;
+ALTER TABLE invoice_line ADD COLUMN code_type VARCHAR(10) NOT NULL DEFAULT '';
+ALTER TABLE invoice_line ADD COLUMN code VARCHAR(20) NOT NULL DEFAULT '';
+ALTER TABLE invoice_line ADD CONSTRAINT f_invoice_line_product FOREIGN KEY (product_id) REFERENCES product (id) ON DELETE SET NULL ON UPDATE CASCADE;
@@ -0,0 +1 @@
+ALTER TABLE invoice DROP COLUMN IF EXISTS rounding;
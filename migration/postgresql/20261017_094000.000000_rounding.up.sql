@@ -0,0 +1 @@
+ALTER TABLE invoice ADD COLUMN rounding VARCHAR(10) NOT NULL DEFAULT 'line';
@@ -0,0 +1 @@
+ALTER TABLE invoice ADD COLUMN print_count integer NOT NULL DEFAULT 0;
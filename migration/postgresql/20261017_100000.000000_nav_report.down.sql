@@ -0,0 +1,3 @@
+ALTER TABLE invoice DROP COLUMN IF EXISTS nav_message;
+ALTER TABLE invoice DROP COLUMN IF EXISTS nav_status;
+ALTER TABLE invoice DROP COLUMN IF EXISTS nav_transaction_id;
@@ -0,0 +1,3 @@
+ALTER TABLE invoice ADD COLUMN nav_transaction_id VARCHAR(30) NULL DEFAULT NULL;
+ALTER TABLE invoice ADD COLUMN nav_status VARCHAR(20) NOT NULL DEFAULT '';
+ALTER TABLE invoice ADD COLUMN nav_message TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,21 @@
+CREATE TABLE job (
+    id SERIAL,
+
+    kind VARCHAR(50) NOT NULL,
+    payload BYTEA NOT NULL,
+    status VARCHAR(10) NOT NULL DEFAULT 'pending',
+    attempts integer NOT NULL DEFAULT 0,
+    max_attempts integer NOT NULL DEFAULT 8,
+    run_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    locked_at TIMESTAMP NULL DEFAULT NULL,
+    last_error TEXT NOT NULL DEFAULT '',
+
+    created_at TIMESTAMP NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NULL DEFAULT NULL,
+
+    CHECK (status IN ('pending', 'running', 'done', 'dead')),
+
+    PRIMARY KEY (id)
+);
+
+CREATE INDEX i_job_due ON job (run_at, id) WHERE status IN ('pending', 'running');
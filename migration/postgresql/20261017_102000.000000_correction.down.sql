@@ -0,0 +1,14 @@
+ALTER TABLE invoice_line DROP COLUMN IF EXISTS line_number_reference;
+
+ALTER TABLE invoice DROP CONSTRAINT IF EXISTS c_invoice_kind;
+ALTER TABLE invoice DROP CONSTRAINT IF EXISTS u_invoice_modification;
+ALTER TABLE invoice DROP CONSTRAINT IF EXISTS f_invoice_original;
+ALTER TABLE invoice DROP COLUMN IF EXISTS modification_index;
+ALTER TABLE invoice DROP COLUMN IF EXISTS original_number;
+ALTER TABLE invoice DROP COLUMN IF EXISTS original_id;
+ALTER TABLE invoice DROP COLUMN IF EXISTS kind;
+
+UPDATE invoice_series SET correction_series_id = NULL;
+DELETE FROM invoice_series WHERE code = 'correction';
+ALTER TABLE invoice_series DROP CONSTRAINT IF EXISTS f_invoice_series_correction;
+ALTER TABLE invoice_series DROP COLUMN IF EXISTS correction_series_id;
@@ -0,0 +1,20 @@
+ALTER TABLE invoice_series ADD COLUMN correction_series_id integer NULL DEFAULT NULL;
+ALTER TABLE invoice_series ADD CONSTRAINT f_invoice_series_correction FOREIGN KEY (correction_series_id) REFERENCES invoice_series (id) ON UPDATE CASCADE;
+
+INSERT INTO invoice_series (code, prefix, year_reset, padding) VALUES
+('correction', 'SZH', TRUE, 5);
+UPDATE invoice_series SET correction_series_id = (SELECT id FROM invoice_series WHERE code = 'correction')
+WHERE code = 'default';
+
+ALTER TABLE invoice ADD COLUMN kind VARCHAR(20) NOT NULL DEFAULT 'normal';
+ALTER TABLE invoice ADD COLUMN original_id integer NULL DEFAULT NULL;
+ALTER TABLE invoice ADD COLUMN original_number VARCHAR(50) NULL DEFAULT NULL;
+ALTER TABLE invoice ADD COLUMN modification_index integer NULL DEFAULT NULL;
+ALTER TABLE invoice ADD CONSTRAINT f_invoice_original FOREIGN KEY (original_id) REFERENCES invoice (id) ON UPDATE CASCADE;
+ALTER TABLE invoice ADD CONSTRAINT u_invoice_modification UNIQUE (original_id, modification_index);
+ALTER TABLE invoice ADD CONSTRAINT c_invoice_kind CHECK (
+    kind = 'normal' AND original_id IS NULL
+    OR kind IN ('storno', 'modification') AND original_id IS NOT NULL AND modification_index IS NOT NULL
+);
+
+ALTER TABLE invoice_line ADD COLUMN line_number_reference integer NULL DEFAULT NULL;
@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS invoice_transition CASCADE;
+
+ALTER TABLE invoice DROP CONSTRAINT IF EXISTS c_invoice_status;
+ALTER TABLE invoice DROP COLUMN IF EXISTS status;
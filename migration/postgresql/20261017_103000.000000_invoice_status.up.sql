@@ -0,0 +1,27 @@
+-- The invoices so far were numbered when they were stored
+ALTER TABLE invoice ADD COLUMN status VARCHAR(20) NOT NULL DEFAULT 'issued';
+ALTER TABLE invoice ALTER COLUMN status SET DEFAULT 'draft';
+ALTER TABLE invoice ADD CONSTRAINT c_invoice_status CHECK (
+    status IN ('draft', 'issued', 'sent', 'partially_paid', 'paid', 'overdue', 'cancelled')
+    AND (status = 'draft') = (number IS NULL)
+);
+
+CREATE TABLE invoice_transition (
+    id SERIAL,
+
+    invoice_id integer NOT NULL,
+    from_status VARCHAR(20) NULL DEFAULT NULL,
+    to_status VARCHAR(20) NOT NULL,
+    note TEXT NOT NULL DEFAULT '',
+
+    user_id integer NULL DEFAULT NULL,
+
+    created_at TIMESTAMP NULL DEFAULT CURRENT_TIMESTAMP,
+
+    CONSTRAINT f_invoice_transition_invoice FOREIGN KEY (invoice_id) REFERENCES invoice (id) ON DELETE CASCADE ON UPDATE CASCADE,
+    CONSTRAINT f_invoice_transition_user FOREIGN KEY (user_id) REFERENCES "user" (id) ON DELETE SET NULL ON UPDATE CASCADE,
+
+    PRIMARY KEY (id)
+);
+
+CREATE INDEX i_invoice_transition_invoice ON invoice_transition (invoice_id, id);
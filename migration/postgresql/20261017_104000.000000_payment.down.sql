@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS payment_allocation CASCADE;
+DROP TABLE IF EXISTS payment CASCADE;
+
+ALTER TABLE invoice DROP COLUMN IF EXISTS gross_total;
@@ -0,0 +1,65 @@
+-- The gross total is kept with the invoice, so that the balances can be
+-- summed in the database
+ALTER TABLE invoice ADD COLUMN gross_total NUMERIC(18,4) NOT NULL DEFAULT 0;
+
+-- Computed like invoice.Item.Totals for the invoices so far
+WITH line AS (
+    SELECT l.invoice_id, l.vat_rate, i.rounding,
+        ROUND(l.quantity * l.unit_price, CASE WHEN i.currency IN ('HUF', 'JPY') THEN 0 ELSE 2 END) AS net,
+        CASE l.vat_rate WHEN '27' THEN 0.27 WHEN '18' THEN 0.18 WHEN '5' THEN 0.05 ELSE 0 END AS pct,
+        CASE WHEN i.currency IN ('HUF', 'JPY') THEN 0 ELSE 2 END AS places
+    FROM invoice_line l
+    JOIN invoice i ON i.id = l.invoice_id
+), rate AS (
+    SELECT invoice_id,
+        SUM(net) + CASE WHEN MIN(rounding) = 'rate'
+            THEN ROUND(SUM(net) * MIN(pct), MIN(places))
+            ELSE SUM(ROUND(net * pct, places)) END AS gross
+    FROM line
+    GROUP BY invoice_id, vat_rate
+)
+UPDATE invoice i
+SET gross_total = t.gross
+FROM (SELECT invoice_id, SUM(gross) AS gross FROM rate GROUP BY invoice_id) t
+WHERE t.invoice_id = i.id;
+
+CREATE TABLE payment (
+    id SERIAL,
+
+    partner_id integer NULL DEFAULT NULL,
+    payer_name VARCHAR(200) NOT NULL DEFAULT '',
+
+    payment_date DATE NOT NULL,
+    amount NUMERIC(18,4) NOT NULL,
+    currency CHAR(3) NOT NULL DEFAULT 'HUF',
+    method VARCHAR(20) NOT NULL,
+    bank_reference VARCHAR(100) NOT NULL DEFAULT '',
+
+    user_id integer NOT NULL,
+
+    created_at TIMESTAMP NULL DEFAULT CURRENT_TIMESTAMP,
+
+    CONSTRAINT c_payment_amount CHECK (amount > 0),
+    CONSTRAINT f_payment_partner FOREIGN KEY (partner_id) REFERENCES partner (id) ON DELETE SET NULL ON UPDATE CASCADE,
+    CONSTRAINT f_payment_user FOREIGN KEY (user_id) REFERENCES "user" (id) ON DELETE CASCADE ON UPDATE CASCADE,
+
+    PRIMARY KEY (id)
+);
+
+CREATE TABLE payment_allocation (
+    id SERIAL,
+
+    payment_id integer NOT NULL,
+    invoice_id integer NOT NULL,
+    amount NUMERIC(18,4) NOT NULL,
+
+    created_at TIMESTAMP NULL DEFAULT CURRENT_TIMESTAMP,
+
+    CONSTRAINT c_payment_allocation_amount CHECK (amount > 0),
+    CONSTRAINT f_payment_allocation_payment FOREIGN KEY (payment_id) REFERENCES payment (id) ON DELETE CASCADE ON UPDATE CASCADE,
+    CONSTRAINT f_payment_allocation_invoice FOREIGN KEY (invoice_id) REFERENCES invoice (id) ON DELETE CASCADE ON UPDATE CASCADE,
+
+    PRIMARY KEY (id)
+);
+
+CREATE INDEX i_payment_allocation_invoice ON payment_allocation (invoice_id);
@@ -0,0 +1,47 @@
+CREATE TABLE bank_statement (
+    id SERIAL,
+
+    file_name VARCHAR(255) NOT NULL,
+    path VARCHAR(255) NOT NULL,
+    sha256 CHAR(64) NOT NULL,
+    format VARCHAR(10) NOT NULL,
+    account VARCHAR(34) NOT NULL DEFAULT '',
+
+    user_id integer NOT NULL,
+
+    created_at TIMESTAMP NULL DEFAULT CURRENT_TIMESTAMP,
+
+    UNIQUE (user_id, sha256),
+    CONSTRAINT f_bank_statement_user FOREIGN KEY (user_id) REFERENCES "user" (id) ON DELETE CASCADE ON UPDATE CASCADE,
+
+    PRIMARY KEY (id)
+);
+
+CREATE TABLE bank_transaction (
+    id SERIAL,
+
+    statement_id integer NOT NULL,
+
+    booking_date DATE NOT NULL,
+    amount NUMERIC(18,4) NOT NULL,
+    currency CHAR(3) NOT NULL,
+    reference VARCHAR(100) NOT NULL DEFAULT '',
+    remittance TEXT NOT NULL DEFAULT '',
+    counterparty_name VARCHAR(200) NOT NULL DEFAULT '',
+    counterparty_account VARCHAR(34) NOT NULL DEFAULT '',
+
+    status VARCHAR(10) NOT NULL,
+    match_rule VARCHAR(20) NOT NULL DEFAULT '',
+    payment_id integer NULL DEFAULT NULL,
+
+    created_at TIMESTAMP NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NULL DEFAULT NULL,
+
+    CONSTRAINT c_bank_transaction_status CHECK (status IN ('matched', 'review', 'ignored')),
+    CONSTRAINT f_bank_transaction_statement FOREIGN KEY (statement_id) REFERENCES bank_statement (id) ON DELETE CASCADE ON UPDATE CASCADE,
+    CONSTRAINT f_bank_transaction_payment FOREIGN KEY (payment_id) REFERENCES payment (id) ON DELETE SET NULL ON UPDATE CASCADE,
+
+    PRIMARY KEY (id)
+);
+
+CREATE INDEX i_bank_transaction_review ON bank_transaction (status) WHERE status = 'review';
@@ -0,0 +1,21 @@
+-- Official MNB exchange rates: the price of a unit of the currency in forints
+CREATE TABLE exchange_rate (
+    id SERIAL,
+
+    currency CHAR(3) NOT NULL,
+    rate_date DATE NOT NULL,
+    rate NUMERIC(18,6) NOT NULL,
+
+    created_at TIMESTAMP NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NULL DEFAULT CURRENT_TIMESTAMP,
+
+    CONSTRAINT c_exchange_rate_rate CHECK (rate > 0),
+    CONSTRAINT u_exchange_rate_currency_date UNIQUE (currency, rate_date),
+
+    PRIMARY KEY (id)
+);
+
+-- The rate of the fulfilment date, recorded when the invoice is issued
+ALTER TABLE invoice ADD COLUMN exchange_rate NUMERIC(18,6) NULL DEFAULT NULL;
+
+UPDATE invoice SET exchange_rate = 1 WHERE currency = 'HUF' AND status <> 'draft';
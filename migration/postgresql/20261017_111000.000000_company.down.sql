@@ -0,0 +1,15 @@
+ALTER TABLE bank_statement DROP CONSTRAINT IF EXISTS u_bank_statement_company_sha256;
+ALTER TABLE bank_statement DROP COLUMN IF EXISTS company_id;
+ALTER TABLE bank_statement ADD CONSTRAINT bank_statement_user_id_sha256_key UNIQUE (user_id, sha256);
+
+ALTER TABLE payment DROP COLUMN IF EXISTS company_id;
+
+ALTER TABLE invoice DROP COLUMN IF EXISTS company_id;
+
+ALTER TABLE invoice_series DROP CONSTRAINT IF EXISTS u_invoice_series_company_code;
+ALTER TABLE invoice_series DROP COLUMN IF EXISTS company_id;
+ALTER TABLE invoice_series ADD CONSTRAINT invoice_series_code_key UNIQUE (code);
+
+DROP TABLE IF EXISTS company_user CASCADE;
+DROP TABLE IF EXISTS company_bank_account CASCADE;
+DROP TABLE IF EXISTS company CASCADE;
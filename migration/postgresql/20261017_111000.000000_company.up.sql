@@ -0,0 +1,88 @@
+-- The companies invoicing from the installation, with their seller details
+CREATE TABLE company (
+    id SERIAL,
+
+    name VARCHAR(200) NOT NULL,
+    address VARCHAR(300) NOT NULL DEFAULT '',
+    tax_number VARCHAR(20) NOT NULL DEFAULT '',
+    eu_vat_number VARCHAR(20) NOT NULL DEFAULT '',
+    logo_path VARCHAR(255) NOT NULL DEFAULT '',
+
+    created_at TIMESTAMP NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NULL DEFAULT NULL,
+    deleted_at TIMESTAMP NULL DEFAULT NULL,
+
+    PRIMARY KEY (id)
+);
+
+CREATE TABLE company_bank_account (
+    id SERIAL,
+
+    company_id integer NOT NULL,
+    account_number VARCHAR(34) NOT NULL,
+
+    created_at TIMESTAMP NULL DEFAULT CURRENT_TIMESTAMP,
+
+    CONSTRAINT f_company_bank_account_company FOREIGN KEY (company_id) REFERENCES company (id) ON DELETE CASCADE ON UPDATE CASCADE,
+
+    PRIMARY KEY (id)
+);
+
+-- The users working for a company, with their role in it
+CREATE TABLE company_user (
+    id SERIAL,
+
+    company_id integer NOT NULL,
+    user_id integer NOT NULL,
+    role VARCHAR(20) NOT NULL DEFAULT 'readonly',
+
+    created_at TIMESTAMP NULL DEFAULT CURRENT_TIMESTAMP,
+
+    CONSTRAINT u_company_user UNIQUE (company_id, user_id),
+    CONSTRAINT c_company_user_role CHECK (role IN ('admin', 'accountant', 'issuer', 'readonly')),
+    CONSTRAINT f_company_user_company FOREIGN KEY (company_id) REFERENCES company (id) ON DELETE CASCADE ON UPDATE CASCADE,
+    CONSTRAINT f_company_user_user FOREIGN KEY (user_id) REFERENCES "user" (id) ON DELETE CASCADE ON UPDATE CASCADE,
+
+    PRIMARY KEY (id)
+);
+
+CREATE INDEX i_company_user_user ON company_user (user_id);
+
+-- Everything so far was invoiced by one seller: it becomes the first
+-- company, named after the seller of the last invoice, with all the users
+INSERT INTO company (name, address, tax_number)
+SELECT COALESCE((SELECT seller_name FROM invoice ORDER BY id DESC LIMIT 1), 'My company'),
+    COALESCE((SELECT seller_address FROM invoice ORDER BY id DESC LIMIT 1), ''),
+    COALESCE((SELECT seller_tax_number FROM invoice ORDER BY id DESC LIMIT 1), '');
+
+INSERT INTO company_user (company_id, user_id, role)
+SELECT (SELECT MIN(id) FROM company), id, 'admin'
+FROM "user"
+WHERE deleted_at IS NULL;
+
+-- The numbering series, the invoices, the payments and the bank statements
+-- belong to a company
+ALTER TABLE invoice_series ADD COLUMN company_id integer NULL DEFAULT NULL;
+UPDATE invoice_series SET company_id = (SELECT MIN(id) FROM company);
+ALTER TABLE invoice_series ALTER COLUMN company_id SET NOT NULL;
+ALTER TABLE invoice_series ADD CONSTRAINT f_invoice_series_company FOREIGN KEY (company_id) REFERENCES company (id) ON UPDATE CASCADE;
+ALTER TABLE invoice_series DROP CONSTRAINT invoice_series_code_key;
+ALTER TABLE invoice_series ADD CONSTRAINT u_invoice_series_company_code UNIQUE (company_id, code);
+
+ALTER TABLE invoice ADD COLUMN company_id integer NULL DEFAULT NULL;
+UPDATE invoice SET company_id = (SELECT MIN(id) FROM company);
+ALTER TABLE invoice ALTER COLUMN company_id SET NOT NULL;
+ALTER TABLE invoice ADD CONSTRAINT f_invoice_company FOREIGN KEY (company_id) REFERENCES company (id) ON UPDATE CASCADE;
+CREATE INDEX i_invoice_company ON invoice (company_id);
+
+ALTER TABLE payment ADD COLUMN company_id integer NULL DEFAULT NULL;
+UPDATE payment SET company_id = (SELECT MIN(id) FROM company);
+ALTER TABLE payment ALTER COLUMN company_id SET NOT NULL;
+ALTER TABLE payment ADD CONSTRAINT f_payment_company FOREIGN KEY (company_id) REFERENCES company (id) ON UPDATE CASCADE;
+
+ALTER TABLE bank_statement ADD COLUMN company_id integer NULL DEFAULT NULL;
+UPDATE bank_statement SET company_id = (SELECT MIN(id) FROM company);
+ALTER TABLE bank_statement ALTER COLUMN company_id SET NOT NULL;
+ALTER TABLE bank_statement ADD CONSTRAINT f_bank_statement_company FOREIGN KEY (company_id) REFERENCES company (id) ON UPDATE CASCADE;
+ALTER TABLE bank_statement DROP CONSTRAINT bank_statement_user_id_sha256_key;
+ALTER TABLE bank_statement ADD CONSTRAINT u_bank_statement_company_sha256 UNIQUE (company_id, sha256);
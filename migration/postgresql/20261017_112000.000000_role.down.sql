@@ -0,0 +1,6 @@
+ALTER TABLE company_user DROP CONSTRAINT IF EXISTS f_company_user_role;
+ALTER TABLE company_user ADD CONSTRAINT c_company_user_role CHECK (role IN ('admin', 'accountant', 'issuer', 'readonly'));
+
+DROP TABLE IF EXISTS role_permission CASCADE;
+DROP TABLE IF EXISTS permission CASCADE;
+DROP TABLE IF EXISTS role CASCADE;
@@ -0,0 +1,66 @@
+-- The roles of the users in a company
+CREATE TABLE role (
+    name VARCHAR(20) NOT NULL,
+    description VARCHAR(200) NOT NULL DEFAULT '',
+    position integer NOT NULL DEFAULT 0,
+
+    PRIMARY KEY (name)
+);
+
+INSERT INTO role (name, description, position) VALUES
+('admin', 'Manages the company and its members', 1),
+('accountant', 'Records payments and bank statements', 2),
+('issuer', 'Writes and issues invoices', 3),
+('readonly', 'Views only', 4);
+
+-- The permissions checked by the routes, see acl.Require
+CREATE TABLE permission (
+    name VARCHAR(50) NOT NULL,
+    description VARCHAR(200) NOT NULL DEFAULT '',
+
+    PRIMARY KEY (name)
+);
+
+INSERT INTO permission (name, description) VALUES
+('company.manage', 'Change the company and its members'),
+('invoice.view', 'View invoices'),
+('invoice.edit', 'Write and delete invoice drafts'),
+('invoice.issue', 'Issue invoices and their corrections'),
+('invoice.status', 'Change the status of invoices'),
+('payment.view', 'View payments and open items'),
+('payment.edit', 'Record and allocate payments'),
+('statement.view', 'View bank statements'),
+('statement.edit', 'Import and match bank statements');
+
+CREATE TABLE role_permission (
+    role VARCHAR(20) NOT NULL,
+    permission VARCHAR(50) NOT NULL,
+
+    CONSTRAINT f_role_permission_role FOREIGN KEY (role) REFERENCES role (name) ON DELETE CASCADE ON UPDATE CASCADE,
+    CONSTRAINT f_role_permission_permission FOREIGN KEY (permission) REFERENCES permission (name) ON DELETE CASCADE ON UPDATE CASCADE,
+
+    PRIMARY KEY (role, permission)
+);
+
+INSERT INTO role_permission (role, permission)
+SELECT 'admin', name FROM permission;
+
+INSERT INTO role_permission (role, permission) VALUES
+('accountant', 'invoice.view'),
+('accountant', 'invoice.status'),
+('accountant', 'payment.view'),
+('accountant', 'payment.edit'),
+('accountant', 'statement.view'),
+('accountant', 'statement.edit'),
+('issuer', 'invoice.view'),
+('issuer', 'invoice.edit'),
+('issuer', 'invoice.issue'),
+('issuer', 'invoice.status'),
+('issuer', 'payment.view'),
+('readonly', 'invoice.view'),
+('readonly', 'payment.view'),
+('readonly', 'statement.view');
+
+-- The roles of the members are the ones above
+ALTER TABLE company_user DROP CONSTRAINT c_company_user_role;
+ALTER TABLE company_user ADD CONSTRAINT f_company_user_role FOREIGN KEY (role) REFERENCES role (name) ON UPDATE CASCADE;
@@ -0,0 +1,25 @@
+-- The tokens authenticating machine clients of the API instead of a session.
+-- Only the SHA-256 hash of a token is kept, the token is shown once.
+CREATE TABLE api_token (
+    id SERIAL,
+
+    user_id integer NOT NULL,
+    company_id integer NULL DEFAULT NULL,
+    name VARCHAR(100) NOT NULL,
+    prefix VARCHAR(20) NOT NULL,
+    hash CHAR(64) NOT NULL,
+    scopes VARCHAR(100) NOT NULL DEFAULT 'read',
+    expires_at TIMESTAMP NULL DEFAULT NULL,
+    last_used_at TIMESTAMP NULL DEFAULT NULL,
+
+    created_at TIMESTAMP NULL DEFAULT CURRENT_TIMESTAMP,
+    deleted_at TIMESTAMP NULL DEFAULT NULL,
+
+    CONSTRAINT u_api_token_hash UNIQUE (hash),
+    CONSTRAINT f_api_token_user FOREIGN KEY (user_id) REFERENCES "user" (id) ON DELETE CASCADE ON UPDATE CASCADE,
+    CONSTRAINT f_api_token_company FOREIGN KEY (company_id) REFERENCES company (id) ON DELETE CASCADE ON UPDATE CASCADE,
+
+    PRIMARY KEY (id)
+);
+
+CREATE INDEX i_api_token_user ON api_token (user_id);
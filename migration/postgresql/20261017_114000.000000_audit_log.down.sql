@@ -0,0 +1,5 @@
+DELETE FROM role_permission WHERE permission = 'audit.view';
+DELETE FROM permission WHERE name = 'audit.view';
+
+DROP TABLE IF EXISTS audit_log CASCADE;
+DROP FUNCTION IF EXISTS audit_log_append_only();
@@ -0,0 +1,43 @@
+-- The append-only trail of the changes, see model/audit
+CREATE TABLE audit_log (
+    id BIGSERIAL,
+
+    user_id integer NULL DEFAULT NULL,
+    company_id integer NULL DEFAULT NULL,
+    action VARCHAR(30) NOT NULL,
+    entity VARCHAR(50) NOT NULL,
+    entity_id integer NULL DEFAULT NULL,
+    before JSONB NULL DEFAULT NULL,
+    after JSONB NULL DEFAULT NULL,
+    ip VARCHAR(45) NOT NULL DEFAULT '',
+    request_id VARCHAR(64) NOT NULL DEFAULT '',
+
+    created_at TIMESTAMP NULL DEFAULT CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (id)
+);
+
+-- No foreign keys: the entries outlive the users, companies and entities
+CREATE INDEX i_audit_log_company ON audit_log (company_id, id);
+CREATE INDEX i_audit_log_entity ON audit_log (entity, entity_id);
+
+CREATE FUNCTION audit_log_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_log is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER t_audit_log_append_only
+    BEFORE UPDATE OR DELETE ON audit_log
+    FOR EACH ROW EXECUTE PROCEDURE audit_log_append_only();
+
+CREATE TRIGGER t_audit_log_no_truncate
+    BEFORE TRUNCATE ON audit_log
+    FOR EACH STATEMENT EXECUTE PROCEDURE audit_log_append_only();
+
+-- Browsing the trail is for the admins
+INSERT INTO permission (name, description) VALUES
+('audit.view', 'Browse the audit log');
+
+INSERT INTO role_permission (role, permission) VALUES
+('admin', 'audit.view');
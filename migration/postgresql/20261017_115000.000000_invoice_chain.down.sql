@@ -0,0 +1,7 @@
+DELETE FROM role_permission WHERE permission = 'invoice.verify';
+DELETE FROM permission WHERE name = 'invoice.verify';
+
+DROP INDEX IF EXISTS u_invoice_chain;
+ALTER TABLE invoice DROP COLUMN IF EXISTS hash;
+ALTER TABLE invoice DROP COLUMN IF EXISTS previous_hash;
+ALTER TABLE invoice DROP COLUMN IF EXISTS chain_position;
@@ -0,0 +1,15 @@
+-- Every issued invoice is sealed by a hash of its content chained to the
+-- previous invoice of its series, see model/invoice/chain.go
+ALTER TABLE invoice ADD COLUMN chain_position integer NULL DEFAULT NULL;
+ALTER TABLE invoice ADD COLUMN previous_hash CHAR(64) NULL DEFAULT NULL;
+ALTER TABLE invoice ADD COLUMN hash CHAR(64) NULL DEFAULT NULL;
+
+CREATE UNIQUE INDEX u_invoice_chain ON invoice (series_id, chain_position);
+
+-- Verifying the chains is for the auditors
+INSERT INTO permission (name, description) VALUES
+('invoice.verify', 'Verify the hash chains of the issued invoices');
+
+INSERT INTO role_permission (role, permission) VALUES
+('admin', 'invoice.verify'),
+('accountant', 'invoice.verify');
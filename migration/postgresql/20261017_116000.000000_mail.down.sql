@@ -0,0 +1,10 @@
+DELETE FROM role_permission WHERE permission = 'invoice.send';
+DELETE FROM permission WHERE name = 'invoice.send';
+
+DROP TABLE IF EXISTS sent_mail CASCADE;
+DROP TABLE IF EXISTS mail_template CASCADE;
+
+ALTER TABLE partner DROP COLUMN IF EXISTS language;
+ALTER TABLE partner DROP COLUMN IF EXISTS email_bcc;
+ALTER TABLE partner DROP COLUMN IF EXISTS email_cc;
+ALTER TABLE partner DROP COLUMN IF EXISTS email;
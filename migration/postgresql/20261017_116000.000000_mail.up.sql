@@ -0,0 +1,63 @@
+-- Where the invoices of a partner are sent, and in which language
+ALTER TABLE partner ADD COLUMN email VARCHAR(1000) NOT NULL DEFAULT '';
+ALTER TABLE partner ADD COLUMN email_cc VARCHAR(1000) NOT NULL DEFAULT '';
+ALTER TABLE partner ADD COLUMN email_bcc VARCHAR(1000) NOT NULL DEFAULT '';
+ALTER TABLE partner ADD COLUMN language CHAR(2) NOT NULL DEFAULT 'hu';
+
+-- The texts of the invoice mails of a company, one per language
+CREATE TABLE mail_template (
+    id SERIAL,
+
+    company_id integer NOT NULL,
+    language CHAR(2) NOT NULL,
+    subject VARCHAR(200) NOT NULL,
+    body TEXT NOT NULL,
+
+    created_at TIMESTAMP NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NULL DEFAULT CURRENT_TIMESTAMP,
+    deleted_at TIMESTAMP NULL DEFAULT NULL,
+
+    CONSTRAINT f_mail_template_company FOREIGN KEY (company_id) REFERENCES company (id) ON DELETE CASCADE ON UPDATE CASCADE,
+
+    PRIMARY KEY (id)
+);
+
+CREATE UNIQUE INDEX u_mail_template_language ON mail_template (company_id, language) WHERE deleted_at IS NULL;
+
+-- The log of the invoices sent by mail, see model/sentmail
+CREATE TABLE sent_mail (
+    id SERIAL,
+
+    company_id integer NOT NULL,
+    invoice_id integer NOT NULL,
+    user_id integer NULL DEFAULT NULL,
+    recipients VARCHAR(1000) NOT NULL,
+    cc VARCHAR(1000) NOT NULL DEFAULT '',
+    bcc VARCHAR(1000) NOT NULL DEFAULT '',
+    subject VARCHAR(200) NOT NULL,
+    body TEXT NOT NULL,
+    copy_no integer NOT NULL DEFAULT 0,
+    status VARCHAR(10) NOT NULL DEFAULT 'queued',
+    attempts integer NOT NULL DEFAULT 0,
+    last_error TEXT NOT NULL DEFAULT '',
+    message_id VARCHAR(200) NOT NULL DEFAULT '',
+
+    created_at TIMESTAMP NULL DEFAULT CURRENT_TIMESTAMP,
+    sent_at TIMESTAMP NULL DEFAULT NULL,
+
+    CONSTRAINT f_sent_mail_company FOREIGN KEY (company_id) REFERENCES company (id) ON DELETE CASCADE ON UPDATE CASCADE,
+    CONSTRAINT f_sent_mail_invoice FOREIGN KEY (invoice_id) REFERENCES invoice (id) ON DELETE CASCADE ON UPDATE CASCADE,
+    CONSTRAINT f_sent_mail_user FOREIGN KEY (user_id) REFERENCES "user" (id) ON DELETE SET NULL ON UPDATE CASCADE,
+
+    PRIMARY KEY (id)
+);
+
+CREATE INDEX i_sent_mail_company ON sent_mail (company_id, id);
+CREATE INDEX i_sent_mail_invoice ON sent_mail (invoice_id);
+
+INSERT INTO permission (name, description) VALUES
+('invoice.send', 'Send issued invoices by mail');
+
+INSERT INTO role_permission (role, permission) VALUES
+('admin', 'invoice.send'),
+('issuer', 'invoice.send');
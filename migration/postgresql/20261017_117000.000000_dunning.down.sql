@@ -0,0 +1,8 @@
+DROP TABLE IF EXISTS reminder CASCADE;
+
+DELETE FROM mail_template WHERE kind <> 'invoice';
+DROP INDEX IF EXISTS u_mail_template_language;
+ALTER TABLE mail_template DROP COLUMN IF EXISTS kind;
+CREATE UNIQUE INDEX u_mail_template_language ON mail_template (company_id, language) WHERE deleted_at IS NULL;
+
+ALTER TABLE partner DROP COLUMN IF EXISTS dunning_paused;
@@ -0,0 +1,31 @@
+-- Partners whose overdue invoices are not reminded of
+ALTER TABLE partner ADD COLUMN dunning_paused BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- The mail templates are for the invoices or for the reminders
+ALTER TABLE mail_template ADD COLUMN kind VARCHAR(20) NOT NULL DEFAULT 'invoice';
+DROP INDEX u_mail_template_language;
+CREATE UNIQUE INDEX u_mail_template_language ON mail_template (company_id, kind, language) WHERE deleted_at IS NULL;
+
+-- The payment reminders of the overdue invoices, see model/reminder
+CREATE TABLE reminder (
+    id SERIAL,
+
+    company_id integer NOT NULL,
+    invoice_id integer NOT NULL,
+    level integer NOT NULL,
+    days_overdue integer NOT NULL,
+    balance NUMERIC(18,4) NOT NULL,
+    sent_mail_id integer NULL DEFAULT NULL,
+
+    created_at TIMESTAMP NULL DEFAULT CURRENT_TIMESTAMP,
+
+    CONSTRAINT f_reminder_company FOREIGN KEY (company_id) REFERENCES company (id) ON DELETE CASCADE ON UPDATE CASCADE,
+    CONSTRAINT f_reminder_invoice FOREIGN KEY (invoice_id) REFERENCES invoice (id) ON DELETE CASCADE ON UPDATE CASCADE,
+    CONSTRAINT f_reminder_sent_mail FOREIGN KEY (sent_mail_id) REFERENCES sent_mail (id) ON DELETE SET NULL ON UPDATE CASCADE,
+
+    PRIMARY KEY (id)
+);
+
+-- One reminder per level, even when several servers look for them
+CREATE UNIQUE INDEX u_reminder_level ON reminder (invoice_id, level);
+CREATE INDEX i_reminder_company ON reminder (company_id, id);
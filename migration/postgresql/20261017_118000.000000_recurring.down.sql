@@ -0,0 +1,6 @@
+DELETE FROM role_permission WHERE permission = 'invoice.recurring';
+DELETE FROM permission WHERE name = 'invoice.recurring';
+
+DROP TABLE IF EXISTS recurring_invoice_run CASCADE;
+DROP TABLE IF EXISTS recurring_invoice_line CASCADE;
+DROP TABLE IF EXISTS recurring_invoice CASCADE;
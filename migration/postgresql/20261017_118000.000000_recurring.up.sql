@@ -0,0 +1,86 @@
+-- The templates the invoices of the subscriptions are generated from, see
+-- model/recurring
+CREATE TABLE recurring_invoice (
+    id SERIAL,
+
+    company_id integer NOT NULL,
+    user_id integer NOT NULL,
+    name VARCHAR(200) NOT NULL,
+    series_id integer NOT NULL,
+    partner_id integer NOT NULL,
+    currency CHAR(3) NOT NULL DEFAULT 'HUF',
+    payment_method VARCHAR(20) NOT NULL,
+    rounding VARCHAR(10) NOT NULL DEFAULT 'line',
+    payment_days integer NOT NULL DEFAULT 8,
+
+    frequency VARCHAR(10) NOT NULL,
+    day_of_month integer NOT NULL,
+    start_date DATE NOT NULL,
+    end_date DATE NULL DEFAULT NULL,
+    next_date DATE NULL DEFAULT NULL,
+    auto_issue BOOLEAN NOT NULL DEFAULT FALSE,
+    send_email BOOLEAN NOT NULL DEFAULT FALSE,
+    last_error TEXT NOT NULL DEFAULT '',
+
+    created_at TIMESTAMP NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NULL DEFAULT CURRENT_TIMESTAMP,
+    deleted_at TIMESTAMP NULL DEFAULT NULL,
+
+    CONSTRAINT c_recurring_invoice_day CHECK (day_of_month BETWEEN 1 AND 31),
+    CONSTRAINT f_recurring_invoice_company FOREIGN KEY (company_id) REFERENCES company (id) ON DELETE CASCADE ON UPDATE CASCADE,
+    CONSTRAINT f_recurring_invoice_user FOREIGN KEY (user_id) REFERENCES "user" (id) ON DELETE CASCADE ON UPDATE CASCADE,
+    CONSTRAINT f_recurring_invoice_series FOREIGN KEY (series_id) REFERENCES invoice_series (id) ON UPDATE CASCADE,
+    CONSTRAINT f_recurring_invoice_partner FOREIGN KEY (partner_id) REFERENCES partner (id) ON DELETE CASCADE ON UPDATE CASCADE,
+
+    PRIMARY KEY (id)
+);
+
+CREATE INDEX i_recurring_invoice_company ON recurring_invoice (company_id);
+CREATE INDEX i_recurring_invoice_next ON recurring_invoice (next_date) WHERE deleted_at IS NULL;
+
+CREATE TABLE recurring_invoice_line (
+    id SERIAL,
+
+    recurring_invoice_id integer NOT NULL,
+    line_number integer NOT NULL,
+
+    product_id integer NULL DEFAULT NULL,
+    code_type VARCHAR(10) NOT NULL DEFAULT '',
+    code VARCHAR(20) NOT NULL DEFAULT '',
+    description TEXT NOT NULL,
+    quantity NUMERIC(18,6) NOT NULL,
+    unit VARCHAR(20) NOT NULL,
+    unit_price NUMERIC(18,6) NOT NULL,
+    vat_rate VARCHAR(10) NOT NULL,
+
+    UNIQUE (recurring_invoice_id, line_number),
+    CONSTRAINT f_recurring_invoice_line_recurring FOREIGN KEY (recurring_invoice_id) REFERENCES recurring_invoice (id) ON DELETE CASCADE ON UPDATE CASCADE,
+    CONSTRAINT f_recurring_invoice_line_product FOREIGN KEY (product_id) REFERENCES product (id) ON DELETE SET NULL ON UPDATE CASCADE,
+
+    PRIMARY KEY (id)
+);
+
+-- The invoices generated of a template, one per date even when several
+-- servers generate them at the same time
+CREATE TABLE recurring_invoice_run (
+    id SERIAL,
+
+    recurring_invoice_id integer NOT NULL,
+    occurrence DATE NOT NULL,
+    invoice_id integer NULL DEFAULT NULL,
+
+    created_at TIMESTAMP NULL DEFAULT CURRENT_TIMESTAMP,
+
+    UNIQUE (recurring_invoice_id, occurrence),
+    CONSTRAINT f_recurring_invoice_run_recurring FOREIGN KEY (recurring_invoice_id) REFERENCES recurring_invoice (id) ON DELETE CASCADE ON UPDATE CASCADE,
+    CONSTRAINT f_recurring_invoice_run_invoice FOREIGN KEY (invoice_id) REFERENCES invoice (id) ON DELETE SET NULL ON UPDATE CASCADE,
+
+    PRIMARY KEY (id)
+);
+
+INSERT INTO permission (name, description) VALUES
+('invoice.recurring', 'Manage recurring invoices');
+
+INSERT INTO role_permission (role, permission) VALUES
+('admin', 'invoice.recurring'),
+('issuer', 'invoice.recurring');
@@ -0,0 +1,4 @@
+DELETE FROM role_permission WHERE permission = 'scheduler.view';
+DELETE FROM permission WHERE name = 'scheduler.view';
+
+DROP TABLE IF EXISTS scheduled_run CASCADE;
@@ -0,0 +1,26 @@
+-- The runs of the periodic tasks, see lib/scheduler
+CREATE TABLE scheduled_run (
+    id SERIAL,
+
+    task VARCHAR(50) NOT NULL,
+    scheduled_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    host VARCHAR(200) NOT NULL,
+    status VARCHAR(10) NOT NULL DEFAULT 'running',
+    summary TEXT NOT NULL DEFAULT '',
+    last_error TEXT NOT NULL DEFAULT '',
+
+    started_at TIMESTAMP NULL DEFAULT CURRENT_TIMESTAMP,
+    finished_at TIMESTAMP NULL DEFAULT NULL,
+
+    PRIMARY KEY (id)
+);
+
+-- One run per time, even with several servers
+CREATE UNIQUE INDEX u_scheduled_run_time ON scheduled_run (task, scheduled_at);
+
+-- The history is for the admins
+INSERT INTO permission (name, description) VALUES
+('scheduler.view', 'Browse the runs of the periodic tasks');
+
+INSERT INTO role_permission (role, permission) VALUES
+('admin', 'scheduler.view');
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS u_product_sku;
+CREATE UNIQUE INDEX u_product_sku ON product (user_id, sku) WHERE deleted_at IS NULL;
+ALTER TABLE product DROP COLUMN IF EXISTS company_id;
+
+ALTER TABLE partner DROP COLUMN IF EXISTS company_id;
@@ -0,0 +1,29 @@
+-- The partners and the products belong to a company, shared by its members.
+-- They go to the company of their latest invoice, else to the first company
+-- of the user who added them
+ALTER TABLE partner ADD COLUMN company_id integer NULL DEFAULT NULL;
+UPDATE partner p SET company_id = COALESCE(
+    (SELECT i.company_id FROM invoice i WHERE i.partner_id = p.id ORDER BY i.id DESC LIMIT 1),
+    (SELECT MIN(cu.company_id) FROM company_user cu WHERE cu.user_id = p.user_id),
+    (SELECT MIN(id) FROM company));
+ALTER TABLE partner ALTER COLUMN company_id SET NOT NULL;
+ALTER TABLE partner ADD CONSTRAINT f_partner_company FOREIGN KEY (company_id) REFERENCES company (id) ON UPDATE CASCADE;
+CREATE INDEX i_partner_company ON partner (company_id);
+
+ALTER TABLE product ADD COLUMN company_id integer NULL DEFAULT NULL;
+UPDATE product p SET company_id = COALESCE(
+    (SELECT i.company_id FROM invoice_line l JOIN invoice i ON i.id = l.invoice_id WHERE l.product_id = p.id ORDER BY i.id DESC LIMIT 1),
+    (SELECT MIN(cu.company_id) FROM company_user cu WHERE cu.user_id = p.user_id),
+    (SELECT MIN(id) FROM company));
+ALTER TABLE product ALTER COLUMN company_id SET NOT NULL;
+ALTER TABLE product ADD CONSTRAINT f_product_company FOREIGN KEY (company_id) REFERENCES company (id) ON UPDATE CASCADE;
+
+-- The SKUs are unique within the company: the later ones of the members
+-- using the same SKU get their ID appended
+UPDATE product p SET sku = p.sku || '-' || p.id
+WHERE p.deleted_at IS NULL
+    AND EXISTS (SELECT 1 FROM product q
+        WHERE q.company_id = p.company_id AND q.sku = p.sku
+            AND q.deleted_at IS NULL AND q.id < p.id);
+DROP INDEX u_product_sku;
+CREATE UNIQUE INDEX u_product_sku ON product (company_id, sku) WHERE deleted_at IS NULL;
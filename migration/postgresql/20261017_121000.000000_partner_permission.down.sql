@@ -0,0 +1,2 @@
+DELETE FROM role_permission WHERE permission IN ('partner.view', 'partner.edit', 'product.view', 'product.edit');
+DELETE FROM permission WHERE name IN ('partner.view', 'partner.edit', 'product.view', 'product.edit');
@@ -0,0 +1,21 @@
+-- Partners and products were open to every signed in user
+INSERT INTO permission (name, description) VALUES
+('partner.view', 'View partners'),
+('partner.edit', 'Add, change and delete partners'),
+('product.view', 'View products'),
+('product.edit', 'Add, change and delete products');
+
+INSERT INTO role_permission (role, permission) VALUES
+('admin', 'partner.view'),
+('admin', 'partner.edit'),
+('admin', 'product.view'),
+('admin', 'product.edit'),
+('accountant', 'partner.view'),
+('accountant', 'partner.edit'),
+('accountant', 'product.view'),
+('issuer', 'partner.view'),
+('issuer', 'partner.edit'),
+('issuer', 'product.view'),
+('issuer', 'product.edit'),
+('readonly', 'partner.view'),
+('readonly', 'product.view');
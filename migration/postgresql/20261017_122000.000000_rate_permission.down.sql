@@ -0,0 +1,2 @@
+DELETE FROM role_permission WHERE permission = 'rate.import';
+DELETE FROM permission WHERE name = 'rate.import';
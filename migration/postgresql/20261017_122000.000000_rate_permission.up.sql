@@ -0,0 +1,7 @@
+-- The exchange rates are shared by every company, so only the admins may
+-- import them
+INSERT INTO permission (name, description) VALUES
+('rate.import', 'Import and fetch the MNB exchange rates');
+
+INSERT INTO role_permission (role, permission) VALUES
+('admin', 'rate.import');
@@ -0,0 +1,2 @@
+ALTER TABLE invoice DROP COLUMN IF EXISTS series_number;
+ALTER TABLE invoice DROP COLUMN IF EXISTS series_year;
@@ -0,0 +1,16 @@
+-- Every issued invoice keeps the counter of its series it was numbered
+-- from, so the end of a chain can be found even after the series changes
+-- the format of its numbers
+ALTER TABLE invoice ADD COLUMN series_year integer NULL DEFAULT NULL;
+ALTER TABLE invoice ADD COLUMN series_number integer NULL DEFAULT NULL;
+
+-- The invoices issued so far: the last number of every series is still in
+-- the format of the series
+UPDATE invoice i
+SET series_year = s.year, series_number = s.last_number
+FROM invoice_series s
+WHERE i.series_id = s.id
+    AND s.last_number > 0
+    AND i.number = s.prefix
+        || CASE WHEN s.year_reset THEN s.year || '/' ELSE '' END
+        || lpad(s.last_number::text, greatest(s.padding, length(s.last_number::text)), '0');
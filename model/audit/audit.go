@@ -0,0 +1,327 @@
+// Package audit provides access to the audit_log table in the database: the
+// append-only trail of who changed what and when. It is written by the
+// services of the other models through Track and TrackNew, with the rows of
+// the entities before and after the change as JSON.
+package audit
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/UNO-SOFT/szamlazo/model/transaction"
+
+	"github.com/pkg/errors"
+
+	"gopkg.in/guregu/null.v3"
+)
+
+var (
+	// table is the table name.
+	table = "audit_log"
+	// userTable is the table name of the users.
+	userTable = "user"
+	// companyTable is the table name of the companies.
+	companyTable = "company"
+)
+
+// Actions recorded for every entity. Services may record their own, like
+// "issue" for invoices.
+const (
+	Create = "create"
+	Update = "update"
+	Delete = "delete"
+)
+
+// child is a table holding the parts of an entity, like the lines of an
+// invoice, with its column referring to the entity.
+type child struct {
+	table  string
+	column string
+}
+
+// children are the parts recorded with the entities, by their table.
+var children = map[string][]child{
+	"invoice":           {{"invoice_line", "invoice_id"}},
+	"partner":           {{"partner_bank_account", "partner_id"}},
+	"payment":           {{"payment_allocation", "payment_id"}},
+	"company":           {{"company_bank_account", "company_id"}, {"company_user", "company_id"}},
+	"bank_statement":    {{"bank_transaction", "statement_id"}},
+	"recurring_invoice": {{"recurring_invoice_line", "recurring_invoice_id"}},
+}
+
+// secrets are the columns never written to the log, by their table. The
+// hash of an invoice is its seal, not a secret, so it is logged.
+var secrets = map[string][]string{
+	"user":      {"password"},
+	"api_token": {"hash"},
+}
+
+// Actor is who makes a change, and from where.
+type Actor struct {
+	UserID    string // "" for the system, like the background jobs
+	CompanyID string // The active company of the user, "" if none
+	IP        string
+	RequestID string
+}
+
+// For returns the actor acting as the user when no user is known, like for
+// services called without an actor.
+func (a Actor) For(userID string) Actor {
+	if a.UserID == "" {
+		a.UserID = userID
+	}
+	return a
+}
+
+// Entry defines the model.
+type Entry struct {
+	ID        uint64      `db:"id"`
+	UserID    null.Int    `db:"user_id"`
+	Email     null.String `db:"email"`
+	CompanyID null.Int    `db:"company_id"`
+	Action    string      `db:"action"`
+	Entity    string      `db:"entity"`
+	EntityID  null.Int    `db:"entity_id"`
+	Before    null.String `db:"before"`
+	After     null.String `db:"after"`
+	IP        string      `db:"ip"`
+	RequestID string      `db:"request_id"`
+	CreatedAt null.Time   `db:"created_at"`
+}
+
+// Change is a field of an entity changed, as JSON.
+type Change struct {
+	Field  string
+	Before string
+	After  string
+}
+
+// Changes lists the fields of the entity which differ before and after the
+// change, in the order of their names. The fields of a created or deleted
+// entity are all listed.
+func (e Entry) Changes() ([]Change, error) {
+	var before, after map[string]json.RawMessage
+	for _, v := range []struct {
+		s    null.String
+		dest *map[string]json.RawMessage
+	}{{e.Before, &before}, {e.After, &after}} {
+		if !v.s.Valid {
+			continue
+		}
+		if err := json.Unmarshal([]byte(v.s.String), v.dest); err != nil {
+			return nil, errors.Wrapf(err, "entry %d", e.ID)
+		}
+	}
+
+	fields := make(map[string]struct{}, len(before)+len(after))
+	for f := range before {
+		fields[f] = struct{}{}
+	}
+	for f := range after {
+		fields[f] = struct{}{}
+	}
+	var result []Change
+	for f := range fields {
+		b, a := string(before[f]), string(after[f])
+		if b != a {
+			result = append(result, Change{Field: f, Before: b, After: a})
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Field < result[j].Field })
+	return result, nil
+}
+
+// Filter narrows the entries browsed. Empty fields do not filter.
+type Filter struct {
+	Entity   string
+	EntityID string
+	Action   string
+	Email    string
+	From     time.Time // The first day
+	To       time.Time // The last day
+}
+
+// Service defines the database connection.
+type Service struct {
+	DB Connection
+}
+
+// Connection is an interface for making queries.
+type Connection interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Get(dest interface{}, query string, args ...interface{}) error
+	Select(dest interface{}, query string, args ...interface{}) error
+}
+
+// Find gets the newest entries of the company matching the filter, at most
+// limit of them.
+func (s Service) Find(companyID string, f Filter, limit int) ([]Entry, bool, error) {
+	var result []Entry
+	where := []string{"a.company_id = $1"}
+	args := []interface{}{companyID}
+	add := func(cond string, arg interface{}) {
+		args = append(args, arg)
+		where = append(where, fmt.Sprintf(cond, len(args)))
+	}
+	if f.Entity != "" {
+		add("a.entity = $%d", f.Entity)
+	}
+	if f.EntityID != "" {
+		add("a.entity_id::text = $%d", f.EntityID)
+	}
+	if f.Action != "" {
+		add("a.action = $%d", f.Action)
+	}
+	if f.Email != "" {
+		add("u.email ILIKE '%%' || $%d || '%%'", f.Email)
+	}
+	if !f.From.IsZero() {
+		add("a.created_at >= $%d", f.From)
+	}
+	if !f.To.IsZero() {
+		add("a.created_at < $%d", f.To.AddDate(0, 0, 1))
+	}
+	args = append(args, limit)
+	qry := fmt.Sprintf(`
+		SELECT a.id, a.user_id, u.email, a.company_id, a.action, a.entity, a.entity_id,
+			a.before, a.after, a.ip, a.request_id, a.created_at
+		FROM %q a
+		LEFT JOIN %q u ON u.id = a.user_id
+		WHERE %s
+		ORDER BY a.id DESC
+		LIMIT $%d
+		`, table, userTable, strings.Join(where, "\n\t\t\tAND "), len(args))
+	err := s.DB.Select(&result, qry, args...)
+	return result, err == sql.ErrNoRows, errors.Wrap(err, qry)
+}
+
+// Entities gets the kinds of entities in the log of the company.
+func (s Service) Entities(companyID string) ([]string, error) {
+	var result []string
+	qry := fmt.Sprintf(`
+		SELECT DISTINCT entity
+		FROM %q
+		WHERE company_id = $1
+		ORDER BY entity
+		`, table)
+	err := s.DB.Select(&result, qry, companyID)
+	return result, errors.Wrap(err, qry)
+}
+
+// Track runs fn, which changes the entity with the ID, within a transaction
+// and records the change with the entity before and after it. Nothing is
+// recorded when fn leaves the entity as it was, like when it matches no row.
+func Track(conn transaction.Connection, actor Actor, action string, entity string, ID interface{}, fn func(tx transaction.Connection) error) error {
+	return transaction.Run(conn, func(tx transaction.Connection) error {
+		before, err := snapshot(tx, entity, ID, true)
+		if err != nil {
+			return err
+		}
+		if err := fn(tx); err != nil {
+			return err
+		}
+		after, err := snapshot(tx, entity, ID, false)
+		if err != nil {
+			return err
+		}
+		if before == after {
+			return nil
+		}
+		return record(tx, actor, action, entity, ID, before, after)
+	})
+}
+
+// TrackNew runs fn, which adds an entity and returns its ID, within a
+// transaction and records the entity created.
+func TrackNew(conn transaction.Connection, actor Actor, entity string, fn func(tx transaction.Connection) (uint32, error)) (uint32, error) {
+	var ID uint32
+	err := transaction.Run(conn, func(tx transaction.Connection) error {
+		var err error
+		if ID, err = fn(tx); err != nil {
+			return err
+		}
+		after, err := snapshot(tx, entity, ID, false)
+		if err != nil {
+			return err
+		}
+		return record(tx, actor, Create, entity, ID, null.String{}, after)
+	})
+	return ID, err
+}
+
+// Created is the result of adding an entity, for the services returning an
+// sql.Result. Unlike the results of PostgreSQL, it knows the ID.
+type Created uint32
+
+// LastInsertId returns the ID of the entity.
+func (c Created) LastInsertId() (int64, error) {
+	return int64(c), nil
+}
+
+// RowsAffected returns the one row added.
+func (c Created) RowsAffected() (int64, error) {
+	return 1, nil
+}
+
+// jsonOf returns the expression of the row t of the entity as JSON, with
+// its parts and without its secrets.
+func jsonOf(entity string) string {
+	expr := "to_jsonb(t)"
+	for _, s := range secrets[entity] {
+		expr += fmt.Sprintf(" - '%s'", s)
+	}
+	expr = "(" + expr + ")"
+	for _, c := range children[entity] {
+		expr += fmt.Sprintf(`
+			|| jsonb_build_object('%s', (SELECT COALESCE(jsonb_agg(to_jsonb(c) ORDER BY c.id), '[]') FROM %q c WHERE c.%q = t.id))`,
+			c.table, c.table, c.column)
+	}
+	return expr
+}
+
+// snapshot reads the entity with the ID as JSON, null if there is none. The
+// row is locked when it is read before a change.
+func snapshot(tx transaction.Connection, entity string, ID interface{}, lock bool) (null.String, error) {
+	var result null.String
+	qry := fmt.Sprintf(`
+		SELECT %s
+		FROM %q t
+		WHERE t.id = $1
+		`, jsonOf(entity), entity)
+	if lock {
+		qry += "FOR UPDATE OF t\n"
+	}
+	err := tx.Get(&result, qry, ID)
+	if err == sql.ErrNoRows {
+		return null.String{}, nil
+	}
+	return result, errors.Wrap(err, qry)
+}
+
+// record adds an entry. It belongs to the company of the entity, so its
+// admins see it, or to the active company of the actor for the entities of
+// the users, like the notes.
+func record(tx transaction.Connection, actor Actor, action string, entity string, ID interface{}, before, after null.String) error {
+	if entity == companyTable {
+		actor.CompanyID = fmt.Sprint(ID)
+	}
+	qry := fmt.Sprintf(`
+		INSERT INTO %q
+		(user_id, company_id, action, entity, entity_id, before, after, ip, request_id)
+		VALUES
+		($1, COALESCE((COALESCE($7::jsonb, $6::jsonb) ->> 'company_id')::integer, $2::integer),
+			$3,$4,$5,$6,$7,$8,$9)
+		`, table)
+	_, err := tx.Exec(qry, optional(actor.UserID), optional(actor.CompanyID), action, entity, ID,
+		before, after, actor.IP, actor.RequestID)
+	return errors.Wrap(err, qry)
+}
+
+// optional returns the ID, null if it is empty.
+func optional(ID string) null.String {
+	return null.NewString(ID, ID != "")
+}
@@ -0,0 +1,50 @@
+package audit_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/UNO-SOFT/szamlazo/model/audit"
+
+	"gopkg.in/guregu/null.v3"
+)
+
+// TestChanges checks the fields listed as changed.
+func TestChanges(t *testing.T) {
+	for _, tc := range []struct {
+		name          string
+		before, after null.String
+		want          []audit.Change
+	}{
+		{"update", null.StringFrom(`{"id": 1, "name": "Kft", "city": "Pécs"}`),
+			null.StringFrom(`{"id": 1, "name": "Bt", "city": "Pécs"}`),
+			[]audit.Change{{Field: "name", Before: `"Kft"`, After: `"Bt"`}}},
+		{"create", null.String{}, null.StringFrom(`{"name": "Kft", "id": 2}`),
+			[]audit.Change{{Field: "id", After: "2"}, {Field: "name", After: `"Kft"`}}},
+		{"delete", null.StringFrom(`{"id": 3}`), null.String{},
+			[]audit.Change{{Field: "id", Before: "3"}}},
+		{"added field", null.StringFrom(`{"id": 4}`), null.StringFrom(`{"id": 4, "deleted_at": "2026-10-17T10:00:00"}`),
+			[]audit.Change{{Field: "deleted_at", After: `"2026-10-17T10:00:00"`}}},
+	} {
+		got, err := audit.Entry{Before: tc.before, After: tc.after}.Changes()
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+		} else if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: got %+v want %+v", tc.name, got, tc.want)
+		}
+	}
+
+	if _, err := (audit.Entry{Before: null.StringFrom("{")}).Changes(); err == nil {
+		t.Error("bad JSON: no error")
+	}
+}
+
+// TestFor checks the fallback to the user of the service call.
+func TestFor(t *testing.T) {
+	if got := (audit.Actor{IP: "10.0.0.1"}).For("7"); got.UserID != "7" || got.IP != "10.0.0.1" {
+		t.Errorf("got %+v", got)
+	}
+	if got := (audit.Actor{UserID: "3"}).For("7"); got.UserID != "3" {
+		t.Errorf("got %+v", got)
+	}
+}
@@ -0,0 +1,19 @@
+package audit
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestJSONOf checks that the secrets are left out of their own tables only.
+func TestJSONOf(t *testing.T) {
+	if got := jsonOf("user"); !strings.Contains(got, "- 'password'") {
+		t.Errorf("user keeps its password: %s", got)
+	}
+	if got := jsonOf("api_token"); !strings.Contains(got, "- 'hash'") {
+		t.Errorf("api_token keeps its hash: %s", got)
+	}
+	if got := jsonOf("invoice"); strings.Contains(got, "- '") {
+		t.Errorf("invoice loses a column: %s", got)
+	}
+}
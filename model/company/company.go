@@ -0,0 +1,377 @@
+// Package company provides access to the company, company_bank_account and
+// company_user tables in the database: the sellers invoicing from the
+// installation, and the users working for them.
+package company
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/UNO-SOFT/szamlazo/lib/taxnumber"
+	"github.com/UNO-SOFT/szamlazo/model/audit"
+	"github.com/UNO-SOFT/szamlazo/model/role"
+	"github.com/UNO-SOFT/szamlazo/model/series"
+	"github.com/UNO-SOFT/szamlazo/model/transaction"
+
+	"github.com/pkg/errors"
+
+	"gopkg.in/guregu/null.v3"
+)
+
+var (
+	// table is the table name.
+	table = "company"
+	// accountTable is the table name of the bank accounts.
+	accountTable = "company_bank_account"
+	// memberTable is the table name of the memberships.
+	memberTable = "company_user"
+	// grantTable is the table name of the permissions of the roles.
+	grantTable = "role_permission"
+)
+
+// Roles of the users in a company, see the role table.
+const (
+	RoleAdmin      = "admin"      // Manages the company and its members
+	RoleAccountant = "accountant" // Records payments and statements
+	RoleIssuer     = "issuer"     // Issues invoices
+	RoleReadOnly   = "readonly"   // Views only
+)
+
+// manage is the permission to change a company and its members.
+const manage = "company.manage"
+
+// actionMember is the action of the audit log changing the members.
+const actionMember = "member"
+
+// ErrNotAdmin is returned when a user who is not an admin of a company tries
+// to change it.
+var ErrNotAdmin = errors.New("only the admins of the company can change it")
+
+// Item defines the model.
+type Item struct {
+	ID          uint32    `db:"id"`
+	Name        string    `db:"name"`
+	Address     string    `db:"address"`
+	TaxNumber   string    `db:"tax_number"`
+	EUVATNumber string    `db:"eu_vat_number"`
+	LogoPath    string    `db:"logo_path"`
+	CreatedAt   null.Time `db:"created_at"`
+	UpdatedAt   null.Time `db:"updated_at"`
+	DeletedAt   null.Time `db:"deleted_at"`
+
+	BankAccounts []string `db:"-"`
+}
+
+// Normalize validates the tax numbers and rewrites them in their canonical
+// form. Empty numbers are left alone.
+func (item *Item) Normalize() error {
+	if item.Name == "" {
+		return errors.New("name is required")
+	}
+	if item.TaxNumber != "" {
+		n, err := taxnumber.Parse(item.TaxNumber)
+		if err != nil {
+			return err
+		}
+		item.TaxNumber = n.String()
+	}
+	if item.EUVATNumber != "" {
+		n, err := taxnumber.ParseEU(item.EUVATNumber)
+		if err != nil {
+			return err
+		}
+		item.EUVATNumber = n
+	}
+	accounts := item.BankAccounts[:0]
+	for _, a := range item.BankAccounts {
+		if a = strings.TrimSpace(a); a != "" {
+			accounts = append(accounts, a)
+		}
+	}
+	item.BankAccounts = accounts
+	return nil
+}
+
+// Membership is a company of a user, with the role of the user in it.
+type Membership struct {
+	CompanyID uint32 `db:"company_id"`
+	Name      string `db:"name"`
+	Role      string `db:"role"`
+}
+
+// Member is a user of a company.
+type Member struct {
+	UserID    uint32    `db:"user_id"`
+	FirstName string    `db:"first_name"`
+	LastName  string    `db:"last_name"`
+	Email     string    `db:"email"`
+	Role      string    `db:"role"`
+	CreatedAt null.Time `db:"created_at"`
+}
+
+// Service defines the database connection.
+type Service struct {
+	DB    Connection
+	Actor audit.Actor // Who makes the changes, for the audit log
+}
+
+// As returns the service making the changes as the actor.
+func (s Service) As(actor audit.Actor) Service {
+	s.Actor = actor
+	return s
+}
+
+// Connection is an interface for making queries.
+type Connection interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Get(dest interface{}, query string, args ...interface{}) error
+	Select(dest interface{}, query string, args ...interface{}) error
+}
+
+// columns lists the columns in the order of Item.
+const columns = `c.id, c.name, c.address, c.tax_number, c.eu_vat_number, c.logo_path,
+			c.created_at, c.updated_at, c.deleted_at`
+
+// ByID gets an item with its bank accounts by ID, if the user is a member.
+func (s Service) ByID(ID string, userID string) (Item, bool, error) {
+	result := Item{}
+	qry := fmt.Sprintf(`
+		SELECT %s
+		FROM %q c
+		JOIN %q m ON m.company_id = c.id
+		WHERE c.id = $1
+			AND m.user_id = $2
+			AND c.deleted_at IS NULL
+		LIMIT 1
+		`, columns, table, memberTable)
+	err := s.DB.Get(&result, qry, ID, userID)
+	if err != nil {
+		return result, err == sql.ErrNoRows, errors.Wrap(err, qry)
+	}
+	qry = fmt.Sprintf(`
+		SELECT account_number
+		FROM %q
+		WHERE company_id = $1
+		ORDER BY id
+		`, accountTable)
+	err = s.DB.Select(&result.BankAccounts, qry, ID)
+	return result, false, errors.Wrap(err, qry)
+}
+
+// ByUserID gets the companies of a user with the roles in them.
+func (s Service) ByUserID(userID string) ([]Membership, bool, error) {
+	var result []Membership
+	qry := fmt.Sprintf(`
+		SELECT m.company_id, c.name, m.role
+		FROM %q m
+		JOIN %q c ON c.id = m.company_id
+		WHERE m.user_id = $1
+			AND c.deleted_at IS NULL
+		ORDER BY c.name
+		`, memberTable, table)
+	err := s.DB.Select(&result, qry, userID)
+	return result, err == sql.ErrNoRows, errors.Wrap(err, qry)
+}
+
+// Create adds an item with its bank accounts and the default numbering
+// series, makes the user its admin, and returns the new ID.
+func (s Service) Create(item Item, userID string) (uint32, error) {
+	return audit.TrackNew(s.DB, s.Actor.For(userID), table, func(tx transaction.Connection) (uint32, error) {
+		var ID uint32
+		qry := fmt.Sprintf(`
+			INSERT INTO %q
+			(name, address, tax_number, eu_vat_number)
+			VALUES
+			($1,$2,$3,$4)
+			RETURNING id
+			`, table)
+		if err := tx.Get(&ID, qry, item.Name, item.Address, item.TaxNumber, item.EUVATNumber); err != nil {
+			return ID, errors.Wrap(err, qry)
+		}
+		if err := insertAccounts(tx, ID, item.BankAccounts); err != nil {
+			return ID, err
+		}
+		qry = fmt.Sprintf(`
+			INSERT INTO %q
+			(company_id, user_id, role)
+			VALUES
+			($1,$2,$3)
+			`, memberTable)
+		if _, err := tx.Exec(qry, ID, userID, RoleAdmin); err != nil {
+			return ID, errors.Wrap(err, qry)
+		}
+		return ID, series.Service{DB: tx, Actor: s.Actor.For(userID)}.CreateDefaults(ID)
+	})
+}
+
+// insertAccounts adds the bank accounts to a company.
+func insertAccounts(tx transaction.Connection, companyID interface{}, accounts []string) error {
+	qry := fmt.Sprintf(`
+		INSERT INTO %q
+		(company_id, account_number)
+		VALUES
+		($1,$2)
+		`, accountTable)
+	for _, a := range accounts {
+		if _, err := tx.Exec(qry, companyID, a); err != nil {
+			return errors.Wrap(err, qry)
+		}
+	}
+	return nil
+}
+
+// admin checks within the transaction of DB that the user may manage the
+// company.
+func (s Service) admin(ID interface{}, userID string) error {
+	var n int
+	qry := fmt.Sprintf(`
+		SELECT COUNT(*)
+		FROM %q m
+		JOIN %q g ON g.role = m.role
+		WHERE m.company_id = $1
+			AND m.user_id = $2
+			AND g.permission = $3
+		`, memberTable, grantTable)
+	if err := s.DB.Get(&n, qry, ID, userID, manage); err != nil {
+		return errors.Wrap(err, qry)
+	}
+	if n == 0 {
+		return ErrNotAdmin
+	}
+	return nil
+}
+
+// Update makes changes to an existing item and replaces its bank accounts.
+// Only the admins of the company can change it.
+func (s Service) Update(item Item, ID string, userID string) error {
+	return audit.Track(s.DB, s.Actor.For(userID), audit.Update, table, ID, func(tx transaction.Connection) error {
+		ts := Service{DB: tx}
+		if err := ts.admin(ID, userID); err != nil {
+			return err
+		}
+		qry := fmt.Sprintf(`
+			UPDATE %q
+			SET name = $1, address = $2, tax_number = $3, eu_vat_number = $4,
+				updated_at = NOW()
+			WHERE id = $5
+				AND deleted_at IS NULL
+			`, table)
+		if _, err := tx.Exec(qry, item.Name, item.Address, item.TaxNumber, item.EUVATNumber, ID); err != nil {
+			return errors.Wrap(err, qry)
+		}
+
+		qry = fmt.Sprintf(`DELETE FROM %q WHERE company_id = $1`, accountTable)
+		if _, err := tx.Exec(qry, ID); err != nil {
+			return errors.Wrap(err, qry)
+		}
+		return insertAccounts(tx, ID, item.BankAccounts)
+	})
+}
+
+// SetLogo records the path of the logo of a company.
+func (s Service) SetLogo(ID string, path string, userID string) error {
+	return audit.Track(s.DB, s.Actor.For(userID), audit.Update, table, ID, func(tx transaction.Connection) error {
+		if err := (Service{DB: tx}).admin(ID, userID); err != nil {
+			return err
+		}
+		qry := fmt.Sprintf(`
+			UPDATE %q
+			SET logo_path = $1, updated_at = NOW()
+			WHERE id = $2
+			`, table)
+		_, err := tx.Exec(qry, path, ID)
+		return errors.Wrap(err, qry)
+	})
+}
+
+// Members gets the users of a company, if the user asking is a member.
+func (s Service) Members(ID string, userID string) ([]Member, bool, error) {
+	var result []Member
+	qry := fmt.Sprintf(`
+		SELECT m.user_id, u.first_name, u.last_name, u.email, m.role, m.created_at
+		FROM %q m
+		JOIN "user" u ON u.id = m.user_id
+		WHERE m.company_id = $1
+			AND EXISTS (SELECT 1 FROM %q WHERE company_id = $1 AND user_id = $2)
+		ORDER BY u.last_name, u.first_name
+		`, memberTable, memberTable)
+	err := s.DB.Select(&result, qry, ID, userID)
+	return result, err == sql.ErrNoRows, errors.Wrap(err, qry)
+}
+
+// SetMember adds the user with the email to a company with the named role,
+// or changes the role if the user is a member already. Only the admins of the
+// company can change its members, and it cannot be left without one.
+func (s Service) SetMember(ID string, email string, name string, userID string) error {
+	return audit.Track(s.DB, s.Actor.For(userID), actionMember, table, ID, func(tx transaction.Connection) error {
+		ts := Service{DB: tx}
+		if err := ts.admin(ID, userID); err != nil {
+			return err
+		}
+		if ok, err := (role.Service{DB: tx}).Exists(name); err != nil {
+			return err
+		} else if !ok {
+			return errors.Errorf("unknown role %q", name)
+		}
+		qry := fmt.Sprintf(`
+			INSERT INTO %q
+			(company_id, user_id, role)
+			SELECT $1, id, $3
+			FROM "user"
+			WHERE email = $2
+				AND deleted_at IS NULL
+			ON CONFLICT (company_id, user_id)
+			DO UPDATE SET role = EXCLUDED.role
+			`, memberTable)
+		result, err := tx.Exec(qry, ID, email, name)
+		if err != nil {
+			return errors.Wrap(err, qry)
+		}
+		if n, err := result.RowsAffected(); err != nil {
+			return errors.Wrap(err, qry)
+		} else if n == 0 {
+			return errors.Errorf("no user with the email %q", email)
+		}
+		return ts.keepAdmin(ID)
+	})
+}
+
+// RemoveMember takes a user off a company. Only the admins of the company
+// can change its members, and it cannot be left without one.
+func (s Service) RemoveMember(ID string, memberID string, userID string) error {
+	return audit.Track(s.DB, s.Actor.For(userID), actionMember, table, ID, func(tx transaction.Connection) error {
+		ts := Service{DB: tx}
+		if err := ts.admin(ID, userID); err != nil {
+			return err
+		}
+		qry := fmt.Sprintf(`
+			DELETE FROM %q
+			WHERE company_id = $1
+				AND user_id = $2
+			`, memberTable)
+		if _, err := tx.Exec(qry, ID, memberID); err != nil {
+			return errors.Wrap(err, qry)
+		}
+		return ts.keepAdmin(ID)
+	})
+}
+
+// keepAdmin checks that a company still has a member who may manage it.
+func (s Service) keepAdmin(ID string) error {
+	var n int
+	qry := fmt.Sprintf(`
+		SELECT COUNT(*)
+		FROM %q m
+		JOIN %q g ON g.role = m.role
+		WHERE m.company_id = $1
+			AND g.permission = $2
+		`, memberTable, grantTable)
+	if err := s.DB.Get(&n, qry, ID, manage); err != nil {
+		return errors.Wrap(err, qry)
+	}
+	if n == 0 {
+		return errors.New("the company must have an admin")
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+package company_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/UNO-SOFT/szamlazo/model/company"
+)
+
+// TestNormalize checks the validation and the canonical forms.
+func TestNormalize(t *testing.T) {
+	item := company.Item{
+		Name:         "Seller Kft.",
+		TaxNumber:    "10773381244",
+		EUVATNumber:  "hu10773381",
+		BankAccounts: []string{" 11773016-12345678 ", ""},
+	}
+	if err := item.Normalize(); err != nil {
+		t.Fatal(err)
+	}
+	want := company.Item{
+		Name:         "Seller Kft.",
+		TaxNumber:    "10773381-2-44",
+		EUVATNumber:  "HU10773381",
+		BankAccounts: []string{"11773016-12345678"},
+	}
+	if !reflect.DeepEqual(item, want) {
+		t.Errorf("got %+v want %+v", item, want)
+	}
+
+	if err := (&company.Item{}).Normalize(); err == nil {
+		t.Error("empty name accepted")
+	}
+}
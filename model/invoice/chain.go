@@ -0,0 +1,294 @@
+package invoice
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/UNO-SOFT/szamlazo/model/series"
+
+	"github.com/pkg/errors"
+
+	"gopkg.in/guregu/null.v3"
+)
+
+// Genesis is the previous hash of the first invoice of a series.
+var Genesis = strings.Repeat("0", 64)
+
+// content is the canonical form of what an issued invoice says. It leaves
+// out what may change after issuing: the status, the NAV report and the
+// printings. JSON keeps the order of the fields, and the amounts are
+// normalized, so the same invoice always gives the same bytes.
+type content struct {
+	CompanyID         uint32        `json:"company_id"`
+	SeriesID          uint32        `json:"series_id"`
+	Number            string        `json:"number"`
+	Kind              string        `json:"kind"`
+	OriginalNumber    string        `json:"original_number"`
+	ModificationIndex int64         `json:"modification_index"`
+	SellerName        string        `json:"seller_name"`
+	SellerAddress     string        `json:"seller_address"`
+	SellerTaxNumber   string        `json:"seller_tax_number"`
+	BuyerName         string        `json:"buyer_name"`
+	BuyerAddress      string        `json:"buyer_address"`
+	BuyerTaxNumber    string        `json:"buyer_tax_number"`
+	IssueDate         string        `json:"issue_date"`
+	FulfilmentDate    string        `json:"fulfilment_date"`
+	DueDate           string        `json:"due_date"`
+	Currency          string        `json:"currency"`
+	PaymentMethod     string        `json:"payment_method"`
+	Rounding          string        `json:"rounding"`
+	GrossTotal        string        `json:"gross_total"`
+	ExchangeRate      string        `json:"exchange_rate"`
+	Lines             []lineContent `json:"lines"`
+}
+
+// lineContent is the canonical form of a line.
+type lineContent struct {
+	LineNumber  uint32 `json:"line_number"`
+	Reference   int64  `json:"line_number_reference"`
+	CodeType    string `json:"code_type"`
+	Code        string `json:"code"`
+	Description string `json:"description"`
+	Quantity    string `json:"quantity"`
+	Unit        string `json:"unit"`
+	UnitPrice   string `json:"unit_price"`
+	VATRate     string `json:"vat_rate"`
+}
+
+// Content returns the canonical form of the item with its lines, which its
+// hash is computed of.
+func (item Item) Content() ([]byte, error) {
+	const day = "2006-01-02"
+	c := content{
+		CompanyID:         item.CompanyID,
+		SeriesID:          item.SeriesID,
+		Number:            item.Number.String,
+		Kind:              item.Kind,
+		OriginalNumber:    item.OriginalNumber.String,
+		ModificationIndex: item.ModificationIndex.Int64,
+		SellerName:        item.SellerName,
+		SellerAddress:     item.SellerAddress,
+		SellerTaxNumber:   item.SellerTaxNumber,
+		BuyerName:         item.BuyerName,
+		BuyerAddress:      item.BuyerAddress,
+		BuyerTaxNumber:    item.BuyerTaxNumber,
+		IssueDate:         item.IssueDate.Format(day),
+		FulfilmentDate:    item.FulfilmentDate.Format(day),
+		DueDate:           item.DueDate.Format(day),
+		Currency:          item.Currency,
+		PaymentMethod:     item.PaymentMethod,
+		Rounding:          item.Rounding,
+		GrossTotal:        item.GrossTotal.Normalize().String(),
+		ExchangeRate:      item.ExchangeRate.Normalize().String(),
+		Lines:             make([]lineContent, len(item.Lines)),
+	}
+	for i, line := range item.Lines {
+		c.Lines[i] = lineContent{
+			LineNumber:  line.LineNumber,
+			Reference:   line.Reference.Int64,
+			CodeType:    line.CodeType,
+			Code:        line.Code,
+			Description: line.Description,
+			Quantity:    line.Quantity.Normalize().String(),
+			Unit:        line.Unit,
+			UnitPrice:   line.UnitPrice.Normalize().String(),
+			VATRate:     line.VATRate,
+		}
+	}
+	return json.Marshal(c)
+}
+
+// Seal returns the hash of the item chained to the hash of the previous
+// invoice of its series: the hex SHA-256 of the previous hash, a newline and
+// the content.
+func (item Item) Seal(previous string) (string, error) {
+	b, err := item.Content()
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	h.Write([]byte(previous + "\n"))
+	h.Write(b)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// seal chains the issued items of a series which are not sealed yet, in the
+// order of their issuing, within the transaction of DB. The series must be
+// locked by series.Next, so its chain grows one invoice at a time. The
+// invoices issued before the chains were introduced are sealed by the next
+// issuing of their series.
+func (s Service) seal(seriesID uint32) error {
+	var last struct {
+		Position int64  `db:"chain_position"`
+		Hash     string `db:"hash"`
+	}
+	qry := fmt.Sprintf(`
+		SELECT chain_position, hash
+		FROM %q
+		WHERE series_id = $1
+			AND chain_position IS NOT NULL
+		ORDER BY chain_position DESC
+		LIMIT 1
+		`, table)
+	err := s.DB.Get(&last, qry, seriesID)
+	if err == sql.ErrNoRows {
+		last.Hash = Genesis
+	} else if err != nil {
+		return errors.Wrap(err, qry)
+	}
+
+	var items []Item
+	qry = fmt.Sprintf(`
+		SELECT %s
+		FROM %q
+		WHERE series_id = $1
+			AND number IS NOT NULL
+			AND chain_position IS NULL
+		ORDER BY issue_date, id
+		`, columns, table)
+	if err = s.DB.Select(&items, qry, seriesID); err != nil {
+		return errors.Wrap(err, qry)
+	}
+
+	qry = fmt.Sprintf(`
+		UPDATE %q
+		SET chain_position = $1, previous_hash = $2, hash = $3
+		WHERE id = $4
+		`, table)
+	for _, item := range items {
+		if item.Lines, err = s.lines(fmt.Sprint(item.ID)); err != nil {
+			return err
+		}
+		hash, err := item.Seal(last.Hash)
+		if err != nil {
+			return err
+		}
+		if _, err = s.DB.Exec(qry, last.Position+1, last.Hash, hash, item.ID); err != nil {
+			return errors.Wrap(err, qry)
+		}
+		last.Position, last.Hash = last.Position+1, hash
+	}
+	return nil
+}
+
+// Break is an issued invoice where the chain of its series is broken. The ID
+// of an invoice missing altogether is 0.
+type Break struct {
+	ID     uint32
+	Number string
+	Reason string
+}
+
+// Verification is the result of walking the chain of a series.
+type Verification struct {
+	SeriesID uint32
+	Checked  int     // Number of the issued invoices walked
+	Breaks   []Break // Empty when the chain is intact
+}
+
+// Intact reports whether no break was found.
+func (v Verification) Intact() bool {
+	return len(v.Breaks) == 0
+}
+
+// Verify walks the issued invoices of a series in the order of their chain,
+// and reports every invoice which is not sealed, was removed or moved, does
+// not follow the invoice before it, or was changed after it was sealed. The
+// last number given by the series must be there too, else the end of the
+// chain was removed.
+func (s Service) Verify(seriesID uint32, companyID string) (Verification, error) {
+	result := Verification{SeriesID: seriesID}
+	sr, err := s.checkSeries(seriesID, companyID)
+	if err != nil {
+		return result, err
+	}
+
+	var items []Item
+	qry := fmt.Sprintf(`
+		SELECT %s
+		FROM %q
+		WHERE series_id = $1
+			AND number IS NOT NULL
+		ORDER BY chain_position NULLS LAST, id
+		`, columns, table)
+	if err := s.DB.Select(&items, qry, seriesID); err != nil {
+		return result, errors.Wrap(err, qry)
+	}
+
+	previous, position := Genesis, int64(0)
+	for _, item := range items {
+		result.Checked++
+		broken := func(format string, args ...interface{}) {
+			result.Breaks = append(result.Breaks, Break{
+				ID:     item.ID,
+				Number: item.Number.String,
+				Reason: fmt.Sprintf(format, args...),
+			})
+		}
+		if !item.ChainPosition.Valid {
+			broken("not sealed")
+			continue
+		}
+		position++
+		if item.ChainPosition.Int64 != position {
+			broken("at position %d instead of %d, an invoice is missing before it", item.ChainPosition.Int64, position)
+			position = item.ChainPosition.Int64
+		}
+		if item.PreviousHash != null.StringFrom(previous) {
+			broken("does not follow the invoice before it")
+		}
+		if item.DeletedAt.Valid {
+			broken("deleted after it was issued")
+		}
+
+		var err error
+		if item.Lines, err = s.lines(fmt.Sprint(item.ID)); err != nil {
+			return result, err
+		}
+		hash, err := item.Seal(item.PreviousHash.String)
+		if err != nil {
+			return result, err
+		}
+		if null.StringFrom(hash) != item.Hash {
+			broken("changed after it was issued")
+		}
+		previous = item.Hash.String
+	}
+	if b, ok := missingTail(sr, items); ok {
+		result.Breaks = append(result.Breaks, b)
+	}
+	return result, nil
+}
+
+// missingTail reports the last number given by the series when no invoice
+// of the items, in the order of their chain, has it. Only the last sealed
+// one or an invoice not sealed yet may have it. The counters are compared,
+// as the series may format its numbers differently since.
+func missingTail(sr series.Item, items []Item) (Break, bool) {
+	if sr.LastNumber == 0 {
+		return Break{}, false
+	}
+	var last Item
+	for _, item := range items {
+		if !item.ChainPosition.Valid && item.lastOf(sr) {
+			return Break{}, false
+		}
+		if item.ChainPosition.Valid {
+			last = item
+		}
+	}
+	if last.lastOf(sr) {
+		return Break{}, false
+	}
+	return Break{Number: sr.Format(sr.Year, sr.LastNumber), Reason: "missing from the end of the chain"}, true
+}
+
+// lastOf reports whether the item got the last number given by the series.
+func (item Item) lastOf(sr series.Item) bool {
+	return item.SeriesYear == null.IntFrom(int64(sr.Year)) &&
+		item.SeriesNumber == null.IntFrom(int64(sr.LastNumber))
+}
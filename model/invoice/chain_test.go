@@ -0,0 +1,86 @@
+package invoice_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/UNO-SOFT/szamlazo/lib/money"
+	"github.com/UNO-SOFT/szamlazo/model/invoice"
+
+	"gopkg.in/guregu/null.v3"
+)
+
+// TestSeal checks that the hash covers what an issued invoice says, and
+// nothing which may change after issuing.
+func TestSeal(t *testing.T) {
+	issued := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	item := func() invoice.Item {
+		return invoice.Item{
+			SeriesID:       1,
+			Number:         null.StringFrom("INV-0001"),
+			Status:         invoice.StatusIssued,
+			Kind:           invoice.KindNormal,
+			SellerName:     "Seller Kft.",
+			BuyerName:      "Buyer Bt.",
+			IssueDate:      issued,
+			FulfilmentDate: issued,
+			DueDate:        issued.AddDate(0, 0, 8),
+			Currency:       "HUF",
+			PaymentMethod:  invoice.PaymentTransfer,
+			Rounding:       invoice.RoundPerLine,
+			GrossTotal:     money.MustParse("12700"),
+			CompanyID:      1,
+			Lines: []invoice.Line{{
+				LineNumber:  1,
+				Description: "Consulting",
+				Quantity:    money.MustParse("1"),
+				Unit:        "hour",
+				UnitPrice:   money.MustParse("10000"),
+				VATRate:     invoice.VAT27,
+			}},
+		}
+	}
+	seal := func(item invoice.Item, previous string) string {
+		hash, err := item.Seal(previous)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return hash
+	}
+
+	want := seal(item(), invoice.Genesis)
+	if len(want) != 64 {
+		t.Fatalf("hash %q is not hex SHA-256", want)
+	}
+	if seal(item(), "f00d") == want {
+		t.Error("hash does not depend on the previous hash")
+	}
+
+	for name, modify := range map[string]func(*invoice.Item){
+		"status":         func(item *invoice.Item) { item.Status = invoice.StatusPaid },
+		"NAV status":     func(item *invoice.Item) { item.NAVStatus = "DONE" },
+		"scale of total": func(item *invoice.Item) { item.GrossTotal = money.MustParse("12700.0000") },
+		"scale of price": func(item *invoice.Item) { item.Lines[0].UnitPrice = money.MustParse("10000.000000") },
+	} {
+		changed := item()
+		modify(&changed)
+		if got := seal(changed, invoice.Genesis); got != want {
+			t.Errorf("%s: hash changed to %s", name, got)
+		}
+	}
+
+	for name, modify := range map[string]func(*invoice.Item){
+		"buyer":       func(item *invoice.Item) { item.BuyerName = "Other Bt." },
+		"due date":    func(item *invoice.Item) { item.DueDate = item.DueDate.AddDate(0, 0, 1) },
+		"total":       func(item *invoice.Item) { item.GrossTotal = money.MustParse("12701") },
+		"quantity":    func(item *invoice.Item) { item.Lines[0].Quantity = money.MustParse("2") },
+		"description": func(item *invoice.Item) { item.Lines[0].Description = "Training" },
+		"lines":       func(item *invoice.Item) { item.Lines = nil },
+	} {
+		changed := item()
+		modify(&changed)
+		if seal(changed, invoice.Genesis) == want {
+			t.Errorf("%s: hash unchanged", name)
+		}
+	}
+}
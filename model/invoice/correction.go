@@ -0,0 +1,226 @@
+package invoice
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/UNO-SOFT/szamlazo/model/audit"
+	"github.com/UNO-SOFT/szamlazo/model/series"
+	"github.com/UNO-SOFT/szamlazo/model/transaction"
+
+	"github.com/pkg/errors"
+
+	"gopkg.in/guregu/null.v3"
+)
+
+// Kinds of the invoices.
+const (
+	KindNormal       = "normal"       // Számla
+	KindStorno       = "storno"       // Sztornó számla, cancelling the original in full
+	KindModification = "modification" // Módosító számla, with the differences to the original
+)
+
+// ErrFinalized is returned for a change of an issued invoice in place.
+var ErrFinalized = errors.New("issued invoices cannot be changed, issue a storno or a modification invoice instead")
+
+// Finalized reports whether the item is issued: it has legal effect then, and
+// can only be corrected by storno and modification invoices.
+func (item Item) Finalized() bool {
+	return item.Number.Valid
+}
+
+// IsCorrection reports whether the item is a storno or a modification
+// invoice.
+func (item Item) IsCorrection() bool {
+	return item.Kind == KindStorno || item.Kind == KindModification
+}
+
+// Modification returns the header of a modification invoice of item, issued
+// on the given day, without lines.
+func (item Item) Modification(issued time.Time) Item {
+	m := Item{
+		Kind:            KindModification,
+		CompanyID:       item.CompanyID,
+		OriginalID:      null.IntFrom(int64(item.ID)),
+		OriginalNumber:  item.Number,
+		SellerName:      item.SellerName,
+		SellerAddress:   item.SellerAddress,
+		SellerTaxNumber: item.SellerTaxNumber,
+		PartnerID:       item.PartnerID,
+		BuyerName:       item.BuyerName,
+		BuyerAddress:    item.BuyerAddress,
+		BuyerTaxNumber:  item.BuyerTaxNumber,
+		IssueDate:       issued,
+		FulfilmentDate:  item.FulfilmentDate,
+		DueDate:         item.DueDate,
+		Currency:        item.Currency,
+		PaymentMethod:   item.PaymentMethod,
+		Rounding:        item.Rounding,
+		ExchangeRate:    item.ExchangeRate,
+	}
+	if m.DueDate.Before(issued) {
+		m.DueDate = issued
+	}
+	return m
+}
+
+// Storno returns the storno invoice of item, issued on the given day: the
+// lines of the original with their quantities negated.
+func (item Item) Storno(issued time.Time) Item {
+	s := item.Modification(issued)
+	s.Kind = KindStorno
+	s.DueDate = issued
+	s.Lines = make([]Line, len(item.Lines))
+	for i, line := range item.Lines {
+		s.Lines[i] = Line{
+			ProductID:   line.ProductID,
+			CodeType:    line.CodeType,
+			Code:        line.Code,
+			Description: line.Description,
+			Quantity:    line.Quantity.Neg(),
+			Unit:        line.Unit,
+			UnitPrice:   line.UnitPrice,
+			VATRate:     line.VATRate,
+		}
+	}
+	return s
+}
+
+// CreateCorrection issues a storno or modification invoice of the original
+// given by its OriginalID, and returns the new ID. It is numbered from the
+// correction series of the original, and gets the next modification index
+// and line references of the original, and is converted to forints by the
+// exchange rate of the original. It is sealed into the hash chain of its
+// series, and a storno cancels the original.
+//
+// The original is locked until the end of the transaction, so the
+// corrections of an invoice are numbered one after the other.
+func (s Service) CreateCorrection(item Item, userID string) (uint32, error) {
+	if !item.IsCorrection() {
+		return 0, errors.Errorf("%q is not a correcting invoice", item.Kind)
+	}
+	if !item.OriginalID.Valid {
+		return 0, errors.New("the original invoice is required")
+	}
+	actor := s.Actor.For(userID)
+	return audit.TrackNew(s.DB, actor, table, func(tx transaction.Connection) (uint32, error) {
+		ts := Service{DB: tx}
+		original, err := ts.referTo(&item)
+		if err != nil {
+			return 0, err
+		}
+		if item.ExchangeRate.IsZero() {
+			if item.ExchangeRate, err = ts.exchangeRate(original); err != nil {
+				return 0, err
+			}
+		}
+		number, year, n, err := series.Service{DB: tx}.Next(fmt.Sprint(item.SeriesID), item.IssueDate)
+		if err != nil {
+			return 0, err
+		}
+		item.Number, item.Status = null.StringFrom(number), StatusIssued
+		item.SeriesYear, item.SeriesNumber = null.IntFrom(int64(year)), null.IntFrom(int64(n))
+		ID, err := ts.create(item, userID)
+		if err != nil {
+			return 0, err
+		}
+		if err = ts.seal(item.SeriesID); err != nil {
+			return 0, err
+		}
+		if item.Kind == KindStorno {
+			err = audit.Track(tx, actor, actionStatus, table, original.ID, func(tx transaction.Connection) error {
+				return Service{DB: tx}.setStatus(original, StatusCancelled, userID, number)
+			})
+		}
+		return ID, err
+	})
+}
+
+// referTo locks the original of a correction within the transaction of DB,
+// checks that it can be corrected, and fills the references to it.
+func (s Service) referTo(item *Item) (Item, error) {
+	original, noRows, err := s.Lock(item.OriginalID.Int64, item.CompanyID)
+	if noRows {
+		return original, errors.Errorf("original invoice %d not found", item.OriginalID.Int64)
+	} else if err != nil {
+		return original, err
+	}
+	if original.Kind != KindNormal {
+		return original, errors.Errorf("invoice %s is a correction, correct its original %s instead",
+			original.Number.String, original.OriginalNumber.String)
+	}
+	if !original.Finalized() {
+		return original, errors.Errorf("invoice %s is not issued yet, change it instead", original.label())
+	}
+	if item.Kind == KindStorno && !CanTransition(original.Status, StatusCancelled) {
+		return original, errors.Errorf("invoice %s is %s, it cannot be cancelled", original.Number.String, original.Status)
+	}
+	if item.Currency != original.Currency {
+		return original, errors.Errorf("the currency of invoice %s is %s", original.Number.String, original.Currency)
+	}
+
+	var chain struct {
+		Stornos   int `db:"stornos"`
+		LastIndex int `db:"last_index"`
+	}
+	qry := fmt.Sprintf(`
+		SELECT COUNT(*) FILTER (WHERE kind = $2) AS stornos,
+			COALESCE(MAX(modification_index), 0) AS last_index
+		FROM %q
+		WHERE original_id = $1
+			AND deleted_at IS NULL
+		`, table)
+	if err = s.DB.Get(&chain, qry, original.ID, KindStorno); err != nil {
+		return original, errors.Wrap(err, qry)
+	}
+	if chain.Stornos > 0 {
+		return original, errors.Errorf("invoice %s is already cancelled", original.Number.String)
+	}
+
+	// The lines of the corrections continue the lines of the original
+	var lines int64
+	qry = fmt.Sprintf(`
+		SELECT COUNT(*)
+		FROM %q l
+		JOIN %q i ON i.id = l.invoice_id
+		WHERE (i.id = $1 OR i.original_id = $1)
+			AND i.deleted_at IS NULL
+		`, lineTable, table)
+	if err = s.DB.Get(&lines, qry, original.ID); err != nil {
+		return original, errors.Wrap(err, qry)
+	}
+
+	sr, _, err := series.Service{DB: s.DB}.ByID(fmt.Sprint(original.SeriesID), fmt.Sprint(original.CompanyID))
+	if err != nil {
+		return original, err
+	}
+	if !sr.CorrectionSeriesID.Valid {
+		return original, errors.Errorf("series %s has no series for the corrections", sr.Code)
+	}
+
+	item.SeriesID = uint32(sr.CorrectionSeriesID.Int64)
+	item.OriginalNumber = original.Number
+	item.ExchangeRate = original.ExchangeRate
+	item.ModificationIndex = null.IntFrom(int64(chain.LastIndex + 1))
+	for i := range item.Lines {
+		item.Lines[i].Reference = null.IntFrom(lines + int64(i) + 1)
+	}
+	return original, nil
+}
+
+// Corrections gets the storno and modification invoices of an item, without
+// their lines, in their order.
+func (s Service) Corrections(ID string, companyID string) ([]Item, bool, error) {
+	var result []Item
+	qry := fmt.Sprintf(`
+		SELECT %s
+		FROM %q
+		WHERE original_id = $1
+			AND company_id = $2
+			AND deleted_at IS NULL
+		ORDER BY modification_index
+		`, columns, table)
+	err := s.DB.Select(&result, qry, ID, companyID)
+	return result, err == sql.ErrNoRows, errors.Wrap(err, qry)
+}
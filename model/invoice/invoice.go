@@ -0,0 +1,503 @@
+// Package invoice provides access to the invoice and invoice_line tables in
+// the database.
+package invoice
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/UNO-SOFT/szamlazo/lib/money"
+	"github.com/UNO-SOFT/szamlazo/model/audit"
+	"github.com/UNO-SOFT/szamlazo/model/series"
+	"github.com/UNO-SOFT/szamlazo/model/transaction"
+
+	"github.com/pkg/errors"
+
+	"gopkg.in/guregu/null.v3"
+)
+
+var (
+	// table is the table name.
+	table = "invoice"
+	// lineTable is the table name of the invoice lines.
+	lineTable = "invoice_line"
+)
+
+// Actions of the audit log, besides creating, updating and deleting.
+const (
+	actionIssue  = "issue"      // Numbered and made final
+	actionStatus = "status"     // Moved to another status
+	actionPrint  = "print"      // Printed, the original or a copy
+	actionNAV    = "nav_status" // Reported to NAV
+)
+
+// Payment methods, named as in the NAV Online Invoice schema.
+const (
+	PaymentTransfer = "TRANSFER"
+	PaymentCash     = "CASH"
+	PaymentCard     = "CARD"
+	PaymentVoucher  = "VOUCHER"
+	PaymentOther    = "OTHER"
+)
+
+// VAT rates of the invoice lines. Percentages are stored as their number,
+// exemptions as their NAV case code.
+const (
+	VAT27  = "27"
+	VAT18  = "18"
+	VAT5   = "5"
+	VAT0   = "0"
+	VATAAM = "AAM"   // Subject is exempt from VAT (alanyi adómentes).
+	VATTAM = "TAM"   // Supply is exempt from VAT (tárgyi adómentes).
+	VATEU  = "KBAET" // Exempt intra-Community supply.
+	VATATK = "ATK"   // Outside the scope of VAT (áfa tv. hatályán kívül).
+)
+
+// Item defines the model.
+//
+// Storno and modification invoices refer to the normal invoice they correct
+// by OriginalID and OriginalNumber, and are counted by ModificationIndex.
+// Issued invoices are numbered from the SeriesNumber of their series in
+// SeriesYear, and sealed by Hash at ChainPosition of their series, see Seal.
+type Item struct {
+	ID                uint32        `db:"id"`
+	SeriesID          uint32        `db:"series_id"`
+	Number            null.String   `db:"number"`
+	SeriesYear        null.Int      `db:"series_year"`
+	SeriesNumber      null.Int      `db:"series_number"`
+	Status            string        `db:"status"`
+	Kind              string        `db:"kind"`
+	OriginalID        null.Int      `db:"original_id"`
+	OriginalNumber    null.String   `db:"original_number"`
+	ModificationIndex null.Int      `db:"modification_index"`
+	SellerName        string        `db:"seller_name"`
+	SellerAddress     string        `db:"seller_address"`
+	SellerTaxNumber   string        `db:"seller_tax_number"`
+	PartnerID         null.Int      `db:"partner_id"`
+	BuyerName         string        `db:"buyer_name"`
+	BuyerAddress      string        `db:"buyer_address"`
+	BuyerTaxNumber    string        `db:"buyer_tax_number"`
+	IssueDate         time.Time     `db:"issue_date"`
+	FulfilmentDate    time.Time     `db:"fulfilment_date"`
+	DueDate           time.Time     `db:"due_date"`
+	Currency          string        `db:"currency"`
+	PaymentMethod     string        `db:"payment_method"`
+	Rounding          string        `db:"rounding"`
+	GrossTotal        money.Decimal `db:"gross_total"`
+	ExchangeRate      money.Decimal `db:"exchange_rate"`
+	NAVTxID           null.String   `db:"nav_transaction_id"`
+	NAVStatus         string        `db:"nav_status"`
+	NAVMessage        string        `db:"nav_message"`
+	ChainPosition     null.Int      `db:"chain_position"`
+	PreviousHash      null.String   `db:"previous_hash"`
+	Hash              null.String   `db:"hash"`
+	CompanyID         uint32        `db:"company_id"`
+	UserID            uint32        `db:"user_id"`
+	CreatedAt         null.Time     `db:"created_at"`
+	UpdatedAt         null.Time     `db:"updated_at"`
+	DeletedAt         null.Time     `db:"deleted_at"`
+
+	Lines []Line `db:"-"`
+}
+
+// Line is a line of an invoice.
+//
+// CodeType is the classification (VTSZ for goods, SZJ for services) of Code.
+// Reference numbers the lines of the storno and modification invoices after
+// the lines of the original and its earlier corrections.
+type Line struct {
+	ID          uint32        `db:"id"`
+	InvoiceID   uint32        `db:"invoice_id"`
+	LineNumber  uint32        `db:"line_number"`
+	Reference   null.Int      `db:"line_number_reference"`
+	ProductID   null.Int      `db:"product_id"`
+	CodeType    string        `db:"code_type"`
+	Code        string        `db:"code"`
+	Description string        `db:"description"`
+	Quantity    money.Decimal `db:"quantity"`
+	Unit        string        `db:"unit"`
+	UnitPrice   money.Decimal `db:"unit_price"`
+	VATRate     string        `db:"vat_rate"`
+}
+
+// Validate checks the fields which the database cannot.
+func (item Item) Validate() error {
+	if item.SellerName == "" || item.BuyerName == "" {
+		return errors.New("seller and buyer name are required")
+	}
+	if len(item.Currency) != 3 {
+		return errors.Errorf("currency %q is not an ISO 4217 code", item.Currency)
+	}
+	switch item.PaymentMethod {
+	case PaymentTransfer, PaymentCash, PaymentCard, PaymentVoucher, PaymentOther:
+	default:
+		return errors.Errorf("unknown payment method %q", item.PaymentMethod)
+	}
+	if item.Rounding != RoundPerLine && item.Rounding != RoundPerRate {
+		return errors.Errorf("unknown rounding %q", item.Rounding)
+	}
+	if item.DueDate.Before(item.IssueDate) {
+		return errors.New("due date is before the issue date")
+	}
+	if len(item.Lines) == 0 {
+		return errors.New("invoice has no lines")
+	}
+	for i, line := range item.Lines {
+		if line.Description == "" {
+			return errors.Errorf("line %d: description is required", i+1)
+		}
+		if line.Quantity.IsZero() {
+			return errors.Errorf("line %d: quantity is zero", i+1)
+		}
+		if !ValidVATRate(line.VATRate) {
+			return errors.Errorf("line %d: unknown VAT rate %q", i+1, line.VATRate)
+		}
+	}
+	return nil
+}
+
+// VATRates lists the VAT rates in the order they are offered.
+var VATRates = []string{VAT27, VAT18, VAT5, VAT0, VATAAM, VATTAM, VATEU, VATATK}
+
+// ValidVATRate reports whether rate is one of VATRates.
+func ValidVATRate(rate string) bool {
+	for _, r := range VATRates {
+		if r == rate {
+			return true
+		}
+	}
+	return false
+}
+
+// Service defines the database connection.
+type Service struct {
+	DB    Connection
+	Actor audit.Actor // Who makes the changes, for the audit log
+}
+
+// As returns the service making the changes as the actor.
+func (s Service) As(actor audit.Actor) Service {
+	s.Actor = actor
+	return s
+}
+
+// Connection is an interface for making queries.
+type Connection interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Get(dest interface{}, query string, args ...interface{}) error
+	Select(dest interface{}, query string, args ...interface{}) error
+}
+
+// columns lists the header columns in the order of Item.
+const columns = `id, series_id, number, series_year, series_number, status,
+			kind, original_id, original_number, modification_index,
+			seller_name, seller_address, seller_tax_number,
+			partner_id, buyer_name, buyer_address, buyer_tax_number,
+			issue_date, fulfilment_date, due_date, currency, payment_method,
+			rounding, gross_total, exchange_rate, nav_transaction_id, nav_status, nav_message,
+			chain_position, previous_hash, hash,
+			company_id, user_id, created_at, updated_at, deleted_at`
+
+// ByID gets an item with its lines by ID.
+func (s Service) ByID(ID string, companyID string) (Item, bool, error) {
+	result := Item{}
+	qry := fmt.Sprintf(`
+		SELECT %s
+		FROM %q
+		WHERE id = $1
+			AND company_id = $2
+			AND deleted_at IS NULL
+		LIMIT 1
+		`, columns, table)
+	err := s.DB.Get(&result, qry, ID, companyID)
+	if err != nil {
+		return result, err == sql.ErrNoRows, errors.Wrap(err, qry)
+	}
+	result.Lines, err = s.lines(ID)
+	return result, false, err
+}
+
+// ByCompanyID gets all entities of a company, without their lines.
+func (s Service) ByCompanyID(companyID string) ([]Item, bool, error) {
+	var result []Item
+	qry := fmt.Sprintf(`
+		SELECT %s
+		FROM %q
+		WHERE company_id = $1
+			AND deleted_at IS NULL
+		ORDER BY issue_date DESC, id DESC
+		`, columns, table)
+	err := s.DB.Select(&result, qry, companyID)
+	return result, err == sql.ErrNoRows, errors.Wrap(err, qry)
+}
+
+// ByNAVStatus gets the items of all the companies reported to NAV with one
+// of the statuses, without their lines.
+func (s Service) ByNAVStatus(statuses ...string) ([]Item, bool, error) {
+	var result []Item
+	marks := make([]string, len(statuses))
+	args := make([]interface{}, len(statuses))
+	for i, status := range statuses {
+		marks[i], args[i] = fmt.Sprintf("$%d", i+1), status
+	}
+	qry := fmt.Sprintf(`
+		SELECT %s
+		FROM %q
+		WHERE nav_status IN (%s)
+			AND nav_transaction_id IS NOT NULL
+			AND deleted_at IS NULL
+		ORDER BY id
+		`, columns, table, strings.Join(marks, ", "))
+	err := s.DB.Select(&result, qry, args...)
+	return result, err == sql.ErrNoRows, errors.Wrap(err, qry)
+}
+
+// lines gets the lines of an invoice.
+func (s Service) lines(invoiceID string) ([]Line, error) {
+	var result []Line
+	qry := fmt.Sprintf(`
+		SELECT id, invoice_id, line_number, line_number_reference,
+			product_id, code_type, code,
+			description, quantity, unit, unit_price, vat_rate
+		FROM %q
+		WHERE invoice_id = $1
+		ORDER BY line_number
+		`, lineTable)
+	err := s.DB.Select(&result, qry, invoiceID)
+	return result, errors.Wrap(err, qry)
+}
+
+// Create adds a normal item with its lines as a draft, and returns the new
+// ID. It gets its number when it is issued.
+func (s Service) Create(item Item, userID string) (uint32, error) {
+	item.Number, item.Status = null.String{}, StatusDraft
+	item.SeriesYear, item.SeriesNumber = null.Int{}, null.Int{}
+	item.ExchangeRate = money.Decimal{}
+	item.Kind = KindNormal
+	item.OriginalID, item.OriginalNumber, item.ModificationIndex = null.Int{}, null.String{}, null.Int{}
+	for i := range item.Lines {
+		item.Lines[i].Reference = null.Int{}
+	}
+	return audit.TrackNew(s.DB, s.Actor.For(userID), table, func(tx transaction.Connection) (uint32, error) {
+		return Service{DB: tx}.create(item, userID)
+	})
+}
+
+// create inserts the invoice with its lines and records its first status
+// within the transaction of DB.
+func (s Service) create(item Item, userID string) (uint32, error) {
+	if _, err := s.checkSeries(item.SeriesID, item.CompanyID); err != nil {
+		return 0, err
+	}
+	var ID uint32
+	qry := fmt.Sprintf(`
+		INSERT INTO %q
+		(series_id, number, series_year, series_number, status,
+			kind, original_id, original_number, modification_index,
+			seller_name, seller_address, seller_tax_number,
+			partner_id, buyer_name, buyer_address, buyer_tax_number,
+			issue_date, fulfilment_date, due_date, currency, payment_method,
+			rounding, gross_total, exchange_rate, company_id, user_id)
+		VALUES
+		($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15,$16,$17,$18,$19,$20,$21,$22,$23,$24,$25,$26)
+		RETURNING id
+		`, table)
+	err := s.DB.Get(&ID, qry,
+		item.SeriesID, item.Number, item.SeriesYear, item.SeriesNumber, item.Status,
+		item.Kind, item.OriginalID, item.OriginalNumber, item.ModificationIndex,
+		item.SellerName, item.SellerAddress, item.SellerTaxNumber,
+		item.PartnerID, item.BuyerName, item.BuyerAddress, item.BuyerTaxNumber,
+		item.IssueDate, item.FulfilmentDate, item.DueDate,
+		item.Currency, item.PaymentMethod,
+		item.Rounding, item.Totals().Gross, rateValue(item.ExchangeRate), item.CompanyID, userID)
+	if err != nil {
+		return 0, errors.Wrap(err, qry)
+	}
+	if err = s.insertLines(ID, item.Lines); err != nil {
+		return 0, err
+	}
+	return ID, s.record(ID, null.String{}, item.Status, userID, "")
+}
+
+// checkSeries refuses the numbering series of the other companies, and
+// returns the series otherwise.
+func (s Service) checkSeries(seriesID uint32, companyID interface{}) (series.Item, error) {
+	sr, noRows, err := series.Service{DB: s.DB}.ByID(fmt.Sprint(seriesID), fmt.Sprint(companyID))
+	if noRows {
+		return sr, errors.Errorf("unknown series %d", seriesID)
+	}
+	return sr, err
+}
+
+// insertLines adds the lines to an invoice, numbering them from 1.
+func (s Service) insertLines(invoiceID uint32, lines []Line) error {
+	qry := fmt.Sprintf(`
+		INSERT INTO %q
+		(invoice_id, line_number, line_number_reference,
+			product_id, code_type, code,
+			description, quantity, unit, unit_price, vat_rate)
+		VALUES
+		($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11)
+		`, lineTable)
+	for i, line := range lines {
+		if _, err := s.DB.Exec(qry, invoiceID, i+1, line.Reference,
+			line.ProductID, line.CodeType, line.Code,
+			line.Description, line.Quantity, line.Unit, line.UnitPrice, line.VATRate,
+		); err != nil {
+			return errors.Wrap(err, qry)
+		}
+	}
+	return nil
+}
+
+// Update makes changes to an existing item and replaces its lines, all in one
+// transaction. Issued items are refused with ErrFinalized.
+func (s Service) Update(item Item, ID string, companyID string) (sql.Result, error) {
+	var result sql.Result
+	err := audit.Track(s.DB, s.Actor, audit.Update, table, ID, func(tx transaction.Connection) error {
+		var err error
+		result, err = Service{DB: tx}.update(item, ID, companyID)
+		return err
+	})
+	return result, err
+}
+
+// update changes the header and the lines within the transaction of DB.
+func (s Service) update(item Item, ID string, companyID string) (sql.Result, error) {
+	if err := s.editable(ID, companyID); err != nil {
+		return nil, err
+	}
+	if _, err := s.checkSeries(item.SeriesID, companyID); err != nil {
+		return nil, err
+	}
+	qry := fmt.Sprintf(`
+		UPDATE %q
+		SET seller_name = $1, seller_address = $2, seller_tax_number = $3,
+			partner_id = $4, buyer_name = $5, buyer_address = $6, buyer_tax_number = $7,
+			issue_date = $8, fulfilment_date = $9, due_date = $10,
+			currency = $11, payment_method = $12, rounding = $13,
+			series_id = $14, gross_total = $15, updated_at = NOW()
+		WHERE id = $16
+			AND company_id = $17
+			AND deleted_at IS NULL
+		`, table)
+	result, err := s.DB.Exec(qry,
+		item.SellerName, item.SellerAddress, item.SellerTaxNumber,
+		item.PartnerID, item.BuyerName, item.BuyerAddress, item.BuyerTaxNumber,
+		item.IssueDate, item.FulfilmentDate, item.DueDate,
+		item.Currency, item.PaymentMethod, item.Rounding,
+		item.SeriesID, item.Totals().Gross, ID, companyID)
+	if err != nil {
+		return result, errors.Wrap(err, qry)
+	}
+	// Leave the lines alone when no invoice matched
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		return result, errors.Wrap(err, qry)
+	}
+
+	qry = fmt.Sprintf(`DELETE FROM %q WHERE invoice_id = $1`, lineTable)
+	if _, err = s.DB.Exec(qry, ID); err != nil {
+		return result, errors.Wrap(err, qry)
+	}
+	var invoiceID uint32
+	if _, err = fmt.Sscan(ID, &invoiceID); err != nil {
+		return result, errors.Wrap(err, ID)
+	}
+	return result, s.insertLines(invoiceID, item.Lines)
+}
+
+// Printed counts a printing of an item and returns the number of the
+// printings before, so 0 means the original is printed and more a copy.
+func (s Service) Printed(ID string, companyID string) (int, error) {
+	var count int
+	err := audit.Track(s.DB, s.Actor, actionPrint, table, ID, func(tx transaction.Connection) error {
+		qry := fmt.Sprintf(`
+			UPDATE %q
+			SET print_count = print_count + 1
+			WHERE id = $1
+				AND company_id = $2
+				AND deleted_at IS NULL
+			RETURNING print_count - 1
+			`, table)
+		err := tx.Get(&count, qry, ID, companyID)
+		return errors.Wrap(err, qry)
+	})
+	return count, err
+}
+
+// SetNAVStatus records the state of the reporting of an item to NAV.
+func (s Service) SetNAVStatus(ID string, transactionID string, status string, message string) (sql.Result, error) {
+	var result sql.Result
+	err := audit.Track(s.DB, s.Actor, actionNAV, table, ID, func(tx transaction.Connection) error {
+		qry := fmt.Sprintf(`
+			UPDATE %q
+			SET nav_transaction_id = $1, nav_status = $2, nav_message = $3
+			WHERE id = $4
+			`, table)
+		var err error
+		result, err = tx.Exec(qry, transactionID, status, message, ID)
+		return errors.Wrap(err, qry)
+	})
+	return result, err
+}
+
+// editable locks an item for a change within the transaction of DB, and
+// refuses it with ErrFinalized once it is issued. A missing item is left to
+// the change, which affects no rows then.
+func (s Service) editable(ID string, companyID string) error {
+	item, noRows, err := s.Lock(ID, companyID)
+	if noRows {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if item.Finalized() {
+		return ErrFinalized
+	}
+	return nil
+}
+
+// DeleteHard removes an item with its lines. Issued items are refused with
+// ErrFinalized. The audit log keeps what they were.
+func (s Service) DeleteHard(ID string, companyID string) (sql.Result, error) {
+	var result sql.Result
+	err := audit.Track(s.DB, s.Actor, audit.Delete, table, ID, func(tx transaction.Connection) error {
+		if err := (Service{DB: tx}).editable(ID, companyID); err != nil {
+			return err
+		}
+		qry := fmt.Sprintf(`
+			DELETE FROM %q
+			WHERE id = $1
+				AND company_id = $2
+				AND deleted_at IS NULL
+			`, table)
+		var err error
+		result, err = tx.Exec(qry, ID, companyID)
+		return errors.Wrap(err, qry)
+	})
+	return result, err
+}
+
+// DeleteSoft marks an item as removed. Issued items are refused with
+// ErrFinalized.
+func (s Service) DeleteSoft(ID string, companyID string) (sql.Result, error) {
+	var result sql.Result
+	err := audit.Track(s.DB, s.Actor, audit.Delete, table, ID, func(tx transaction.Connection) error {
+		if err := (Service{DB: tx}).editable(ID, companyID); err != nil {
+			return err
+		}
+		qry := fmt.Sprintf(`
+			UPDATE %q
+			SET deleted_at = NOW()
+			WHERE id = $1
+				AND company_id = $2
+				AND deleted_at IS NULL
+			`, table)
+		var err error
+		result, err = tx.Exec(qry, ID, companyID)
+		return errors.Wrap(err, qry)
+	})
+	return result, err
+}
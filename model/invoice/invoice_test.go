@@ -0,0 +1,346 @@
+package invoice_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/UNO-SOFT/szamlazo/lib/money"
+	"github.com/UNO-SOFT/szamlazo/model/invoice"
+	"github.com/UNO-SOFT/szamlazo/model/series"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/lib/pq"
+	"gopkg.in/guregu/null.v3"
+)
+
+// TestValidate checks the rules the database cannot enforce.
+func TestValidate(t *testing.T) {
+	issued := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	valid := func() invoice.Item {
+		return invoice.Item{
+			SellerName:     "Seller Kft.",
+			BuyerName:      "Buyer Bt.",
+			IssueDate:      issued,
+			FulfilmentDate: issued,
+			DueDate:        issued.AddDate(0, 0, 8),
+			Currency:       "HUF",
+			PaymentMethod:  invoice.PaymentTransfer,
+			Rounding:       invoice.RoundPerLine,
+			Lines: []invoice.Line{{
+				Description: "Consulting",
+				Quantity:    money.MustParse("1"),
+				Unit:        "hour",
+				UnitPrice:   money.MustParse("10000"),
+				VATRate:     invoice.VAT27,
+			}},
+		}
+	}
+
+	if err := valid().Validate(); err != nil {
+		t.Error("valid invoice rejected:", err)
+	}
+
+	for name, modify := range map[string]func(*invoice.Item){
+		"no buyer":       func(item *invoice.Item) { item.BuyerName = "" },
+		"bad currency":   func(item *invoice.Item) { item.Currency = "FT" },
+		"bad payment":    func(item *invoice.Item) { item.PaymentMethod = "BARTER" },
+		"bad rounding":   func(item *invoice.Item) { item.Rounding = "" },
+		"zero quantity":  func(item *invoice.Item) { item.Lines[0].Quantity = money.Decimal{} },
+		"due too early":  func(item *invoice.Item) { item.DueDate = issued.AddDate(0, 0, -1) },
+		"no lines":       func(item *invoice.Item) { item.Lines = nil },
+		"bad VAT rate":   func(item *invoice.Item) { item.Lines[0].VATRate = "25" },
+		"no description": func(item *invoice.Item) { item.Lines[0].Description = "" },
+	} {
+		item := valid()
+		modify(&item)
+		if err := item.Validate(); err == nil {
+			t.Errorf("%s: invalid invoice accepted", name)
+		}
+	}
+}
+
+// TestTotals checks the rounding of the VAT per line and per rate.
+func TestTotals(t *testing.T) {
+	item := invoice.Item{
+		Currency: "HUF",
+		Rounding: invoice.RoundPerLine,
+		Lines: []invoice.Line{
+			{Quantity: money.MustParse("1"), UnitPrice: money.MustParse("101"), VATRate: invoice.VAT27},
+			{Quantity: money.MustParse("1"), UnitPrice: money.MustParse("101"), VATRate: invoice.VAT27},
+			{Quantity: money.MustParse("2.5"), UnitPrice: money.MustParse("999.99"), VATRate: invoice.VAT5},
+			{Quantity: money.MustParse("1"), UnitPrice: money.MustParse("5000"), VATRate: invoice.VATAAM},
+		},
+	}
+
+	// Lines: 101 + 27.27 → 27, twice; 2499.975 → 2500 + 125; 5000 + 0
+	totals := item.Totals()
+	check := func(name string, got money.Decimal, want string) {
+		if got.String() != want {
+			t.Errorf("%s: got %s want %s", name, got, want)
+		}
+	}
+	check("net", totals.Net, "7702")
+	check("VAT per line", totals.VAT, "179")
+	check("gross", totals.Gross, "7881")
+	if len(totals.ByRate) != 3 || totals.ByRate[0].Rate != invoice.VAT27 ||
+		totals.ByRate[1].Rate != invoice.VAT5 || totals.ByRate[2].Rate != invoice.VATAAM {
+		t.Fatalf("wrong VAT summary: %+v", totals.ByRate)
+	}
+	check("27% VAT per line", totals.ByRate[0].VAT, "54")
+
+	// 202 × 27% = 54.54 → 55
+	item.Rounding = invoice.RoundPerRate
+	totals = item.Totals()
+	check("27% VAT per rate", totals.ByRate[0].VAT, "55")
+	check("VAT per rate", totals.VAT, "180")
+
+	item.Currency = "EUR"
+	totals = item.Totals()
+	check("EUR net", totals.Net, "7701.98")
+	check("EUR VAT", totals.VAT, "179.54")
+}
+
+// TestStorno checks that a storno invoice cancels the original.
+func TestStorno(t *testing.T) {
+	issued := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	original := invoice.Item{
+		ID:             7,
+		Number:         null.StringFrom("SZ2026/00007"),
+		Kind:           invoice.KindNormal,
+		SellerName:     "Seller Kft.",
+		BuyerName:      "Buyer Bt.",
+		IssueDate:      issued,
+		FulfilmentDate: issued,
+		DueDate:        issued.AddDate(0, 0, 8),
+		Currency:       "HUF",
+		PaymentMethod:  invoice.PaymentTransfer,
+		Rounding:       invoice.RoundPerLine,
+		NAVStatus:      "DONE",
+		Lines: []invoice.Line{
+			{ID: 1, LineNumber: 1, Description: "Consulting", Quantity: money.MustParse("2.5"),
+				UnitPrice: money.MustParse("10000"), VATRate: invoice.VAT27},
+			{ID: 2, LineNumber: 2, Description: "Book", Quantity: money.MustParse("1"),
+				UnitPrice: money.MustParse("3999"), VATRate: invoice.VAT5},
+		},
+	}
+
+	storno := original.Storno(issued.AddDate(0, 0, 3))
+	if !storno.IsCorrection() || storno.Kind != invoice.KindStorno || storno.Finalized() {
+		t.Errorf("got kind %q, finalized %t", storno.Kind, storno.Finalized())
+	}
+	if storno.OriginalID.Int64 != 7 || storno.OriginalNumber.String != "SZ2026/00007" {
+		t.Errorf("got reference %v %v", storno.OriginalID, storno.OriginalNumber)
+	}
+	if storno.NAVStatus != "" || storno.Lines[0].ID != 0 {
+		t.Error("state of the original copied")
+	}
+	if err := storno.Validate(); err != nil {
+		t.Error(err)
+	}
+	want, got := original.Totals(), storno.Totals()
+	if got.Gross.Neg().Cmp(want.Gross) != 0 || got.VAT.Neg().Cmp(want.VAT) != 0 {
+		t.Errorf("got %s + %s, want the negative of %s + %s", got.Net, got.VAT, want.Net, want.VAT)
+	}
+	if original.Lines[0].Quantity.Sign() < 0 {
+		t.Error("original changed")
+	}
+}
+
+// TestCanTransition checks the lifecycle of the invoices.
+func TestCanTransition(t *testing.T) {
+	for _, tc := range []struct {
+		from, to string
+		want     bool
+	}{
+		{invoice.StatusDraft, invoice.StatusIssued, true},
+		{invoice.StatusDraft, invoice.StatusPaid, false},
+		{invoice.StatusDraft, invoice.StatusCancelled, false},
+		{invoice.StatusIssued, invoice.StatusSent, true},
+		{invoice.StatusIssued, invoice.StatusDraft, false},
+		{invoice.StatusSent, invoice.StatusIssued, false},
+		{invoice.StatusSent, invoice.StatusPartiallyPaid, true},
+		{invoice.StatusPartiallyPaid, invoice.StatusPaid, true},
+		{invoice.StatusPartiallyPaid, invoice.StatusSent, false},
+		{invoice.StatusOverdue, invoice.StatusPaid, true},
+		{invoice.StatusPaid, invoice.StatusOverdue, false},
+		{invoice.StatusPaid, invoice.StatusCancelled, true},
+		{invoice.StatusCancelled, invoice.StatusIssued, false},
+		{invoice.StatusCancelled, invoice.StatusPaid, false},
+		{"", invoice.StatusIssued, false},
+	} {
+		if got := invoice.CanTransition(tc.from, tc.to); got != tc.want {
+			t.Errorf("%q → %q: got %t want %t", tc.from, tc.to, got, tc.want)
+		}
+	}
+}
+
+// testDB connects to the PostgreSQL database named by SZAMLAZO_TEST_DB and
+// migrates a scratch schema there, with a user whose ID is returned. The
+// test is skipped without a database; done drops the schema.
+func testDB(t *testing.T) (db *sqlx.DB, userID string, done func()) {
+	dsn := os.Getenv("SZAMLAZO_TEST_DB")
+	if dsn == "" {
+		t.Skip("SZAMLAZO_TEST_DB is not set")
+	}
+	db, err := sqlx.Connect("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The search path belongs to the connection, so there must be only one.
+	db.SetMaxOpenConns(1)
+	schema := fmt.Sprintf("invoice_test_%d", os.Getpid())
+	db.MustExec("CREATE SCHEMA " + schema)
+	done = func() {
+		db.Exec("DROP SCHEMA " + schema + " CASCADE")
+		db.Close()
+	}
+	db.MustExec("SET search_path TO " + schema)
+
+	files, err := filepath.Glob("../../migration/postgresql/*.up.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(files)
+	for _, file := range files {
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = db.Exec(string(b)); err != nil {
+			done()
+			t.Fatalf("%s: %v", filepath.Base(file), err)
+		}
+	}
+
+	err = db.Get(&userID, `
+		INSERT INTO "user" (first_name, last_name, email, password)
+		VALUES ('John', 'Doe', 'jdoe@domain.com', '')
+		RETURNING id`)
+	if err != nil {
+		done()
+		t.Fatal(err)
+	}
+	return db, userID, done
+}
+
+// draft returns a draft of the first company, in its default series added
+// by the migrations.
+func draft(issued time.Time) invoice.Item {
+	return invoice.Item{
+		SeriesID:       1,
+		CompanyID:      1,
+		SellerName:     "Seller Kft.",
+		BuyerName:      "Buyer Bt.",
+		IssueDate:      issued,
+		FulfilmentDate: issued,
+		DueDate:        issued.AddDate(0, 0, 8),
+		Currency:       "HUF",
+		PaymentMethod:  invoice.PaymentTransfer,
+		Rounding:       invoice.RoundPerLine,
+		Lines: []invoice.Line{{
+			Description: "Consulting",
+			Quantity:    money.MustParse("2"),
+			Unit:        "hour",
+			UnitPrice:   money.MustParse("10000"),
+			VATRate:     invoice.VAT27,
+		}, {
+			Description: "Travel",
+			Quantity:    money.MustParse("1"),
+			Unit:        "pcs",
+			UnitPrice:   money.MustParse("5000"),
+			VATRate:     invoice.VATAAM,
+		}},
+	}
+}
+
+// TestCreate stores an invoice with its lines and reads it back.
+func TestCreate(t *testing.T) {
+	db, userID, done := testDB(t)
+	defer done()
+
+	item := draft(time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC))
+	s := invoice.Service{DB: db}
+	ID, err := s.Create(item, userID)
+	if err != nil {
+		t.Fatal("could not create record:", err)
+	}
+	got, _, err := s.ByID(fmt.Sprint(ID), "1")
+	if err != nil {
+		t.Fatal("could not retrieve record:", err)
+	}
+	if got.Status != invoice.StatusDraft || got.Number.Valid {
+		t.Errorf("got %s %q, want an unnumbered draft", got.Status, got.Number.String)
+	}
+	if len(got.Lines) != len(item.Lines) {
+		t.Fatalf("got %d lines, want %d", len(got.Lines), len(item.Lines))
+	}
+	for i, line := range got.Lines {
+		want := item.Lines[i]
+		if line.LineNumber != uint32(i+1) || line.Description != want.Description ||
+			line.Quantity.Cmp(want.Quantity) != 0 || line.UnitPrice.Cmp(want.UnitPrice) != 0 ||
+			line.VATRate != want.VATRate {
+			t.Errorf("line %d: got %+v want %+v", i+1, line, want)
+		}
+	}
+	if gross := money.MustParse("30400"); got.GrossTotal.Cmp(gross) != 0 {
+		t.Errorf("gross total: got %s want %s", got.GrossTotal, gross)
+	}
+}
+
+// TestVerify checks that a chain is intact after issuing, also when its
+// series formats its numbers differently since, and that losing its last
+// invoice is reported even though nothing follows it.
+func TestVerify(t *testing.T) {
+	db, userID, done := testDB(t)
+	defer done()
+
+	issued := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	s := invoice.Service{DB: db}
+	var IDs []string
+	for i := 0; i < 2; i++ {
+		ID, err := s.Create(draft(issued), userID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		IDs = append(IDs, fmt.Sprint(ID))
+		if err = s.Issue(IDs[i], "1", userID, issued); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	v, err := s.Verify(1, "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !v.Intact() || v.Checked != 2 {
+		t.Fatalf("got %d checked with breaks %v, want 2 intact", v.Checked, v.Breaks)
+	}
+
+	if _, err = (series.Service{DB: db}).Update(series.Item{Code: "default", Prefix: "NEW-", Padding: 3}, "1", "1"); err != nil {
+		t.Fatal(err)
+	}
+	if v, err = s.Verify(1, "1"); err != nil {
+		t.Fatal(err)
+	}
+	if !v.Intact() {
+		t.Fatalf("got breaks %v after changing the format, want intact", v.Breaks)
+	}
+
+	// Nothing follows the last invoice, so only the series can tell it is
+	// gone.
+	if _, err = db.Exec(`UPDATE invoice SET number = NULL WHERE id = $1`, IDs[1]); err != nil {
+		t.Fatal(err)
+	}
+	if v, err = s.Verify(1, "1"); err != nil {
+		t.Fatal(err)
+	}
+	if len(v.Breaks) != 1 || v.Breaks[0].ID != 0 {
+		t.Fatalf("got breaks %v, want the missing last invoice", v.Breaks)
+	}
+}
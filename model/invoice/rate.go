@@ -0,0 +1,43 @@
+package invoice
+
+import (
+	"github.com/UNO-SOFT/szamlazo/lib/money"
+	"github.com/UNO-SOFT/szamlazo/model/rate"
+
+	"github.com/pkg/errors"
+)
+
+// Foreign reports whether the invoice is in a currency other than the forint,
+// so its VAT is shown in forints too.
+func (item Item) Foreign() bool {
+	return item.Currency != "HUF"
+}
+
+// HUF converts an amount of the invoice to forints by its exchange rate,
+// rounded to the fillér. It is zero until the invoice is issued.
+func (item Item) HUF(amount money.Decimal) money.Decimal {
+	return amount.Mul(item.ExchangeRate).Round(2)
+}
+
+// exchangeRate gets the MNB rate of the currency of the invoice in force on
+// its fulfilment date, 1 for the forint. The rates are fetched into the store
+// before, see mnb.Ensure.
+func (s Service) exchangeRate(item Item) (money.Decimal, error) {
+	if !item.Foreign() {
+		return money.New(1, 0), nil
+	}
+	r, noRows, err := rate.Service{DB: s.DB}.On(item.Currency, item.FulfilmentDate)
+	if noRows {
+		return money.Decimal{}, errors.Errorf("no MNB exchange rate of %s on %s",
+			item.Currency, item.FulfilmentDate.Format("2006-01-02"))
+	}
+	return r.Rate, err
+}
+
+// rateValue returns the exchange rate to store, NULL until it is recorded.
+func rateValue(d money.Decimal) interface{} {
+	if d.IsZero() {
+		return nil
+	}
+	return d
+}
@@ -0,0 +1,241 @@
+package invoice
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/UNO-SOFT/szamlazo/lib/money"
+	"github.com/UNO-SOFT/szamlazo/model/audit"
+	"github.com/UNO-SOFT/szamlazo/model/series"
+	"github.com/UNO-SOFT/szamlazo/model/transaction"
+
+	"github.com/pkg/errors"
+
+	"gopkg.in/guregu/null.v3"
+)
+
+// ErrIssued is returned for issuing an invoice which is not a draft.
+var ErrIssued = errors.New("the invoice is already issued")
+
+var (
+	// transitionTable is the table name of the status changes.
+	transitionTable = "invoice_transition"
+)
+
+// Statuses of the invoices in their lifecycle.
+const (
+	StatusDraft         = "draft"          // Being prepared, without a number
+	StatusIssued        = "issued"         // Numbered, with legal effect
+	StatusSent          = "sent"           // Delivered to the buyer
+	StatusPartiallyPaid = "partially_paid" // Paid in part
+	StatusPaid          = "paid"           // Settled
+	StatusOverdue       = "overdue"        // Not settled by its due date
+	StatusCancelled     = "cancelled"      // Cancelled by a storno invoice
+)
+
+// transitions lists the statuses an invoice can move to from each status.
+var transitions = map[string][]string{
+	StatusDraft:         {StatusIssued},
+	StatusIssued:        {StatusSent, StatusPartiallyPaid, StatusPaid, StatusOverdue, StatusCancelled},
+	StatusSent:          {StatusPartiallyPaid, StatusPaid, StatusOverdue, StatusCancelled},
+	StatusPartiallyPaid: {StatusPaid, StatusOverdue, StatusCancelled},
+	StatusOverdue:       {StatusPartiallyPaid, StatusPaid, StatusCancelled},
+	StatusPaid:          {StatusCancelled},
+	StatusCancelled:     {},
+}
+
+// CanTransition reports whether an invoice can move from one status to the
+// other.
+func CanTransition(from, to string) bool {
+	for _, s := range transitions[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+// Transitions returns the statuses the item can move to.
+func (item Item) Transitions() []string {
+	return transitions[item.Status]
+}
+
+// label names the item in the messages: by its number, or its ID while it is
+// a draft.
+func (item Item) label() string {
+	if item.Number.Valid {
+		return item.Number.String
+	}
+	return fmt.Sprintf("draft #%d", item.ID)
+}
+
+// Transition is a recorded change of the status of an invoice. From is null
+// when the invoice was created, UserID when it was changed by the system.
+type Transition struct {
+	ID        uint32      `db:"id"`
+	InvoiceID uint32      `db:"invoice_id"`
+	From      null.String `db:"from_status"`
+	To        string      `db:"to_status"`
+	Note      string      `db:"note"`
+	UserID    null.Int    `db:"user_id"`
+	UserName  string      `db:"user_name"`
+	CreatedAt null.Time   `db:"created_at"`
+}
+
+// Lock gets the header of an item and locks it until the end of the
+// transaction of DB.
+func (s Service) Lock(ID interface{}, companyID interface{}) (Item, bool, error) {
+	result := Item{}
+	qry := fmt.Sprintf(`
+		SELECT %s
+		FROM %q
+		WHERE id = $1
+			AND company_id = $2
+			AND deleted_at IS NULL
+		FOR UPDATE
+		`, columns, table)
+	err := s.DB.Get(&result, qry, ID, companyID)
+	return result, err == sql.ErrNoRows, errors.Wrap(err, qry)
+}
+
+// Issue numbers a draft from its series as issued on the given day, records
+// the exchange rate of its fulfilment date, makes it final, and seals it into
+// the hash chain of the series.
+//
+// Everything happens in one transaction, so the number is given back to the
+// series when anything fails.
+func (s Service) Issue(ID string, companyID string, userID string, issued time.Time) error {
+	return audit.Track(s.DB, s.Actor.For(userID), actionIssue, table, ID, func(tx transaction.Connection) error {
+		ts := Service{DB: tx}
+		item, noRows, err := ts.Lock(ID, companyID)
+		if noRows {
+			return errors.Errorf("invoice %s not found", ID)
+		} else if err != nil {
+			return err
+		}
+		if item.Status != StatusDraft {
+			return ErrIssued
+		}
+		if item.Lines, err = ts.lines(ID); err != nil {
+			return err
+		}
+		item.IssueDate = issued
+		if err = item.Validate(); err != nil {
+			return err
+		}
+		exchangeRate, err := ts.exchangeRate(item)
+		if err != nil {
+			return err
+		}
+
+		number, year, n, err := series.Service{DB: tx}.Next(fmt.Sprint(item.SeriesID), issued)
+		if err != nil {
+			return err
+		}
+		qry := fmt.Sprintf(`
+			UPDATE %q
+			SET number = $1, series_year = $2, series_number = $3,
+				status = $4, issue_date = $5, exchange_rate = $6, updated_at = NOW()
+			WHERE id = $7
+			`, table)
+		if _, err = tx.Exec(qry, number, year, n, StatusIssued, issued, exchangeRate, ID); err != nil {
+			return errors.Wrap(err, qry)
+		}
+		if err = ts.seal(item.SeriesID); err != nil {
+			return err
+		}
+		return ts.record(item.ID, null.StringFrom(item.Status), StatusIssued, userID, number)
+	})
+}
+
+// SetStatus moves an item to another status, recording who did it and why.
+// Drafts are issued with Issue, and invoices are cancelled by a storno
+// invoice.
+func (s Service) SetStatus(ID string, status string, companyID string, userID string, note string, today time.Time) error {
+	switch status {
+	case StatusIssued:
+		return errors.New("issue the draft to number it")
+	case StatusCancelled:
+		return errors.New("cancel the invoice with a storno invoice")
+	}
+	return audit.Track(s.DB, s.Actor.For(userID), actionStatus, table, ID, func(tx transaction.Connection) error {
+		ts := Service{DB: tx}
+		item, noRows, err := ts.Lock(ID, companyID)
+		if noRows {
+			return errors.Errorf("invoice %s not found", ID)
+		} else if err != nil {
+			return err
+		}
+		if !CanTransition(item.Status, status) {
+			return errors.Errorf("invoice %s cannot move from %s to %s", item.label(), item.Status, status)
+		}
+		if status == StatusOverdue && !item.DueDate.Before(today) {
+			return errors.Errorf("invoice %s is due on %s", item.label(), item.DueDate.Format("2006-01-02"))
+		}
+		return ts.setStatus(item, status, userID, note)
+	})
+}
+
+// Settle moves an item locked by Lock to partially paid or paid, paid being
+// the sum of the payments allocated to it so far.
+func (s Service) Settle(item Item, paid money.Decimal, userID string, note string) error {
+	status := StatusPartiallyPaid
+	if paid.Cmp(item.GrossTotal) >= 0 {
+		status = StatusPaid
+	}
+	if item.Status == status {
+		return nil
+	}
+	if !CanTransition(item.Status, status) {
+		return errors.Errorf("invoice %s is %s, it cannot be paid", item.label(), item.Status)
+	}
+	return audit.Track(s.DB, s.Actor.For(userID), actionStatus, table, item.ID, func(tx transaction.Connection) error {
+		return Service{DB: tx}.setStatus(item, status, userID, note)
+	})
+}
+
+// setStatus changes the status of a locked item within the transaction of
+// DB.
+func (s Service) setStatus(item Item, status string, userID string, note string) error {
+	qry := fmt.Sprintf(`
+		UPDATE %q
+		SET status = $1, updated_at = NOW()
+		WHERE id = $2
+		`, table)
+	if _, err := s.DB.Exec(qry, status, item.ID); err != nil {
+		return errors.Wrap(err, qry)
+	}
+	return s.record(item.ID, null.StringFrom(item.Status), status, userID, note)
+}
+
+// record adds a change of status to the history of an invoice. An empty
+// userID stands for the system.
+func (s Service) record(invoiceID uint32, from null.String, to string, userID string, note string) error {
+	qry := fmt.Sprintf(`
+		INSERT INTO %q
+		(invoice_id, from_status, to_status, note, user_id)
+		VALUES
+		($1,$2,$3,$4,$5)
+		`, transitionTable)
+	_, err := s.DB.Exec(qry, invoiceID, from, to, note, null.NewString(userID, userID != ""))
+	return errors.Wrap(err, qry)
+}
+
+// History gets the changes of the status of an item, the first one first.
+func (s Service) History(ID string, companyID string) ([]Transition, bool, error) {
+	var result []Transition
+	qry := fmt.Sprintf(`
+		SELECT t.id, t.invoice_id, t.from_status, t.to_status, t.note, t.user_id,
+			COALESCE(u.first_name || ' ' || u.last_name, '') AS user_name,
+			t.created_at
+		FROM %q t
+		JOIN %q i ON i.id = t.invoice_id
+		LEFT JOIN "user" u ON u.id = t.user_id
+		WHERE t.invoice_id = $1
+			AND i.company_id = $2
+		ORDER BY t.id
+		`, transitionTable, table)
+	err := s.DB.Select(&result, qry, ID, companyID)
+	return result, err == sql.ErrNoRows, errors.Wrap(err, qry)
+}
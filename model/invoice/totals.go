@@ -0,0 +1,88 @@
+package invoice
+
+import (
+	"github.com/UNO-SOFT/szamlazo/lib/money"
+)
+
+// Rounding modes of the VAT.
+const (
+	RoundPerLine = "line" // VAT is rounded on every line and then summed
+	RoundPerRate = "rate" // VAT is rounded once on the net total of each rate
+)
+
+// VATPercent returns the VAT rate as a fraction, 0.27 for VAT27. Exempt and
+// out of scope supplies have zero VAT.
+func VATPercent(rate string) money.Decimal {
+	switch rate {
+	case VAT27:
+		return money.New(27, 2)
+	case VAT18:
+		return money.New(18, 2)
+	case VAT5:
+		return money.New(5, 2)
+	}
+	return money.Decimal{}
+}
+
+// Amounts are the net, VAT and gross amounts of a line or of a group of
+// lines.
+type Amounts struct {
+	Net   money.Decimal
+	VAT   money.Decimal
+	Gross money.Decimal
+}
+
+// add returns the sum of a and b.
+func (a Amounts) add(b Amounts) Amounts {
+	return Amounts{Net: a.Net.Add(b.Net), VAT: a.VAT.Add(b.VAT), Gross: a.Gross.Add(b.Gross)}
+}
+
+// RateAmounts are the amounts of the lines with the same VAT rate.
+type RateAmounts struct {
+	Rate string
+	Amounts
+}
+
+// Totals are the amounts of an invoice, in its currency.
+type Totals struct {
+	Amounts
+	Lines  []Amounts     // In the order of the lines
+	ByRate []RateAmounts // In the order of VATRates, only the rates used
+}
+
+// Amounts returns the amounts of the line, rounded to places.
+func (line Line) Amounts(places int) Amounts {
+	net := line.Quantity.Mul(line.UnitPrice).Round(places)
+	vat := net.Mul(VATPercent(line.VATRate)).Round(places)
+	return Amounts{Net: net, VAT: vat, Gross: net.Add(vat)}
+}
+
+// Totals computes the amounts of the lines, the VAT summary and the totals of
+// the invoice. Amounts are rounded to the places of the currency, the VAT as
+// set by Rounding.
+func (item Item) Totals() Totals {
+	places := money.Places(item.Currency)
+	t := Totals{Lines: make([]Amounts, len(item.Lines))}
+
+	byRate := make(map[string]Amounts)
+	for i, line := range item.Lines {
+		t.Lines[i] = line.Amounts(places)
+		byRate[line.VATRate] = byRate[line.VATRate].add(t.Lines[i])
+	}
+
+	zero := money.Decimal{}.Round(places)
+	t.Amounts = Amounts{Net: zero, VAT: zero, Gross: zero}
+	for _, rate := range VATRates {
+		a, ok := byRate[rate]
+		if !ok {
+			continue
+		}
+		if item.Rounding == RoundPerRate {
+			a.VAT = a.Net.Mul(VATPercent(rate)).Round(places)
+			a.Gross = a.Net.Add(a.VAT)
+		}
+		t.ByRate = append(t.ByRate, RateAmounts{Rate: rate, Amounts: a})
+		t.Amounts = t.Amounts.add(a)
+	}
+	return t
+}
@@ -0,0 +1,152 @@
+// Package job provides access to the job table in the database, the queue of
+// the background tasks.
+package job
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"gopkg.in/guregu/null.v3"
+)
+
+var (
+	// table is the table name.
+	table = "job"
+)
+
+// Statuses of the jobs.
+const (
+	StatusPending = "pending" // Waiting for its run_at
+	StatusRunning = "running" // Claimed by a worker
+	StatusDone    = "done"    // Finished successfully
+	StatusDead    = "dead"    // Failed too many times, kept for inspection
+)
+
+// Item defines the model.
+type Item struct {
+	ID          uint32    `db:"id"`
+	Kind        string    `db:"kind"`
+	Payload     []byte    `db:"payload"`
+	Status      string    `db:"status"`
+	Attempts    int       `db:"attempts"`
+	MaxAttempts int       `db:"max_attempts"`
+	RunAt       time.Time `db:"run_at"`
+	LockedAt    null.Time `db:"locked_at"`
+	LastError   string    `db:"last_error"`
+	CreatedAt   null.Time `db:"created_at"`
+	UpdatedAt   null.Time `db:"updated_at"`
+}
+
+// Service defines the database connection.
+type Service struct {
+	DB Connection
+}
+
+// Connection is an interface for making queries.
+type Connection interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Get(dest interface{}, query string, args ...interface{}) error
+	Select(dest interface{}, query string, args ...interface{}) error
+}
+
+// columns lists the columns in the order of Item.
+const columns = `id, kind, payload, status, attempts, max_attempts, run_at,
+			locked_at, last_error, created_at, updated_at`
+
+// Enqueue adds a job to be run at runAt.
+func (s Service) Enqueue(kind string, payload []byte, runAt time.Time, maxAttempts int) (uint32, error) {
+	var ID uint32
+	qry := fmt.Sprintf(`
+		INSERT INTO %q
+		(kind, payload, run_at, max_attempts)
+		VALUES
+		($1,$2,$3,$4)
+		RETURNING id
+		`, table)
+	err := s.DB.Get(&ID, qry, kind, payload, runAt, maxAttempts)
+	return ID, errors.Wrap(err, qry)
+}
+
+// Claim locks the next job due and counts its attempt. Jobs running for
+// longer than lockTimeout are taken to be abandoned by a stopped worker and
+// are claimed again. Concurrent workers skip each other's jobs.
+func (s Service) Claim(lockTimeout time.Duration) (Item, bool, error) {
+	result := Item{}
+	qry := fmt.Sprintf(`
+		UPDATE %q
+		SET status = $1, locked_at = NOW(), attempts = attempts + 1, updated_at = NOW()
+		WHERE id = (
+			SELECT id
+			FROM %q
+			WHERE status = $2 AND run_at <= NOW()
+				OR status = $1 AND locked_at < NOW() - $3 * INTERVAL '1 second'
+			ORDER BY run_at, id
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING %s
+		`, table, table, columns)
+	err := s.DB.Get(&result, qry, StatusRunning, StatusPending, lockTimeout.Seconds())
+	if err == sql.ErrNoRows {
+		return result, true, nil
+	}
+	return result, false, errors.Wrap(err, qry)
+}
+
+// Done marks a job as finished. The outcomes are recorded only for the
+// claim of the job they belong to, counted by its attempts: a job claimed
+// again meanwhile is not changed, and no row is affected.
+func (s Service) Done(ID uint32, attempts int) (sql.Result, error) {
+	qry := fmt.Sprintf(`
+		UPDATE %q
+		SET status = $1, locked_at = NULL, last_error = '', updated_at = NOW()
+		WHERE id = $2
+			AND status = $3
+			AND attempts = $4
+		`, table)
+	result, err := s.DB.Exec(qry, StatusDone, ID, StatusRunning, attempts)
+	return result, errors.Wrap(err, qry)
+}
+
+// Retry puts a failed job back to the queue to be run at runAt, see Done.
+func (s Service) Retry(ID uint32, attempts int, runAt time.Time, lastError string) (sql.Result, error) {
+	qry := fmt.Sprintf(`
+		UPDATE %q
+		SET status = $1, run_at = $2, locked_at = NULL, last_error = $3, updated_at = NOW()
+		WHERE id = $4
+			AND status = $5
+			AND attempts = $6
+		`, table)
+	result, err := s.DB.Exec(qry, StatusPending, runAt, lastError, ID, StatusRunning, attempts)
+	return result, errors.Wrap(err, qry)
+}
+
+// Bury moves a job which failed for the last time to the dead letters, see
+// Done.
+func (s Service) Bury(ID uint32, attempts int, lastError string) (sql.Result, error) {
+	qry := fmt.Sprintf(`
+		UPDATE %q
+		SET status = $1, locked_at = NULL, last_error = $2, updated_at = NOW()
+		WHERE id = $3
+			AND status = $4
+			AND attempts = $5
+		`, table)
+	result, err := s.DB.Exec(qry, StatusDead, lastError, ID, StatusRunning, attempts)
+	return result, errors.Wrap(err, qry)
+}
+
+// Dead gets the dead jobs, the latest first.
+func (s Service) Dead() ([]Item, bool, error) {
+	var result []Item
+	qry := fmt.Sprintf(`
+		SELECT %s
+		FROM %q
+		WHERE status = $1
+		ORDER BY updated_at DESC
+		`, columns, table)
+	err := s.DB.Select(&result, qry, StatusDead)
+	return result, err == sql.ErrNoRows, errors.Wrap(err, qry)
+}
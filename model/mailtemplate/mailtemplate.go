@@ -0,0 +1,336 @@
+// Package mailtemplate provides access to the mail_template table in the
+// database: the texts of the mails sending the invoices of a company and
+// reminding of the overdue ones, one per kind and language.
+package mailtemplate
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/UNO-SOFT/szamlazo/model/audit"
+	"github.com/UNO-SOFT/szamlazo/model/transaction"
+
+	"github.com/pkg/errors"
+
+	"gopkg.in/guregu/null.v3"
+)
+
+var (
+	// table is the table name.
+	table = "mail_template"
+)
+
+// Kinds of the mails.
+const (
+	KindInvoice       = "invoice"        // Sending an issued invoice
+	KindReminder      = "reminder"       // Reminding of an overdue invoice
+	KindFinalReminder = "final_reminder" // The last reminder
+)
+
+// Kinds lists the kinds of the mails.
+var Kinds = []string{KindInvoice, KindReminder, KindFinalReminder}
+
+// Item defines the model. Subject and Body are text/template templates of
+// Data, like "Számla {{.Number}}".
+type Item struct {
+	ID        uint32    `db:"id"`
+	CompanyID uint32    `db:"company_id"`
+	Kind      string    `db:"kind"`
+	Language  string    `db:"language"`
+	Subject   string    `db:"subject"`
+	Body      string    `db:"body"`
+	CreatedAt null.Time `db:"created_at"`
+	UpdatedAt null.Time `db:"updated_at"`
+	DeletedAt null.Time `db:"deleted_at"`
+}
+
+// Data are the fields of an invoice the templates can refer to. Balance and
+// DaysOverdue are set for the reminders.
+type Data struct {
+	Number      string
+	Seller      string
+	Buyer       string
+	IssueDate   string
+	DueDate     string
+	Total       string
+	Currency    string
+	Balance     string
+	DaysOverdue int
+}
+
+// Defaults are the texts, by kind and language, of the languages a company
+// has no template for.
+var Defaults = map[string]map[string]Item{
+	KindInvoice: {
+		"hu": {
+			Kind:     KindInvoice,
+			Language: "hu",
+			Subject:  "Számla {{.Number}} - {{.Seller}}",
+			Body: `Tisztelt {{.Buyer}}!
+
+Mellékelten küldjük a(z) {{.Number}} számú számlánkat {{.Total}} {{.Currency}} összegről.
+Fizetési határidő: {{.DueDate}}
+
+Üdvözlettel:
+{{.Seller}}
+`,
+		},
+		"en": {
+			Kind:     KindInvoice,
+			Language: "en",
+			Subject:  "Invoice {{.Number}} - {{.Seller}}",
+			Body: `Dear {{.Buyer}},
+
+Please find attached our invoice {{.Number}} of {{.Total}} {{.Currency}}.
+Due date: {{.DueDate}}
+
+Kind regards,
+{{.Seller}}
+`,
+		},
+	},
+	KindReminder: {
+		"hu": {
+			Kind:     KindReminder,
+			Language: "hu",
+			Subject:  "Fizetési emlékeztető: {{.Number}} - {{.Seller}}",
+			Body: `Tisztelt {{.Buyer}}!
+
+Nyilvántartásunk szerint a(z) {{.Number}} számú, {{.DueDate}} fizetési határidejű számlánkon még {{.Balance}} {{.Currency}} kiegyenlítetlen.
+Kérjük, rendezze a tartozást, vagy ha már megtette, tekintse levelünket tárgytalannak.
+A számlát ismét mellékeljük.
+
+Üdvözlettel:
+{{.Seller}}
+`,
+		},
+		"en": {
+			Kind:     KindReminder,
+			Language: "en",
+			Subject:  "Payment reminder: {{.Number}} - {{.Seller}}",
+			Body: `Dear {{.Buyer}},
+
+According to our records {{.Balance}} {{.Currency}} of our invoice {{.Number}}, due on {{.DueDate}}, is still unpaid.
+Please settle it, or disregard this letter if you already have.
+The invoice is attached again.
+
+Kind regards,
+{{.Seller}}
+`,
+		},
+	},
+	KindFinalReminder: {
+		"hu": {
+			Kind:     KindFinalReminder,
+			Language: "hu",
+			Subject:  "Utolsó fizetési felszólítás: {{.Number}} - {{.Seller}}",
+			Body: `Tisztelt {{.Buyer}}!
+
+A(z) {{.Number}} számú számlánk fizetési határideje ({{.DueDate}}) óta {{.DaysOverdue}} nap telt el, a fennálló tartozás {{.Balance}} {{.Currency}}.
+Felszólítjuk, hogy az összeget haladéktalanul fizesse meg, ellenkező esetben további lépéseket teszünk a követelés behajtására.
+A számlát ismét mellékeljük.
+
+Üdvözlettel:
+{{.Seller}}
+`,
+		},
+		"en": {
+			Kind:     KindFinalReminder,
+			Language: "en",
+			Subject:  "Final notice: {{.Number}} - {{.Seller}}",
+			Body: `Dear {{.Buyer}},
+
+Our invoice {{.Number}} is {{.DaysOverdue}} days past its due date of {{.DueDate}}, with {{.Balance}} {{.Currency}} outstanding.
+Please pay the amount without delay, otherwise we will take further steps to collect it.
+The invoice is attached again.
+
+Kind regards,
+{{.Seller}}
+`,
+		},
+	},
+}
+
+// fallback is the language of the default used for the languages without
+// one.
+const fallback = "en"
+
+// Default returns the default of a kind in a language, or in English.
+func Default(kind, language string) Item {
+	if d, ok := Defaults[kind][language]; ok {
+		return d
+	}
+	return Defaults[kind][fallback]
+}
+
+// sample fills the templates when they are checked.
+var sample = Data{"INV-0001", "Seller Kft.", "Buyer Bt.", "2026-10-17", "2026-10-25", "12 700", "HUF", "2 700", 15}
+
+// Render returns the subject and the body of a mail about the invoice.
+func (item Item) Render(d Data) (string, string, error) {
+	var subject, body bytes.Buffer
+	t, err := template.New("subject").Parse(item.Subject)
+	if err != nil {
+		return "", "", errors.Errorf("subject: %v", err)
+	}
+	if err = t.Execute(&subject, d); err != nil {
+		return "", "", errors.Errorf("subject: %v", err)
+	}
+	if t, err = template.New("body").Parse(item.Body); err != nil {
+		return "", "", errors.Errorf("body: %v", err)
+	}
+	if err = t.Execute(&body, d); err != nil {
+		return "", "", errors.Errorf("body: %v", err)
+	}
+	return strings.Join(strings.Fields(subject.String()), " "), body.String(), nil
+}
+
+// Normalize validates the item and rewrites the language in lower case. The
+// kind defaults to the invoice mail, the templates must render with the
+// fields of Data.
+func (item *Item) Normalize() error {
+	if item.Kind == "" {
+		item.Kind = KindInvoice
+	} else if _, ok := Defaults[item.Kind]; !ok {
+		return errors.Errorf("unknown kind of mail %q", item.Kind)
+	}
+	item.Language = strings.ToLower(strings.TrimSpace(item.Language))
+	if len(item.Language) != 2 {
+		return errors.Errorf("language %q is not an ISO 639-1 code", item.Language)
+	}
+	if strings.TrimSpace(item.Subject) == "" || strings.TrimSpace(item.Body) == "" {
+		return errors.New("subject and body are required")
+	}
+	_, _, err := item.Render(sample)
+	return err
+}
+
+// Service defines the database connection.
+type Service struct {
+	DB    Connection
+	Actor audit.Actor // Who makes the changes, for the audit log
+}
+
+// As returns the service making the changes as the actor.
+func (s Service) As(actor audit.Actor) Service {
+	s.Actor = actor
+	return s
+}
+
+// Connection is an interface for making queries.
+type Connection interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Get(dest interface{}, query string, args ...interface{}) error
+	Select(dest interface{}, query string, args ...interface{}) error
+}
+
+// columns lists the columns in the order of Item.
+const columns = `id, company_id, kind, language, subject, body,
+			created_at, updated_at, deleted_at`
+
+// ByID gets an item by ID.
+func (s Service) ByID(ID string, companyID string) (Item, bool, error) {
+	result := Item{}
+	qry := fmt.Sprintf(`
+		SELECT %s
+		FROM %q
+		WHERE id = $1
+			AND company_id = $2
+			AND deleted_at IS NULL
+		LIMIT 1
+		`, columns, table)
+	err := s.DB.Get(&result, qry, ID, companyID)
+	return result, err == sql.ErrNoRows, errors.Wrap(err, qry)
+}
+
+// ByCompanyID gets the templates of a company.
+func (s Service) ByCompanyID(companyID string) ([]Item, bool, error) {
+	var result []Item
+	qry := fmt.Sprintf(`
+		SELECT %s
+		FROM %q
+		WHERE company_id = $1
+			AND deleted_at IS NULL
+		ORDER BY kind, language
+		`, columns, table)
+	err := s.DB.Select(&result, qry, companyID)
+	return result, err == sql.ErrNoRows, errors.Wrap(err, qry)
+}
+
+// ForLanguage gets the template of a kind of a company in a language. The
+// companies without one get the default of the language, or the English one.
+func (s Service) ForLanguage(companyID interface{}, kind string, language string) (Item, error) {
+	result := Item{}
+	qry := fmt.Sprintf(`
+		SELECT %s
+		FROM %q
+		WHERE company_id = $1
+			AND kind = $2
+			AND language = $3
+			AND deleted_at IS NULL
+		LIMIT 1
+		`, columns, table)
+	err := s.DB.Get(&result, qry, companyID, kind, language)
+	if err == nil {
+		return result, nil
+	} else if err != sql.ErrNoRows {
+		return result, errors.Wrap(err, qry)
+	}
+	return Default(kind, language), nil
+}
+
+// Create adds an item and returns the new ID.
+func (s Service) Create(item Item) (uint32, error) {
+	return audit.TrackNew(s.DB, s.Actor, table, func(tx transaction.Connection) (uint32, error) {
+		var ID uint32
+		qry := fmt.Sprintf(`
+			INSERT INTO %q
+			(company_id, kind, language, subject, body)
+			VALUES
+			($1,$2,$3,$4,$5)
+			RETURNING id
+			`, table)
+		err := tx.Get(&ID, qry, item.CompanyID, item.Kind, item.Language, item.Subject, item.Body)
+		return ID, errors.Wrap(err, qry)
+	})
+}
+
+// Update makes changes to an existing item.
+func (s Service) Update(item Item, ID string, companyID string) (sql.Result, error) {
+	var result sql.Result
+	err := audit.Track(s.DB, s.Actor, audit.Update, table, ID, func(tx transaction.Connection) error {
+		qry := fmt.Sprintf(`
+			UPDATE %q
+			SET kind = $1, language = $2, subject = $3, body = $4, updated_at = NOW()
+			WHERE id = $5
+				AND company_id = $6
+				AND deleted_at IS NULL
+			`, table)
+		var err error
+		result, err = tx.Exec(qry, item.Kind, item.Language, item.Subject, item.Body, ID, companyID)
+		return errors.Wrap(err, qry)
+	})
+	return result, err
+}
+
+// DeleteSoft marks an item as removed, so the default of its language is
+// used again.
+func (s Service) DeleteSoft(ID string, companyID string) (sql.Result, error) {
+	var result sql.Result
+	err := audit.Track(s.DB, s.Actor, audit.Delete, table, ID, func(tx transaction.Connection) error {
+		qry := fmt.Sprintf(`
+			UPDATE %q
+			SET deleted_at = NOW()
+			WHERE id = $1
+				AND company_id = $2
+				AND deleted_at IS NULL
+			`, table)
+		var err error
+		result, err = tx.Exec(qry, ID, companyID)
+		return errors.Wrap(err, qry)
+	})
+	return result, err
+}
@@ -0,0 +1,47 @@
+package mailtemplate_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/UNO-SOFT/szamlazo/model/mailtemplate"
+)
+
+// TestRender checks the defaults and the validation of the templates.
+func TestRender(t *testing.T) {
+	d := mailtemplate.Data{Number: "INV-0001", Seller: "Seller Kft.", Buyer: "Buyer Bt.",
+		DueDate: "2026-10-25", Total: "12 700", Currency: "HUF", Balance: "2 700", DaysOverdue: 30}
+	for kind, languages := range mailtemplate.Defaults {
+		amount := "2 700 HUF"
+		if kind == mailtemplate.KindInvoice {
+			amount = "12 700 HUF"
+		}
+		for language, item := range languages {
+			subject, body, err := item.Render(d)
+			if err != nil {
+				t.Fatalf("%s/%s: %v", kind, language, err)
+			}
+			if !strings.Contains(subject, "INV-0001") || !strings.Contains(body, amount) {
+				t.Errorf("%s/%s: got %q and %q", kind, language, subject, body)
+			}
+			if err = item.Normalize(); err != nil {
+				t.Errorf("%s/%s: %v", kind, language, err)
+			}
+		}
+	}
+	if item := mailtemplate.Default(mailtemplate.KindReminder, "de"); item.Language != "en" {
+		t.Errorf("got the %q default for German", item.Language)
+	}
+
+	for name, item := range map[string]mailtemplate.Item{
+		"language":      {Language: "hun", Subject: "Számla", Body: "-"},
+		"kind":          {Kind: "letter", Language: "hu", Subject: "Számla", Body: "-"},
+		"no subject":    {Language: "hu", Subject: " ", Body: "-"},
+		"syntax":        {Language: "hu", Subject: "Számla {{.Number", Body: "-"},
+		"unknown field": {Language: "hu", Subject: "Számla", Body: "{{.Amount}}"},
+	} {
+		if err := item.Normalize(); err == nil {
+			t.Errorf("%s: accepted", name)
+		}
+	}
+}
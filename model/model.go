@@ -2,19 +2,125 @@
 package model
 
 import (
+	"github.com/UNO-SOFT/szamlazo/model/audit"
+	"github.com/UNO-SOFT/szamlazo/model/company"
+	"github.com/UNO-SOFT/szamlazo/model/invoice"
+	"github.com/UNO-SOFT/szamlazo/model/job"
+	"github.com/UNO-SOFT/szamlazo/model/mailtemplate"
 	"github.com/UNO-SOFT/szamlazo/model/note"
+	"github.com/UNO-SOFT/szamlazo/model/partner"
+	"github.com/UNO-SOFT/szamlazo/model/payment"
+	"github.com/UNO-SOFT/szamlazo/model/product"
+	"github.com/UNO-SOFT/szamlazo/model/rate"
+	"github.com/UNO-SOFT/szamlazo/model/recurring"
+	"github.com/UNO-SOFT/szamlazo/model/reminder"
+	"github.com/UNO-SOFT/szamlazo/model/role"
+	"github.com/UNO-SOFT/szamlazo/model/schedule"
+	"github.com/UNO-SOFT/szamlazo/model/sentmail"
+	"github.com/UNO-SOFT/szamlazo/model/series"
+	"github.com/UNO-SOFT/szamlazo/model/statement"
+	"github.com/UNO-SOFT/szamlazo/model/token"
+	"github.com/UNO-SOFT/szamlazo/model/transaction"
 	"github.com/UNO-SOFT/szamlazo/model/user"
 
 	"github.com/jmoiron/sqlx"
 )
 
 var (
-	Note note.Service // Note model
-	User user.Service // User model
+	Audit        audit.Service        // Audit log model
+	Company      company.Service      // Company model
+	Invoice      invoice.Service      // Invoice model
+	Job          job.Service          // Background job model
+	MailTemplate mailtemplate.Service // Invoice mail text model
+	Note         note.Service         // Note model
+	Partner      partner.Service      // Partner model
+	Payment      payment.Service      // Payment model
+	Product      product.Service      // Product model
+	Rate         rate.Service         // Exchange rate model
+	Recurring    recurring.Service    // Recurring invoice model
+	Reminder     reminder.Service     // Payment reminder model
+	Role         role.Service         // Role and permission model
+	Schedule     schedule.Service     // Periodic task run model
+	SentMail     sentmail.Service     // Sent mail log model
+	Series       series.Service       // Invoice number series model
+	Statement    statement.Service    // Bank statement model
+	Token        token.Service        // API token model
+	User         user.Service         // User model
+
+	db *sqlx.DB
 )
 
 // Load injects the dependencies for the models
-func Load(db *sqlx.DB) {
-	Note = note.Service{db}
-	User = user.Service{db}
+func Load(conn *sqlx.DB) {
+	db = conn
+	Audit = audit.Service{DB: db}
+	Company = company.Service{DB: db}
+	Invoice = invoice.Service{DB: db}
+	Job = job.Service{DB: db}
+	MailTemplate = mailtemplate.Service{DB: db}
+	Note = note.Service{DB: db}
+	Partner = partner.Service{DB: db}
+	Payment = payment.Service{DB: db}
+	Product = product.Service{DB: db}
+	Rate = rate.Service{DB: db}
+	Recurring = recurring.Service{DB: db}
+	Reminder = reminder.Service{DB: db}
+	Role = role.Service{DB: db}
+	Schedule = schedule.Service{DB: db}
+	SentMail = sentmail.Service{DB: db}
+	Series = series.Service{DB: db}
+	Statement = statement.Service{DB: db}
+	Token = token.Service{DB: db}
+	User = user.Service{DB: db}
+}
+
+// Tx holds the models bound to one transaction.
+type Tx struct {
+	Audit        audit.Service
+	Company      company.Service
+	Invoice      invoice.Service
+	Job          job.Service
+	MailTemplate mailtemplate.Service
+	Note         note.Service
+	Partner      partner.Service
+	Payment      payment.Service
+	Product      product.Service
+	Rate         rate.Service
+	Recurring    recurring.Service
+	Reminder     reminder.Service
+	Role         role.Service
+	Schedule     schedule.Service
+	SentMail     sentmail.Service
+	Series       series.Service
+	Statement    statement.Service
+	Token        token.Service
+	User         user.Service
+}
+
+// Transaction runs fn as a unit of work: the changes made through the models
+// of tx are committed when fn returns nil, and rolled back otherwise.
+func Transaction(fn func(tx Tx) error) error {
+	return transaction.Run(db, func(conn transaction.Connection) error {
+		return fn(Tx{
+			Audit:        audit.Service{DB: conn},
+			Company:      company.Service{DB: conn},
+			Invoice:      invoice.Service{DB: conn},
+			Job:          job.Service{DB: conn},
+			MailTemplate: mailtemplate.Service{DB: conn},
+			Note:         note.Service{DB: conn},
+			Partner:      partner.Service{DB: conn},
+			Payment:      payment.Service{DB: conn},
+			Product:      product.Service{DB: conn},
+			Rate:         rate.Service{DB: conn},
+			Recurring:    recurring.Service{DB: conn},
+			Reminder:     reminder.Service{DB: conn},
+			Role:         role.Service{DB: conn},
+			Schedule:     schedule.Service{DB: conn},
+			SentMail:     sentmail.Service{DB: conn},
+			Series:       series.Service{DB: conn},
+			Statement:    statement.Service{DB: conn},
+			Token:        token.Service{DB: conn},
+			User:         user.Service{DB: conn},
+		})
+	})
 }
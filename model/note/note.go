@@ -5,6 +5,9 @@ import (
 	"database/sql"
 	"fmt"
 
+	"github.com/UNO-SOFT/szamlazo/model/audit"
+	"github.com/UNO-SOFT/szamlazo/model/transaction"
+
 	"github.com/pkg/errors"
 
 	"gopkg.in/guregu/null.v3"
@@ -27,7 +30,14 @@ type Item struct {
 
 // Service defines the database connection.
 type Service struct {
-	DB Connection
+	DB    Connection
+	Actor audit.Actor // Who makes the changes, for the audit log
+}
+
+// As returns the service making the changes as the actor.
+func (s Service) As(actor audit.Actor) Service {
+	s.Actor = actor
+	return s
 }
 
 // Connection is an interface for making queries.
@@ -67,52 +77,70 @@ func (s Service) ByUserID(userID string) ([]Item, bool, error) {
 
 // Create adds an item.
 func (s Service) Create(name string, userID string) (sql.Result, error) {
-	qry := fmt.Sprintf(`
-		INSERT INTO %q
-		(name, user_id)
-		VALUES
-		($1,$2)
-		`, table)
-	result, err := s.DB.Exec(qry, name, userID)
-	return result, errors.Wrap(err, qry)
+	ID, err := audit.TrackNew(s.DB, s.Actor.For(userID), table, func(tx transaction.Connection) (uint32, error) {
+		var ID uint32
+		qry := fmt.Sprintf(`
+			INSERT INTO %q
+			(name, user_id)
+			VALUES
+			($1,$2)
+			RETURNING id
+			`, table)
+		err := tx.Get(&ID, qry, name, userID)
+		return ID, errors.Wrap(err, qry)
+	})
+	return audit.Created(ID), err
 }
 
 // Update makes changes to an existing item.
 func (s Service) Update(name string, ID string, userID string) (sql.Result, error) {
-	qry := fmt.Sprintf(`
-		UPDATE %q
-		SET name = $1
-		WHERE id = $2
-			AND user_id = $3
-			AND deleted_at IS NULL
-		LIMIT 1
-		`, table)
-	result, err := s.DB.Exec(qry, name, ID, userID)
-	return result, errors.Wrap(err, qry)
+	var result sql.Result
+	err := audit.Track(s.DB, s.Actor.For(userID), audit.Update, table, ID, func(tx transaction.Connection) error {
+		qry := fmt.Sprintf(`
+			UPDATE %q
+			SET name = $1, updated_at = NOW()
+			WHERE id = $2
+				AND user_id = $3
+				AND deleted_at IS NULL
+			`, table)
+		var err error
+		result, err = tx.Exec(qry, name, ID, userID)
+		return errors.Wrap(err, qry)
+	})
+	return result, err
 }
 
-// DeleteHard removes an item.
+// DeleteHard removes an item. The audit log keeps what it was.
 func (s Service) DeleteHard(ID string, userID string) (sql.Result, error) {
-	qry := fmt.Sprintf(`
-		DELETE FROM %q
-		WHERE id = $1
-			AND user_id = $2
-			AND deleted_at IS NULL
-		`, table)
-	result, err := s.DB.Exec(qry, ID, userID)
-	return result, errors.Wrap(err, qry)
+	var result sql.Result
+	err := audit.Track(s.DB, s.Actor.For(userID), audit.Delete, table, ID, func(tx transaction.Connection) error {
+		qry := fmt.Sprintf(`
+			DELETE FROM %q
+			WHERE id = $1
+				AND user_id = $2
+				AND deleted_at IS NULL
+			`, table)
+		var err error
+		result, err = tx.Exec(qry, ID, userID)
+		return errors.Wrap(err, qry)
+	})
+	return result, err
 }
 
 // DeleteSoft marks an item as removed.
 func (s Service) DeleteSoft(ID string, userID string) (sql.Result, error) {
-	qry := fmt.Sprintf(`
-		UPDATE %q
-		SET deleted_at = NOW()
-		WHERE id = $1
-			AND user_id = $2
-			AND deleted_at IS NULL
-		LIMIT 1
-		`, table)
-	result, err := s.DB.Exec(qry, ID, userID)
-	return result, errors.Wrap(err, qry)
+	var result sql.Result
+	err := audit.Track(s.DB, s.Actor.For(userID), audit.Delete, table, ID, func(tx transaction.Connection) error {
+		qry := fmt.Sprintf(`
+			UPDATE %q
+			SET deleted_at = NOW()
+			WHERE id = $1
+				AND user_id = $2
+				AND deleted_at IS NULL
+			`, table)
+		var err error
+		result, err = tx.Exec(qry, ID, userID)
+		return errors.Wrap(err, qry)
+	})
+	return result, err
 }
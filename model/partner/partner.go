@@ -0,0 +1,323 @@
+// Package partner provides access to the partner and partner_bank_account
+// tables in the database.
+package partner
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/UNO-SOFT/szamlazo/lib/mail"
+	"github.com/UNO-SOFT/szamlazo/lib/taxnumber"
+	"github.com/UNO-SOFT/szamlazo/model/audit"
+	"github.com/UNO-SOFT/szamlazo/model/transaction"
+
+	"github.com/pkg/errors"
+
+	"gopkg.in/guregu/null.v3"
+)
+
+var (
+	// table is the table name.
+	table = "partner"
+	// accountTable is the table name of the bank accounts.
+	accountTable = "partner_bank_account"
+)
+
+// DefaultLanguage is the language of the partners without one.
+const DefaultLanguage = "hu"
+
+// Item defines the model.
+//
+// The invoices of the partner are sent to Email, with copies to EmailCC and
+// blind copies to EmailBCC, all lists of addresses, in Language.
+type Item struct {
+	ID            uint32    `db:"id"`
+	Name          string    `db:"name"`
+	CountryCode   string    `db:"country_code"`
+	PostalCode    string    `db:"postal_code"`
+	City          string    `db:"city"`
+	Street        string    `db:"street"`
+	TaxNumber     string    `db:"tax_number"`
+	EUVATNumber   string    `db:"eu_vat_number"`
+	GroupID       string    `db:"group_id"`
+	Email         string    `db:"email"`
+	EmailCC       string    `db:"email_cc"`
+	EmailBCC      string    `db:"email_bcc"`
+	Language      string    `db:"language"`
+	DunningPaused bool      `db:"dunning_paused"` // No reminders of its overdue invoices
+	CompanyID     uint32    `db:"company_id"`
+	UserID        uint32    `db:"user_id"`
+	CreatedAt     null.Time `db:"created_at"`
+	UpdatedAt     null.Time `db:"updated_at"`
+	DeletedAt     null.Time `db:"deleted_at"`
+
+	BankAccounts []string `db:"-"`
+}
+
+// Address returns the address on one line, as printed on invoices.
+func (item Item) Address() string {
+	addr := strings.TrimSpace(item.PostalCode + " " + item.City)
+	if item.Street != "" {
+		addr += ", " + item.Street
+	}
+	if item.CountryCode != "" && item.CountryCode != "HU" {
+		addr += ", " + item.CountryCode
+	}
+	return addr
+}
+
+// Normalize validates the tax numbers and the mail addresses, and rewrites
+// them in their canonical form. Empty numbers are left alone.
+func (item *Item) Normalize() error {
+	if item.Name == "" {
+		return errors.New("name is required")
+	}
+	item.CountryCode = strings.ToUpper(strings.TrimSpace(item.CountryCode))
+	if len(item.CountryCode) != 2 {
+		return errors.Errorf("country code %q is not an ISO 3166 code", item.CountryCode)
+	}
+	if item.TaxNumber != "" {
+		n, err := taxnumber.Parse(item.TaxNumber)
+		if err != nil {
+			return err
+		}
+		item.TaxNumber = n.String()
+	}
+	if item.GroupID != "" {
+		n, err := taxnumber.ParseGroup(item.GroupID)
+		if err != nil {
+			return err
+		}
+		item.GroupID = n.String()
+	}
+	if item.EUVATNumber != "" {
+		n, err := taxnumber.ParseEU(item.EUVATNumber)
+		if err != nil {
+			return err
+		}
+		item.EUVATNumber = n
+	}
+	for _, list := range []*string{&item.Email, &item.EmailCC, &item.EmailBCC} {
+		addresses, err := mail.ParseList(*list)
+		if err != nil {
+			return err
+		}
+		*list = strings.Join(addresses, ", ")
+	}
+	item.Language = strings.ToLower(strings.TrimSpace(item.Language))
+	if item.Language == "" {
+		item.Language = DefaultLanguage
+	}
+	if len(item.Language) != 2 {
+		return errors.Errorf("language %q is not an ISO 639-1 code", item.Language)
+	}
+	accounts := item.BankAccounts[:0]
+	for _, a := range item.BankAccounts {
+		if a = strings.TrimSpace(a); a != "" {
+			accounts = append(accounts, a)
+		}
+	}
+	item.BankAccounts = accounts
+	return nil
+}
+
+// Service defines the database connection.
+type Service struct {
+	DB    Connection
+	Actor audit.Actor // Who makes the changes, for the audit log
+}
+
+// As returns the service making the changes as the actor.
+func (s Service) As(actor audit.Actor) Service {
+	s.Actor = actor
+	return s
+}
+
+// Connection is an interface for making queries.
+type Connection interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Get(dest interface{}, query string, args ...interface{}) error
+	Select(dest interface{}, query string, args ...interface{}) error
+}
+
+// columns lists the columns in the order of Item.
+const columns = `id, name, country_code, postal_code, city, street,
+			tax_number, eu_vat_number, group_id,
+			email, email_cc, email_bcc, language, dunning_paused,
+			company_id, user_id, created_at, updated_at, deleted_at`
+
+// ByID gets an item of a company with its bank accounts by ID.
+func (s Service) ByID(ID string, companyID string) (Item, bool, error) {
+	result := Item{}
+	qry := fmt.Sprintf(`
+		SELECT %s
+		FROM %q
+		WHERE id = $1
+			AND company_id = $2
+			AND deleted_at IS NULL
+		LIMIT 1
+		`, columns, table)
+	err := s.DB.Get(&result, qry, ID, companyID)
+	if err != nil {
+		return result, err == sql.ErrNoRows, errors.Wrap(err, qry)
+	}
+	qry = fmt.Sprintf(`
+		SELECT account_number
+		FROM %q
+		WHERE partner_id = $1
+		ORDER BY id
+		`, accountTable)
+	err = s.DB.Select(&result.BankAccounts, qry, ID)
+	return result, false, errors.Wrap(err, qry)
+}
+
+// ByCompanyID gets all entities of a company, without their bank accounts.
+func (s Service) ByCompanyID(companyID string) ([]Item, bool, error) {
+	var result []Item
+	qry := fmt.Sprintf(`
+		SELECT %s
+		FROM %q
+		WHERE company_id = $1
+			AND deleted_at IS NULL
+		ORDER BY name
+		`, columns, table)
+	err := s.DB.Select(&result, qry, companyID)
+	return result, err == sql.ErrNoRows, errors.Wrap(err, qry)
+}
+
+// Account is a bank account of a partner.
+type Account struct {
+	PartnerID uint32 `db:"partner_id"`
+	Number    string `db:"account_number"`
+}
+
+// Accounts gets the bank accounts of all the partners of a company.
+func (s Service) Accounts(companyID string) ([]Account, error) {
+	var result []Account
+	qry := fmt.Sprintf(`
+		SELECT a.partner_id, a.account_number
+		FROM %q a
+		JOIN %q p ON p.id = a.partner_id
+		WHERE p.company_id = $1
+			AND p.deleted_at IS NULL
+		ORDER BY a.id
+		`, accountTable, table)
+	err := s.DB.Select(&result, qry, companyID)
+	return result, errors.Wrap(err, qry)
+}
+
+// Create adds an item to its company with its bank accounts and returns the
+// new ID.
+func (s Service) Create(item Item, userID string) (uint32, error) {
+	return audit.TrackNew(s.DB, s.Actor.For(userID), table, func(tx transaction.Connection) (uint32, error) {
+		var ID uint32
+		qry := fmt.Sprintf(`
+			INSERT INTO %q
+			(name, country_code, postal_code, city, street,
+				tax_number, eu_vat_number, group_id,
+				email, email_cc, email_bcc, language, dunning_paused, company_id, user_id)
+			VALUES
+			($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15)
+			RETURNING id
+			`, table)
+		if err := tx.Get(&ID, qry,
+			item.Name, item.CountryCode, item.PostalCode, item.City, item.Street,
+			item.TaxNumber, item.EUVATNumber, item.GroupID,
+			item.Email, item.EmailCC, item.EmailBCC, item.Language, item.DunningPaused,
+			item.CompanyID, userID,
+		); err != nil {
+			return ID, errors.Wrap(err, qry)
+		}
+		return ID, insertAccounts(tx, ID, item.BankAccounts)
+	})
+}
+
+// insertAccounts adds the bank accounts to a partner.
+func insertAccounts(tx transaction.Connection, partnerID interface{}, accounts []string) error {
+	qry := fmt.Sprintf(`
+		INSERT INTO %q
+		(partner_id, account_number)
+		VALUES
+		($1,$2)
+		`, accountTable)
+	for _, a := range accounts {
+		if _, err := tx.Exec(qry, partnerID, a); err != nil {
+			return errors.Wrap(err, qry)
+		}
+	}
+	return nil
+}
+
+// Update makes changes to an existing item and replaces its bank accounts.
+func (s Service) Update(item Item, ID string, companyID string) (sql.Result, error) {
+	var result sql.Result
+	err := audit.Track(s.DB, s.Actor, audit.Update, table, ID, func(tx transaction.Connection) error {
+		qry := fmt.Sprintf(`
+			UPDATE %q
+			SET name = $1, country_code = $2, postal_code = $3, city = $4,
+				street = $5, tax_number = $6, eu_vat_number = $7, group_id = $8,
+				email = $9, email_cc = $10, email_bcc = $11, language = $12,
+				dunning_paused = $13, updated_at = NOW()
+			WHERE id = $14
+				AND company_id = $15
+				AND deleted_at IS NULL
+			`, table)
+		var err error
+		result, err = tx.Exec(qry,
+			item.Name, item.CountryCode, item.PostalCode, item.City,
+			item.Street, item.TaxNumber, item.EUVATNumber, item.GroupID,
+			item.Email, item.EmailCC, item.EmailBCC, item.Language,
+			item.DunningPaused, ID, companyID)
+		if err != nil {
+			return errors.Wrap(err, qry)
+		}
+		// Leave the accounts alone when no partner matched
+		if n, err := result.RowsAffected(); err != nil || n == 0 {
+			return errors.Wrap(err, qry)
+		}
+
+		qry = fmt.Sprintf(`DELETE FROM %q WHERE partner_id = $1`, accountTable)
+		if _, err = tx.Exec(qry, ID); err != nil {
+			return errors.Wrap(err, qry)
+		}
+		return insertAccounts(tx, ID, item.BankAccounts)
+	})
+	return result, err
+}
+
+// DeleteHard removes an item with its bank accounts. The audit log keeps
+// what they were.
+func (s Service) DeleteHard(ID string, companyID string) (sql.Result, error) {
+	var result sql.Result
+	err := audit.Track(s.DB, s.Actor, audit.Delete, table, ID, func(tx transaction.Connection) error {
+		qry := fmt.Sprintf(`
+			DELETE FROM %q
+			WHERE id = $1
+				AND company_id = $2
+				AND deleted_at IS NULL
+			`, table)
+		var err error
+		result, err = tx.Exec(qry, ID, companyID)
+		return errors.Wrap(err, qry)
+	})
+	return result, err
+}
+
+// DeleteSoft marks an item as removed.
+func (s Service) DeleteSoft(ID string, companyID string) (sql.Result, error) {
+	var result sql.Result
+	err := audit.Track(s.DB, s.Actor, audit.Delete, table, ID, func(tx transaction.Connection) error {
+		qry := fmt.Sprintf(`
+			UPDATE %q
+			SET deleted_at = NOW()
+			WHERE id = $1
+				AND company_id = $2
+				AND deleted_at IS NULL
+			`, table)
+		var err error
+		result, err = tx.Exec(qry, ID, companyID)
+		return errors.Wrap(err, qry)
+	})
+	return result, err
+}
@@ -0,0 +1,53 @@
+package partner_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/UNO-SOFT/szamlazo/model/partner"
+)
+
+// TestNormalize checks the validation and the canonical forms.
+func TestNormalize(t *testing.T) {
+	item := partner.Item{
+		Name:         "Buyer Kft.",
+		CountryCode:  "hu ",
+		PostalCode:   "1051",
+		City:         "Budapest",
+		Street:       "Fő utca 1.",
+		TaxNumber:    "10773381244",
+		EUVATNumber:  "hu10773381",
+		EmailCC:      "konyveles@buyer.hu;\n Pénzügy <penzugy@buyer.hu>",
+		BankAccounts: []string{" 11773016-12345678 ", "", "HU42117730161111101800000000"},
+	}
+	if err := item.Normalize(); err != nil {
+		t.Fatal(err)
+	}
+	want := partner.Item{
+		Name:         "Buyer Kft.",
+		CountryCode:  "HU",
+		PostalCode:   "1051",
+		City:         "Budapest",
+		Street:       "Fő utca 1.",
+		TaxNumber:    "10773381-2-44",
+		EUVATNumber:  "HU10773381",
+		EmailCC:      "konyveles@buyer.hu, Pénzügy <penzugy@buyer.hu>",
+		Language:     "hu",
+		BankAccounts: []string{"11773016-12345678", "HU42117730161111101800000000"},
+	}
+	if !reflect.DeepEqual(item, want) {
+		t.Errorf("got %+v want %+v", item, want)
+	}
+	if got, want := item.Address(), "1051 Budapest, Fő utca 1."; got != want {
+		t.Errorf("got address %q want %q", got, want)
+	}
+
+	item.TaxNumber = "10773382-2-44"
+	if err := item.Normalize(); err == nil {
+		t.Error("wrong check digit accepted")
+	}
+	item.TaxNumber, item.Email = "10773381-2-44", "buyer.hu"
+	if err := item.Normalize(); err == nil {
+		t.Error("invalid mail address accepted")
+	}
+}
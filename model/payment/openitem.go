@@ -0,0 +1,103 @@
+package payment
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/UNO-SOFT/szamlazo/lib/money"
+	"github.com/UNO-SOFT/szamlazo/model/invoice"
+
+	"github.com/pkg/errors"
+
+	"gopkg.in/guregu/null.v3"
+)
+
+// OpenItem is an issued invoice not paid in full. Modification invoices
+// lowering the amount show up with a negative balance. DebtorName is the
+// name of the partner now, BuyerName the one written on the invoice.
+type OpenItem struct {
+	InvoiceID  uint32        `db:"invoice_id"`
+	Number     string        `db:"number"`
+	Status     string        `db:"status"`
+	PartnerID  null.Int      `db:"partner_id"`
+	DebtorName string        `db:"debtor_name"`
+	BuyerName  string        `db:"buyer_name"`
+	IssueDate  time.Time     `db:"issue_date"`
+	DueDate    time.Time     `db:"due_date"`
+	Currency   string        `db:"currency"`
+	Total      money.Decimal `db:"gross_total"`
+	Paid       money.Decimal `db:"paid"`
+}
+
+// Balance returns the amount still due.
+func (o OpenItem) Balance() money.Decimal {
+	return o.Total.Sub(o.Paid)
+}
+
+// Debtor sums the open items of a partner in one currency, under the current
+// name of the partner. Buyers not in the registry are told apart by their
+// name.
+type Debtor struct {
+	PartnerID null.Int
+	Name      string
+	Currency  string
+	Balance   money.Decimal
+	Overdue   money.Decimal // Balance of the items due before today
+	Items     []OpenItem
+}
+
+// OpenItems gets the open items of a company by debtor, in the order of their
+// names, each by due date.
+func (s Service) OpenItems(companyID string, today time.Time) ([]Debtor, error) {
+	var items []OpenItem
+	qry := fmt.Sprintf(`
+		SELECT i.id AS invoice_id, i.number, i.status, i.partner_id,
+			COALESCE(p.name, i.buyer_name) AS debtor_name, i.buyer_name,
+			i.issue_date, i.due_date, i.currency, i.gross_total,
+			COALESCE(SUM(a.amount), 0) AS paid
+		FROM %q i
+		LEFT JOIN %q p ON p.id = i.partner_id
+		LEFT JOIN %q a ON a.invoice_id = i.id
+		WHERE i.company_id = $1
+			AND i.deleted_at IS NULL
+			AND i.status IN ($2, $3, $4, $5)
+			AND i.kind <> $6
+		GROUP BY i.id, p.id
+		HAVING i.gross_total <> COALESCE(SUM(a.amount), 0)
+		ORDER BY i.partner_id, CASE WHEN i.partner_id IS NULL THEN i.buyer_name END,
+			i.currency, i.due_date, i.id
+		`, invoiceTable, partnerTable, allocationTable)
+	err := s.DB.Select(&items, qry, companyID,
+		invoice.StatusIssued, invoice.StatusSent, invoice.StatusPartiallyPaid, invoice.StatusOverdue,
+		invoice.KindStorno)
+	if err != nil {
+		return nil, errors.Wrap(err, qry)
+	}
+	return debtors(items, today), nil
+}
+
+// debtors groups the open items, ordered by partner and the buyers without
+// one by name, by debtor and currency, and orders the debtors by name.
+func debtors(items []OpenItem, today time.Time) []Debtor {
+	var result []Debtor
+	for _, o := range items {
+		n := len(result) - 1
+		if n < 0 || result[n].Currency != o.Currency ||
+			result[n].PartnerID != o.PartnerID ||
+			!o.PartnerID.Valid && result[n].Name != o.DebtorName {
+			result = append(result, Debtor{PartnerID: o.PartnerID, Name: o.DebtorName, Currency: o.Currency})
+			n++
+		}
+		d := &result[n]
+		d.Balance = d.Balance.Add(o.Balance())
+		if o.DueDate.Before(today) {
+			d.Overdue = d.Overdue.Add(o.Balance())
+		}
+		d.Items = append(d.Items, o)
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
@@ -0,0 +1,319 @@
+// Package payment provides access to the payment and payment_allocation
+// tables in the database.
+package payment
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/UNO-SOFT/szamlazo/lib/money"
+	"github.com/UNO-SOFT/szamlazo/model/audit"
+	"github.com/UNO-SOFT/szamlazo/model/invoice"
+	"github.com/UNO-SOFT/szamlazo/model/transaction"
+
+	"github.com/pkg/errors"
+
+	"gopkg.in/guregu/null.v3"
+)
+
+// actionAllocate is the action of the audit log allocating a payment.
+const actionAllocate = "allocate"
+
+// RefusedError is an allocation refused for a reason of the request, like
+// more than what is due on the invoice.
+type RefusedError struct {
+	Reason string
+}
+
+// Error returns the reason.
+func (e RefusedError) Error() string {
+	return e.Reason
+}
+
+// refused returns a RefusedError with the formatted reason.
+func refused(format string, args ...interface{}) error {
+	return RefusedError{Reason: fmt.Sprintf(format, args...)}
+}
+
+var (
+	// table is the table name.
+	table = "payment"
+	// allocationTable is the table name of the parts of the payments paid
+	// for the invoices.
+	allocationTable = "payment_allocation"
+	// invoiceTable is the table name of the invoices.
+	invoiceTable = "invoice"
+	// partnerTable is the table name of the partners, whose current names
+	// the debtors are listed by.
+	partnerTable = "partner"
+)
+
+// Item defines the model. Allocated is the part of the amount allocated to
+// invoices so far.
+type Item struct {
+	ID            uint32        `db:"id"`
+	PartnerID     null.Int      `db:"partner_id"`
+	PayerName     string        `db:"payer_name"`
+	Date          time.Time     `db:"payment_date"`
+	Amount        money.Decimal `db:"amount"`
+	Currency      string        `db:"currency"`
+	Method        string        `db:"method"`
+	BankReference string        `db:"bank_reference"`
+	Allocated     money.Decimal `db:"allocated"`
+	CompanyID     uint32        `db:"company_id"`
+	UserID        uint32        `db:"user_id"`
+	CreatedAt     null.Time     `db:"created_at"`
+
+	Allocations []Allocation `db:"-"`
+}
+
+// Allocation is the part of a payment paid for an invoice. The number of the
+// invoice and the date and reference of the payment are read with it.
+type Allocation struct {
+	ID            uint32        `db:"id"`
+	PaymentID     uint32        `db:"payment_id"`
+	InvoiceID     uint32        `db:"invoice_id"`
+	Amount        money.Decimal `db:"amount"`
+	InvoiceNumber null.String   `db:"invoice_number"`
+	PaymentDate   time.Time     `db:"payment_date"`
+	BankReference string        `db:"bank_reference"`
+	CreatedAt     null.Time     `db:"created_at"`
+}
+
+// Unallocated returns the part of the amount not allocated to any invoice.
+func (item Item) Unallocated() money.Decimal {
+	return item.Amount.Sub(item.Allocated)
+}
+
+// Validate checks the fields which the database cannot, and that the
+// allocations fit in the amount.
+func (item Item) Validate() error {
+	if item.Amount.Sign() <= 0 {
+		return errors.New("amount must be positive")
+	}
+	if len(item.Currency) != 3 {
+		return errors.Errorf("currency %q is not an ISO 4217 code", item.Currency)
+	}
+	switch item.Method {
+	case invoice.PaymentTransfer, invoice.PaymentCash, invoice.PaymentCard,
+		invoice.PaymentVoucher, invoice.PaymentOther:
+	default:
+		return errors.Errorf("unknown payment method %q", item.Method)
+	}
+	if item.Date.IsZero() {
+		return errors.New("payment date is required")
+	}
+	return fits(item.Unallocated(), item.Allocations)
+}
+
+// fits checks that the allocations are positive and their sum is not more
+// than the amount left.
+func fits(left money.Decimal, allocations []Allocation) error {
+	sum := money.Decimal{}
+	for i, a := range allocations {
+		if a.Amount.Sign() <= 0 {
+			return refused("allocation %d: amount must be positive", i+1)
+		}
+		sum = sum.Add(a.Amount)
+	}
+	if sum.Cmp(left) > 0 {
+		return refused("the allocations add up to %s, only %s is left of the payment", sum, left)
+	}
+	return nil
+}
+
+// Service defines the database connection.
+type Service struct {
+	DB    Connection
+	Actor audit.Actor // Who makes the changes, for the audit log
+}
+
+// As returns the service making the changes as the actor.
+func (s Service) As(actor audit.Actor) Service {
+	s.Actor = actor
+	return s
+}
+
+// Connection is an interface for making queries.
+type Connection interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Get(dest interface{}, query string, args ...interface{}) error
+	Select(dest interface{}, query string, args ...interface{}) error
+}
+
+// columns lists the columns in the order of Item.
+const columns = `p.id, p.partner_id, p.payer_name,
+			p.payment_date, p.amount, p.currency, p.method, p.bank_reference,
+			COALESCE((SELECT SUM(a.amount) FROM payment_allocation a WHERE a.payment_id = p.id), 0) AS allocated,
+			p.company_id, p.user_id, p.created_at`
+
+// allocationColumns lists the columns in the order of Allocation.
+const allocationColumns = `a.id, a.payment_id, a.invoice_id, a.amount,
+			i.number AS invoice_number, p.payment_date, p.bank_reference,
+			a.created_at`
+
+// ByID gets an item with its allocations by ID.
+func (s Service) ByID(ID string, companyID string) (Item, bool, error) {
+	result := Item{}
+	qry := fmt.Sprintf(`
+		SELECT %s
+		FROM %q p
+		WHERE p.id = $1
+			AND p.company_id = $2
+		LIMIT 1
+		`, columns, table)
+	err := s.DB.Get(&result, qry, ID, companyID)
+	if err != nil {
+		return result, err == sql.ErrNoRows, errors.Wrap(err, qry)
+	}
+	qry = fmt.Sprintf(`
+		SELECT %s
+		FROM %q a
+		JOIN %q p ON p.id = a.payment_id
+		JOIN %q i ON i.id = a.invoice_id
+		WHERE a.payment_id = $1
+		ORDER BY a.id
+		`, allocationColumns, allocationTable, table, invoiceTable)
+	err = s.DB.Select(&result.Allocations, qry, ID)
+	return result, false, errors.Wrap(err, qry)
+}
+
+// ByCompanyID gets all entities of a company, without their allocations,
+// the latest first.
+func (s Service) ByCompanyID(companyID string) ([]Item, bool, error) {
+	var result []Item
+	qry := fmt.Sprintf(`
+		SELECT %s
+		FROM %q p
+		WHERE p.company_id = $1
+		ORDER BY p.payment_date DESC, p.id DESC
+		`, columns, table)
+	err := s.DB.Select(&result, qry, companyID)
+	return result, err == sql.ErrNoRows, errors.Wrap(err, qry)
+}
+
+// ByInvoice gets the allocations paid for an invoice.
+func (s Service) ByInvoice(invoiceID string, companyID string) ([]Allocation, bool, error) {
+	var result []Allocation
+	qry := fmt.Sprintf(`
+		SELECT %s
+		FROM %q a
+		JOIN %q p ON p.id = a.payment_id
+		JOIN %q i ON i.id = a.invoice_id
+		WHERE a.invoice_id = $1
+			AND p.company_id = $2
+		ORDER BY p.payment_date, a.id
+		`, allocationColumns, allocationTable, table, invoiceTable)
+	err := s.DB.Select(&result, qry, invoiceID, companyID)
+	return result, err == sql.ErrNoRows, errors.Wrap(err, qry)
+}
+
+// Create adds an item with its allocations, and returns the new ID. The
+// invoices paid in full or in part change their status.
+func (s Service) Create(item Item, userID string) (uint32, error) {
+	if err := item.Validate(); err != nil {
+		return 0, err
+	}
+	return audit.TrackNew(s.DB, s.Actor.For(userID), table, func(tx transaction.Connection) (uint32, error) {
+		var ID uint32
+		qry := fmt.Sprintf(`
+			INSERT INTO %q
+			(partner_id, payer_name, payment_date, amount, currency, method,
+				bank_reference, company_id, user_id)
+			VALUES
+			($1,$2,$3,$4,$5,$6,$7,$8,$9)
+			RETURNING id
+			`, table)
+		if err := tx.Get(&ID, qry,
+			item.PartnerID, item.PayerName, item.Date, item.Amount, item.Currency, item.Method,
+			item.BankReference, item.CompanyID, userID,
+		); err != nil {
+			return ID, errors.Wrap(err, qry)
+		}
+		item.ID = ID
+		ts := Service{DB: tx, Actor: s.Actor}
+		for _, a := range item.Allocations {
+			if err := ts.allocate(item, a, userID); err != nil {
+				return ID, err
+			}
+		}
+		return ID, nil
+	})
+}
+
+// Allocate allocates the unallocated part of a payment to invoices.
+func (s Service) Allocate(ID string, allocations []Allocation, companyID string, userID string) error {
+	return audit.Track(s.DB, s.Actor.For(userID), actionAllocate, table, ID, func(tx transaction.Connection) error {
+		item := Item{}
+		qry := fmt.Sprintf(`
+			SELECT %s
+			FROM %q p
+			WHERE p.id = $1
+				AND p.company_id = $2
+			FOR UPDATE
+			`, columns, table)
+		if err := tx.Get(&item, qry, ID, companyID); err == sql.ErrNoRows {
+			return refused("payment %s not found", ID)
+		} else if err != nil {
+			return errors.Wrap(err, qry)
+		}
+		if err := fits(item.Unallocated(), allocations); err != nil {
+			return err
+		}
+		ts := Service{DB: tx, Actor: s.Actor}
+		for _, a := range allocations {
+			if err := ts.allocate(item, a, userID); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// allocate pays a part of the payment for an invoice within the transaction
+// of DB, and settles the invoice. The invoice is locked, so its balance
+// cannot change meanwhile.
+func (s Service) allocate(item Item, a Allocation, userID string) error {
+	inv, noRows, err := invoice.Service{DB: s.DB}.Lock(a.InvoiceID, item.CompanyID)
+	if noRows {
+		return refused("invoice %d not found", a.InvoiceID)
+	} else if err != nil {
+		return err
+	}
+	if !invoice.CanTransition(inv.Status, invoice.StatusPartiallyPaid) &&
+		!invoice.CanTransition(inv.Status, invoice.StatusPaid) {
+		return refused("invoice %d is %s, it cannot be paid", inv.ID, inv.Status)
+	}
+	if inv.Currency != item.Currency {
+		return refused("invoice %s is in %s, the payment in %s", inv.Number.String, inv.Currency, item.Currency)
+	}
+
+	var paid money.Decimal
+	qry := fmt.Sprintf(`
+		SELECT COALESCE(SUM(amount), 0)
+		FROM %q
+		WHERE invoice_id = $1
+		`, allocationTable)
+	if err = s.DB.Get(&paid, qry, inv.ID); err != nil {
+		return errors.Wrap(err, qry)
+	}
+	if balance := inv.GrossTotal.Sub(paid); a.Amount.Cmp(balance) > 0 {
+		return refused("only %s %s is due on invoice %s", balance, inv.Currency, inv.Number.String)
+	}
+
+	qry = fmt.Sprintf(`
+		INSERT INTO %q
+		(payment_id, invoice_id, amount)
+		VALUES
+		($1,$2,$3)
+		`, allocationTable)
+	if _, err = s.DB.Exec(qry, item.ID, inv.ID, a.Amount); err != nil {
+		return errors.Wrap(err, qry)
+	}
+	note := fmt.Sprintf("%s %s paid on %s", a.Amount, item.Currency, item.Date.Format("2006-01-02"))
+	if item.BankReference != "" {
+		note += ", " + item.BankReference
+	}
+	return invoice.Service{DB: s.DB, Actor: s.Actor}.Settle(inv, paid.Add(a.Amount), userID, note)
+}
@@ -0,0 +1,96 @@
+package payment
+
+import (
+	"testing"
+	"time"
+
+	"github.com/UNO-SOFT/szamlazo/lib/money"
+	"github.com/UNO-SOFT/szamlazo/model/invoice"
+
+	"gopkg.in/guregu/null.v3"
+)
+
+// TestValidate checks that the allocations fit in the payment.
+func TestValidate(t *testing.T) {
+	valid := func() Item {
+		return Item{
+			Date:     time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC),
+			Amount:   money.MustParse("12700"),
+			Currency: "HUF",
+			Method:   invoice.PaymentTransfer,
+			Allocations: []Allocation{
+				{InvoiceID: 1, Amount: money.MustParse("10000")},
+				{InvoiceID: 2, Amount: money.MustParse("2700")},
+			},
+		}
+	}
+
+	if err := valid().Validate(); err != nil {
+		t.Error("valid payment rejected:", err)
+	}
+
+	for name, modify := range map[string]func(*Item){
+		"zero amount":      func(p *Item) { p.Amount = money.Decimal{} },
+		"bad currency":     func(p *Item) { p.Currency = "Ft" },
+		"unknown method":   func(p *Item) { p.Method = "CHEQUE" },
+		"no date":          func(p *Item) { p.Date = time.Time{} },
+		"negative part":    func(p *Item) { p.Allocations[1].Amount = money.MustParse("-1") },
+		"more than amount": func(p *Item) { p.Allocations[1].Amount = money.MustParse("2701") },
+	} {
+		p := valid()
+		modify(&p)
+		if err := p.Validate(); err == nil {
+			t.Errorf("%s: accepted", name)
+		}
+	}
+}
+
+// TestDebtors checks the grouping of the open items, coming ordered by
+// partner, under the current names of the partners.
+func TestDebtors(t *testing.T) {
+	today := time.Date(2026, 10, 17, 0, 0, 0, 0, time.UTC)
+	item := func(partnerID int64, name, currency string, due time.Time, total, paid string) OpenItem {
+		return OpenItem{
+			PartnerID:  null.NewInt(partnerID, partnerID != 0),
+			DebtorName: name,
+			BuyerName:  name,
+			Currency:   currency,
+			DueDate:    due,
+			Total:      money.MustParse(total),
+			Paid:       money.MustParse(paid),
+		}
+	}
+	items := []OpenItem{
+		item(1, "Alfa Kft.", "EUR", today, "100.00", "0"),
+		item(1, "Alfa Kft.", "HUF", today.AddDate(0, 0, -10), "12700", "2700"),
+		item(1, "Alfa Kft.", "HUF", today, "5000", "0"),
+		item(2, "Aladár Bt.", "HUF", today, "3000", "0"),
+		item(0, "Béla", "HUF", today, "1000", "0"),
+		item(0, "Cecil", "HUF", today.AddDate(0, 0, -1), "2000", "500"),
+	}
+	// Issued before the partner was renamed.
+	items[1].BuyerName = "Alfa Bt."
+
+	got := debtors(items, today)
+	want := []struct {
+		name, currency, balance, overdue string
+		items                            int
+	}{
+		{"Aladár Bt.", "HUF", "3000", "0", 1},
+		{"Alfa Kft.", "EUR", "100", "0", 1},
+		{"Alfa Kft.", "HUF", "15000", "10000", 2},
+		{"Béla", "HUF", "1000", "0", 1},
+		{"Cecil", "HUF", "1500", "1500", 1},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d debtors, wanted %d: %+v", len(got), len(want), got)
+	}
+	for i, w := range want {
+		d := got[i]
+		if d.Name != w.name || d.Currency != w.currency || len(d.Items) != w.items ||
+			d.Balance.Cmp(money.MustParse(w.balance)) != 0 || d.Overdue.Cmp(money.MustParse(w.overdue)) != 0 {
+			t.Errorf("%d. got %s %s %s (overdue %s) with %d items, wanted %+v",
+				i, d.Name, d.Currency, d.Balance, d.Overdue, len(d.Items), w)
+		}
+	}
+}
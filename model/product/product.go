@@ -0,0 +1,219 @@
+// Package product provides access to the product table in the database,
+// the catalogue of the goods and services sold.
+package product
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/UNO-SOFT/szamlazo/lib/money"
+	"github.com/UNO-SOFT/szamlazo/model/audit"
+	"github.com/UNO-SOFT/szamlazo/model/invoice"
+	"github.com/UNO-SOFT/szamlazo/model/transaction"
+
+	"github.com/pkg/errors"
+
+	"gopkg.in/guregu/null.v3"
+)
+
+var (
+	// table is the table name.
+	table = "product"
+)
+
+// Classification code types.
+const (
+	CodeVTSZ = "VTSZ" // Customs tariff number of goods
+	CodeSZJ  = "SZJ"  // Services nomenclature number
+	CodeOwn  = "OWN"  // Own product code
+)
+
+// Item defines the model.
+type Item struct {
+	ID        uint32        `db:"id"`
+	SKU       string        `db:"sku"`
+	Name      string        `db:"name"`
+	Unit      string        `db:"unit"`
+	UnitPrice money.Decimal `db:"unit_price"`
+	VATRate   string        `db:"vat_rate"`
+	CodeType  string        `db:"code_type"`
+	Code      string        `db:"code"`
+	CompanyID uint32        `db:"company_id"`
+	UserID    uint32        `db:"user_id"`
+	CreatedAt null.Time     `db:"created_at"`
+	UpdatedAt null.Time     `db:"updated_at"`
+	DeletedAt null.Time     `db:"deleted_at"`
+}
+
+// Validate checks the fields which the database cannot.
+func (item Item) Validate() error {
+	if strings.TrimSpace(item.SKU) == "" || item.Name == "" || item.Unit == "" {
+		return errors.New("SKU, name and unit are required")
+	}
+	if item.UnitPrice.Sign() < 0 {
+		return errors.New("unit price cannot be negative")
+	}
+	if !invoice.ValidVATRate(item.VATRate) {
+		return errors.Errorf("unknown VAT rate %q", item.VATRate)
+	}
+	switch item.CodeType {
+	case "":
+		if item.Code != "" {
+			return errors.New("code type is required with a code")
+		}
+	case CodeVTSZ, CodeSZJ, CodeOwn:
+		if item.Code == "" {
+			return errors.Errorf("%s code is missing", item.CodeType)
+		}
+	default:
+		return errors.Errorf("unknown code type %q", item.CodeType)
+	}
+	return nil
+}
+
+// Fill prefills the empty fields of an invoice line from the product. The
+// unit price is only taken unless the line is priced, as a price of 0 may well
+// be meant.
+func (item Item) Fill(line *invoice.Line, priced bool) {
+	line.ProductID.SetValid(int64(item.ID))
+	if line.Description == "" {
+		line.Description = item.Name
+	}
+	if line.Unit == "" {
+		line.Unit = item.Unit
+	}
+	if !priced {
+		line.UnitPrice = item.UnitPrice
+	}
+	if line.VATRate == "" {
+		line.VATRate = item.VATRate
+	}
+	if line.CodeType == "" && line.Code == "" {
+		line.CodeType, line.Code = item.CodeType, item.Code
+	}
+}
+
+// Service defines the database connection.
+type Service struct {
+	DB    Connection
+	Actor audit.Actor // Who makes the changes, for the audit log
+}
+
+// As returns the service making the changes as the actor.
+func (s Service) As(actor audit.Actor) Service {
+	s.Actor = actor
+	return s
+}
+
+// Connection is an interface for making queries.
+type Connection interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Get(dest interface{}, query string, args ...interface{}) error
+	Select(dest interface{}, query string, args ...interface{}) error
+}
+
+// columns lists the columns in the order of Item.
+const columns = `id, sku, name, unit, unit_price, vat_rate, code_type, code,
+			company_id, user_id, created_at, updated_at, deleted_at`
+
+// ByID gets an item of a company by ID.
+func (s Service) ByID(ID string, companyID string) (Item, bool, error) {
+	result := Item{}
+	qry := fmt.Sprintf(`
+		SELECT %s
+		FROM %q
+		WHERE id = $1
+			AND company_id = $2
+			AND deleted_at IS NULL
+		LIMIT 1
+		`, columns, table)
+	err := s.DB.Get(&result, qry, ID, companyID)
+	return result, err == sql.ErrNoRows, errors.Wrap(err, qry)
+}
+
+// ByCompanyID gets all entities of a company.
+func (s Service) ByCompanyID(companyID string) ([]Item, bool, error) {
+	var result []Item
+	qry := fmt.Sprintf(`
+		SELECT %s
+		FROM %q
+		WHERE company_id = $1
+			AND deleted_at IS NULL
+		ORDER BY name
+		`, columns, table)
+	err := s.DB.Select(&result, qry, companyID)
+	return result, err == sql.ErrNoRows, errors.Wrap(err, qry)
+}
+
+// Create adds an item to its company, and returns the new ID.
+func (s Service) Create(item Item, userID string) (uint32, error) {
+	return audit.TrackNew(s.DB, s.Actor.For(userID), table, func(tx transaction.Connection) (uint32, error) {
+		var ID uint32
+		qry := fmt.Sprintf(`
+			INSERT INTO %q
+			(sku, name, unit, unit_price, vat_rate, code_type, code, company_id, user_id)
+			VALUES
+			($1,$2,$3,$4,$5,$6,$7,$8,$9)
+			RETURNING id
+			`, table)
+		err := tx.Get(&ID, qry, strings.TrimSpace(item.SKU), item.Name, item.Unit,
+			item.UnitPrice, item.VATRate, item.CodeType, item.Code, item.CompanyID, userID)
+		return ID, errors.Wrap(err, qry)
+	})
+}
+
+// Update makes changes to an existing item.
+func (s Service) Update(item Item, ID string, companyID string) (sql.Result, error) {
+	var result sql.Result
+	err := audit.Track(s.DB, s.Actor, audit.Update, table, ID, func(tx transaction.Connection) error {
+		qry := fmt.Sprintf(`
+			UPDATE %q
+			SET sku = $1, name = $2, unit = $3, unit_price = $4, vat_rate = $5,
+				code_type = $6, code = $7, updated_at = NOW()
+			WHERE id = $8
+				AND company_id = $9
+				AND deleted_at IS NULL
+			`, table)
+		var err error
+		result, err = tx.Exec(qry, strings.TrimSpace(item.SKU), item.Name, item.Unit,
+			item.UnitPrice, item.VATRate, item.CodeType, item.Code, ID, companyID)
+		return errors.Wrap(err, qry)
+	})
+	return result, err
+}
+
+// DeleteHard removes an item. The audit log keeps what it was.
+func (s Service) DeleteHard(ID string, companyID string) (sql.Result, error) {
+	var result sql.Result
+	err := audit.Track(s.DB, s.Actor, audit.Delete, table, ID, func(tx transaction.Connection) error {
+		qry := fmt.Sprintf(`
+			DELETE FROM %q
+			WHERE id = $1
+				AND company_id = $2
+				AND deleted_at IS NULL
+			`, table)
+		var err error
+		result, err = tx.Exec(qry, ID, companyID)
+		return errors.Wrap(err, qry)
+	})
+	return result, err
+}
+
+// DeleteSoft marks an item as removed.
+func (s Service) DeleteSoft(ID string, companyID string) (sql.Result, error) {
+	var result sql.Result
+	err := audit.Track(s.DB, s.Actor, audit.Delete, table, ID, func(tx transaction.Connection) error {
+		qry := fmt.Sprintf(`
+			UPDATE %q
+			SET deleted_at = NOW()
+			WHERE id = $1
+				AND company_id = $2
+				AND deleted_at IS NULL
+			`, table)
+		var err error
+		result, err = tx.Exec(qry, ID, companyID)
+		return errors.Wrap(err, qry)
+	})
+	return result, err
+}
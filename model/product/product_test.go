@@ -0,0 +1,60 @@
+package product_test
+
+import (
+	"testing"
+
+	"github.com/UNO-SOFT/szamlazo/lib/money"
+	"github.com/UNO-SOFT/szamlazo/model/invoice"
+	"github.com/UNO-SOFT/szamlazo/model/product"
+)
+
+// TestFill checks that only the empty fields of a line are prefilled.
+func TestFill(t *testing.T) {
+	p := product.Item{
+		ID:        7,
+		SKU:       "CONS-1",
+		Name:      "Consulting",
+		Unit:      "hour",
+		UnitPrice: money.MustParse("15000"),
+		VATRate:   invoice.VAT27,
+		CodeType:  product.CodeSZJ,
+		Code:      "72.20",
+	}
+	if err := p.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	quantity, price := money.MustParse("2"), money.MustParse("12000")
+	line := invoice.Line{Quantity: quantity, UnitPrice: price}
+	p.Fill(&line, true)
+	want := invoice.Line{
+		Quantity:    quantity,
+		UnitPrice:   price,
+		Description: "Consulting",
+		Unit:        "hour",
+		VATRate:     invoice.VAT27,
+		CodeType:    product.CodeSZJ,
+		Code:        "72.20",
+	}
+	want.ProductID.SetValid(7)
+	if line != want {
+		t.Errorf("got %+v want %+v", line, want)
+	}
+
+	line = invoice.Line{Quantity: quantity}
+	p.Fill(&line, false)
+	if line.UnitPrice.Cmp(p.UnitPrice) != 0 {
+		t.Errorf("unit price: got %s want %s", line.UnitPrice, p.UnitPrice)
+	}
+
+	line = invoice.Line{Quantity: quantity}
+	p.Fill(&line, true)
+	if !line.UnitPrice.IsZero() {
+		t.Errorf("free line priced at %s", line.UnitPrice)
+	}
+
+	p.Code = ""
+	if err := p.Validate(); err == nil {
+		t.Error("SZJ without a code accepted")
+	}
+}
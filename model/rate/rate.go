@@ -0,0 +1,93 @@
+// Package rate provides access to the exchange_rate table in the database,
+// the store of the MNB exchange rates.
+package rate
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/UNO-SOFT/szamlazo/lib/mnb"
+	"github.com/UNO-SOFT/szamlazo/lib/money"
+	"github.com/UNO-SOFT/szamlazo/model/transaction"
+
+	"github.com/pkg/errors"
+
+	"gopkg.in/guregu/null.v3"
+)
+
+var (
+	// table is the table name.
+	table = "exchange_rate"
+)
+
+// Item defines the model.
+type Item struct {
+	ID        uint32        `db:"id"`
+	Currency  string        `db:"currency"`
+	Date      time.Time     `db:"rate_date"`
+	Rate      money.Decimal `db:"rate"`
+	CreatedAt null.Time     `db:"created_at"`
+	UpdatedAt null.Time     `db:"updated_at"`
+}
+
+// Service defines the database connection.
+type Service struct {
+	DB Connection
+}
+
+// Connection is an interface for making queries.
+type Connection interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Get(dest interface{}, query string, args ...interface{}) error
+	Select(dest interface{}, query string, args ...interface{}) error
+}
+
+// On gets the rate of the currency in force on the day: the latest one
+// published on or before it.
+func (s Service) On(currency string, day time.Time) (mnb.Rate, bool, error) {
+	result := Item{}
+	qry := fmt.Sprintf(`
+		SELECT id, currency, rate_date, rate, created_at, updated_at
+		FROM %q
+		WHERE currency = $1
+			AND rate_date <= $2
+		ORDER BY rate_date DESC
+		LIMIT 1
+		`, table)
+	err := s.DB.Get(&result, qry, currency, day)
+	return mnb.Rate{Date: result.Date, Currency: result.Currency, Rate: result.Rate},
+		err == sql.ErrNoRows, errors.Wrap(err, qry)
+}
+
+// Latest gets the last rate of each currency stored.
+func (s Service) Latest() ([]Item, bool, error) {
+	var result []Item
+	qry := fmt.Sprintf(`
+		SELECT DISTINCT ON (currency) id, currency, rate_date, rate, created_at, updated_at
+		FROM %q
+		ORDER BY currency, rate_date DESC
+		`, table)
+	err := s.DB.Select(&result, qry)
+	return result, err == sql.ErrNoRows, errors.Wrap(err, qry)
+}
+
+// Save stores the rates, replacing the ones of the same currency and day.
+func (s Service) Save(rates []mnb.Rate) error {
+	qry := fmt.Sprintf(`
+		INSERT INTO %q
+		(currency, rate_date, rate)
+		VALUES
+		($1,$2,$3)
+		ON CONFLICT (currency, rate_date)
+		DO UPDATE SET rate = EXCLUDED.rate, updated_at = NOW()
+		`, table)
+	return transaction.Run(s.DB, func(tx transaction.Connection) error {
+		for _, r := range rates {
+			if _, err := tx.Exec(qry, r.Currency, r.Date, r.Rate); err != nil {
+				return errors.Wrap(err, qry)
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,448 @@
+// Package recurring provides access to the recurring_invoice tables in the
+// database: the templates the invoices of the subscriptions are generated
+// from, one on each date of their schedule.
+package recurring
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/UNO-SOFT/szamlazo/model/audit"
+	"github.com/UNO-SOFT/szamlazo/model/invoice"
+	"github.com/UNO-SOFT/szamlazo/model/transaction"
+
+	"github.com/pkg/errors"
+
+	"gopkg.in/guregu/null.v3"
+)
+
+var (
+	// table is the table name.
+	table = "recurring_invoice"
+	// lineTable is the table name of the template lines.
+	lineTable = "recurring_invoice_line"
+	// runTable is the table name of the generated invoices.
+	runTable = "recurring_invoice_run"
+)
+
+// Frequencies of the schedules.
+const (
+	FrequencyMonthly   = "monthly"
+	FrequencyQuarterly = "quarterly"
+	FrequencyYearly    = "yearly"
+)
+
+// Frequencies lists the frequencies in the order they are offered.
+var Frequencies = []string{FrequencyMonthly, FrequencyQuarterly, FrequencyYearly}
+
+// months is the number of months between the dates of a frequency.
+var months = map[string]int{
+	FrequencyMonthly:   1,
+	FrequencyQuarterly: 3,
+	FrequencyYearly:    12,
+}
+
+// Item defines the model.
+//
+// An invoice is generated on DayOfMonth of every month, every third month or
+// every twelfth month from the month of StartDate, the last day of the
+// shorter months, until EndDate if any. NextDate is the date of the next
+// one, null when the schedule is over. The invoices are drafts unless
+// AutoIssue is set, and the issued ones are sent to the partner when
+// SendEmail is set.
+type Item struct {
+	ID            uint32    `db:"id"`
+	CompanyID     uint32    `db:"company_id"`
+	UserID        uint32    `db:"user_id"`
+	Name          string    `db:"name"`
+	SeriesID      uint32    `db:"series_id"`
+	PartnerID     uint32    `db:"partner_id"`
+	PartnerName   string    `db:"partner_name"`
+	Currency      string    `db:"currency"`
+	PaymentMethod string    `db:"payment_method"`
+	Rounding      string    `db:"rounding"`
+	PaymentDays   int       `db:"payment_days"`
+	Frequency     string    `db:"frequency"`
+	DayOfMonth    int       `db:"day_of_month"`
+	StartDate     time.Time `db:"start_date"`
+	EndDate       null.Time `db:"end_date"`
+	NextDate      null.Time `db:"next_date"`
+	AutoIssue     bool      `db:"auto_issue"`
+	SendEmail     bool      `db:"send_email"`
+	LastError     string    `db:"last_error"`
+	CreatedAt     null.Time `db:"created_at"`
+	UpdatedAt     null.Time `db:"updated_at"`
+	DeletedAt     null.Time `db:"deleted_at"`
+
+	Lines []invoice.Line `db:"-"`
+}
+
+// Run is an invoice generated of a template.
+type Run struct {
+	Occurrence    time.Time   `db:"occurrence"`
+	InvoiceID     null.Int    `db:"invoice_id"`
+	InvoiceNumber null.String `db:"invoice_number"`
+	InvoiceStatus null.String `db:"invoice_status"`
+	CreatedAt     null.Time   `db:"created_at"`
+}
+
+// Normalize validates the item and rewrites the currency in upper case. The
+// lines are checked as the ones of an invoice.
+func (item *Item) Normalize() error {
+	item.Name = strings.TrimSpace(item.Name)
+	if item.Name == "" {
+		return errors.New("name is required")
+	}
+	if _, ok := months[item.Frequency]; !ok {
+		return errors.Errorf("unknown frequency %q", item.Frequency)
+	}
+	if item.DayOfMonth < 1 || item.DayOfMonth > 31 {
+		return errors.Errorf("day of month %d is not between 1 and 31", item.DayOfMonth)
+	}
+	if item.PaymentDays < 0 {
+		return errors.New("payment days are negative")
+	}
+	if item.EndDate.Valid && item.EndDate.Time.Before(item.StartDate) {
+		return errors.New("end date is before the start date")
+	}
+	item.Currency = strings.ToUpper(strings.TrimSpace(item.Currency))
+	// The invoice checks all the rest but the names.
+	inv := item.Invoice(item.StartDate)
+	inv.SellerName, inv.BuyerName = "-", "-"
+	return inv.Validate()
+}
+
+// occurrence returns the day of a month, or the last day of the month when it
+// is shorter. Months after December are of the years after.
+func occurrence(year int, month time.Month, day int) time.Time {
+	if last := time.Date(year, month+1, 0, 0, 0, 0, 0, time.UTC).Day(); day > last {
+		day = last
+	}
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+// Next returns the first date of the schedule on or after from, or null when
+// the schedule ends before it.
+func (item Item) Next(from time.Time) null.Time {
+	step := months[item.Frequency]
+	if step == 0 {
+		return null.Time{}
+	}
+	y, m, _ := item.StartDate.Date()
+	start := time.Date(y, m, item.StartDate.Day(), 0, 0, 0, 0, time.UTC)
+	from = time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, time.UTC)
+	if from.Before(start) {
+		from = start
+	}
+	d := occurrence(y, m, item.DayOfMonth)
+	for i := 1; d.Before(from); i++ {
+		d = occurrence(y, m+time.Month(i*step), item.DayOfMonth)
+	}
+	if item.EndDate.Valid {
+		end := item.EndDate.Time
+		if d.After(time.Date(end.Year(), end.Month(), end.Day(), 0, 0, 0, 0, time.UTC)) {
+			return null.Time{}
+		}
+	}
+	return null.TimeFrom(d)
+}
+
+// Invoice returns the invoice of the date, without its seller and buyer: it
+// is issued and fulfilled on the date, and due after the payment days.
+func (item Item) Invoice(date time.Time) invoice.Item {
+	result := invoice.Item{
+		SeriesID:       item.SeriesID,
+		PartnerID:      null.IntFrom(int64(item.PartnerID)),
+		IssueDate:      date,
+		FulfilmentDate: date,
+		DueDate:        date.AddDate(0, 0, item.PaymentDays),
+		Currency:       item.Currency,
+		PaymentMethod:  item.PaymentMethod,
+		Rounding:       item.Rounding,
+		CompanyID:      item.CompanyID,
+		Lines:          make([]invoice.Line, len(item.Lines)),
+	}
+	for i, line := range item.Lines {
+		line.ID, line.InvoiceID, line.LineNumber = 0, 0, 0
+		result.Lines[i] = line
+	}
+	return result
+}
+
+// Service defines the database connection.
+type Service struct {
+	DB    Connection
+	Actor audit.Actor // Who makes the changes, for the audit log
+}
+
+// As returns the service making the changes as the actor.
+func (s Service) As(actor audit.Actor) Service {
+	s.Actor = actor
+	return s
+}
+
+// Connection is an interface for making queries.
+type Connection interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Get(dest interface{}, query string, args ...interface{}) error
+	Select(dest interface{}, query string, args ...interface{}) error
+}
+
+// selectItems selects the items with the name of their partner.
+const selectItems = `
+		SELECT r.id, r.company_id, r.user_id, r.name, r.series_id,
+			r.partner_id, p.name AS partner_name,
+			r.currency, r.payment_method, r.rounding, r.payment_days,
+			r.frequency, r.day_of_month, r.start_date, r.end_date, r.next_date,
+			r.auto_issue, r.send_email, r.last_error,
+			r.created_at, r.updated_at, r.deleted_at
+		FROM recurring_invoice r
+		JOIN partner p ON p.id = r.partner_id`
+
+// ByID gets an item with its lines by ID.
+func (s Service) ByID(ID string, companyID string) (Item, bool, error) {
+	result := Item{}
+	qry := selectItems + `
+		WHERE r.id = $1
+			AND r.company_id = $2
+			AND r.deleted_at IS NULL
+		LIMIT 1`
+	err := s.DB.Get(&result, qry, ID, companyID)
+	if err != nil {
+		return result, err == sql.ErrNoRows, errors.Wrap(err, qry)
+	}
+	result.Lines, err = s.lines(ID)
+	return result, false, err
+}
+
+// ByCompanyID gets all entities of a company, without their lines.
+func (s Service) ByCompanyID(companyID string) ([]Item, bool, error) {
+	var result []Item
+	qry := selectItems + `
+		WHERE r.company_id = $1
+			AND r.deleted_at IS NULL
+		ORDER BY r.name, r.id`
+	err := s.DB.Select(&result, qry, companyID)
+	return result, err == sql.ErrNoRows, errors.Wrap(err, qry)
+}
+
+// lines gets the lines of a template.
+func (s Service) lines(ID interface{}) ([]invoice.Line, error) {
+	var result []invoice.Line
+	qry := fmt.Sprintf(`
+		SELECT id, line_number, product_id, code_type, code,
+			description, quantity, unit, unit_price, vat_rate
+		FROM %q
+		WHERE recurring_invoice_id = $1
+		ORDER BY line_number
+		`, lineTable)
+	err := s.DB.Select(&result, qry, ID)
+	return result, errors.Wrap(err, qry)
+}
+
+// Runs gets the invoices generated of a template, the latest first.
+func (s Service) Runs(ID string, companyID string) ([]Run, bool, error) {
+	var result []Run
+	qry := fmt.Sprintf(`
+		SELECT g.occurrence, g.invoice_id, i.number AS invoice_number,
+			i.status AS invoice_status, g.created_at
+		FROM %q g
+		JOIN %q r ON r.id = g.recurring_invoice_id
+		LEFT JOIN invoice i ON i.id = g.invoice_id AND i.deleted_at IS NULL
+		WHERE g.recurring_invoice_id = $1
+			AND r.company_id = $2
+		ORDER BY g.occurrence DESC
+		`, runTable, table)
+	err := s.DB.Select(&result, qry, ID, companyID)
+	return result, err == sql.ErrNoRows, errors.Wrap(err, qry)
+}
+
+// Create adds an item with its lines of the user, scheduled from its start
+// date, and returns the new ID. Its invoices are generated as the user.
+func (s Service) Create(item Item, userID string) (uint32, error) {
+	item.NextDate = item.Next(item.StartDate)
+	return audit.TrackNew(s.DB, s.Actor.For(userID), table, func(tx transaction.Connection) (uint32, error) {
+		var ID uint32
+		qry := fmt.Sprintf(`
+			INSERT INTO %q
+			(company_id, user_id, name, series_id, partner_id,
+				currency, payment_method, rounding, payment_days,
+				frequency, day_of_month, start_date, end_date, next_date,
+				auto_issue, send_email)
+			VALUES
+			($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15,$16)
+			RETURNING id
+			`, table)
+		err := tx.Get(&ID, qry,
+			item.CompanyID, userID, item.Name, item.SeriesID, item.PartnerID,
+			item.Currency, item.PaymentMethod, item.Rounding, item.PaymentDays,
+			item.Frequency, item.DayOfMonth, item.StartDate, item.EndDate, item.NextDate,
+			item.AutoIssue, item.SendEmail)
+		if err != nil {
+			return 0, errors.Wrap(err, qry)
+		}
+		return ID, Service{DB: tx}.insertLines(ID, item.Lines)
+	})
+}
+
+// insertLines adds the lines to a template, numbering them from 1.
+func (s Service) insertLines(ID interface{}, lines []invoice.Line) error {
+	qry := fmt.Sprintf(`
+		INSERT INTO %q
+		(recurring_invoice_id, line_number, product_id, code_type, code,
+			description, quantity, unit, unit_price, vat_rate)
+		VALUES
+		($1,$2,$3,$4,$5,$6,$7,$8,$9,$10)
+		`, lineTable)
+	for i, line := range lines {
+		if _, err := s.DB.Exec(qry, ID, i+1, line.ProductID, line.CodeType, line.Code,
+			line.Description, line.Quantity, line.Unit, line.UnitPrice, line.VATRate,
+		); err != nil {
+			return errors.Wrap(err, qry)
+		}
+	}
+	return nil
+}
+
+// Update makes changes to an existing item and replaces its lines. It is
+// scheduled again from the day after its last invoice, and its last error is
+// cleared.
+func (s Service) Update(item Item, ID string, companyID string) (sql.Result, error) {
+	var result sql.Result
+	err := audit.Track(s.DB, s.Actor, audit.Update, table, ID, func(tx transaction.Connection) error {
+		var last null.Time
+		qry := fmt.Sprintf(`
+			SELECT MAX(occurrence)
+			FROM %q
+			WHERE recurring_invoice_id = $1
+			`, runTable)
+		if err := tx.Get(&last, qry, ID); err != nil {
+			return errors.Wrap(err, qry)
+		}
+		from := item.StartDate
+		if last.Valid {
+			from = last.Time.AddDate(0, 0, 1)
+		}
+		item.NextDate = item.Next(from)
+
+		qry = fmt.Sprintf(`
+			UPDATE %q
+			SET name = $1, series_id = $2, partner_id = $3,
+				currency = $4, payment_method = $5, rounding = $6, payment_days = $7,
+				frequency = $8, day_of_month = $9, start_date = $10, end_date = $11, next_date = $12,
+				auto_issue = $13, send_email = $14, last_error = '', updated_at = NOW()
+			WHERE id = $15
+				AND company_id = $16
+				AND deleted_at IS NULL
+			`, table)
+		var err error
+		result, err = tx.Exec(qry, item.Name, item.SeriesID, item.PartnerID,
+			item.Currency, item.PaymentMethod, item.Rounding, item.PaymentDays,
+			item.Frequency, item.DayOfMonth, item.StartDate, item.EndDate, item.NextDate,
+			item.AutoIssue, item.SendEmail, ID, companyID)
+		if err != nil {
+			return errors.Wrap(err, qry)
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			return nil
+		}
+
+		qry = fmt.Sprintf(`
+			DELETE FROM %q
+			WHERE recurring_invoice_id = $1
+			`, lineTable)
+		if _, err = tx.Exec(qry, ID); err != nil {
+			return errors.Wrap(err, qry)
+		}
+		return Service{DB: tx}.insertLines(ID, item.Lines)
+	})
+	return result, err
+}
+
+// DeleteSoft marks an item as removed. Its invoices are kept.
+func (s Service) DeleteSoft(ID string, companyID string) (sql.Result, error) {
+	var result sql.Result
+	err := audit.Track(s.DB, s.Actor, audit.Delete, table, ID, func(tx transaction.Connection) error {
+		qry := fmt.Sprintf(`
+			UPDATE %q
+			SET deleted_at = NOW()
+			WHERE id = $1
+				AND company_id = $2
+				AND deleted_at IS NULL
+			`, table)
+		var err error
+		result, err = tx.Exec(qry, ID, companyID)
+		return errors.Wrap(err, qry)
+	})
+	return result, err
+}
+
+// Due gets the IDs of the templates of all the companies with an invoice
+// due on or before today.
+func (s Service) Due(today time.Time) ([]uint32, error) {
+	var result []uint32
+	qry := fmt.Sprintf(`
+		SELECT id
+		FROM %q
+		WHERE next_date <= $1
+			AND deleted_at IS NULL
+		ORDER BY next_date, id
+		`, table)
+	err := s.DB.Select(&result, qry, today)
+	return result, errors.Wrap(err, qry)
+}
+
+// Lock gets an item with its lines when it has an invoice due on or before
+// today, and locks it till the end of the transaction of DB. It returns
+// noRows when it is not due any more, or another server is generating its
+// invoice.
+func (s Service) Lock(ID uint32, today time.Time) (Item, bool, error) {
+	result := Item{}
+	qry := selectItems + `
+		WHERE r.id = $1
+			AND r.next_date <= $2
+			AND r.deleted_at IS NULL
+		FOR UPDATE OF r SKIP LOCKED`
+	err := s.DB.Get(&result, qry, ID, today)
+	if err != nil {
+		return result, err == sql.ErrNoRows, errors.Wrap(err, qry)
+	}
+	result.Lines, err = s.lines(ID)
+	return result, false, err
+}
+
+// Generated records the invoice generated of an item for its next date, and
+// schedules the following one. The invoice of a date is refused once it has
+// been generated.
+func (s Service) Generated(item Item, invoiceID uint32) error {
+	qry := fmt.Sprintf(`
+		INSERT INTO %q
+		(recurring_invoice_id, occurrence, invoice_id)
+		VALUES
+		($1,$2,$3)
+		`, runTable)
+	if _, err := s.DB.Exec(qry, item.ID, item.NextDate, invoiceID); err != nil {
+		return errors.Wrap(err, qry)
+	}
+	qry = fmt.Sprintf(`
+		UPDATE %q
+		SET next_date = $1, last_error = ''
+		WHERE id = $2
+		`, table)
+	_, err := s.DB.Exec(qry, item.Next(item.NextDate.Time.AddDate(0, 0, 1)), item.ID)
+	return errors.Wrap(err, qry)
+}
+
+// SetError records why generating or issuing the last invoice of an item
+// failed. An empty message clears it.
+func (s Service) SetError(ID uint32, message string) (sql.Result, error) {
+	qry := fmt.Sprintf(`
+		UPDATE %q
+		SET last_error = $1
+		WHERE id = $2
+		`, table)
+	result, err := s.DB.Exec(qry, message, ID)
+	return result, errors.Wrap(err, qry)
+}
@@ -0,0 +1,98 @@
+package recurring_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/UNO-SOFT/szamlazo/lib/money"
+	"github.com/UNO-SOFT/szamlazo/model/invoice"
+	"github.com/UNO-SOFT/szamlazo/model/recurring"
+
+	"gopkg.in/guregu/null.v3"
+)
+
+func date(s string) time.Time {
+	t, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+// TestNext checks the dates of the schedules, the short months and the end.
+func TestNext(t *testing.T) {
+	for _, tc := range []struct {
+		frequency string
+		day       int
+		start     string
+		end       string
+		from      string
+		want      string // "" if over
+	}{
+		{recurring.FrequencyMonthly, 15, "2026-10-01", "", "2026-10-01", "2026-10-15"},
+		{recurring.FrequencyMonthly, 15, "2026-10-20", "", "2026-10-20", "2026-11-15"},
+		{recurring.FrequencyMonthly, 15, "2026-10-01", "", "2026-10-16", "2026-11-15"},
+		{recurring.FrequencyMonthly, 31, "2026-01-31", "", "2026-02-01", "2026-02-28"},
+		{recurring.FrequencyMonthly, 31, "2026-01-31", "", "2026-03-01", "2026-03-31"},
+		{recurring.FrequencyMonthly, 1, "2026-12-05", "", "2026-12-05", "2027-01-01"},
+		{recurring.FrequencyQuarterly, 10, "2026-01-01", "", "2026-02-01", "2026-04-10"},
+		{recurring.FrequencyQuarterly, 31, "2026-11-30", "", "2026-12-01", "2027-02-28"},
+		{recurring.FrequencyYearly, 29, "2028-02-01", "", "2028-03-01", "2029-02-28"},
+		{recurring.FrequencyMonthly, 15, "2026-10-01", "2026-12-14", "2026-11-16", ""},
+		{recurring.FrequencyMonthly, 15, "2026-10-01", "2026-12-15", "2026-11-16", "2026-12-15"},
+		{recurring.FrequencyMonthly, 15, "2026-10-01", "", "2026-01-01", "2026-10-15"},
+	} {
+		item := recurring.Item{Frequency: tc.frequency, DayOfMonth: tc.day, StartDate: date(tc.start)}
+		if tc.end != "" {
+			item.EndDate = null.TimeFrom(date(tc.end))
+		}
+		got := item.Next(date(tc.from))
+		if tc.want == "" {
+			if got.Valid {
+				t.Errorf("%+v from %s: got %s, wanted none", tc, tc.from, got.Time.Format("2006-01-02"))
+			}
+			continue
+		}
+		if !got.Valid || !got.Time.Equal(date(tc.want)) {
+			t.Errorf("%+v from %s: got %v, wanted %s", tc, tc.from, got, tc.want)
+		}
+	}
+}
+
+// TestInvoice checks the invoice of a date and the validation.
+func TestInvoice(t *testing.T) {
+	item := recurring.Item{
+		Name: "Hosting", SeriesID: 1, PartnerID: 2, CompanyID: 3,
+		Currency: "huf", PaymentMethod: invoice.PaymentTransfer, Rounding: invoice.RoundPerLine,
+		PaymentDays: 8, Frequency: recurring.FrequencyMonthly, DayOfMonth: 1,
+		StartDate: date("2026-11-01"),
+		Lines: []invoice.Line{{ID: 7, Description: "Hosting", Quantity: money.New(1, 0),
+			Unit: "month", UnitPrice: money.New(10000, 0), VATRate: invoice.VAT27}},
+	}
+	if err := item.Normalize(); err != nil {
+		t.Fatal(err)
+	}
+	if item.Currency != "HUF" {
+		t.Errorf("currency is %q", item.Currency)
+	}
+	inv := item.Invoice(date("2026-12-01"))
+	if !inv.DueDate.Equal(date("2026-12-09")) || !inv.FulfilmentDate.Equal(date("2026-12-01")) {
+		t.Errorf("dates are %v and %v", inv.FulfilmentDate, inv.DueDate)
+	}
+	if inv.PartnerID.Int64 != 2 || len(inv.Lines) != 1 || inv.Lines[0].ID != 0 || item.Lines[0].ID != 7 {
+		t.Errorf("got %+v", inv)
+	}
+
+	for _, bad := range []func(*recurring.Item){
+		func(i *recurring.Item) { i.Frequency = "weekly" },
+		func(i *recurring.Item) { i.DayOfMonth = 32 },
+		func(i *recurring.Item) { i.EndDate = null.TimeFrom(date("2026-10-01")) },
+		func(i *recurring.Item) { i.Lines = nil },
+	} {
+		i := item
+		bad(&i)
+		if err := i.Normalize(); err == nil {
+			t.Errorf("%+v is valid", i)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+// Package reminder provides access to the reminder table in the database, the
+// payment reminders sent of the overdue invoices.
+package reminder
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/UNO-SOFT/szamlazo/lib/money"
+	"github.com/UNO-SOFT/szamlazo/model/invoice"
+
+	"github.com/pkg/errors"
+
+	"gopkg.in/guregu/null.v3"
+)
+
+var (
+	// table is the table name.
+	table = "reminder"
+)
+
+// Item defines the model. Level is the number of the offsets of the schedule
+// the invoice was overdue for, the first one being 1. The levels skipped when
+// several offsets passed since the previous reminder have no reminder, so it
+// does not count the reminders of the invoice. The mail of the reminder is in
+// the sent mail log.
+type Item struct {
+	ID            uint32        `db:"id"`
+	CompanyID     uint32        `db:"company_id"`
+	InvoiceID     uint32        `db:"invoice_id"`
+	InvoiceNumber null.String   `db:"invoice_number"`
+	BuyerName     string        `db:"buyer_name"`
+	Currency      string        `db:"currency"`
+	Level         int           `db:"level"`
+	DaysOverdue   int           `db:"days_overdue"`
+	Balance       money.Decimal `db:"balance"`
+	SentMailID    null.Int      `db:"sent_mail_id"`
+	MailStatus    null.String   `db:"mail_status"`
+	CreatedAt     null.Time     `db:"created_at"`
+}
+
+// Candidate is an overdue invoice of a partner not paused, with the
+// addresses the reminders are sent to.
+type Candidate struct {
+	InvoiceID uint32        `db:"invoice_id"`
+	CompanyID uint32        `db:"company_id"`
+	DueDate   time.Time     `db:"due_date"`
+	Currency  string        `db:"currency"`
+	Total     money.Decimal `db:"gross_total"`
+	Paid      money.Decimal `db:"paid"`
+	Level     int           `db:"level"` // Offset reached by the last reminder, 0 if none
+	Email     string        `db:"email"`
+	EmailCC   string        `db:"email_cc"`
+	EmailBCC  string        `db:"email_bcc"`
+	Language  string        `db:"language"`
+}
+
+// Balance returns the amount still due.
+func (c Candidate) Balance() money.Decimal {
+	return c.Total.Sub(c.Paid)
+}
+
+// Service defines the database connection.
+type Service struct {
+	DB Connection
+}
+
+// Connection is an interface for making queries.
+type Connection interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Get(dest interface{}, query string, args ...interface{}) error
+	Select(dest interface{}, query string, args ...interface{}) error
+}
+
+// selectItems selects the items with their invoice and the status of their
+// mail.
+const selectItems = `
+		SELECT r.id, r.company_id, r.invoice_id, i.number AS invoice_number,
+			i.buyer_name, i.currency, r.level, r.days_overdue, r.balance,
+			r.sent_mail_id, m.status AS mail_status, r.created_at
+		FROM reminder r
+		JOIN invoice i ON i.id = r.invoice_id
+		LEFT JOIN sent_mail m ON m.id = r.sent_mail_id`
+
+// ByInvoiceID gets the reminders of an invoice, the first one first.
+func (s Service) ByInvoiceID(invoiceID string, companyID string) ([]Item, bool, error) {
+	var result []Item
+	qry := selectItems + `
+		WHERE r.invoice_id = $1
+			AND r.company_id = $2
+		ORDER BY r.level`
+	err := s.DB.Select(&result, qry, invoiceID, companyID)
+	return result, err == sql.ErrNoRows, errors.Wrap(err, qry)
+}
+
+// ByCompanyID gets the latest reminders of a company, at most limit.
+func (s Service) ByCompanyID(companyID string, limit int) ([]Item, bool, error) {
+	var result []Item
+	qry := selectItems + `
+		WHERE r.company_id = $1
+		ORDER BY r.id DESC
+		LIMIT $2`
+	err := s.DB.Select(&result, qry, companyID, limit)
+	return result, err == sql.ErrNoRows, errors.Wrap(err, qry)
+}
+
+// Candidates gets the issued invoices of all the companies with their due
+// date before today and a balance, of the partners with an address and not
+// paused, with the level of their last reminder.
+func (s Service) Candidates(today time.Time) ([]Candidate, error) {
+	var result []Candidate
+	qry := fmt.Sprintf(`
+		SELECT i.id AS invoice_id, i.company_id, i.due_date, i.currency, i.gross_total,
+			COALESCE(SUM(a.amount), 0) AS paid,
+			COALESCE((SELECT MAX(r.level) FROM %q r WHERE r.invoice_id = i.id), 0) AS level,
+			p.email, p.email_cc, p.email_bcc, p.language
+		FROM invoice i
+		JOIN partner p ON p.id = i.partner_id
+		LEFT JOIN payment_allocation a ON a.invoice_id = i.id
+		WHERE i.deleted_at IS NULL
+			AND i.status IN ($1, $2, $3, $4)
+			AND i.kind <> $5
+			AND i.due_date < $6
+			AND p.deleted_at IS NULL
+			AND NOT p.dunning_paused
+			AND p.email <> ''
+		GROUP BY i.id, p.id
+		HAVING i.gross_total > COALESCE(SUM(a.amount), 0)
+		ORDER BY i.company_id, i.due_date, i.id
+		`, table)
+	err := s.DB.Select(&result, qry,
+		invoice.StatusIssued, invoice.StatusSent, invoice.StatusPartiallyPaid, invoice.StatusOverdue,
+		invoice.KindStorno, today)
+	return result, errors.Wrap(err, qry)
+}
+
+// Create adds an item and returns the new ID. It returns false when the
+// invoice already has a reminder of the level, so each is sent once even
+// when several servers look for them at the same time.
+func (s Service) Create(item Item) (uint32, bool, error) {
+	var ID uint32
+	qry := fmt.Sprintf(`
+		INSERT INTO %q
+		(company_id, invoice_id, level, days_overdue, balance)
+		VALUES
+		($1,$2,$3,$4,$5)
+		ON CONFLICT (invoice_id, level) DO NOTHING
+		RETURNING id
+		`, table)
+	err := s.DB.Get(&ID, qry, item.CompanyID, item.InvoiceID, item.Level, item.DaysOverdue, item.Balance)
+	if err == sql.ErrNoRows {
+		return 0, false, nil
+	}
+	return ID, err == nil, errors.Wrap(err, qry)
+}
+
+// SetSentMail links an item to the mail sending it.
+func (s Service) SetSentMail(ID uint32, sentMailID uint32) (sql.Result, error) {
+	qry := fmt.Sprintf(`
+		UPDATE %q
+		SET sent_mail_id = $1
+		WHERE id = $2
+		`, table)
+	result, err := s.DB.Exec(qry, sentMailID, ID)
+	return result, errors.Wrap(err, qry)
+}
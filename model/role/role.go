@@ -0,0 +1,128 @@
+// Package role provides access to the role, permission and role_permission
+// tables in the database: what the members of a company may do in it.
+package role
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/pkg/errors"
+
+	"gopkg.in/guregu/null.v3"
+)
+
+var (
+	// table is the table name.
+	table = "role"
+	// grantTable is the table name of the permissions of the roles.
+	grantTable = "role_permission"
+	// memberTable is the table name of the memberships.
+	memberTable = "company_user"
+	// companyTable is the table name of the companies.
+	companyTable = "company"
+)
+
+// Item defines the model.
+type Item struct {
+	Name        string `db:"name"`
+	Description string `db:"description"`
+
+	Permissions []string `db:"-"`
+}
+
+// Can reports whether the role has the permission.
+func (item Item) Can(permission string) bool {
+	return has(item.Permissions, permission)
+}
+
+// Service defines the database connection.
+type Service struct {
+	DB Connection
+}
+
+// Connection is an interface for making queries.
+type Connection interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Get(dest interface{}, query string, args ...interface{}) error
+	Select(dest interface{}, query string, args ...interface{}) error
+}
+
+// grant is a row of the roles joined with their permissions.
+type grant struct {
+	Name        string      `db:"name"`
+	Description string      `db:"description"`
+	Permission  null.String `db:"permission"`
+}
+
+// All gets the roles in the order they are offered, with their permissions.
+func (s Service) All() ([]Item, bool, error) {
+	var rows []grant
+	qry := fmt.Sprintf(`
+		SELECT r.name, r.description, g.permission
+		FROM %q r
+		LEFT JOIN %q g ON g.role = r.name
+		ORDER BY r.position, r.name, g.permission
+		`, table, grantTable)
+	if err := s.DB.Select(&rows, qry); err != nil {
+		return nil, err == sql.ErrNoRows, errors.Wrap(err, qry)
+	}
+	var result []Item
+	for _, g := range rows {
+		if len(result) == 0 || result[len(result)-1].Name != g.Name {
+			result = append(result, Item{Name: g.Name, Description: g.Description})
+		}
+		if g.Permission.Valid {
+			last := &result[len(result)-1]
+			last.Permissions = append(last.Permissions, g.Permission.String)
+		}
+	}
+	return result, len(result) == 0, nil
+}
+
+// Exists reports whether there is a role of the name.
+func (s Service) Exists(name string) (bool, error) {
+	var n int
+	qry := fmt.Sprintf(`SELECT COUNT(*) FROM %q WHERE name = $1`, table)
+	err := s.DB.Get(&n, qry, name)
+	return n > 0, errors.Wrap(err, qry)
+}
+
+// Member gets the role of a user in a company with its permissions, with
+// noRows set if the user is not a member.
+func (s Service) Member(companyID string, userID string) (Item, bool, error) {
+	var rows []grant
+	qry := fmt.Sprintf(`
+		SELECT r.name, r.description, g.permission
+		FROM %q m
+		JOIN %q c ON c.id = m.company_id
+		JOIN %q r ON r.name = m.role
+		LEFT JOIN %q g ON g.role = r.name
+		WHERE m.company_id = $1
+			AND m.user_id = $2
+			AND c.deleted_at IS NULL
+		ORDER BY g.permission
+		`, memberTable, companyTable, table, grantTable)
+	if err := s.DB.Select(&rows, qry, companyID, userID); err != nil {
+		return Item{}, err == sql.ErrNoRows, errors.Wrap(err, qry)
+	}
+	if len(rows) == 0 {
+		return Item{}, true, nil
+	}
+	result := Item{Name: rows[0].Name, Description: rows[0].Description}
+	for _, g := range rows {
+		if g.Permission.Valid {
+			result.Permissions = append(result.Permissions, g.Permission.String)
+		}
+	}
+	return result, false, nil
+}
+
+// has reports whether the permission is in the list.
+func has(permissions []string, permission string) bool {
+	for _, p := range permissions {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,26 @@
+package role_test
+
+import (
+	"testing"
+
+	"github.com/UNO-SOFT/szamlazo/model/role"
+)
+
+// TestCan checks the lookup of the permissions of a role.
+func TestCan(t *testing.T) {
+	item := role.Item{Name: "issuer", Permissions: []string{"invoice.issue", "invoice.view"}}
+	for permission, want := range map[string]bool{
+		"invoice.issue":  true,
+		"invoice.view":   true,
+		"payment.edit":   false,
+		"company.manage": false,
+		"":               false,
+	} {
+		if got := item.Can(permission); got != want {
+			t.Errorf("%q: got %t want %t", permission, got, want)
+		}
+	}
+	if (role.Item{}).Can("invoice.view") {
+		t.Error("the zero role can view")
+	}
+}
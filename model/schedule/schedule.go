@@ -0,0 +1,147 @@
+// Package schedule provides access to the scheduled_run table in the
+// database, the history of the periodic tasks of lib/scheduler.
+package schedule
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/UNO-SOFT/szamlazo/model/transaction"
+
+	"github.com/pkg/errors"
+
+	"gopkg.in/guregu/null.v3"
+)
+
+var (
+	// table is the table name.
+	table = "scheduled_run"
+)
+
+// lockSpace is the first key of the advisory locks of the tasks, the second
+// one being the hash of their name.
+const lockSpace = 0x5343 // "SC"
+
+// Statuses of the runs.
+const (
+	StatusRunning = "running" // Or stopped with its server
+	StatusDone    = "done"
+	StatusFailed  = "failed"
+)
+
+// Item defines the model.
+type Item struct {
+	ID          uint32    `db:"id"`
+	Task        string    `db:"task"`
+	ScheduledAt time.Time `db:"scheduled_at"`
+	Host        string    `db:"host"`
+	Status      string    `db:"status"`
+	Summary     string    `db:"summary"`
+	LastError   string    `db:"last_error"`
+	StartedAt   null.Time `db:"started_at"`
+	FinishedAt  null.Time `db:"finished_at"`
+}
+
+// Service defines the database connection.
+type Service struct {
+	DB Connection
+}
+
+// Connection is an interface for making queries.
+type Connection interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Get(dest interface{}, query string, args ...interface{}) error
+	Select(dest interface{}, query string, args ...interface{}) error
+}
+
+// columns lists the columns in the order of Item.
+const columns = `id, task, scheduled_at, host, status, summary, last_error,
+			started_at, finished_at`
+
+// Latest gets the latest runs of all the tasks, at most limit.
+func (s Service) Latest(limit int) ([]Item, bool, error) {
+	var result []Item
+	qry := fmt.Sprintf(`
+		SELECT %s
+		FROM %q
+		ORDER BY id DESC
+		LIMIT $1
+		`, columns, table)
+	err := s.DB.Select(&result, qry, limit)
+	return result, err == sql.ErrNoRows, errors.Wrap(err, qry)
+}
+
+// LastByTask gets the last run of each task.
+func (s Service) LastByTask() ([]Item, bool, error) {
+	var result []Item
+	qry := fmt.Sprintf(`
+		SELECT DISTINCT ON (task) %s
+		FROM %q
+		ORDER BY task, scheduled_at DESC
+		`, columns, table)
+	err := s.DB.Select(&result, qry)
+	return result, err == sql.ErrNoRows, errors.Wrap(err, qry)
+}
+
+// Run calls fn and records its outcome as the run of the task at the time,
+// unless another server is running the task or has run it for the time. It
+// reports whether fn was called.
+//
+// The advisory lock of the task is held by a transaction till fn returns,
+// so it is released even when the server stops. The run is recorded outside
+// of it, to be seen while it lasts.
+func (s Service) Run(task string, at time.Time, host string, fn func() (string, error)) (bool, error) {
+	ran := false
+	err := transaction.Run(s.DB, func(tx transaction.Connection) error {
+		var locked bool
+		qry := `SELECT pg_try_advisory_xact_lock($1, hashtext($2))`
+		if err := tx.Get(&locked, qry, lockSpace, task); err != nil || !locked {
+			return errors.Wrap(err, qry)
+		}
+
+		ID, ok, err := s.start(task, at, host)
+		if err != nil || !ok {
+			return err
+		}
+		ran = true
+		summary, runErr := fn()
+		_, err = s.finish(ID, summary, runErr)
+		return err
+	})
+	return ran, err
+}
+
+// start records the run of a task at the time, and returns false if there
+// is one already.
+func (s Service) start(task string, at time.Time, host string) (uint32, bool, error) {
+	var ID uint32
+	qry := fmt.Sprintf(`
+		INSERT INTO %q
+		(task, scheduled_at, host, status)
+		VALUES
+		($1,$2,$3,$4)
+		ON CONFLICT (task, scheduled_at) DO NOTHING
+		RETURNING id
+		`, table)
+	err := s.DB.Get(&ID, qry, task, at, host, StatusRunning)
+	if err == sql.ErrNoRows {
+		return 0, false, nil
+	}
+	return ID, err == nil, errors.Wrap(err, qry)
+}
+
+// finish records the outcome of a run.
+func (s Service) finish(ID uint32, summary string, runErr error) (sql.Result, error) {
+	status, lastError := StatusDone, ""
+	if runErr != nil {
+		status, lastError = StatusFailed, runErr.Error()
+	}
+	qry := fmt.Sprintf(`
+		UPDATE %q
+		SET status = $1, summary = $2, last_error = $3, finished_at = NOW()
+		WHERE id = $4
+		`, table)
+	result, err := s.DB.Exec(qry, status, summary, lastError, ID)
+	return result, errors.Wrap(err, qry)
+}
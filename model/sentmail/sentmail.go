@@ -0,0 +1,151 @@
+// Package sentmail provides access to the sent_mail table in the database,
+// the log of the invoices sent by mail.
+package sentmail
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/pkg/errors"
+
+	"gopkg.in/guregu/null.v3"
+)
+
+var (
+	// table is the table name.
+	table = "sent_mail"
+)
+
+// Statuses of the mails.
+const (
+	StatusQueued   = "queued"   // Waiting to be sent
+	StatusRetrying = "retrying" // Failed, to be sent again
+	StatusSent     = "sent"     // Accepted by the mail server
+	StatusFailed   = "failed"   // Given up
+)
+
+// Item defines the model. Recipients, CC and BCC are lists of addresses
+// separated by commas. CopyNo is the printing of the attached invoice, 0 for
+// the original.
+type Item struct {
+	ID            uint32      `db:"id"`
+	CompanyID     uint32      `db:"company_id"`
+	InvoiceID     uint32      `db:"invoice_id"`
+	InvoiceNumber null.String `db:"invoice_number"`
+	UserID        null.Int    `db:"user_id"`
+	UserName      string      `db:"user_name"`
+	Recipients    string      `db:"recipients"`
+	CC            string      `db:"cc"`
+	BCC           string      `db:"bcc"`
+	Subject       string      `db:"subject"`
+	Body          string      `db:"body"`
+	CopyNo        int         `db:"copy_no"`
+	Status        string      `db:"status"`
+	Attempts      int         `db:"attempts"`
+	LastError     string      `db:"last_error"`
+	MessageID     string      `db:"message_id"`
+	CreatedAt     null.Time   `db:"created_at"`
+	SentAt        null.Time   `db:"sent_at"`
+}
+
+// Service defines the database connection.
+type Service struct {
+	DB Connection
+}
+
+// Connection is an interface for making queries.
+type Connection interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Get(dest interface{}, query string, args ...interface{}) error
+	Select(dest interface{}, query string, args ...interface{}) error
+}
+
+// selectItems selects the items with the number of their invoice and the name
+// of their sender.
+const selectItems = `
+		SELECT m.id, m.company_id, m.invoice_id, i.number AS invoice_number,
+			m.user_id, COALESCE(u.first_name || ' ' || u.last_name, '') AS user_name,
+			m.recipients, m.cc, m.bcc, m.subject, m.body, m.copy_no,
+			m.status, m.attempts, m.last_error, m.message_id, m.created_at, m.sent_at
+		FROM sent_mail m
+		JOIN invoice i ON i.id = m.invoice_id
+		LEFT JOIN "user" u ON u.id = m.user_id`
+
+// ByID gets an item by ID.
+func (s Service) ByID(ID interface{}, companyID interface{}) (Item, bool, error) {
+	result := Item{}
+	qry := selectItems + `
+		WHERE m.id = $1
+			AND m.company_id = $2
+		LIMIT 1`
+	err := s.DB.Get(&result, qry, ID, companyID)
+	return result, err == sql.ErrNoRows, errors.Wrap(err, qry)
+}
+
+// ByInvoiceID gets the mails of an invoice, the latest first.
+func (s Service) ByInvoiceID(invoiceID string, companyID string) ([]Item, bool, error) {
+	var result []Item
+	qry := selectItems + `
+		WHERE m.invoice_id = $1
+			AND m.company_id = $2
+		ORDER BY m.id DESC`
+	err := s.DB.Select(&result, qry, invoiceID, companyID)
+	return result, err == sql.ErrNoRows, errors.Wrap(err, qry)
+}
+
+// ByCompanyID gets the latest mails of a company, at most limit.
+func (s Service) ByCompanyID(companyID string, limit int) ([]Item, bool, error) {
+	var result []Item
+	qry := selectItems + `
+		WHERE m.company_id = $1
+		ORDER BY m.id DESC
+		LIMIT $2`
+	err := s.DB.Select(&result, qry, companyID, limit)
+	return result, err == sql.ErrNoRows, errors.Wrap(err, qry)
+}
+
+// Create adds a queued item and returns the new ID. An empty userID stands
+// for the system.
+func (s Service) Create(item Item, userID string) (uint32, error) {
+	var ID uint32
+	qry := fmt.Sprintf(`
+		INSERT INTO %q
+		(company_id, invoice_id, user_id, recipients, cc, bcc,
+			subject, body, copy_no, status)
+		VALUES
+		($1,$2,$3,$4,$5,$6,$7,$8,$9,$10)
+		RETURNING id
+		`, table)
+	err := s.DB.Get(&ID, qry,
+		item.CompanyID, item.InvoiceID, null.NewString(userID, userID != ""), item.Recipients, item.CC, item.BCC,
+		item.Subject, item.Body, item.CopyNo, StatusQueued)
+	return ID, errors.Wrap(err, qry)
+}
+
+// Sent records that an item was accepted by the mail server.
+func (s Service) Sent(ID uint32, messageID string) (sql.Result, error) {
+	qry := fmt.Sprintf(`
+		UPDATE %q
+		SET status = $1, message_id = $2, attempts = attempts + 1,
+			last_error = '', sent_at = NOW()
+		WHERE id = $3
+		`, table)
+	result, err := s.DB.Exec(qry, StatusSent, messageID, ID)
+	return result, errors.Wrap(err, qry)
+}
+
+// Failed records a failed attempt to send an item: it is retried, unless the
+// failure is final.
+func (s Service) Failed(ID uint32, lastError string, final bool) (sql.Result, error) {
+	status := StatusRetrying
+	if final {
+		status = StatusFailed
+	}
+	qry := fmt.Sprintf(`
+		UPDATE %q
+		SET status = $1, last_error = $2, attempts = attempts + 1
+		WHERE id = $3
+		`, table)
+	result, err := s.DB.Exec(qry, status, lastError, ID)
+	return result, errors.Wrap(err, qry)
+}
@@ -0,0 +1,237 @@
+// Package series provides access to the invoice_series table in the database
+// and hands out gap-free invoice numbers.
+package series
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/UNO-SOFT/szamlazo/model/audit"
+	"github.com/UNO-SOFT/szamlazo/model/transaction"
+
+	"github.com/pkg/errors"
+
+	"gopkg.in/guregu/null.v3"
+)
+
+var (
+	// table is the table name.
+	table = "invoice_series"
+)
+
+// Item defines the model.
+//
+// The storno and modification invoices correcting the documents of a series
+// are numbered from its CorrectionSeriesID. Every company has its own series.
+type Item struct {
+	ID                 uint32    `db:"id"`
+	CompanyID          uint32    `db:"company_id"`
+	Code               string    `db:"code"`
+	Prefix             string    `db:"prefix"`
+	YearReset          bool      `db:"year_reset"`
+	Padding            uint8     `db:"padding"`
+	Year               int       `db:"year"`
+	LastNumber         uint32    `db:"last_number"`
+	CorrectionSeriesID null.Int  `db:"correction_series_id"`
+	CreatedAt          null.Time `db:"created_at"`
+	UpdatedAt          null.Time `db:"updated_at"`
+	DeletedAt          null.Time `db:"deleted_at"`
+}
+
+// maxPadding is the most digits a number is padded to.
+const maxPadding = 12
+
+// Normalize trims the code and the prefix, and checks that they fit.
+func (item *Item) Normalize() error {
+	item.Code = strings.TrimSpace(item.Code)
+	item.Prefix = strings.TrimSpace(item.Prefix)
+	if item.Code == "" {
+		return errors.New("code is required")
+	}
+	if len(item.Code) > 20 || len(item.Prefix) > 20 {
+		return errors.New("code and prefix are at most 20 characters")
+	}
+	if item.Padding > maxPadding {
+		return errors.Errorf("padding is at most %d digits", maxPadding)
+	}
+	return nil
+}
+
+// Format returns the invoice number of the nth document of the series in the
+// given year.
+func (item Item) Format(year int, n uint32) string {
+	s := strconv.FormatUint(uint64(n), 10)
+	for len(s) < int(item.Padding) {
+		s = "0" + s
+	}
+	if item.YearReset {
+		return fmt.Sprintf("%s%d/%s", item.Prefix, year, s)
+	}
+	return item.Prefix + s
+}
+
+// Service defines the database connection.
+type Service struct {
+	DB    Connection
+	Actor audit.Actor // Who makes the changes, for the audit log
+}
+
+// As returns the service making the changes as the actor.
+func (s Service) As(actor audit.Actor) Service {
+	s.Actor = actor
+	return s
+}
+
+// Connection is an interface for making queries.
+type Connection interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Get(dest interface{}, query string, args ...interface{}) error
+	Select(dest interface{}, query string, args ...interface{}) error
+}
+
+// ByID gets an item of a company by ID.
+func (s Service) ByID(ID string, companyID string) (Item, bool, error) {
+	result := Item{}
+	qry := fmt.Sprintf(`
+		SELECT id, company_id, code, prefix, year_reset, padding, year, last_number,
+			correction_series_id, created_at, updated_at, deleted_at
+		FROM %q
+		WHERE id = $1
+			AND company_id = $2
+			AND deleted_at IS NULL
+		LIMIT 1
+		`, table)
+	err := s.DB.Get(&result, qry, ID, companyID)
+	return result, err == sql.ErrNoRows, errors.Wrap(err, qry)
+}
+
+// ByCompanyID gets the series of a company.
+func (s Service) ByCompanyID(companyID string) ([]Item, bool, error) {
+	var result []Item
+	qry := fmt.Sprintf(`
+		SELECT id, company_id, code, prefix, year_reset, padding, year, last_number,
+			correction_series_id, created_at, updated_at, deleted_at
+		FROM %q
+		WHERE company_id = $1
+			AND deleted_at IS NULL
+		ORDER BY code
+		`, table)
+	err := s.DB.Select(&result, qry, companyID)
+	return result, err == sql.ErrNoRows, errors.Wrap(err, qry)
+}
+
+// Create adds an item to its company and returns the new ID. Its numbers
+// start from 1.
+func (s Service) Create(item Item) (uint32, error) {
+	return audit.TrackNew(s.DB, s.Actor, table, func(tx transaction.Connection) (uint32, error) {
+		if err := (Service{DB: tx}).checkCorrection(item.CorrectionSeriesID, fmt.Sprint(item.CompanyID)); err != nil {
+			return 0, err
+		}
+		var ID uint32
+		qry := fmt.Sprintf(`
+			INSERT INTO %q
+			(company_id, code, prefix, year_reset, padding, correction_series_id)
+			VALUES
+			($1,$2,$3,$4,$5,$6)
+			RETURNING id
+			`, table)
+		err := tx.Get(&ID, qry, item.CompanyID, item.Code, item.Prefix, item.YearReset, item.Padding, item.CorrectionSeriesID)
+		return ID, errors.Wrap(err, qry)
+	})
+}
+
+// CreateDefaults adds the series of a new company: the default one with
+// yearly numbers, and the one of its corrections.
+func (s Service) CreateDefaults(companyID uint32) error {
+	correctionID, err := s.Create(Item{CompanyID: companyID, Code: "correction", Prefix: "SZH", YearReset: true, Padding: 5})
+	if err != nil {
+		return err
+	}
+	_, err = s.Create(Item{CompanyID: companyID, Code: "default", Prefix: "SZ", YearReset: true, Padding: 5,
+		CorrectionSeriesID: null.IntFrom(int64(correctionID))})
+	return err
+}
+
+// Update changes the code, the format of the numbers handed out from now on
+// and the series of the corrections. The counter is never touched, so no
+// number is reused or skipped.
+func (s Service) Update(item Item, ID string, companyID string) (sql.Result, error) {
+	var result sql.Result
+	err := audit.Track(s.DB, s.Actor, audit.Update, table, ID, func(tx transaction.Connection) error {
+		if item.CorrectionSeriesID.Valid && fmt.Sprint(item.CorrectionSeriesID.Int64) == ID {
+			return errors.New("a series cannot number its own corrections")
+		}
+		if err := (Service{DB: tx}).checkCorrection(item.CorrectionSeriesID, companyID); err != nil {
+			return err
+		}
+		qry := fmt.Sprintf(`
+			UPDATE %q
+			SET code = $1, prefix = $2, year_reset = $3, padding = $4, correction_series_id = $5,
+				updated_at = NOW()
+			WHERE id = $6
+				AND company_id = $7
+				AND deleted_at IS NULL
+			`, table)
+		var err error
+		result, err = tx.Exec(qry, item.Code, item.Prefix, item.YearReset, item.Padding, item.CorrectionSeriesID, ID, companyID)
+		return errors.Wrap(err, qry)
+	})
+	return result, err
+}
+
+// checkCorrection refuses a series of the corrections of another company.
+func (s Service) checkCorrection(correctionSeriesID null.Int, companyID string) error {
+	if !correctionSeriesID.Valid {
+		return nil
+	}
+	_, noRows, err := s.ByID(fmt.Sprint(correctionSeriesID.Int64), companyID)
+	if noRows {
+		return errors.Errorf("unknown series %d", correctionSeriesID.Int64)
+	}
+	return err
+}
+
+// Next allocates the next number of the series for a document issued on the
+// given date, and returns it with the counter it was made of: the year and
+// the ordinal of the series, which stay comparable with Year and LastNumber
+// even when Update changes the format later.
+//
+// The series row stays locked until the surrounding transaction ends, so DB
+// must be the transaction that also stores the document: if it rolls back,
+// the number is given back and the next document gets it, leaving no gap.
+func (s Service) Next(ID string, issued time.Time) (number string, year int, n uint32, err error) {
+	item := Item{}
+	qry := fmt.Sprintf(`
+		SELECT id, code, prefix, year_reset, padding, year, last_number
+		FROM %q
+		WHERE id = $1
+			AND deleted_at IS NULL
+		FOR UPDATE
+		`, table)
+	if err = s.DB.Get(&item, qry, ID); err != nil {
+		return "", 0, 0, errors.Wrap(err, qry)
+	}
+
+	year, n = item.Year, item.LastNumber+1
+	if item.YearReset && issued.Year() != year {
+		// Numbers must grow with the dates within a yearly series
+		if issued.Year() < year {
+			return "", 0, 0, errors.Errorf("series %s is already in %d, cannot number a document of %d",
+				item.Code, year, issued.Year())
+		}
+		year, n = issued.Year(), 1
+	}
+
+	qry = fmt.Sprintf(`
+		UPDATE %q
+		SET year = $1, last_number = $2
+		WHERE id = $3
+		`, table)
+	if _, err = s.DB.Exec(qry, year, n, ID); err != nil {
+		return "", 0, 0, errors.Wrap(err, qry)
+	}
+	return item.Format(year, n), year, n, nil
+}
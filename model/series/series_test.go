@@ -0,0 +1,46 @@
+package series_test
+
+import (
+	"testing"
+
+	"github.com/UNO-SOFT/szamlazo/model/series"
+)
+
+// TestFormat checks the prefix, year and padding of the numbers.
+func TestFormat(t *testing.T) {
+	for _, tc := range []struct {
+		item series.Item
+		n    uint32
+		want string
+	}{
+		{series.Item{Prefix: "SZ", YearReset: true, Padding: 5}, 42, "SZ2026/00042"},
+		{series.Item{Prefix: "SZ", YearReset: false, Padding: 5}, 42, "SZ00042"},
+		{series.Item{Prefix: "", YearReset: false, Padding: 0}, 7, "7"},
+		{series.Item{Prefix: "E-", YearReset: true, Padding: 2}, 123, "E-2026/123"},
+	} {
+		if got := tc.item.Format(2026, tc.n); got != tc.want {
+			t.Errorf("%+v: got %q want %q", tc.item, got, tc.want)
+		}
+	}
+}
+
+// TestNormalize checks the trimming and the limits of the fields.
+func TestNormalize(t *testing.T) {
+	item := series.Item{Code: " export ", Prefix: " EX- ", Padding: 4}
+	if err := item.Normalize(); err != nil {
+		t.Fatal(err)
+	}
+	if item.Code != "export" || item.Prefix != "EX-" {
+		t.Errorf("got %q and %q", item.Code, item.Prefix)
+	}
+
+	for name, item := range map[string]series.Item{
+		"no code": {Code: " ", Padding: 5},
+		"long":    {Code: "c", Prefix: "123456789012345678901", Padding: 5},
+		"padding": {Code: "c", Padding: 13},
+	} {
+		if err := item.Normalize(); err == nil {
+			t.Errorf("%s: accepted", name)
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package statement
+
+import (
+	"bytes"
+	"strings"
+	"unicode"
+
+	"github.com/UNO-SOFT/szamlazo/lib/bankstatement"
+	"github.com/UNO-SOFT/szamlazo/lib/money"
+	"github.com/UNO-SOFT/szamlazo/model/partner"
+	"github.com/UNO-SOFT/szamlazo/model/payment"
+
+	"gopkg.in/guregu/null.v3"
+)
+
+// Rules by which the transactions are matched to invoices.
+const (
+	RuleNumber  = "invoice_number" // The remittance names the invoices
+	RuleAccount = "bank_account"   // The account of the payer is of a partner owing the amount
+	RuleAmount  = "amount"         // Only one invoice is due with the amount
+	RuleManual  = "manual"         // Picked on review
+)
+
+// Match is the outcome of matching a transaction: the invoices it pays and
+// the payer. No allocations leave the transaction for review.
+type Match struct {
+	Rule        string
+	PartnerID   null.Int
+	Allocations []payment.Allocation
+}
+
+// Matcher matches the credits of statements to the open items.
+type Matcher struct {
+	open   []payment.OpenItem
+	owners map[string]uint32
+}
+
+// NewMatcher returns a matcher of the open items, knowing the payers by the
+// bank accounts of the partners.
+func NewMatcher(open []payment.OpenItem, accounts []partner.Account) *Matcher {
+	m := &Matcher{owners: make(map[string]uint32, len(accounts))}
+	for _, o := range open {
+		if o.Balance().Sign() > 0 {
+			m.open = append(m.open, o)
+		}
+	}
+	for _, a := range accounts {
+		m.owners[bankstatement.AccountKey(a.Number)] = a.PartnerID
+	}
+	return m
+}
+
+// Match finds the invoices a credit pays: the ones named in the remittance
+// if the amount does not exceed their balance, else the ones of the partner
+// owning the account of the payer with exactly the amount due, else the only
+// invoice due with exactly the amount, when the payer is not known.
+//
+// The matched amounts are taken off the balances, so the later transactions
+// of an import do not pay the same invoices again.
+func (m *Matcher) Match(t bankstatement.Transaction) Match {
+	var result Match
+	if owner, ok := m.owners[bankstatement.AccountKey(t.Account)]; ok && t.Account != "" {
+		result.PartnerID = null.IntFrom(int64(owner))
+	}
+	if t.Amount.Sign() <= 0 {
+		return result
+	}
+
+	var named, owed, exact []int
+	owedTotal := money.Decimal{}
+	for i, o := range m.open {
+		if o.Currency != t.Currency || o.Balance().Sign() <= 0 {
+			continue
+		}
+		if mentions(t.Remittance, o.Number) {
+			named = append(named, i)
+		}
+		if result.PartnerID.Valid && o.PartnerID == result.PartnerID {
+			owed = append(owed, i)
+			owedTotal = owedTotal.Add(o.Balance())
+		}
+		if o.Balance().Cmp(t.Amount) == 0 {
+			exact = append(exact, i)
+		}
+	}
+
+	switch {
+	case len(named) > 0 && t.Amount.Cmp(m.total(named)) <= 0:
+		result.Rule = RuleNumber
+		result.Allocations = m.allocate(named, t.Amount)
+	case result.PartnerID.Valid && len(intersect(owed, exact)) == 1:
+		result.Rule = RuleAccount
+		result.Allocations = m.allocate(intersect(owed, exact), t.Amount)
+	case result.PartnerID.Valid && len(owed) > 0 && owedTotal.Cmp(t.Amount) == 0:
+		result.Rule = RuleAccount
+		result.Allocations = m.allocate(owed, t.Amount)
+	case !result.PartnerID.Valid && len(exact) == 1:
+		result.Rule = RuleAmount
+		result.Allocations = m.allocate(exact, t.Amount)
+	}
+	if !result.PartnerID.Valid && len(result.Allocations) > 0 {
+		result.PartnerID = m.payer(result.Allocations)
+	}
+	return result
+}
+
+// total returns the balance of the items at the indices.
+func (m *Matcher) total(indices []int) money.Decimal {
+	sum := money.Decimal{}
+	for _, i := range indices {
+		sum = sum.Add(m.open[i].Balance())
+	}
+	return sum
+}
+
+// allocate pays the items at the indices, in the order of their due dates,
+// from the amount, and takes the allocations off their balances.
+func (m *Matcher) allocate(indices []int, amount money.Decimal) []payment.Allocation {
+	var result []payment.Allocation
+	for _, i := range indices {
+		if amount.Sign() <= 0 {
+			break
+		}
+		part := m.open[i].Balance()
+		if part.Cmp(amount) > 0 {
+			part = amount
+		}
+		m.open[i].Paid = m.open[i].Paid.Add(part)
+		amount = amount.Sub(part)
+		result = append(result, payment.Allocation{InvoiceID: m.open[i].InvoiceID, Amount: part})
+	}
+	return result
+}
+
+// payer returns the partner of the invoices paid, if they are of the same
+// one.
+func (m *Matcher) payer(allocations []payment.Allocation) null.Int {
+	var result null.Int
+	for i, a := range allocations {
+		for _, o := range m.open {
+			if o.InvoiceID != a.InvoiceID {
+				continue
+			}
+			if i > 0 && o.PartnerID != result {
+				return null.Int{}
+			}
+			result = o.PartnerID
+		}
+	}
+	return result
+}
+
+// intersect returns the indices in both a and b, both being ascending.
+func intersect(a, b []int) []int {
+	var result []int
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		switch {
+		case a[i] < b[j]:
+			i++
+		case a[i] > b[j]:
+			j++
+		default:
+			result = append(result, a[i])
+			i, j = i+1, j+1
+		}
+	}
+	return result
+}
+
+// mentions reports whether the text names the invoice number, with any
+// separators or none between its parts, but not as part of a longer word.
+func mentions(text, number string) bool {
+	if number == "" {
+		return false
+	}
+	text = words(text)
+	return strings.Contains(text, words(number)) ||
+		strings.Contains(text, " "+strings.Replace(strings.TrimSpace(words(number)), " ", "", -1)+" ")
+}
+
+// words returns the letters and digits of s in upper case, with every run of
+// other characters turned into a space, and spaces around.
+func words(s string) string {
+	var b bytes.Buffer
+	b.WriteByte(' ')
+	space := true
+	for _, r := range s {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(unicode.ToUpper(r))
+			space = false
+		} else if !space {
+			b.WriteByte(' ')
+			space = true
+		}
+	}
+	if !space {
+		b.WriteByte(' ')
+	}
+	return b.String()
+}
@@ -0,0 +1,87 @@
+package statement_test
+
+import (
+	"testing"
+
+	"github.com/UNO-SOFT/szamlazo/lib/bankstatement"
+	"github.com/UNO-SOFT/szamlazo/lib/money"
+	"github.com/UNO-SOFT/szamlazo/model/partner"
+	"github.com/UNO-SOFT/szamlazo/model/payment"
+	"github.com/UNO-SOFT/szamlazo/model/statement"
+
+	"gopkg.in/guregu/null.v3"
+)
+
+// TestMatch checks the rules in their order, and that an invoice is not paid
+// twice within an import.
+func TestMatch(t *testing.T) {
+	item := func(ID uint32, number string, partnerID int64, currency, total, paid string) payment.OpenItem {
+		return payment.OpenItem{
+			InvoiceID: ID,
+			Number:    number,
+			PartnerID: null.NewInt(partnerID, partnerID != 0),
+			Currency:  currency,
+			Total:     money.MustParse(total),
+			Paid:      money.MustParse(paid),
+		}
+	}
+	open := []payment.OpenItem{
+		item(1, "SZ2026/000012", 1, "HUF", "12700", "0"),
+		item(2, "SZ2026/000013", 1, "HUF", "5000", "0"),
+		item(3, "SZ2026/000014", 2, "HUF", "7000", "2000"),
+		item(4, "SZ2026/000015", 0, "HUF", "3300", "0"),
+		item(5, "SZ2026/000016", 0, "EUR", "3300", "0"),
+		item(6, "SZ2026/000017", 3, "HUF", "3300", "0"),
+	}
+	accounts := []partner.Account{{PartnerID: 1, Number: "11773016-11111018"}}
+	m := statement.NewMatcher(open, accounts)
+
+	credit := func(amount, remittance, account string) bankstatement.Transaction {
+		return bankstatement.Transaction{Amount: money.MustParse(amount), Currency: "HUF",
+			Remittance: remittance, Account: account}
+	}
+	type alloc struct {
+		ID     uint32
+		Amount string
+	}
+	for i, tc := range []struct {
+		name      string
+		t         bankstatement.Transaction
+		rule      string
+		partnerID int64
+		want      []alloc
+	}{
+		{"both named", credit("15000", "szamla SZ2026-000012, SZ2026 000013", ""), statement.RuleNumber, 1,
+			[]alloc{{1, "12700"}, {2, "2300"}}},
+		{"named again", credit("2700", "SZ2026000013", ""), statement.RuleNumber, 1,
+			[]alloc{{2, "2700"}}},
+		{"paid already", credit("100", "SZ2026/000012", ""), "", 0, nil},
+		{"more than named", credit("6000", "SZ2026/000014", ""), "", 0, nil},
+		{"part of a number", credit("4000", "SZ2026/0000141", ""), "", 0, nil},
+		{"by account", credit("5000", "", "HU12 1177 3016 1111 1018 0000 0000"), "", 1, nil},
+		{"by amount", credit("5000", "", ""), statement.RuleAmount, 2,
+			[]alloc{{3, "5000"}}},
+		{"amount ambiguous", credit("3300", "", ""), "", 0, nil},
+		{"debit", credit("-3300", "SZ2026/000015", ""), "", 0, nil},
+	} {
+		got := m.Match(tc.t)
+		if got.Rule != tc.rule || got.PartnerID != null.NewInt(tc.partnerID, tc.partnerID != 0) ||
+			len(got.Allocations) != len(tc.want) {
+			t.Errorf("%d. %s: got %+v", i, tc.name, got)
+			continue
+		}
+		for j, w := range tc.want {
+			a := got.Allocations[j]
+			if a.InvoiceID != w.ID || a.Amount.Cmp(money.MustParse(w.Amount)) != 0 {
+				t.Errorf("%d. %s: %d. allocation is %d %s, wanted %+v", i, tc.name, j, a.InvoiceID, a.Amount, w)
+			}
+		}
+	}
+
+	// The partner owns the account and owes exactly the amount
+	m = statement.NewMatcher(open, []partner.Account{{PartnerID: 3, Number: "HU42117730161234567800000000"}})
+	got := m.Match(credit("3300", "", "11773016-12345678"))
+	if got.Rule != statement.RuleAccount || len(got.Allocations) != 1 || got.Allocations[0].InvoiceID != 6 {
+		t.Errorf("by account: got %+v", got)
+	}
+}
@@ -0,0 +1,374 @@
+// Package statement provides access to the bank_statement and
+// bank_transaction tables in the database: the imported bank statements, and
+// their transactions matched to invoices or waiting for review.
+package statement
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/UNO-SOFT/szamlazo/lib/bankstatement"
+	"github.com/UNO-SOFT/szamlazo/lib/money"
+	"github.com/UNO-SOFT/szamlazo/model/audit"
+	"github.com/UNO-SOFT/szamlazo/model/invoice"
+	"github.com/UNO-SOFT/szamlazo/model/partner"
+	"github.com/UNO-SOFT/szamlazo/model/payment"
+	"github.com/UNO-SOFT/szamlazo/model/transaction"
+
+	"github.com/pkg/errors"
+
+	"gopkg.in/guregu/null.v3"
+)
+
+var (
+	// table is the table name.
+	table = "bank_statement"
+	// transactionTable is the table name of the transactions.
+	transactionTable = "bank_transaction"
+)
+
+// actionReview is the action of the audit log assigning or ignoring a
+// transaction waiting for review.
+const actionReview = "review"
+
+// Statuses of the transactions.
+const (
+	StatusMatched = "matched" // Recorded as a payment
+	StatusReview  = "review"  // Waiting for the invoices to be picked
+	StatusIgnored = "ignored" // Debits, and credits not paying invoices
+)
+
+// Item defines the model: an uploaded file, with the counts of its
+// transactions by status.
+type Item struct {
+	ID        uint32    `db:"id"`
+	FileName  string    `db:"file_name"`
+	Path      string    `db:"path"`
+	SHA256    string    `db:"sha256"`
+	Format    string    `db:"format"`
+	Account   string    `db:"account"`
+	Matched   int       `db:"matched"`
+	Review    int       `db:"review"`
+	Ignored   int       `db:"ignored"`
+	CompanyID uint32    `db:"company_id"`
+	UserID    uint32    `db:"user_id"`
+	CreatedAt null.Time `db:"created_at"`
+
+	Transactions []Transaction `db:"-"`
+}
+
+// Transaction is a transaction of a statement with the outcome of its
+// matching.
+type Transaction struct {
+	ID          uint32        `db:"id"`
+	StatementID uint32        `db:"statement_id"`
+	Date        time.Time     `db:"booking_date"`
+	Amount      money.Decimal `db:"amount"`
+	Currency    string        `db:"currency"`
+	Reference   string        `db:"reference"`
+	Remittance  string        `db:"remittance"`
+	Name        string        `db:"counterparty_name"`
+	Account     string        `db:"counterparty_account"`
+	Status      string        `db:"status"`
+	Rule        string        `db:"match_rule"`
+	PaymentID   null.Int      `db:"payment_id"`
+	CompanyID   uint32        `db:"company_id"` // Read by lock only
+	CreatedAt   null.Time     `db:"created_at"`
+	UpdatedAt   null.Time     `db:"updated_at"`
+}
+
+// Service defines the database connection.
+type Service struct {
+	DB    Connection
+	Actor audit.Actor // Who makes the changes, for the audit log
+}
+
+// As returns the service making the changes as the actor.
+func (s Service) As(actor audit.Actor) Service {
+	s.Actor = actor
+	return s
+}
+
+// Connection is an interface for making queries.
+type Connection interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Get(dest interface{}, query string, args ...interface{}) error
+	Select(dest interface{}, query string, args ...interface{}) error
+}
+
+// columns lists the columns in the order of Item.
+const columns = `s.id, s.file_name, s.path, s.sha256, s.format, s.account,
+			(SELECT COUNT(*) FROM bank_transaction t WHERE t.statement_id = s.id AND t.status = 'matched') AS matched,
+			(SELECT COUNT(*) FROM bank_transaction t WHERE t.statement_id = s.id AND t.status = 'review') AS review,
+			(SELECT COUNT(*) FROM bank_transaction t WHERE t.statement_id = s.id AND t.status = 'ignored') AS ignored,
+			s.company_id, s.user_id, s.created_at`
+
+// transactionColumns lists the columns in the order of Transaction.
+const transactionColumns = `t.id, t.statement_id, t.booking_date, t.amount, t.currency,
+			t.reference, t.remittance, t.counterparty_name, t.counterparty_account,
+			t.status, t.match_rule, t.payment_id, t.created_at, t.updated_at`
+
+// ByID gets an item with its transactions by ID.
+func (s Service) ByID(ID string, companyID string) (Item, bool, error) {
+	result := Item{}
+	qry := fmt.Sprintf(`
+		SELECT %s
+		FROM %q s
+		WHERE s.id = $1
+			AND s.company_id = $2
+		LIMIT 1
+		`, columns, table)
+	err := s.DB.Get(&result, qry, ID, companyID)
+	if err != nil {
+		return result, err == sql.ErrNoRows, errors.Wrap(err, qry)
+	}
+	qry = fmt.Sprintf(`
+		SELECT %s
+		FROM %q t
+		WHERE t.statement_id = $1
+		ORDER BY t.id
+		`, transactionColumns, transactionTable)
+	err = s.DB.Select(&result.Transactions, qry, ID)
+	return result, false, errors.Wrap(err, qry)
+}
+
+// ByCompanyID gets all entities of a company, without their transactions,
+// the latest first.
+func (s Service) ByCompanyID(companyID string) ([]Item, bool, error) {
+	var result []Item
+	qry := fmt.Sprintf(`
+		SELECT %s
+		FROM %q s
+		WHERE s.company_id = $1
+		ORDER BY s.id DESC
+		`, columns, table)
+	err := s.DB.Select(&result, qry, companyID)
+	return result, err == sql.ErrNoRows, errors.Wrap(err, qry)
+}
+
+// Review gets the transactions waiting for review, the oldest first.
+func (s Service) Review(companyID string) ([]Transaction, bool, error) {
+	var result []Transaction
+	qry := fmt.Sprintf(`
+		SELECT %s
+		FROM %q t
+		JOIN %q s ON s.id = t.statement_id
+		WHERE t.status = $1
+			AND s.company_id = $2
+		ORDER BY t.booking_date, t.id
+		`, transactionColumns, transactionTable, table)
+	err := s.DB.Select(&result, qry, StatusReview, companyID)
+	return result, err == sql.ErrNoRows, errors.Wrap(err, qry)
+}
+
+// Import records the transactions of the statements read from the file of
+// item, and returns the new ID. The credits matched to open items are
+// recorded as payments, the other credits are left for review, and the
+// debits are ignored. A file is imported only once.
+func (s Service) Import(item Item, statements []bankstatement.Statement, userID string, today time.Time) (uint32, error) {
+	return audit.TrackNew(s.DB, s.Actor.For(userID), table, func(tx transaction.Connection) (uint32, error) {
+		var ID uint32
+		var seen int
+		qry := fmt.Sprintf(`
+			SELECT COUNT(*)
+			FROM %q
+			WHERE company_id = $1
+				AND sha256 = $2
+			`, table)
+		if err := tx.Get(&seen, qry, item.CompanyID, item.SHA256); err != nil {
+			return ID, errors.Wrap(err, qry)
+		}
+		if seen > 0 {
+			return ID, errors.Errorf("%s is already imported", item.FileName)
+		}
+		if len(statements) > 0 {
+			item.Account = statements[0].Account
+		}
+		qry = fmt.Sprintf(`
+			INSERT INTO %q
+			(file_name, path, sha256, format, account, company_id, user_id)
+			VALUES
+			($1,$2,$3,$4,$5,$6,$7)
+			RETURNING id
+			`, table)
+		if err := tx.Get(&ID, qry,
+			item.FileName, item.Path, item.SHA256, item.Format, item.Account, item.CompanyID, userID,
+		); err != nil {
+			return ID, errors.Wrap(err, qry)
+		}
+
+		debtors, err := payment.Service{DB: tx}.OpenItems(fmt.Sprint(item.CompanyID), today)
+		if err != nil {
+			return ID, err
+		}
+		var open []payment.OpenItem
+		for _, d := range debtors {
+			open = append(open, d.Items...)
+		}
+		accounts, err := partner.Service{DB: tx}.Accounts(fmt.Sprint(item.CompanyID))
+		if err != nil {
+			return ID, err
+		}
+		matcher := NewMatcher(open, accounts)
+
+		ts := Service{DB: tx, Actor: s.Actor}
+		for _, st := range statements {
+			for _, bt := range st.Transactions {
+				t := Transaction{
+					StatementID: ID,
+					Date:        bt.Date,
+					Amount:      bt.Amount,
+					Currency:    bt.Currency,
+					Reference:   bt.Reference,
+					Remittance:  bt.Remittance,
+					Name:        bt.Name,
+					Account:     bt.Account,
+					Status:      StatusIgnored,
+				}
+				if bt.Amount.Sign() > 0 {
+					t.Status = StatusReview
+					if m := matcher.Match(bt); len(m.Allocations) > 0 {
+						if t.PaymentID, err = ts.pay(t, m, item.CompanyID, userID); err != nil {
+							return ID, err
+						}
+						t.Status, t.Rule = StatusMatched, m.Rule
+					}
+				}
+				if err = ts.insert(t); err != nil {
+					return ID, err
+				}
+			}
+		}
+		return ID, nil
+	})
+}
+
+// insert adds a transaction.
+func (s Service) insert(t Transaction) error {
+	qry := fmt.Sprintf(`
+		INSERT INTO %q
+		(statement_id, booking_date, amount, currency,
+			reference, remittance, counterparty_name, counterparty_account,
+			status, match_rule, payment_id)
+		VALUES
+		($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11)
+		`, transactionTable)
+	_, err := s.DB.Exec(qry,
+		t.StatementID, t.Date, t.Amount, t.Currency,
+		t.Reference, t.Remittance, t.Name, t.Account,
+		t.Status, t.Rule, t.PaymentID)
+	return errors.Wrap(err, qry)
+}
+
+// pay records a transaction as a payment of the matched invoices within the
+// transaction of DB.
+func (s Service) pay(t Transaction, m Match, companyID uint32, userID string) (null.Int, error) {
+	ID, err := payment.Service{DB: s.DB, Actor: s.Actor}.Create(payment.Item{
+		CompanyID:     companyID,
+		PartnerID:     m.PartnerID,
+		PayerName:     t.Name,
+		Date:          t.Date,
+		Amount:        t.Amount,
+		Currency:      t.Currency,
+		Method:        invoice.PaymentTransfer,
+		BankReference: t.Reference,
+		Allocations:   m.Allocations,
+	}, userID)
+	return null.IntFrom(int64(ID)), err
+}
+
+// lock gets a transaction waiting for review and locks it until the end of
+// the transaction of DB.
+func (s Service) lock(ID string, companyID string) (Transaction, error) {
+	result := Transaction{}
+	qry := fmt.Sprintf(`
+		SELECT %s, s.company_id
+		FROM %q t
+		JOIN %q s ON s.id = t.statement_id
+		WHERE t.id = $1
+			AND s.company_id = $2
+		FOR UPDATE OF t
+		`, transactionColumns, transactionTable, table)
+	err := s.DB.Get(&result, qry, ID, companyID)
+	if err == sql.ErrNoRows {
+		return result, errors.Errorf("transaction %s not found", ID)
+	} else if err != nil {
+		return result, errors.Wrap(err, qry)
+	}
+	if result.Status != StatusReview {
+		return result, errors.Errorf("transaction %s is already %s", ID, result.Status)
+	}
+	return result, nil
+}
+
+// Assign records a transaction waiting for review as a payment of the
+// invoices picked, and returns the ID of the payment. The part not allocated
+// can be allocated later on the payment.
+func (s Service) Assign(ID string, allocations []payment.Allocation, companyID string, userID string) (uint32, error) {
+	var paymentID uint32
+	err := audit.Track(s.DB, s.Actor.For(userID), actionReview, transactionTable, ID, func(tx transaction.Connection) error {
+		ts := Service{DB: tx, Actor: s.Actor.For(userID)}
+		t, err := ts.lock(ID, companyID)
+		if err != nil {
+			return err
+		}
+		m := Match{Rule: RuleManual, Allocations: allocations}
+		if m.PartnerID, err = ts.payer(t, allocations); err != nil {
+			return err
+		}
+		pID, err := ts.pay(t, m, t.CompanyID, userID)
+		if err != nil {
+			return err
+		}
+		paymentID = uint32(pID.Int64)
+		return ts.setStatus(ID, StatusMatched, RuleManual, pID)
+	})
+	return paymentID, err
+}
+
+// payer returns the partner owning the account of the payer, or else the
+// partner of the first invoice paid.
+func (s Service) payer(t Transaction, allocations []payment.Allocation) (null.Int, error) {
+	accounts, err := partner.Service{DB: s.DB}.Accounts(fmt.Sprint(t.CompanyID))
+	if err != nil {
+		return null.Int{}, err
+	}
+	key := bankstatement.AccountKey(t.Account)
+	for _, a := range accounts {
+		if t.Account != "" && bankstatement.AccountKey(a.Number) == key {
+			return null.IntFrom(int64(a.PartnerID)), nil
+		}
+	}
+	if len(allocations) == 0 {
+		return null.Int{}, nil
+	}
+	// A missing invoice is reported by the allocation
+	inv, noRows, err := invoice.Service{DB: s.DB}.Lock(allocations[0].InvoiceID, t.CompanyID)
+	if err != nil && !noRows {
+		return null.Int{}, err
+	}
+	return inv.PartnerID, nil
+}
+
+// Ignore takes a transaction off the review queue without recording it.
+func (s Service) Ignore(ID string, companyID string) error {
+	return audit.Track(s.DB, s.Actor, actionReview, transactionTable, ID, func(tx transaction.Connection) error {
+		ts := Service{DB: tx}
+		if _, err := ts.lock(ID, companyID); err != nil {
+			return err
+		}
+		return ts.setStatus(ID, StatusIgnored, "", null.Int{})
+	})
+}
+
+// setStatus records the outcome of a review.
+func (s Service) setStatus(ID string, status string, rule string, paymentID null.Int) error {
+	qry := fmt.Sprintf(`
+		UPDATE %q
+		SET status = $1, match_rule = $2, payment_id = $3, updated_at = NOW()
+		WHERE id = $4
+		`, transactionTable)
+	_, err := s.DB.Exec(qry, status, rule, paymentID, ID)
+	return errors.Wrap(err, qry)
+}
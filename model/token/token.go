@@ -0,0 +1,219 @@
+// Package token provides access to the api_token table in the database: the
+// tokens machine clients of the API authenticate with as a user, sent in an
+// "Authorization: Bearer" header.
+package token
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/UNO-SOFT/szamlazo/model/audit"
+	"github.com/UNO-SOFT/szamlazo/model/transaction"
+
+	"github.com/pkg/errors"
+
+	"gopkg.in/guregu/null.v3"
+)
+
+var (
+	// table is the table name.
+	table = "api_token"
+)
+
+// Scopes of the tokens. A token with the write scope may also read.
+const (
+	ScopeRead  = "read"  // GET and HEAD requests
+	ScopeWrite = "write" // Requests changing data
+)
+
+// Scopes are the scopes offered, in order.
+var Scopes = []string{ScopeRead, ScopeWrite}
+
+// prefix starts every token, so leaked ones are easy to search for.
+const prefix = "szt_"
+
+// shown is the length of the start of a token kept to tell them apart.
+const shown = 12
+
+// Item defines the model.
+type Item struct {
+	ID         uint32    `db:"id"`
+	UserID     uint32    `db:"user_id"`
+	CompanyID  null.Int  `db:"company_id"`
+	Name       string    `db:"name"`
+	Prefix     string    `db:"prefix"`
+	Scopes     string    `db:"scopes"`
+	ExpiresAt  null.Time `db:"expires_at"`
+	LastUsedAt null.Time `db:"last_used_at"`
+	CreatedAt  null.Time `db:"created_at"`
+}
+
+// Has reports whether the token has the scope.
+func (item Item) Has(scope string) bool {
+	for _, s := range strings.Fields(item.Scopes) {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Allows reports whether the scopes of the token allow a request with the
+// method.
+func (item Item) Allows(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return item.Has(ScopeRead) || item.Has(ScopeWrite)
+	}
+	return item.Has(ScopeWrite)
+}
+
+// Expired reports whether the token is past its expiry.
+func (item Item) Expired() bool {
+	return item.ExpiresAt.Valid && !item.ExpiresAt.Time.After(time.Now())
+}
+
+// NormalizeScopes checks the scopes and joins them as they are stored.
+func NormalizeScopes(scopes []string) (string, error) {
+	var result []string
+	for _, want := range Scopes {
+		for _, s := range scopes {
+			if s == want {
+				result = append(result, s)
+				break
+			}
+		}
+	}
+	for _, s := range scopes {
+		if s != ScopeRead && s != ScopeWrite {
+			return "", fmt.Errorf("unknown scope %q", s)
+		}
+	}
+	if len(result) == 0 {
+		return "", errors.New("at least one scope is required")
+	}
+	return strings.Join(result, " "), nil
+}
+
+// Hash returns the hash of the token as it is stored.
+func Hash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// generate returns a new random token.
+func generate() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return prefix + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// Service defines the database connection.
+type Service struct {
+	DB    Connection
+	Actor audit.Actor // Who makes the changes, for the audit log
+}
+
+// As returns the service making the changes as the actor.
+func (s Service) As(actor audit.Actor) Service {
+	s.Actor = actor
+	return s
+}
+
+// Connection is an interface for making queries.
+type Connection interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Get(dest interface{}, query string, args ...interface{}) error
+	Select(dest interface{}, query string, args ...interface{}) error
+}
+
+// ByUserID gets the tokens of the user, the newest first.
+func (s Service) ByUserID(userID string) ([]Item, bool, error) {
+	var result []Item
+	qry := fmt.Sprintf(`
+		SELECT id, user_id, company_id, name, prefix, scopes, expires_at, last_used_at, created_at
+		FROM %q
+		WHERE user_id = $1
+			AND deleted_at IS NULL
+		ORDER BY created_at DESC, id DESC
+		`, table)
+	err := s.DB.Select(&result, qry, userID)
+	return result, err == sql.ErrNoRows, errors.Wrap(err, qry)
+}
+
+// ByToken gets the token which is neither revoked nor expired.
+func (s Service) ByToken(token string) (Item, bool, error) {
+	result := Item{}
+	qry := fmt.Sprintf(`
+		SELECT id, user_id, company_id, name, prefix, scopes, expires_at, last_used_at, created_at
+		FROM %q
+		WHERE hash = $1
+			AND deleted_at IS NULL
+			AND (expires_at IS NULL OR expires_at > CURRENT_TIMESTAMP)
+		LIMIT 1
+		`, table)
+	err := s.DB.Get(&result, qry, Hash(token))
+	return result, err == sql.ErrNoRows, errors.Wrap(err, qry)
+}
+
+// Create adds a token of the user for the company, and returns it. Only its
+// hash is stored, so it cannot be shown again.
+func (s Service) Create(item Item, userID string) (string, error) {
+	t, err := generate()
+	if err != nil {
+		return "", err
+	}
+	_, err = audit.TrackNew(s.DB, s.Actor.For(userID), table, func(tx transaction.Connection) (uint32, error) {
+		var ID uint32
+		qry := fmt.Sprintf(`
+			INSERT INTO %q
+			(user_id, company_id, name, prefix, hash, scopes, expires_at)
+			VALUES
+			($1,$2,$3,$4,$5,$6,$7)
+			RETURNING id
+			`, table)
+		err := tx.Get(&ID, qry, userID, item.CompanyID, item.Name, t[:shown], Hash(t), item.Scopes, item.ExpiresAt)
+		return ID, errors.Wrap(err, qry)
+	})
+	return t, err
+}
+
+// Touch records the use of the token. It is written at most once a minute,
+// so busy clients do not update the row on every request.
+func (s Service) Touch(ID uint32) error {
+	qry := fmt.Sprintf(`
+		UPDATE %q
+		SET last_used_at = CURRENT_TIMESTAMP
+		WHERE id = $1
+			AND (last_used_at IS NULL OR last_used_at < CURRENT_TIMESTAMP - INTERVAL '1 minute')
+		`, table)
+	_, err := s.DB.Exec(qry, ID)
+	return errors.Wrap(err, qry)
+}
+
+// Revoke marks a token of the user removed.
+func (s Service) Revoke(ID string, userID string) (sql.Result, error) {
+	var result sql.Result
+	err := audit.Track(s.DB, s.Actor.For(userID), audit.Delete, table, ID, func(tx transaction.Connection) error {
+		qry := fmt.Sprintf(`
+			UPDATE %q
+			SET deleted_at = CURRENT_TIMESTAMP
+			WHERE id = $1
+				AND user_id = $2
+				AND deleted_at IS NULL
+			`, table)
+		var err error
+		result, err = tx.Exec(qry, ID, userID)
+		return errors.Wrap(err, qry)
+	})
+	return result, err
+}
@@ -0,0 +1,65 @@
+package token_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/UNO-SOFT/szamlazo/model/token"
+
+	"gopkg.in/guregu/null.v3"
+)
+
+// TestAllows checks the methods allowed by the scopes.
+func TestAllows(t *testing.T) {
+	for _, tc := range []struct {
+		scopes string
+		method string
+		want   bool
+	}{
+		{"read", http.MethodGet, true},
+		{"read", http.MethodHead, true},
+		{"read", http.MethodPost, false},
+		{"read", http.MethodDelete, false},
+		{"read write", http.MethodPut, true},
+		{"write", http.MethodGet, true},
+		{"", http.MethodGet, false},
+	} {
+		if got := (token.Item{Scopes: tc.scopes}).Allows(tc.method); got != tc.want {
+			t.Errorf("%q %s: got %t want %t", tc.scopes, tc.method, got, tc.want)
+		}
+	}
+}
+
+// TestNormalizeScopes checks the validation and order of the scopes.
+func TestNormalizeScopes(t *testing.T) {
+	if got, err := token.NormalizeScopes([]string{"write", "read", "write"}); err != nil || got != "read write" {
+		t.Errorf("got %q, %v", got, err)
+	}
+	for _, scopes := range [][]string{nil, {"admin"}, {"read", "admin"}} {
+		if got, err := token.NormalizeScopes(scopes); err == nil {
+			t.Errorf("%q: got %q", scopes, got)
+		}
+	}
+}
+
+// TestExpired checks the expiry of the tokens.
+func TestExpired(t *testing.T) {
+	if (token.Item{}).Expired() {
+		t.Error("a token without expiry expired")
+	}
+	if !(token.Item{ExpiresAt: null.TimeFrom(time.Now().Add(-time.Minute))}).Expired() {
+		t.Error("a past expiry did not expire")
+	}
+	if (token.Item{ExpiresAt: null.TimeFrom(time.Now().Add(time.Hour))}).Expired() {
+		t.Error("a future expiry expired")
+	}
+}
+
+// TestHash checks that the hash is stable and hides the token.
+func TestHash(t *testing.T) {
+	h := token.Hash("szt_abc")
+	if len(h) != 64 || h != token.Hash("szt_abc") || h == token.Hash("szt_abd") {
+		t.Errorf("bad hash %q", h)
+	}
+}
@@ -0,0 +1,80 @@
+// Package transaction provides units of work, so the statements of one or
+// more services are applied all together or not at all.
+package transaction
+
+import (
+	"database/sql"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// Connection is an interface for making queries. Both *sqlx.DB and *sqlx.Tx
+// implement it, as do the Connection interfaces of the services.
+type Connection interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Get(dest interface{}, query string, args ...interface{}) error
+	Select(dest interface{}, query string, args ...interface{}) error
+}
+
+// Beginner is implemented by connections which can start a transaction.
+type Beginner interface {
+	Beginx() (*sqlx.Tx, error)
+}
+
+// Unit is a unit of work.
+type Unit struct {
+	Tx   *sqlx.Tx
+	done bool
+}
+
+// Begin starts a unit of work.
+func Begin(db Beginner) (*Unit, error) {
+	tx, err := db.Beginx()
+	if err != nil {
+		return nil, errors.Wrap(err, "begin")
+	}
+	return &Unit{Tx: tx}, nil
+}
+
+// Commit applies the changes of the unit.
+func (u *Unit) Commit() error {
+	if u.done {
+		return errors.New("transaction already finished")
+	}
+	u.done = true
+	return errors.Wrap(u.Tx.Commit(), "commit")
+}
+
+// Rollback discards the changes of the unit. It does nothing after Commit,
+// so it can be deferred right after Begin.
+func (u *Unit) Rollback() error {
+	if u.done {
+		return nil
+	}
+	u.done = true
+	return errors.Wrap(u.Tx.Rollback(), "rollback")
+}
+
+// Run calls fn within a transaction, which is committed if fn returns nil and
+// rolled back otherwise.
+//
+// If conn is already a transaction, fn joins it and the caller decides its
+// fate, so services can call each other through Run freely.
+func Run(conn Connection, fn func(tx Connection) error) error {
+	db, ok := conn.(Beginner)
+	if !ok {
+		return fn(conn)
+	}
+
+	u, err := Begin(db)
+	if err != nil {
+		return err
+	}
+	defer u.Rollback()
+
+	if err = fn(u.Tx); err != nil {
+		return err
+	}
+	return u.Commit()
+}
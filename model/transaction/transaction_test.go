@@ -0,0 +1,99 @@
+package transaction_test
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"testing"
+
+	"github.com/UNO-SOFT/szamlazo/model/transaction"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// counter records what happened to the transactions of the fake driver.
+type counter struct {
+	begin, commit, rollback, exec int
+}
+
+var count counter
+
+// fakeDriver is a database/sql driver which only counts the calls.
+type fakeDriver struct{}
+type fakeConn struct{}
+type fakeTx struct{}
+type fakeStmt struct{}
+
+func (fakeDriver) Open(string) (driver.Conn, error)        { return fakeConn{}, nil }
+func (fakeConn) Prepare(string) (driver.Stmt, error)       { return fakeStmt{}, nil }
+func (fakeConn) Close() error                              { return nil }
+func (fakeConn) Begin() (driver.Tx, error)                 { count.begin++; return fakeTx{}, nil }
+func (fakeTx) Commit() error                               { count.commit++; return nil }
+func (fakeTx) Rollback() error                             { count.rollback++; return nil }
+func (fakeStmt) Close() error                              { return nil }
+func (fakeStmt) NumInput() int                             { return -1 }
+func (fakeStmt) Query([]driver.Value) (driver.Rows, error) { return nil, errors.New("no rows") }
+func (fakeStmt) Exec([]driver.Value) (driver.Result, error) {
+	count.exec++
+	return driver.RowsAffected(1), nil
+}
+
+func init() {
+	sql.Register("transaction_test", fakeDriver{})
+}
+
+// TestRun checks that Run commits on success, rolls back on failure, and
+// joins an already running transaction.
+func TestRun(t *testing.T) {
+	db := sqlx.MustOpen("transaction_test", "")
+	defer db.Close()
+
+	exec := func(tx transaction.Connection) error {
+		_, err := tx.Exec("UPDATE x SET y = 1")
+		return err
+	}
+	fail := errors.New("fail")
+
+	count = counter{}
+	if err := transaction.Run(db, exec); err != nil {
+		t.Fatal(err)
+	}
+	if want := (counter{begin: 1, commit: 1, exec: 1}); count != want {
+		t.Errorf("success: got %+v want %+v", count, want)
+	}
+
+	count = counter{}
+	err := transaction.Run(db, func(tx transaction.Connection) error {
+		if err := exec(tx); err != nil {
+			return err
+		}
+		return fail
+	})
+	if err != fail {
+		t.Errorf("failure: got error %v want %v", err, fail)
+	}
+	if want := (counter{begin: 1, rollback: 1, exec: 1}); count != want {
+		t.Errorf("failure: got %+v want %+v", count, want)
+	}
+
+	count = counter{}
+	u, err := transaction.Begin(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = transaction.Run(u.Tx, exec); err != nil {
+		t.Fatal(err)
+	}
+	if want := (counter{begin: 1, exec: 1}); count != want {
+		t.Errorf("nested: got %+v want %+v", count, want)
+	}
+	if err = u.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err = u.Rollback(); err != nil {
+		t.Error("rollback after commit:", err)
+	}
+	if want := (counter{begin: 1, commit: 1, exec: 1}); count != want {
+		t.Errorf("nested: got %+v want %+v", count, want)
+	}
+}
@@ -5,6 +5,9 @@ import (
 	"database/sql"
 	"fmt"
 
+	"github.com/UNO-SOFT/szamlazo/model/audit"
+	"github.com/UNO-SOFT/szamlazo/model/transaction"
+
 	"github.com/pkg/errors"
 	"gopkg.in/guregu/null.v3"
 )
@@ -29,7 +32,14 @@ type Item struct {
 
 // Service defines the database connection.
 type Service struct {
-	DB Connection
+	DB    Connection
+	Actor audit.Actor // Who makes the changes, for the audit log
+}
+
+// As returns the service making the changes as the actor.
+func (c Service) As(actor audit.Actor) Service {
+	c.Actor = actor
+	return c
 }
 
 // Connection is an interface for making queries.
@@ -53,14 +63,33 @@ func (c Service) ByEmail(email string) (Item, bool, error) {
 	return result, err == sql.ErrNoRows, errors.Wrap(err, qry)
 }
 
+// ByID gets the details of a user.
+func (c Service) ByID(ID string) (Item, bool, error) {
+	result := Item{}
+	qry := fmt.Sprintf(`
+		SELECT id, first_name, last_name, email, status_id, created_at, updated_at
+		FROM %q
+		WHERE id = $1
+			AND deleted_at IS NULL
+		LIMIT 1
+		`, table)
+	err := c.DB.Get(&result, qry, ID)
+	return result, err == sql.ErrNoRows, errors.Wrap(err, qry)
+}
+
 // Create creates user.
 func (c Service) Create(firstName, lastName, email, password string) (sql.Result, error) {
-	result, err := c.DB.Exec(fmt.Sprintf(`
-		INSERT INTO %q
-		(first_name, last_name, email, password)
-		VALUES
-		($1,$2,$3,$4)
-		`, table),
-		firstName, lastName, email, password)
-	return result, err
+	ID, err := audit.TrackNew(c.DB, c.Actor, table, func(tx transaction.Connection) (uint32, error) {
+		var ID uint32
+		qry := fmt.Sprintf(`
+			INSERT INTO %q
+			(first_name, last_name, email, password)
+			VALUES
+			($1,$2,$3,$4)
+			RETURNING id
+			`, table)
+		err := tx.Get(&ID, qry, firstName, lastName, email, password)
+		return ID, errors.Wrap(err, qry)
+	})
+	return audit.Created(ID), err
 }
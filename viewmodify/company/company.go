@@ -0,0 +1,20 @@
+// Package company adds a CompanyName variable to the view template.
+package company
+
+import (
+	"net/http"
+
+	"github.com/UNO-SOFT/szamlazo/lib/flight"
+	"github.com/blue-jay/core/view"
+)
+
+// Modify sets CompanyName in the template to the name of the active company
+// of the user, empty if there is none.
+func Modify(w http.ResponseWriter, r *http.Request, v *view.Info) {
+	c := flight.Context(w, r)
+
+	v.Vars["CompanyName"] = ""
+	if name, ok := c.Sess.Values["company_name"].(string); ok {
+		v.Vars["CompanyName"] = name
+	}
+}
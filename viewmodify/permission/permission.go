@@ -0,0 +1,36 @@
+// Package permission adds Role and Can variables to the view template.
+package permission
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/UNO-SOFT/szamlazo/lib/flight"
+	"github.com/UNO-SOFT/szamlazo/model"
+	"github.com/blue-jay/core/view"
+)
+
+// Modify sets Role in the template to the role of the user in the active
+// company, and Can to the set of its permissions, so the templates can hide
+// what the user may not do: {{if index $.Can "invoice.issue"}}. Both are
+// empty if there is no active company.
+func Modify(w http.ResponseWriter, r *http.Request, v *view.Info) {
+	c := flight.Context(w, r)
+
+	can := make(map[string]bool)
+	v.Vars["Role"] = ""
+	v.Vars["Can"] = can
+	if c.Sess.Values["id"] == nil || c.Sess.Values["company_id"] == nil {
+		return
+	}
+
+	role, _, err := model.Role.Member(c.CompanyID, c.UserID)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	v.Vars["Role"] = role.Name
+	for _, p := range role.Permissions {
+		can[p] = true
+	}
+}